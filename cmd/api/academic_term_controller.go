@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// parseTimeValue accepts either a full RFC3339 timestamp or a plain date.
+func parseTimeValue(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// readTermForm fills the calendar fields of term from the request form.
+// Missing fields keep their current value so the same code serves updates.
+func readTermForm(r *http.Request, term *data.AcademicTerm) map[string]string {
+	fields := map[string]*time.Time{
+		"proposal_open":             &term.ProposalOpen,
+		"proposal_close":            &term.ProposalClose,
+		"advisor_response_deadline": &term.AdvisorResponseDeadline,
+		"final_submission_deadline": &term.FinalSubmissionDeadline,
+		"defense_start":             &term.DefenseStart,
+		"defense_end":               &term.DefenseEnd,
+	}
+	errs := map[string]string{}
+	for field, dst := range fields {
+		value := r.FormValue(field)
+		if value == "" {
+			if dst.IsZero() {
+				errs[field] = "هذا الحقل مطلوب"
+			}
+			continue
+		}
+		t, err := parseTimeValue(value)
+		if err != nil {
+			errs[field] = "تنسيق التاريخ غير صالح"
+			continue
+		}
+		*dst = t
+	}
+	return errs
+}
+
+func (app *application) termFromPath(w http.ResponseWriter, r *http.Request) (*data.AcademicTerm, bool) {
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return nil, false
+	}
+	term, err := app.Model.AcademicTermDB.GetTerm(year, strings.ToLower(r.PathValue("season")))
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	return term, true
+}
+
+func (app *application) CreateAcademicTermHandler(w http.ResponseWriter, r *http.Request) {
+	year, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return
+	}
+
+	term := &data.AcademicTerm{
+		Year:   year,
+		Season: strings.ToLower(r.FormValue("season")),
+	}
+	if errs := readTermForm(r, term); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateAcademicTerm(v, term)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.Model.AcademicTermDB.InsertTerm(term)
+	if err != nil {
+		if errors.Is(err, data.ErrDuplicatedKey) {
+			app.errorResponse(w, r, http.StatusConflict, "An academic term already exists for this year and season")
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"term": term})
+}
+
+func (app *application) ListAcademicTermsHandler(w http.ResponseWriter, r *http.Request) {
+	terms, err := app.Model.AcademicTermDB.ListTerms()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"terms": terms})
+}
+
+func (app *application) GetCurrentAcademicTermHandler(w http.ResponseWriter, r *http.Request) {
+	term, err := app.Model.AcademicTermDB.GetCurrentTerm(time.Now())
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"term": term})
+}
+
+func (app *application) GetAcademicTermHandler(w http.ResponseWriter, r *http.Request) {
+	term, ok := app.termFromPath(w, r)
+	if !ok {
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"term": term})
+}
+
+func (app *application) UpdateAcademicTermHandler(w http.ResponseWriter, r *http.Request) {
+	term, ok := app.termFromPath(w, r)
+	if !ok {
+		return
+	}
+	if errs := readTermForm(r, term); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateAcademicTerm(v, term)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.AcademicTermDB.UpdateTerm(term); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"term": term})
+}
+
+func (app *application) DeleteAcademicTermHandler(w http.ResponseWriter, r *http.Request) {
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return
+	}
+	if err := app.Model.AcademicTermDB.DeleteTerm(year, strings.ToLower(r.PathValue("season"))); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "academic term deleted successfully"})
+}
+
+// checkWindow enforces the academic calendar for a pre-project action. Terms
+// that were never configured leave legacy projects unrestricted. An admin
+// override for the window lets the project act after it has closed.
+func (app *application) checkWindow(preProject *data.PreProject, window string) error {
+	term, err := app.Model.AcademicTermDB.GetTerm(preProject.Year, preProject.Season)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	if term.IsOpen(window, now) {
+		return nil
+	}
+
+	overridden, err := app.Model.AcademicTermDB.HasActiveOverride(preProject.ID, window, now)
+	if err != nil {
+		return err
+	}
+	if overridden {
+		return nil
+	}
+
+	open, close := term.WindowBounds(window)
+	return fmt.Errorf("%w: %s (%s - %s)", data.ErrWindowClosed, window,
+		open.Format("2006-01-02"), close.Format("2006-01-02"))
+}
+
+// submissionWindow is the window that governs student edits: the proposal
+// window until an advisor accepts, the final-submission window afterwards.
+func submissionWindow(preProject *data.PreProject) string {
+	if preProject.AcceptedAdvisor != nil {
+		return data.WindowFinalSubmission
+	}
+	return data.WindowProposal
+}
+
+func (app *application) ListWindowOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	overrides, err := app.Model.AcademicTermDB.ListOverrides(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"overrides": overrides})
+}
+
+func (app *application) GrantWindowOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	override := &data.WindowOverride{
+		PreProjectID: preProjectID,
+		Window:       r.FormValue("window"),
+		GrantedBy:    &adminID,
+	}
+	if until := r.FormValue("until"); until != "" {
+		expiresAt, err := parseTimeValue(until)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid until date"))
+			return
+		}
+		override.ExpiresAt = &expiresAt
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		override.Reason = &reason
+	}
+
+	v := validator.New()
+	data.ValidateWindow(v, override.Window)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.AcademicTermDB.GrantOverride(override); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"override": override})
+}
+
+func (app *application) RevokeWindowOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	if err := app.Model.AcademicTermDB.RevokeOverride(preProjectID, r.PathValue("window")); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "override revoked successfully"})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const maxBulkResponses = 50
+
+type advisorResponseInput struct {
+	PreProjectID uuid.UUID `json:"pre_project_id"`
+	Status       string    `json:"status"`
+}
+
+// advisorResponseResult reports what happened to one item of a bulk answer;
+// Code is the status the single-project endpoint would have returned.
+type advisorResponseResult struct {
+	PreProjectID uuid.UUID `json:"pre_project_id"`
+	Status       string    `json:"status"`
+	Recorded     bool      `json:"recorded"`
+	Code         int       `json:"code"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ListAdvisorRequestsHandler is the teacher's inbox: every project that named
+// them as an advisor, grouped by their response. ?status narrows it to one
+// group.
+func (app *application) ListAdvisorRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	advisorID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	status := strings.ToLower(r.URL.Query().Get("status"))
+	if status != "" && !validator.In(status, "pending", "accepted", "rejected") {
+		app.failedValidationResponse(w, r, map[string]string{"status": "Invalid status. Must be 'pending', 'accepted', or 'rejected'"})
+		return
+	}
+
+	requests, err := app.Model.PreProjectDB.ListAdvisorRequests(advisorID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	grouped := map[string][]data.AdvisorRequest{}
+	for _, group := range []string{"pending", "accepted", "rejected"} {
+		if status == "" || status == group {
+			grouped[group] = []data.AdvisorRequest{}
+		}
+	}
+	for _, request := range requests {
+		if list, ok := grouped[request.ResponseStatus]; ok {
+			grouped[request.ResponseStatus] = append(list, request)
+		}
+	}
+	counts := map[string]int{}
+	for group, list := range grouped {
+		counts[group] = len(list)
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"requests": grouped, "counts": counts})
+}
+
+// respondAsAdvisor applies the same checks as POST advisorresponse to one
+// project and records the answer.
+func (app *application) respondAsAdvisor(advisorID uuid.UUID, input advisorResponseInput) advisorResponseResult {
+	result := advisorResponseResult{PreProjectID: input.PreProjectID, Status: input.Status}
+	fail := func(code int, err error) advisorResponseResult {
+		result.Code = code
+		result.Error = err.Error()
+		return result
+	}
+
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(input.PreProjectID)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return fail(http.StatusNotFound, err)
+		}
+		return fail(http.StatusInternalServerError, errors.New("the server encountered a problem and could not process your request"))
+	}
+	if preProject.PreProject.AcceptedAdvisor != nil && *preProject.PreProject.AcceptedAdvisor == advisorID {
+		return fail(http.StatusConflict, errors.New("you have already accepted this project"))
+	}
+	if err := app.checkWindow(&preProject.PreProject, data.WindowAdvisorResponse); err != nil {
+		return fail(http.StatusForbidden, err)
+	}
+
+	advisorIDs := make([]uuid.UUID, len(preProject.Advisors))
+	for i, advisor := range preProject.Advisors {
+		advisorIDs[i] = advisor.AdvisorID
+	}
+	v := validator.New()
+	data.ValidateAdvisorResponse(v, advisorID, input.Status, advisorIDs)
+	if !v.Valid() {
+		return fail(http.StatusUnprocessableEntity, errors.New(validationMessage(v.Errors)))
+	}
+
+	if err := app.Model.PreProjectDB.InsertAdvisorResponse(input.PreProjectID, advisorID, input.Status); err != nil {
+		switch {
+		case errors.Is(err, data.ErrAlreadyAccepted):
+			return fail(http.StatusConflict, err)
+		case errors.Is(err, data.ErrRecordNotFound):
+			return fail(http.StatusNotFound, err)
+		default:
+			app.log.Printf("advisor response %s: %v", input.PreProjectID, err)
+			return fail(http.StatusInternalServerError, errors.New("the server encountered a problem and could not process your request"))
+		}
+	}
+	if input.Status == "accepted" {
+		if err := app.Model.MilestoneDB.InstantiateMilestones(input.PreProjectID); err != nil {
+			app.log.Printf("instantiate milestones %s: %v", input.PreProjectID, err)
+		}
+	}
+
+	result.Recorded = true
+	result.Code = http.StatusOK
+	return result
+}
+
+// RespondToAdvisorRequestsHandler accepts or declines several requests at
+// once. Items are processed in order and independently, so a project another
+// advisor got to first is reported without undoing the others.
+func (app *application) RespondToAdvisorRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	advisorID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var input struct {
+		Responses []advisorResponseInput `json:"responses"`
+	}
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Responses) > 0, "responses", "يجب إرسال رد واحد على الأقل")
+	v.Check(len(input.Responses) <= maxBulkResponses, "responses", "لا يمكن الرد على أكثر من 50 طلبًا في المرة الواحدة")
+	seen := map[uuid.UUID]bool{}
+	for _, response := range input.Responses {
+		v.Check(validator.In(response.Status, "accepted", "rejected"), "status", "يجب أن تكون الحالة accepted أو rejected")
+		v.Check(!seen[response.PreProjectID], "pre_project_id", "لا يمكن تكرار المشروع في نفس الطلب")
+		seen[response.PreProjectID] = true
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	results := make([]advisorResponseResult, len(input.Responses))
+	recorded := 0
+	for i, response := range input.Responses {
+		results[i] = app.respondAsAdvisor(advisorID, response)
+		if results[i].Recorded {
+			recorded++
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"results":  results,
+		"recorded": recorded,
+		"failed":   len(results) - recorded,
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func termPathValues(r *http.Request) (int, string, error) {
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		return 0, "", errors.New("invalid year")
+	}
+	season := strings.ToLower(r.PathValue("season"))
+	if season != "spring" && season != "fall" {
+		return 0, "", errors.New("invalid season")
+	}
+	return year, season, nil
+}
+
+// validationMessage flattens validator errors into one line for a report.
+func validationMessage(errs map[string]string) string {
+	keys := make([]string, 0, len(errs))
+	for key := range errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + ": " + errs[key]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// archiveCandidate validates one project the way POST transferbook does and,
+// unless dryRun is set, archives it with the degree it already has.
+func (app *application) archiveCandidate(candidate data.ArchiveCandidate, dryRun bool) data.ArchiveJobItem {
+	item := data.ArchiveJobItem{
+		PreProjectID: candidate.ID,
+		ProjectName:  candidate.Name,
+		Status:       data.ArchiveItemFailed,
+	}
+	fail := func(message string) data.ArchiveJobItem {
+		item.Message = &message
+		return item
+	}
+
+	if candidate.Degree == nil {
+		return fail("لم يتم رصد درجة المشروع بعد")
+	}
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(candidate.ID)
+	if err != nil {
+		return fail(err.Error())
+	}
+	if details.PreProject.AcceptedAdvisor == nil {
+		return fail(data.ErrNoAcceptedAdvisor.Error())
+	}
+
+	book := &data.Book{
+		Name:        details.PreProject.Name,
+		Description: details.PreProject.Description,
+		Year:        details.PreProject.Year,
+		Season:      details.PreProject.Season,
+		Degree:      candidate.Degree,
+	}
+	studentIDs := make([]uuid.UUID, len(details.Students))
+	for i, student := range details.Students {
+		studentIDs[i] = student.StudentID
+	}
+	discussantIDs := make([]uuid.UUID, len(details.Discussants))
+	for i, discussant := range details.Discussants {
+		discussantIDs[i] = discussant.DiscussantID
+	}
+	advisorIDs := []uuid.UUID{*details.PreProject.AcceptedAdvisor}
+
+	v := validator.New()
+	data.ValidateBook(v, book, studentIDs, advisorIDs, discussantIDs, false)
+	if !v.Valid() {
+		return fail(validationMessage(v.Errors))
+	}
+	if dryRun {
+		item.Status = data.ArchiveItemReady
+		return item
+	}
+
+	archived, err := app.Model.BookDB.ArchivePreProject(candidate.ID, *candidate.Degree)
+	if err != nil {
+		return fail(err.Error())
+	}
+	app.ingestBookFile(archived.ID, archived.File)
+	item.Status = data.ArchiveItemArchived
+	item.BookID = &archived.ID
+	return item
+}
+
+// runArchiveJob archives the candidates one by one, recording each outcome
+// so the progress endpoint can follow along. A project that fails does not
+// stop the run.
+func (app *application) runArchiveJob(job *data.ArchiveJob, candidates []data.ArchiveCandidate) {
+	status := data.ArchiveJobCompleted
+	var jobErr *string
+	defer func() {
+		if rec := recover(); rec != nil {
+			status = data.ArchiveJobFailed
+			message := fmt.Sprint(rec)
+			jobErr = &message
+		}
+		if err := app.Model.ArchiveJobDB.FinishArchiveJob(job.ID, status, jobErr); err != nil {
+			app.log.Printf("archive job %s: %v", job.ID, err)
+		}
+		if job.StartedBy != nil {
+			app.wsManager.BroadcastMessage(*job.StartedBy, map[string]interface{}{
+				"type":    "archive_job_finished",
+				"job_id":  job.ID,
+				"status":  status,
+				"message": fmt.Sprintf("انتهت أرشفة مشاريع %s %d", job.Season, job.Year),
+			})
+		}
+	}()
+
+	for _, candidate := range candidates {
+		item := app.archiveCandidate(candidate, false)
+		item.JobID = job.ID
+		if err := app.Model.ArchiveJobDB.RecordArchiveResult(&item); err != nil {
+			app.log.Printf("archive job %s: %v", job.ID, err)
+			status = data.ArchiveJobFailed
+			message := err.Error()
+			jobErr = &message
+			return
+		}
+	}
+}
+
+// ArchiveTermHandler archives every defended or graded project of a term.
+// With dry_run it only reports which projects would pass validation;
+// otherwise it starts a background job and answers 202 with its id.
+func (app *application) ArchiveTermHandler(w http.ResponseWriter, r *http.Request) {
+	year, season, err := termPathValues(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	candidates, err := app.Model.ArchiveJobDB.ListArchiveCandidates(year, season, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+	if dryRun {
+		items := make([]data.ArchiveJobItem, len(candidates))
+		ready := 0
+		for i, candidate := range candidates {
+			items[i] = app.archiveCandidate(candidate, true)
+			if items[i].Status == data.ArchiveItemReady {
+				ready++
+			}
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"dry_run": true,
+			"total":   len(items),
+			"ready":   ready,
+			"failed":  len(items) - ready,
+			"items":   items,
+		})
+		return
+	}
+
+	if len(candidates) == 0 {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "لا توجد مشاريع جاهزة للأرشفة في هذا الفصل")
+		return
+	}
+
+	job := &data.ArchiveJob{
+		Year:      year,
+		Season:    season,
+		Total:     len(candidates),
+		StartedBy: &adminID,
+	}
+	if err := app.Model.ArchiveJobDB.InsertArchiveJob(job); err != nil {
+		if errors.Is(err, data.ErrArchiveJobRunning) {
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	go app.runArchiveJob(job, candidates)
+
+	utils.SendJSONResponse(w, http.StatusAccepted, utils.Envelope{
+		"dry_run": false,
+		"job":     job,
+		"message": "Archival started",
+	})
+}
+
+func (app *application) GetArchiveJobHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid job ID"))
+		return
+	}
+	job, err := app.Model.ArchiveJobDB.GetArchiveJob(jobID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"job": job})
+}
+
+func (app *application) ListArchiveJobsHandler(w http.ResponseWriter, r *http.Request) {
+	year, season, err := termPathValues(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	jobs, err := app.Model.ArchiveJobDB.ListArchiveJobs(year, season)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"jobs": jobs})
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"project/internal/data"
 	"project/utils"
+	"project/utils/pdf"
 	"project/utils/validator"
 	"strconv"
 	"strings"
@@ -106,20 +109,48 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		descriptionPtr = &description
 	}
 
+	subjectIDs, ok := app.readSubjects(w, r)
+	if !ok {
+		return
+	}
+
 	book := &data.Book{
 		Name:        name,
 		Description: descriptionPtr,
 		Year:        year,
 		Season:      strings.ToLower(season),
 		Degree:      &degree,
+		Keywords:    utils.ParseKeywords(r.FormValue("keywords")),
+		SubjectIDs:  subjectIDs,
+		FileAccess:  data.FileAccessPublic,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	if errs := readBookFileAccess(r, book); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
 
-	// Handle file upload
+	// Handle file upload; book files must be PDFs so their text can be
+	// indexed
+	var doc *pdf.Info
 	if file, fileHeader, err := r.FormFile("file"); err == nil {
 		defer file.Close()
-		fileName, err := utils.SaveFile(file, "books", fileHeader.Filename)
+		var content []byte
+		content, doc, err = readDocument(file)
+		if err != nil {
+			if errors.Is(err, errDocumentTooLarge) {
+				app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+				return
+			}
+			app.errorResponse(w, r, http.StatusBadRequest, "invalid file")
+			return
+		}
+		if doc == nil {
+			app.failedValidationResponse(w, r, map[string]string{"file": "يجب أن يكون الملف بصيغة PDF صالحة"})
+			return
+		}
+		fileName, err := utils.SaveFile(bytes.NewReader(content), "books", fileHeader.Filename)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "invalid file")
 			return
@@ -137,7 +168,7 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, 0.5)
+	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, documentText(doc), 0.5)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -190,6 +221,12 @@ func (app *application) CreateBookHandler(w http.ResponseWriter, r *http.Request
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if doc != nil {
+		if err := app.Model.BookDB.SetBookDocument(book.ID, doc); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
 	// Fetch the created book with details to return
 	createdBookWithDetails, err := app.Model.BookDB.GetBook(book.ID)
@@ -233,9 +270,96 @@ func (app *application) GetBookWithDetailsHandler(w http.ResponseWriter, r *http
 		app.handleRetrievalError(w, r, err)
 		return
 	}
+	app.recordBookEvent(r, id, data.BookEventView)
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"book": bookWithDetails})
 }
 
+// RestoreBookHandler reverses a book transfer made by mistake: the book is
+// deleted and its pre-project is active again with everything it had.
+func (app *application) RestoreBookHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	preProjectID, err := app.Model.BookDB.RestorePreProject(bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrNotArchived), errors.Is(err, data.ErrStudentHasProject):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
+		return
+	}
+
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"pre_project": preProject,
+		"message":     "Book transfer reverted successfully",
+	})
+}
+
+// GetBookHistoryHandler returns what a book carried over from its
+// pre-project: submitted versions, score sheets and milestone reports with
+// the advisors' comments.
+func (app *application) GetBookHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+
+	book, err := app.Model.BookDB.GetBook(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if book.SourcePreProjectID == nil {
+		app.errorResponse(w, r, http.StatusNotFound, data.ErrNotArchived.Error())
+		return
+	}
+	preProjectID := *book.SourcePreProjectID
+
+	versions, err := app.Model.PreProjectDB.ListPreProjectVersions(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	sheets, err := app.Model.GradingDB.ListSheets(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	for i := range sheets {
+		rubric, err := app.Model.GradingDB.GetRubric(sheets[i].RubricID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		total := data.SheetTotal(rubric, &sheets[i])
+		sheets[i].Total = &total
+	}
+	milestones, err := app.Model.MilestoneDB.ListMilestones(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"pre_project_id": preProjectID,
+		"versions":       versions,
+		"score_sheets":   sheets,
+		"milestones":     milestones,
+	})
+}
+
 func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse book ID from path
 	idStr := r.PathValue("id")
@@ -252,11 +376,25 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// The stored path, as the file is not shown while under embargo
+	storedFile, err := app.Model.BookDB.GetBookFile(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
 	// Prepare book for update
 	book := &data.Book{
-		ID:        bookID,
-		CreatedAt: existingBookWithDetails.Book.CreatedAt,
-		UpdatedAt: time.Now(),
+		ID:           bookID,
+		File:         storedFile,
+		FileAccess:   existingBookWithDetails.Book.FileAccess,
+		EmbargoUntil: existingBookWithDetails.Book.EmbargoUntil,
+		CreatedAt:    existingBookWithDetails.Book.CreatedAt,
+		UpdatedAt:    time.Now(),
+	}
+	if errs := readBookFileAccess(r, book); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
 	}
 
 	// Parse and update name if provided
@@ -308,28 +446,50 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 		book.Season = existingBookWithDetails.Book.Season
 	}
 
+	// Keywords and subjects are replaced whenever the field is sent, so an
+	// empty value clears them
+	if _, ok := r.Form["keywords"]; ok {
+		book.Keywords = utils.ParseKeywords(r.FormValue("keywords"))
+	} else {
+		book.Keywords = existingBookWithDetails.Book.Keywords
+	}
+	subjectIDs, ok := app.readSubjects(w, r)
+	if !ok {
+		return
+	}
+	book.SubjectIDs = subjectIDs
+
 	// Handle file upload
 	var file *string
 	var oldFile *string
+	var doc *pdf.Info
 	if uploadedFile, fileHeader, err := r.FormFile("file"); err == nil {
 		defer uploadedFile.Close()
-		fileName, err := utils.SaveFile(uploadedFile, "books", fileHeader.Filename)
+		var content []byte
+		content, doc, err = readDocument(uploadedFile)
+		if err != nil {
+			if errors.Is(err, errDocumentTooLarge) {
+				app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+				return
+			}
+			app.errorResponse(w, r, http.StatusBadRequest, "invalid file")
+			return
+		}
+		if doc == nil {
+			app.failedValidationResponse(w, r, map[string]string{"file": "يجب أن يكون الملف بصيغة PDF صالحة"})
+			return
+		}
+		fileName, err := utils.SaveFile(bytes.NewReader(content), "books", fileHeader.Filename)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "invalid file")
 			return
 		}
 		file = &fileName
-		oldFile = existingBookWithDetails.Book.File
+		oldFile = storedFile
 		book.File = file
 	} else if err != http.ErrMissingFile {
 		app.errorResponse(w, r, http.StatusBadRequest, "invalid file upload")
 		return
-	} else {
-		if existingBookWithDetails.Book.File != nil {
-			*existingBookWithDetails.Book.File = strings.TrimPrefix(*existingBookWithDetails.Book.File, data.Domain+"/")
-		}
-
-		existingBookWithDetails.File = existingBookWithDetails.Book.File
 	}
 
 	// Determine if user lists are being updated
@@ -431,7 +591,7 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, 0.3)
+	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, documentText(doc), 0.3)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -473,17 +633,22 @@ func (app *application) UpdateBookHandler(w http.ResponseWriter, r *http.Request
 	// Perform update
 	err = app.Model.BookDB.UpdateBook(book, discutants, advisors, students)
 	if err != nil {
-		if book.File != nil {
-			utils.DeleteFile(*book.File)
+		if file != nil {
+			utils.DeleteFile(*file)
 		}
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	if doc != nil {
+		if err := app.Model.BookDB.SetBookDocument(book.ID, doc); err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
 
-	// Delete old file if a new file was uploaded
-	if oldFile != nil {
-		*oldFile = strings.TrimPrefix(*oldFile, data.Domain+"/")
-
+	// Delete old file if a new file was uploaded; an archived project's file
+	// is still referenced by its version history
+	if oldFile != nil && existingBookWithDetails.SourcePreProjectID == nil {
 		if err := utils.DeleteFile(*oldFile); err != nil {
 
 			log.Printf("Failed to delete old file %s: %v", *oldFile, err)
@@ -552,3 +717,138 @@ func (app *application) ListBooksHandler(w http.ResponseWriter, r *http.Request)
 		"books": books,
 	})
 }
+
+// readPage reads page and per_page, 1 and 20 when absent.
+func readPage(v *validator.Validator, queryParams url.Values) (int, int) {
+	page, perPage := 1, 20
+	if param := queryParams.Get("page"); param != "" {
+		var err error
+		page, err = strconv.Atoi(param)
+		v.Check(err == nil && page > 0, "page", "رقم الصفحة غير صالح")
+	}
+	if param := queryParams.Get("per_page"); param != "" {
+		var err error
+		perPage, err = strconv.Atoi(param)
+		v.Check(err == nil && perPage > 0 && perPage <= 100, "per_page", "يجب أن يكون عدد النتائج في الصفحة بين 1 و 100")
+	}
+	return page, perPage
+}
+
+// SearchBooksHandler is the archive's full-text search. It takes the search
+// in q, optional year and season filters and page/per_page (1 and 20 by
+// default).
+func (app *application) SearchBooksHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	search := strings.TrimSpace(queryParams.Get("q"))
+
+	v := validator.New()
+	v.Check(search != "", "q", "نص البحث مطلوب")
+	v.Check(len(search) <= 200, "q", "نص البحث طويل جداً")
+
+	var filter data.BookSearchFilter
+	if yearStr := queryParams.Get("year"); yearStr != "" {
+		year, err := strconv.Atoi(yearStr)
+		v.Check(err == nil, "year", "السنة غير صالحة")
+		filter.Year = &year
+	}
+	if season := strings.ToLower(queryParams.Get("season")); season != "" {
+		v.Check(validator.In(season, "spring", "fall"), "season", "يجب اختيار موسم ربيع أو خريف")
+		filter.Season = season
+	}
+
+	page, perPage := readPage(v, queryParams)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, meta, err := app.Model.BookDB.SearchBooks(search, filter, page, perPage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"meta":  meta,
+		"books": books,
+	})
+}
+
+// listParam collects a multi-valued query parameter given either repeated
+// (?year=2023&year=2024) or comma separated (?year=2023,2024).
+func listParam(queryParams url.Values, key string) []string {
+	var values []string
+	for _, param := range queryParams[key] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// readBrowseFilter reads the drill-down selections shared by the browse view
+// and the citation export.
+func readBrowseFilter(v *validator.Validator, queryParams url.Values) data.BookBrowseFilter {
+	filter := data.BookBrowseFilter{
+		Search:   strings.TrimSpace(queryParams.Get("q")),
+		Keywords: listParam(queryParams, "keyword"),
+	}
+	v.Check(len(filter.Search) <= 200, "q", "نص البحث طويل جداً")
+	for _, value := range listParam(queryParams, "year") {
+		year, err := strconv.Atoi(value)
+		v.Check(err == nil, "year", "السنة غير صالحة")
+		filter.Years = append(filter.Years, year)
+	}
+	for _, value := range listParam(queryParams, "season") {
+		season := strings.ToLower(value)
+		v.Check(validator.In(season, "spring", "fall"), "season", "يجب اختيار موسم ربيع أو خريف")
+		filter.Seasons = append(filter.Seasons, season)
+	}
+	for _, value := range listParam(queryParams, "advisor") {
+		id, err := uuid.Parse(value)
+		v.Check(err == nil, "advisor", "معرف المشرف غير صالح")
+		filter.Advisors = append(filter.Advisors, id)
+	}
+	for _, value := range listParam(queryParams, "discussant") {
+		id, err := uuid.Parse(value)
+		v.Check(err == nil, "discussant", "معرف المناقش غير صالح")
+		filter.Discussants = append(filter.Discussants, id)
+	}
+	bands := make([]string, len(data.GradeBands))
+	for i, band := range data.GradeBands {
+		bands[i] = band.Name
+	}
+	for _, value := range listParam(queryParams, "grade") {
+		v.Check(validator.In(value, bands...), "grade", "فئة الدرجة غير صالحة")
+		filter.Grades = append(filter.Grades, value)
+	}
+	return filter
+}
+
+// BrowseBooksHandler serves the archive's drill-down view: a page of books
+// matching the selected year, season, advisor, discussant, keyword and
+// grade values (and q, when given) plus the facet counts to build the
+// sidebar from.
+func (app *application) BrowseBooksHandler(w http.ResponseWriter, r *http.Request) {
+	queryParams := r.URL.Query()
+	v := validator.New()
+
+	filter := readBrowseFilter(v, queryParams)
+	page, perPage := readPage(v, queryParams)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, facets, meta, err := app.Model.BookDB.BrowseBooks(filter, page, perPage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"meta":   meta,
+		"books":  books,
+		"facets": facets,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// relatedCache keeps the related book scores of each book for one state of
+// the archive. Any change to a book can change what is related to the
+// others, so all of them are dropped when the archive stamp changes. The
+// books themselves are loaded on every request.
+var relatedCache = struct {
+	sync.Mutex
+	stamp string
+	books map[uuid.UUID][]data.RelatedScore
+}{books: map[uuid.UUID][]data.RelatedScore{}}
+
+// relatedScores returns the scores of the most related books of a book, up
+// to data.MaxRelatedBooks, from the cache when the archive has not changed.
+func (app *application) relatedScores(bookID uuid.UUID) ([]data.RelatedScore, error) {
+	stamp, err := app.Model.BookDB.GetArchiveStamp()
+	if err != nil {
+		return nil, err
+	}
+
+	relatedCache.Lock()
+	if relatedCache.stamp != stamp {
+		relatedCache.stamp = stamp
+		relatedCache.books = map[uuid.UUID][]data.RelatedScore{}
+	}
+	scores, ok := relatedCache.books[bookID]
+	relatedCache.Unlock()
+	if ok {
+		return scores, nil
+	}
+
+	scores, err = app.Model.BookDB.ListRelatedScores(bookID, data.MaxRelatedBooks)
+	if err != nil {
+		return nil, err
+	}
+	relatedCache.Lock()
+	if relatedCache.stamp == stamp {
+		relatedCache.books[bookID] = scores
+	}
+	relatedCache.Unlock()
+	return scores, nil
+}
+
+// RelatedBooksHandler lists the theses related to a book by their text,
+// subjects, keywords and advisors, so the archive can be explored from one
+// to the next.
+func (app *application) RelatedBooksHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+	limit, err := queryInt(r, "limit", data.DefaultRelatedBooks, data.MaxRelatedBooks)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	scores, err := app.relatedScores(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if len(scores) > limit {
+		scores = scores[:limit]
+	}
+	books, err := app.Model.BookDB.GetRelatedBooks(scores)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"related": books})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// lastEventPrune is the day the past deduplication rows were last
+	// dropped, so it happens once a day from the first event recorded
+	lastEventPrune   string
+	lastEventPruneMu sync.Mutex
+)
+
+// bookVisitor identifies who viewed or downloaded a book, for counting them
+// once a day: the user when signed in, otherwise a hash of their address so
+// it is not stored as is.
+func bookVisitor(r *http.Request) string {
+	if userID, ok := r.Context().Value(UserIDKey).(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	sum := sha256.Sum256([]byte(host))
+	return "ip:" + hex.EncodeToString(sum[:16])
+}
+
+// recordBookEvent counts a view or download of a book. A failure is only
+// logged, it never fails the request being served.
+func (app *application) recordBookEvent(r *http.Request, bookID uuid.UUID, kind string) {
+	if _, err := app.Model.BookDB.RecordBookEvent(bookID, kind, bookVisitor(r)); err != nil {
+		app.log.Printf("book %s %s: %v", bookID, kind, err)
+	}
+
+	today := time.Now().Format("2006-01-02")
+	lastEventPruneMu.Lock()
+	prune := lastEventPrune != today
+	lastEventPrune = today
+	lastEventPruneMu.Unlock()
+	if prune {
+		go func() {
+			if err := app.Model.BookDB.PruneBookEvents(); err != nil {
+				app.log.Printf("prune book events: %v", err)
+			}
+		}()
+	}
+}
+
+// recordBookDownload counts the download of a stored file when it is the
+// file of a book.
+func (app *application) recordBookDownload(r *http.Request, file string) {
+	bookID, err := app.Model.BookDB.GetBookIDByFile(file)
+	if err != nil {
+		if !errors.Is(err, data.ErrRecordNotFound) {
+			app.log.Printf("book download %s: %v", file, err)
+		}
+		return
+	}
+	app.recordBookEvent(r, bookID, data.BookEventDownload)
+}
+
+// statusRecorder keeps the status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// queryInt reads a positive integer query parameter, with def when it is
+// missing and limit as its upper bound.
+func queryInt(r *http.Request, name string, def, limit int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid " + name)
+	}
+	if n > limit {
+		n = limit
+	}
+	return n, nil
+}
+
+func (app *application) BookStatsHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+	days, err := queryInt(r, "days", data.DefaultStatsDays, data.MaxStatsDays)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	stats, err := app.Model.BookDB.GetBookStats(bookID, days)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"stats": stats})
+}
+
+// PopularBooksHandler lists the most downloaded books of a term: the one in
+// ?year and ?season, or else the latest term that has started.
+func (app *application) PopularBooksHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", data.DefaultPopularSize, data.MaxPopularBooks)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var term *data.AcademicTerm
+	if yearStr := r.URL.Query().Get("year"); yearStr != "" {
+		year, convErr := strconv.Atoi(yearStr)
+		if convErr != nil {
+			app.badRequestResponse(w, r, errors.New("invalid year"))
+			return
+		}
+		season := strings.ToLower(r.URL.Query().Get("season"))
+		if season != "spring" && season != "fall" {
+			app.badRequestResponse(w, r, errors.New("invalid season"))
+			return
+		}
+		term, err = app.Model.AcademicTermDB.GetTerm(year, season)
+	} else {
+		term, err = app.Model.AcademicTermDB.GetLatestTerm(time.Now())
+	}
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	books, err := app.Model.BookDB.ListPopularBooks(term.ProposalOpen, term.DefenseEnd, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"term":  utils.Envelope{"year": term.Year, "season": term.Season, "from": term.ProposalOpen, "to": term.DefenseEnd},
+		"books": books,
+	})
+}
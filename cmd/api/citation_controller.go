@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const citationGenre = "Graduation project"
+
+type citationFormat struct {
+	contentType string
+	extension   string
+	render      func(books []data.BookWithDetails, institution string) ([]byte, error)
+}
+
+var citationFormats = map[string]citationFormat{
+	"bibtex":   {"application/x-bibtex; charset=utf-8", "bib", bibtexCitations},
+	"ris":      {"application/x-research-info-systems; charset=utf-8", "ris", risCitations},
+	"csl-json": {"application/vnd.citationstyles.csl+json; charset=utf-8", "json", cslCitations},
+	"apa":      {"text/plain; charset=utf-8", "txt", apaCitations},
+}
+
+// readCitationFormat returns the ?format= of a citation request, BibTeX when
+// none is given.
+func readCitationFormat(v *validator.Validator, r *http.Request) (citationFormat, string) {
+	name := strings.ToLower(r.URL.Query().Get("format"))
+	if name == "" {
+		name = "bibtex"
+	}
+	format, ok := citationFormats[name]
+	v.Check(ok, "format", "صيغة الاقتباس يجب أن تكون bibtex أو ris أو csl-json أو apa")
+	return format, name
+}
+
+func citationSeason(season string) string {
+	switch season {
+	case "spring":
+		return "Spring"
+	case "fall":
+		return "Fall"
+	}
+	return season
+}
+
+func citationNames(people []data.UserDetails) []string {
+	names := make([]string, len(people))
+	for i, person := range people {
+		names[i] = person.Name
+	}
+	return names
+}
+
+func citationFile(book *data.BookWithDetails) string {
+	if book.File == nil {
+		return ""
+	}
+	return *book.File
+}
+
+// oneLine folds the line breaks of descriptions for the line-based formats.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+// bibtexCitations writes biblatex @thesis entries. Names are braced so the
+// Arabic full names are not split into first and last names.
+func bibtexCitations(books []data.BookWithDetails, institution string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := range books {
+		book := &books[i]
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(&buf, "@thesis{thesis%d_%s,\n", book.Year, strings.ReplaceAll(book.ID.String(), "-", "")[:8])
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(&buf, "  %s = {%s},\n", name, value)
+			}
+		}
+
+		authors := make([]string, len(book.Students))
+		for i, name := range citationNames(book.Students) {
+			authors[i] = "{" + bibtexEscaper.Replace(name) + "}"
+		}
+		field("author", strings.Join(authors, " and "))
+		field("title", bibtexEscaper.Replace(book.Name))
+		field("type", citationGenre)
+		field("institution", bibtexEscaper.Replace(institution))
+		field("year", strconv.Itoa(book.Year))
+
+		note := citationSeason(book.Season) + " " + strconv.Itoa(book.Year)
+		if advisors := citationNames(book.Advisors); len(advisors) > 0 {
+			note = "Advisor: " + strings.Join(advisors, ", ") + ". " + note
+		}
+		field("note", bibtexEscaper.Replace(note))
+		field("keywords", bibtexEscaper.Replace(strings.Join(book.Keywords, ", ")))
+		field("url", citationFile(book))
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// risCitations writes RIS records; advisors are tertiary authors (A3), the
+// tag RIS uses for thesis advisors.
+func risCitations(books []data.BookWithDetails, institution string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := range books {
+		book := &books[i]
+		tag := func(name, value string) {
+			if value = oneLine(value); value != "" {
+				fmt.Fprintf(&buf, "%s  - %s\r\n", name, value)
+			}
+		}
+
+		tag("TY", "THES")
+		tag("TI", book.Name)
+		for _, name := range citationNames(book.Students) {
+			tag("AU", name)
+		}
+		for _, name := range citationNames(book.Advisors) {
+			tag("A3", name)
+		}
+		tag("PY", strconv.Itoa(book.Year))
+		tag("DA", fmt.Sprintf("%d///%s", book.Year, citationSeason(book.Season)))
+		tag("PB", institution)
+		tag("M3", citationGenre)
+		for _, keyword := range book.Keywords {
+			tag("KW", keyword)
+		}
+		if book.Description != nil {
+			tag("AB", *book.Description)
+		}
+		tag("UR", citationFile(book))
+		buf.WriteString("ER  - \r\n\r\n")
+	}
+	return buf.Bytes(), nil
+}
+
+type cslName struct {
+	Literal string `json:"literal"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+	Season    int     `json:"season,omitempty"`
+}
+
+type cslItem struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	Author      []cslName `json:"author,omitempty"`
+	Contributor []cslName `json:"contributor,omitempty"`
+	Issued      cslDate   `json:"issued"`
+	Publisher   string    `json:"publisher,omitempty"`
+	Genre       string    `json:"genre"`
+	Abstract    string    `json:"abstract,omitempty"`
+	Keyword     string    `json:"keyword,omitempty"`
+	URL         string    `json:"URL,omitempty"`
+}
+
+// cslSeasons are the CSL season numbers of the terms.
+var cslSeasons = map[string]int{"spring": 1, "fall": 3}
+
+func cslCitations(books []data.BookWithDetails, institution string) ([]byte, error) {
+	items := make([]cslItem, len(books))
+	for i := range books {
+		book := &books[i]
+		item := cslItem{
+			ID:        book.ID.String(),
+			Type:      "thesis",
+			Title:     book.Name,
+			Issued:    cslDate{DateParts: [][]int{{book.Year}}, Season: cslSeasons[book.Season]},
+			Publisher: institution,
+			Genre:     citationGenre,
+			Keyword:   strings.Join(book.Keywords, ", "),
+			URL:       citationFile(book),
+		}
+		for _, name := range citationNames(book.Students) {
+			item.Author = append(item.Author, cslName{name})
+		}
+		for _, name := range citationNames(book.Advisors) {
+			item.Contributor = append(item.Contributor, cslName{name})
+		}
+		if book.Description != nil {
+			item.Abstract = *book.Description
+		}
+		items[i] = item
+	}
+	return json.MarshalIndent(items, "", "  ")
+}
+
+// apaCitations writes APA 7 references, one paragraph per book.
+func apaCitations(books []data.BookWithDetails, institution string) ([]byte, error) {
+	var buf bytes.Buffer
+	for i := range books {
+		book := &books[i]
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+		authors := citationNames(book.Students)
+		switch len(authors) {
+		case 0:
+		case 1:
+			buf.WriteString(authors[0] + " ")
+		default:
+			buf.WriteString(strings.Join(authors[:len(authors)-1], ", ") + ", & " + authors[len(authors)-1] + " ")
+		}
+		fmt.Fprintf(&buf, "(%d). %s [%s, %s].", book.Year, oneLine(book.Name), citationGenre, institution)
+		if file := citationFile(book); file != "" {
+			buf.WriteString(" " + file)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func (app *application) writeCitations(w http.ResponseWriter, r *http.Request, format citationFormat, books []data.BookWithDetails, filename string) {
+	body, err := format.render(books, app.cfg.institution)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, filename, format.extension))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// CiteBookHandler returns the citation of a book in ?format= (bibtex, ris,
+// csl-json or apa).
+func (app *application) CiteBookHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+	v := validator.New()
+	format, _ := readCitationFormat(v, r)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	book, err := app.Model.BookDB.GetBook(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	app.writeCitations(w, r, format, []data.BookWithDetails{*book}, "book-"+bookID.String())
+}
+
+// ExportCitationsHandler exports the citations of every book matching the
+// browse filters (q, year, season, advisor, discussant, keyword, grade).
+func (app *application) ExportCitationsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+	format, name := readCitationFormat(v, r)
+	filter := readBrowseFilter(v, r.URL.Query())
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	books, err := app.Model.BookDB.ListCitationBooks(filter)
+	if err != nil {
+		if errors.Is(err, data.ErrTooManyCitations) {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.writeCitations(w, r, format, books, "citations-"+name)
+}
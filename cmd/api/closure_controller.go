@@ -0,0 +1,338 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// isPreProjectStudent reports whether the user is one of the project's team.
+func isPreProjectStudent(details *data.PreProjectWithAdvisorDetails, userID uuid.UUID) bool {
+	if details.PreProject.ProjectOwner == userID {
+		return true
+	}
+	for _, student := range details.Students {
+		if student.StudentID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyPreProjectMembers sends a websocket message to the students,
+// advisors and discussants of a project.
+func (app *application) notifyPreProjectMembers(details *data.PreProjectWithAdvisorDetails, message map[string]interface{}) {
+	notified := map[uuid.UUID]bool{}
+	notify := func(id uuid.UUID) {
+		if notified[id] {
+			return
+		}
+		notified[id] = true
+		app.wsManager.BroadcastMessage(id, message)
+	}
+
+	notify(details.PreProject.ProjectOwner)
+	for _, student := range details.Students {
+		notify(student.StudentID)
+	}
+	for _, advisor := range details.Advisors {
+		notify(advisor.AdvisorID)
+	}
+	for _, discussant := range details.Discussants {
+		notify(discussant.DiscussantID)
+	}
+}
+
+// RequestWithdrawalHandler lets a student of the project ask to withdraw it.
+// The project stays active until an admin approves the request.
+func (app *application) RequestWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if !isPreProjectStudent(details, userID) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	closure := &data.Closure{
+		PreProjectID: preProjectID,
+		Reason:       strings.TrimSpace(r.FormValue("reason")),
+		RequestedBy:  &userID,
+	}
+	v := validator.New()
+	data.ValidateClosureReason(v, closure.Reason)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.ClosureDB.RequestWithdrawal(closure); err != nil {
+		switch {
+		case errors.Is(err, data.ErrClosurePending):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
+		return
+	}
+
+	app.notifyPreProjectMembers(details, map[string]interface{}{
+		"type":           "withdrawal_requested",
+		"pre_project_id": preProjectID,
+		"message":        "تم تقديم طلب انسحاب من المشروع: " + details.PreProject.Name,
+	})
+
+	created, err := app.Model.ClosureDB.GetClosure(closure.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"withdrawal": created})
+}
+
+// ListPreProjectClosuresHandler shows a project's withdrawal requests and
+// cancellations to its members.
+func (app *application) ListPreProjectClosuresHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	closures, err := app.Model.ClosureDB.ListClosures("", &preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"closures": closures})
+}
+
+// ListWithdrawalsHandler lists withdrawal requests and cancellations for
+// admins, the pending ones by default; ?status=all lists every one.
+func (app *application) ListWithdrawalsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = data.ClosurePending
+	case "all":
+		status = ""
+	default:
+		if !validator.In(status, data.ClosurePending, data.ClosureApproved, data.ClosureRejected,
+			data.ClosureRetracted, data.ClosureSuperseded) {
+			app.failedValidationResponse(w, r, map[string]string{"status": "حالة الطلب غير صالحة"})
+			return
+		}
+	}
+
+	closures, err := app.Model.ClosureDB.ListClosures(status, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"withdrawals": closures})
+}
+
+// getPendingWithdrawal loads the withdrawal request in the path.
+func (app *application) getPendingWithdrawal(w http.ResponseWriter, r *http.Request) (*data.Closure, bool) {
+	closureID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid withdrawal ID"))
+		return nil, false
+	}
+	closure, err := app.Model.ClosureDB.GetClosure(closureID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if closure.Kind != data.ClosureWithdrawal {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return nil, false
+	}
+	if closure.Status != data.ClosurePending {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrClosureReviewed.Error())
+		return nil, false
+	}
+	return closure, true
+}
+
+func reviewNote(r *http.Request) *string {
+	if note := strings.TrimSpace(r.FormValue("note")); note != "" {
+		return &note
+	}
+	return nil
+}
+
+func (app *application) handleClosureError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, data.ErrClosureReviewed):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.handleRetrievalError(w, r, err)
+	}
+}
+
+// ApproveWithdrawalHandler accepts a withdrawal request. The project is
+// closed but kept, its students may propose again and everyone involved is
+// notified.
+func (app *application) ApproveWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	closure, ok := app.getPendingWithdrawal(w, r)
+	if !ok {
+		return
+	}
+
+	// Loaded before closing: archived projects are no longer returned.
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(closure.PreProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	outcome, err := app.Model.ClosureDB.ApproveWithdrawal(closure.ID, adminID, reviewNote(r))
+	if err != nil {
+		app.handleClosureError(w, r, err)
+		return
+	}
+
+	app.notifyPreProjectMembers(details, map[string]interface{}{
+		"type":           "pre_project_withdrawn",
+		"pre_project_id": closure.PreProjectID,
+		"message":        "تمت الموافقة على الانسحاب من المشروع: " + details.PreProject.Name,
+	})
+
+	updated, err := app.Model.ClosureDB.GetClosure(closure.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"withdrawal": updated, "outcome": outcome})
+}
+
+func (app *application) RejectWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	closure, ok := app.getPendingWithdrawal(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Model.ClosureDB.RejectWithdrawal(closure.ID, adminID, reviewNote(r)); err != nil {
+		app.handleClosureError(w, r, err)
+		return
+	}
+
+	if closure.RequestedBy != nil {
+		app.wsManager.BroadcastMessage(*closure.RequestedBy, map[string]interface{}{
+			"type":           "withdrawal_rejected",
+			"pre_project_id": closure.PreProjectID,
+			"message":        "تم رفض طلب الانسحاب من المشروع: " + closure.ProjectName,
+		})
+	}
+
+	updated, err := app.Model.ClosureDB.GetClosure(closure.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"withdrawal": updated})
+}
+
+// RetractWithdrawalHandler lets the student who asked to withdraw take the
+// request back while it is still pending.
+func (app *application) RetractWithdrawalHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	closure, ok := app.getPendingWithdrawal(w, r)
+	if !ok {
+		return
+	}
+	if closure.RequestedBy == nil || *closure.RequestedBy != userID {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.Model.ClosureDB.RetractWithdrawal(closure.ID); err != nil {
+		app.handleClosureError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Withdrawal request retracted successfully"})
+}
+
+// CancelPreProjectHandler lets an admin end a project outright. Unlike
+// DELETE preproject/{id} the project and its history are kept.
+func (app *application) CancelPreProjectHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	closure := &data.Closure{
+		PreProjectID: preProjectID,
+		Reason:       strings.TrimSpace(r.FormValue("reason")),
+		RequestedBy:  &adminID,
+	}
+	v := validator.New()
+	data.ValidateClosureReason(v, closure.Reason)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	outcome, err := app.Model.ClosureDB.CancelPreProject(closure)
+	if err != nil {
+		app.handleClosureError(w, r, err)
+		return
+	}
+
+	app.notifyPreProjectMembers(details, map[string]interface{}{
+		"type":           "pre_project_cancelled",
+		"pre_project_id": preProjectID,
+		"message":        "تم إلغاء المشروع: " + details.PreProject.Name,
+	})
+
+	created, err := app.Model.ClosureDB.GetClosure(closure.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"cancellation": created, "outcome": outcome})
+}
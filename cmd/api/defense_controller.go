@@ -0,0 +1,467 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultDefenseMinutes = 60
+
+// parseClock parses an "HH:MM" time of day into an offset from midnight.
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func readDuration(r *http.Request, fallback int) (int, error) {
+	value := r.FormValue("duration")
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// checkDefensePeriod makes sure a defense falls inside the defense period of
+// its term. Terms that were never configured leave the date unrestricted.
+func (app *application) checkDefensePeriod(year int, season string, defense *data.Defense) (map[string]string, error) {
+	term, err := app.Model.AcademicTermDB.GetTerm(year, season)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !term.InDefensePeriod(defense.StartsAt) || !term.InDefensePeriod(defense.EndsAt()) {
+		return map[string]string{
+			"starts_at": "يجب أن تكون المناقشة ضمن فترة المناقشات (" +
+				term.DefenseStart.Format("2006-01-02") + " - " + term.DefenseEnd.Format("2006-01-02") + ")",
+		}, nil
+	}
+	return nil, nil
+}
+
+// scheduleErrorResponse reports conflicts with the clashing defenses so the
+// admin can pick another slot.
+func (app *application) scheduleErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var conflictErr *data.ScheduleConflictError
+	switch {
+	case errors.As(err, &conflictErr):
+		utils.SendJSONResponse(w, http.StatusConflict, utils.Envelope{
+			"error":     "الموعد المحدد يتعارض مع مناقشات أخرى",
+			"conflicts": conflictErr.Conflicts,
+		})
+	case errors.Is(err, data.ErrDefenseAlreadyScheduled):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	default:
+		app.handleRetrievalError(w, r, err)
+	}
+}
+
+func (app *application) notifyDefense(defense *data.DefenseWithDetails, message string) {
+	notification := map[string]interface{}{
+		"type":    "defense_scheduled",
+		"defense": defense,
+		"message": message,
+	}
+	for _, member := range defense.Committee {
+		app.wsManager.BroadcastMessage(member.UserID, notification)
+	}
+	for _, student := range defense.Students {
+		app.wsManager.BroadcastMessage(student.ID, notification)
+	}
+}
+
+func (app *application) ScheduleDefenseHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	startsAt, err := parseTimeValue(r.FormValue("starts_at"))
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"starts_at": "تنسيق التاريخ غير صالح"})
+		return
+	}
+	duration, err := readDuration(r, defaultDefenseMinutes)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid duration"))
+		return
+	}
+
+	defense := &data.Defense{
+		PreProjectID:    preProjectID,
+		StartsAt:        startsAt,
+		DurationMinutes: duration,
+		Room:            strings.TrimSpace(r.FormValue("room")),
+		CreatedBy:       &adminID,
+	}
+	if details.PreProject.AcceptedAdvisor != nil {
+		defense.AdvisorID = *details.PreProject.AcceptedAdvisor
+	}
+
+	v := validator.New()
+	data.ValidateDefense(v, defense)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	errs, err := app.checkDefensePeriod(details.PreProject.Year, details.PreProject.Season, defense)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	discussantIDs := make([]uuid.UUID, 0, len(details.Discussants))
+	for _, discussant := range details.Discussants {
+		discussantIDs = append(discussantIDs, discussant.DiscussantID)
+	}
+
+	if err := app.Model.DefenseDB.ScheduleDefense(defense, discussantIDs); err != nil {
+		app.scheduleErrorResponse(w, r, err)
+		return
+	}
+
+	scheduled, err := app.Model.DefenseDB.GetDefense(defense.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.notifyDefense(scheduled, "تم تحديد موعد مناقشة المشروع")
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"defense": scheduled})
+}
+
+func (app *application) RescheduleDefenseHandler(w http.ResponseWriter, r *http.Request) {
+	defenseID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid defense ID"))
+		return
+	}
+
+	current, err := app.Model.DefenseDB.GetDefense(defenseID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	defense := current.Defense
+
+	if value := r.FormValue("starts_at"); value != "" {
+		defense.StartsAt, err = parseTimeValue(value)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"starts_at": "تنسيق التاريخ غير صالح"})
+			return
+		}
+	}
+	defense.DurationMinutes, err = readDuration(r, defense.DurationMinutes)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid duration"))
+		return
+	}
+	if room := strings.TrimSpace(r.FormValue("room")); room != "" {
+		defense.Room = room
+	}
+
+	v := validator.New()
+	data.ValidateDefense(v, &defense)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	errs, err := app.checkDefensePeriod(current.Year, current.Season, &defense)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if errs != nil {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	if err := app.Model.DefenseDB.RescheduleDefense(&defense); err != nil {
+		app.scheduleErrorResponse(w, r, err)
+		return
+	}
+
+	rescheduled, err := app.Model.DefenseDB.GetDefense(defense.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.notifyDefense(rescheduled, "تم تعديل موعد مناقشة المشروع")
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"defense": rescheduled})
+}
+
+func (app *application) DeleteDefenseHandler(w http.ResponseWriter, r *http.Request) {
+	defenseID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid defense ID"))
+		return
+	}
+
+	if err := app.Model.DefenseDB.DeleteDefense(defenseID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "defense cancelled successfully"})
+}
+
+func (app *application) GetPreProjectDefenseHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	defense, err := app.Model.DefenseDB.GetDefenseByPreProject(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"defense": defense})
+}
+
+// PublicScheduleHandler lists upcoming defenses. It only exposes what is
+// announced on the department board: project, the names and roles of the
+// students and committee, time and room.
+func (app *application) PublicScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	from := time.Now()
+	if value := query.Get("from"); value != "" {
+		t, err := parseTimeValue(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid from date"))
+			return
+		}
+		from = t
+	}
+	year := 0
+	if value := query.Get("year"); value != "" {
+		y, err := strconv.Atoi(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid year"))
+			return
+		}
+		year = y
+	}
+
+	defenses, err := app.Model.DefenseDB.ListUpcomingDefenses(from, year, strings.ToLower(query.Get("season")), query.Get("room"), 200)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"defenses": defenses})
+}
+
+// AutoScheduleDefensesHandler proposes, and unless dry_run is set books, a slot
+// for every accepted project of a term that has no defense yet.
+func (app *application) AutoScheduleDefensesHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	year, err := strconv.Atoi(r.FormValue("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return
+	}
+	season := strings.ToLower(r.FormValue("season"))
+
+	term, err := app.Model.AcademicTermDB.GetTerm(year, season)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	opts := data.AutoScheduleOptions{
+		From:     term.DefenseStart,
+		To:       term.DefensePeriodEnd(),
+		DayStart: 9 * time.Hour,
+		DayEnd:   15 * time.Hour,
+	}
+	if opts.From.Before(time.Now()) {
+		opts.From = time.Now()
+	}
+
+	errs := map[string]string{}
+	for _, room := range strings.Split(r.FormValue("rooms"), ",") {
+		if room = strings.TrimSpace(room); room != "" {
+			opts.Rooms = append(opts.Rooms, room)
+		}
+	}
+	if len(opts.Rooms) == 0 {
+		errs["rooms"] = "يجب تحديد قاعة واحدة على الأقل"
+	}
+	if opts.DurationMinutes, err = readDuration(r, defaultDefenseMinutes); err != nil || opts.DurationMinutes < 15 {
+		errs["duration"] = "مدة المناقشة غير صالحة"
+	}
+	if value := r.FormValue("day_start"); value != "" {
+		if opts.DayStart, err = parseClock(value); err != nil {
+			errs["day_start"] = "يجب أن يكون الوقت بصيغة HH:MM"
+		}
+	}
+	if value := r.FormValue("day_end"); value != "" {
+		if opts.DayEnd, err = parseClock(value); err != nil {
+			errs["day_end"] = "يجب أن يكون الوقت بصيغة HH:MM"
+		}
+	}
+	if opts.DayEnd <= opts.DayStart {
+		errs["day_end"] = "يجب أن تكون نهاية اليوم بعد بدايته"
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	candidates, err := app.Model.DefenseDB.ListDefenseCandidates(year, season)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	planned, unplanned, err := app.Model.DefenseDB.PlanDefenses(candidates, opts)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+	if dryRun {
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+			"dry_run":   true,
+			"planned":   planned,
+			"unplanned": unplanned,
+		})
+		return
+	}
+
+	// Slots are re-checked on insert, so a defense booked by someone else in
+	// the meantime moves the project to the unplanned list instead of clashing
+	scheduled := []data.PlannedDefense{}
+	for _, plan := range planned {
+		defense := &data.Defense{
+			PreProjectID:    plan.PreProjectID,
+			StartsAt:        plan.StartsAt,
+			DurationMinutes: opts.DurationMinutes,
+			Room:            plan.Room,
+			AdvisorID:       plan.AdvisorID,
+			CreatedBy:       &adminID,
+		}
+		if err := app.Model.DefenseDB.ScheduleDefense(defense, plan.Discussants); err != nil {
+			unplanned = append(unplanned, data.UnplannedDefense{
+				PreProjectID: plan.PreProjectID,
+				ProjectName:  plan.ProjectName,
+				Reason:       err.Error(),
+			})
+			continue
+		}
+		scheduled = append(scheduled, plan)
+		if details, err := app.Model.DefenseDB.GetDefense(defense.ID); err == nil {
+			app.notifyDefense(details, "تم تحديد موعد مناقشة المشروع")
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"dry_run":   false,
+		"scheduled": scheduled,
+		"unplanned": unplanned,
+	})
+}
+
+func (app *application) AddAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	availability := &data.Availability{UserID: userID}
+	errs := map[string]string{}
+	if availability.StartsAt, err = parseTimeValue(r.FormValue("starts_at")); err != nil {
+		errs["starts_at"] = "تنسيق التاريخ غير صالح"
+	}
+	if availability.EndsAt, err = parseTimeValue(r.FormValue("ends_at")); err != nil {
+		errs["ends_at"] = "تنسيق التاريخ غير صالح"
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateAvailability(v, availability)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.DefenseDB.InsertAvailability(availability); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"availability": availability})
+}
+
+func (app *application) ListAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	slots, err := app.Model.DefenseDB.ListAvailability(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"availability": slots})
+}
+
+func (app *application) DeleteAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	slotID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid availability ID"))
+		return
+	}
+
+	if err := app.Model.DefenseDB.DeleteAvailability(slotID, userID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "availability removed successfully"})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+const defaultDiscussantsPerProject = 2
+
+// isAdminRequest reports whether the current user has the admin role.
+func isAdminRequest(r *http.Request) bool {
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	for _, role := range roles {
+		if role == "admin" {
+			return true
+		}
+	}
+	return false
+}
+
+// ListDiscussantConflictsHandler lists declared conflicts. Admins see all of
+// them, or one examiner's with ?discussant_id; teachers see their own.
+func (app *application) ListDiscussantConflictsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	discussantID := &userID
+	if isAdminRequest(r) {
+		discussantID = nil
+		if param := r.URL.Query().Get("discussant_id"); param != "" {
+			id, err := uuid.Parse(param)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New("invalid discussant ID"))
+				return
+			}
+			discussantID = &id
+		}
+	}
+
+	conflicts, err := app.Model.DiscussantDB.ListConflicts(discussantID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"conflicts": conflicts})
+}
+
+// CreateDiscussantConflictHandler declares a conflict of interest with a
+// user (user_email) or a project (pre_project_id). Teachers declare their
+// own; admins may declare one for any examiner with discussant_email.
+func (app *application) CreateDiscussantConflictHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	conflict := &data.DiscussantConflict{DiscussantID: userID, DeclaredBy: &userID}
+	if email := r.FormValue("discussant_email"); email != "" && isAdminRequest(r) {
+		discussant, err := app.Model.UserDB.GetUserByEmail(email)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid discussant email")
+			return
+		}
+		conflict.DiscussantID = discussant.ID
+	}
+	if email := r.FormValue("user_email"); email != "" {
+		user, err := app.Model.UserDB.GetUserByEmail(email)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid user email")
+			return
+		}
+		conflict.UserID = &user.ID
+	}
+	if id := r.FormValue("pre_project_id"); id != "" {
+		preProjectID, err := uuid.Parse(id)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+			return
+		}
+		conflict.PreProjectID = &preProjectID
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		conflict.Reason = &reason
+	}
+
+	v := validator.New()
+	data.ValidateDiscussantConflict(v, conflict)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.DiscussantDB.InsertConflict(conflict); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatedKey):
+			app.errorResponse(w, r, http.StatusConflict, "This conflict has already been declared")
+		case errors.Is(err, data.ErrForeignKeyViolation):
+			app.errorResponse(w, r, http.StatusNotFound, "Pre-project not found")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	created, err := app.Model.DiscussantDB.GetConflict(conflict.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"conflict": created})
+}
+
+func (app *application) DeleteDiscussantConflictHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	conflictID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid conflict ID"))
+		return
+	}
+
+	conflict, err := app.Model.DiscussantDB.GetConflict(conflictID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	// Only who declared the conflict may take it back
+	if (conflict.DeclaredBy == nil || *conflict.DeclaredBy != userID) && !isAdminRequest(r) {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.Model.DiscussantDB.DeleteConflict(conflictID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Conflict removed successfully"})
+}
+
+// ListExaminerLoadsHandler shows how many projects of the term each eligible
+// examiner discusses.
+func (app *application) ListExaminerLoadsHandler(w http.ResponseWriter, r *http.Request) {
+	year, season, err := termPathValues(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	loads, err := app.Model.DiscussantDB.ListExaminerLoads(year, season)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"examiners": loads})
+}
+
+// AssignDiscussantsHandler fills the discussant seats of the term's accepted
+// projects, per_project seats each (2 by default). With dry_run it only
+// reports the assignment it would make.
+func (app *application) AssignDiscussantsHandler(w http.ResponseWriter, r *http.Request) {
+	year, season, err := termPathValues(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	perProject := defaultDiscussantsPerProject
+	if param := r.FormValue("per_project"); param != "" {
+		perProject, err = strconv.Atoi(param)
+		if err != nil || perProject < 1 || perProject > 3 {
+			app.failedValidationResponse(w, r, map[string]string{"per_project": "يجب أن يكون عدد المناقشين بين 1 و 3"})
+			return
+		}
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+
+	assignments, err := app.Model.DiscussantDB.AssignDiscussants(year, season, perProject, dryRun)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	added, missing := 0, 0
+	for _, assignment := range assignments {
+		added += len(assignment.Added)
+		missing += assignment.Missing
+		if dryRun {
+			continue
+		}
+		for _, discussant := range assignment.Added {
+			app.wsManager.BroadcastMessage(discussant.ID, map[string]interface{}{
+				"type":           "discussant_assigned",
+				"pre_project_id": assignment.PreProjectID,
+				"message":        "تم تعيينك مناقشاً للمشروع: " + assignment.ProjectName,
+			})
+		}
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"dry_run":     dryRun,
+		"assignments": assignments,
+		"added":       added,
+		"missing":     missing,
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"project/internal/data"
+	"project/utils/pdf"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxDocumentSize bounds the uploads read into memory for ingestion.
+const maxDocumentSize = 64 << 20
+
+var errDocumentTooLarge = errors.New("يجب ألا يتجاوز حجم الملف 64 ميغابايت")
+
+// readDocument reads an upload into memory and parses it as a PDF. doc is
+// nil when the file is not a PDF; callers decide whether that is an error.
+func readDocument(file io.Reader) (content []byte, doc *pdf.Info, err error) {
+	content, err = io.ReadAll(io.LimitReader(file, maxDocumentSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(content) > maxDocumentSize {
+		return nil, nil, errDocumentTooLarge
+	}
+	doc, err = pdf.Read(content)
+	if err != nil {
+		return content, nil, nil
+	}
+	return content, doc, nil
+}
+
+// documentText is what the similarity service compares for an upload.
+func documentText(doc *pdf.Info) string {
+	if doc == nil {
+		return ""
+	}
+	return doc.Text
+}
+
+// ingestBookFile extracts the PDF already stored as a book's file, as for
+// archived pre-projects whose uploads were never required to be PDFs. It is
+// best effort: other files are skipped and failures only logged.
+func (app *application) ingestBookFile(bookID uuid.UUID, file *string) {
+	if file == nil || *file == "" {
+		return
+	}
+	path := strings.TrimPrefix(*file, data.Domain+"/")
+
+	f, err := os.Open(path)
+	if err != nil {
+		app.log.Printf("ingest book %s: %v", bookID, err)
+		return
+	}
+	defer f.Close()
+
+	_, doc, err := readDocument(f)
+	if err != nil {
+		app.log.Printf("ingest book %s: %v", bookID, err)
+		return
+	}
+	if doc == nil {
+		return
+	}
+	if err := app.Model.BookDB.SetBookDocument(bookID, doc); err != nil {
+		app.log.Printf("ingest book %s: %v", bookID, err)
+	}
+}
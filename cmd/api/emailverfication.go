@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	"gopkg.in/gomail.v2"
@@ -41,3 +42,33 @@ func SendVerificationEmail(to, verificationCode string) error {
 	log.Printf("Verification email sent to: %s", to)
 	return nil
 }
+
+// SendExternalExaminerLinkEmail sends an external examiner the link that
+// opens their assigned project and score sheet.
+func SendExternalExaminerLinkEmail(to, name, projectName, link string, expiresAt time.Time) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", os.Getenv("GMAIL_USER"))
+	m.SetHeader("To", to)
+	m.SetHeader("Subject", "دعوة لمناقشة مشروع تخرج")
+
+	body := fmt.Sprintf(
+		"مرحبًا %s،\n\n"+
+			"تمت دعوتكم لمناقشة مشروع التخرج: %s\n\n"+
+			"يمكنكم الاطلاع على ملفات المشروع وتعبئة ورقة الدرجات من خلال الرابط التالي:\n\n"+
+			"%s\n\n"+
+			"هذا الرابط صالح حتى %s ولا يجب مشاركته مع أي شخص آخر.\n\n"+
+			"مع تحياتنا،\n"+
+			"قسم تقنية المعلومات.",
+		name, projectName, link, expiresAt.Format("2006-01-02 15:04"),
+	)
+
+	m.SetBody("text/plain", body)
+
+	d := gomail.NewDialer("smtp.gmail.com", 587, os.Getenv("GMAIL_USER"), os.Getenv("GMAIL_PASSWORD"))
+	if err := d.DialAndSend(m); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+
+	log.Printf("External examiner link sent to: %s", to)
+	return nil
+}
@@ -19,6 +19,14 @@ func (app *application) handleRetrievalError(w http.ResponseWriter, r *http.Requ
 		app.errorResponse(w, r, http.StatusConflict, data.ErrEmailAlreadyInserted.Error())
 	case errors.Is(err, data.ErrHasRole):
 		app.errorResponse(w, r, http.StatusConflict, data.ErrHasRole.Error())
+	case errors.Is(err, data.ErrDuplicatedKey):
+		app.errorResponse(w, r, http.StatusConflict, data.ErrDuplicatedKey.Error())
+	case errors.Is(err, data.ErrWindowClosed):
+		app.errorResponse(w, r, http.StatusForbidden, err.Error())
+	case errors.Is(err, data.ErrSheetLocked), errors.Is(err, data.ErrRubricInUse):
+		app.errorResponse(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, data.ErrSheetIncomplete):
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
 
 	default:
 		app.serverErrorResponse(w, r, err)
@@ -35,7 +43,7 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) logError(r *http.Request, err error) {
-	log.Printf("Error: %v, Method: %s, URL: %s", err, r.Method, r.URL.String())
+	log.Printf("Error: %v, Method: %s, URL: %s", err, r.Method, redactedURI(r))
 }
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
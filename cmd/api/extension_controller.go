@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ExtendPreProjectHandler carries an unfinished project over to the next
+// term. Everything attached to the project is kept; only its term changes.
+func (app *application) ExtendPreProjectHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	extension := &data.Extension{PreProjectID: preProjectID, ExtendedBy: &adminID}
+	if reason := strings.TrimSpace(r.FormValue("reason")); reason != "" {
+		if len(reason) > 1500 {
+			app.failedValidationResponse(w, r, map[string]string{"reason": "لا يمكن للسبب أن يكون أكثر من 1500 حرف"})
+			return
+		}
+		extension.Reason = &reason
+	}
+
+	if err := app.Model.ExtensionDB.ExtendPreProject(extension, app.cfg.maxExtensions); err != nil {
+		switch {
+		case errors.Is(err, data.ErrExtensionLimit), errors.Is(err, data.ErrExtensionGraded),
+			errors.Is(err, data.ErrExtensionDefended), errors.Is(err, data.ErrExtensionTooEarly):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrDuplicatedKey):
+			app.errorResponse(w, r, http.StatusConflict, "This pre-project has already been extended from its current term")
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
+		return
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.notifyPreProjectMembers(details, map[string]interface{}{
+		"type":           "pre_project_extended",
+		"pre_project_id": preProjectID,
+		"message": fmt.Sprintf("تم تمديد المشروع %s إلى الفصل %s %d",
+			details.PreProject.Name, extension.ToSeason, extension.ToYear),
+	})
+
+	extensions, err := app.Model.ExtensionDB.ListExtensions(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"pre_project":     details,
+		"extensions":      extensions,
+		"extensions_left": app.cfg.maxExtensions - len(extensions),
+	})
+}
+
+func (app *application) ListPreProjectExtensionsHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	extensions, err := app.Model.ExtensionDB.ListExtensions(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"extensions":     extensions,
+		"max_extensions": app.cfg.maxExtensions,
+	})
+}
+
+// ListTermExtensionsHandler reports the projects carried over out of a term.
+func (app *application) ListTermExtensionsHandler(w http.ResponseWriter, r *http.Request) {
+	year, season, err := termPathValues(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	extensions, err := app.Model.ExtensionDB.ListTermExtensions(year, season)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	nextYear, nextSeason := data.NextTerm(year, season)
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"extensions":  extensions,
+		"count":       len(extensions),
+		"next_year":   nextYear,
+		"next_season": nextSeason,
+	})
+}
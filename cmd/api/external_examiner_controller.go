@@ -0,0 +1,417 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultExternalLinkDays = 14
+	maxExternalLinkDays     = 60
+
+	// externalFileLifetime is how long the file links an examiner is shown
+	// work
+	externalFileLifetime = time.Hour
+)
+
+// externalLinkURL is the address sent to the examiner. EXTERNAL_EXAMINER_URL
+// points at the page that consumes the token; the API route is the fallback.
+func externalLinkURL(token string) string {
+	base := os.Getenv("EXTERNAL_EXAMINER_URL")
+	if base == "" {
+		base = data.Domain + "/external"
+	}
+	return strings.TrimSuffix(base, "/") + "/" + token
+}
+
+func newLinkToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func readExternalExaminerForm(r *http.Request, examiner *data.ExternalExaminer) {
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		examiner.Name = name
+	}
+	if email := strings.TrimSpace(r.FormValue("email")); email != "" {
+		examiner.Email = email
+	}
+	if _, ok := r.Form["affiliation"]; ok {
+		affiliation := strings.TrimSpace(r.FormValue("affiliation"))
+		examiner.Affiliation = &affiliation
+		if affiliation == "" {
+			examiner.Affiliation = nil
+		}
+	}
+}
+
+func (app *application) CreateExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	examiner := &data.ExternalExaminer{CreatedBy: &adminID}
+	readExternalExaminerForm(r, examiner)
+
+	v := validator.New()
+	data.ValidateExternalExaminer(v, examiner)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.ExternalExaminerDB.InsertExaminer(examiner); err != nil {
+		if errors.Is(err, data.ErrEmailAlreadyInserted) {
+			app.failedValidationResponse(w, r, map[string]string{"email": err.Error()})
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"external_examiner": examiner})
+}
+
+func (app *application) ListExternalExaminersHandler(w http.ResponseWriter, r *http.Request) {
+	examiners, err := app.Model.ExternalExaminerDB.ListExaminers(r.URL.Query().Get("search"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"external_examiners": examiners})
+}
+
+func (app *application) GetExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	examinerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid examiner ID"))
+		return
+	}
+	examiner, err := app.Model.ExternalExaminerDB.GetExaminer(examinerID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"external_examiner": examiner})
+}
+
+func (app *application) UpdateExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	examinerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid examiner ID"))
+		return
+	}
+	examiner, err := app.Model.ExternalExaminerDB.GetExaminer(examinerID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	readExternalExaminerForm(r, examiner)
+
+	v := validator.New()
+	data.ValidateExternalExaminer(v, examiner)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.ExternalExaminerDB.UpdateExaminer(examiner); err != nil {
+		if errors.Is(err, data.ErrEmailAlreadyInserted) {
+			app.failedValidationResponse(w, r, map[string]string{"email": err.Error()})
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"external_examiner": examiner})
+}
+
+func (app *application) DeleteExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	examinerID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid examiner ID"))
+		return
+	}
+	if err := app.Model.ExternalExaminerDB.DeleteExaminer(examinerID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "External examiner deleted successfully"})
+}
+
+// assignmentIDs parses the {id} of the project or book and the examiner id,
+// taken from the path when present and from the form otherwise.
+func assignmentIDs(r *http.Request) (uuid.UUID, uuid.UUID, error) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("invalid ID")
+	}
+	examiner := r.PathValue("examiner_id")
+	if examiner == "" {
+		examiner = r.FormValue("examiner_id")
+	}
+	examinerID, err := uuid.Parse(examiner)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, errors.New("invalid examiner ID")
+	}
+	return id, examinerID, nil
+}
+
+func (app *application) assignExternalExaminer(w http.ResponseWriter, r *http.Request, assign func(id, examinerID uuid.UUID) error) {
+	id, examinerID, err := assignmentIDs(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := assign(id, examinerID); err != nil {
+		if errors.Is(err, data.ErrDuplicatedKey) {
+			app.errorResponse(w, r, http.StatusConflict, "The examiner is already assigned")
+			return
+		}
+		if errors.Is(err, data.ErrDiscussantLimit) {
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"message": "External examiner assigned successfully"})
+}
+
+func (app *application) unassignExternalExaminer(w http.ResponseWriter, r *http.Request, unassign func(id, examinerID uuid.UUID) error) {
+	id, examinerID, err := assignmentIDs(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := unassign(id, examinerID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "External examiner removed successfully"})
+}
+
+func (app *application) AssignPreProjectExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	app.assignExternalExaminer(w, r, app.Model.ExternalExaminerDB.AssignToPreProject)
+}
+
+func (app *application) UnassignPreProjectExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	app.unassignExternalExaminer(w, r, app.Model.ExternalExaminerDB.UnassignFromPreProject)
+}
+
+func (app *application) AssignBookExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	app.assignExternalExaminer(w, r, app.Model.ExternalExaminerDB.AssignToBook)
+}
+
+func (app *application) UnassignBookExternalExaminerHandler(w http.ResponseWriter, r *http.Request) {
+	app.unassignExternalExaminer(w, r, app.Model.ExternalExaminerDB.UnassignFromBook)
+}
+
+// CreateExternalLinkHandler issues a magic link for an examiner assigned to
+// the project, valid for ?days (14 by default, at most 60), and emails it.
+// The link is also returned so it can be shared another way if the email
+// fails; it cannot be retrieved again later.
+func (app *application) CreateExternalLinkHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	preProjectID, examinerID, err := assignmentIDs(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	days := defaultExternalLinkDays
+	if param := r.FormValue("days"); param != "" {
+		days, err = strconv.Atoi(param)
+		if err != nil || days < 1 || days > maxExternalLinkDays {
+			app.failedValidationResponse(w, r, map[string]string{"days": "يجب أن تكون مدة الرابط بين 1 و 60 يوماً"})
+			return
+		}
+	}
+
+	examiner, err := app.Model.ExternalExaminerDB.GetExaminer(examinerID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	token, err := newLinkToken()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	link := &data.ExternalExaminerLink{
+		ExaminerID:   examinerID,
+		PreProjectID: preProjectID,
+		ExpiresAt:    time.Now().AddDate(0, 0, days),
+		CreatedBy:    &adminID,
+		Token:        token,
+	}
+	if err := app.Model.ExternalExaminerDB.InsertLink(link); err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusUnprocessableEntity, "The examiner is not assigned to this project")
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	url := externalLinkURL(token)
+	emailSent := true
+	if err := SendExternalExaminerLinkEmail(examiner.Email, examiner.Name, details.PreProject.Name, url, link.ExpiresAt); err != nil {
+		app.logError(r, err)
+		emailSent = false
+	}
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"link":       link,
+		"url":        url,
+		"email_sent": emailSent,
+	})
+}
+
+func (app *application) ListExternalLinksHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, examinerID, err := assignmentIDs(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	links, err := app.Model.ExternalExaminerDB.ListLinks(preProjectID, examinerID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"links": links})
+}
+
+func (app *application) RevokeExternalLinksHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, examinerID, err := assignmentIDs(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := app.Model.ExternalExaminerDB.RevokeLinks(preProjectID, examinerID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Links revoked successfully"})
+}
+
+// GetExternalProjectHandler shows an external examiner the project their
+// link opens: its description, current file and earlier versions, and who
+// is on it. Contact details are left out.
+func (app *application) GetExternalProjectHandler(w http.ResponseWriter, r *http.Request) {
+	link := r.Context().Value(ExternalLinkKey).(*data.ExternalExaminerLink)
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(link.PreProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	examiner, err := app.Model.ExternalExaminerDB.GetExaminer(link.ExaminerID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	versions, err := app.Model.PreProjectDB.ListPreProjectVersions(link.PreProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Examiners have no account, so the files they are shown are signed
+	// links, which last a while since the page stays open as they read
+	expires := time.Now().Add(externalFileLifetime)
+	for i := range versions {
+		versions[i].File = signedFileURL(versions[i].File, expires)
+	}
+
+	students := make([]string, len(details.Students))
+	for i, student := range details.Students {
+		students[i] = student.StudentName
+	}
+	var advisor *string
+	if details.AcceptedAdvisorInfo != nil {
+		advisor = &details.AcceptedAdvisorInfo.Name
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"examiner": examiner,
+		"project": utils.Envelope{
+			"id":               details.PreProject.ID,
+			"name":             details.PreProject.Name,
+			"description":      details.PreProject.Description,
+			"file":             signedFileURL(details.PreProject.File, expires),
+			"file_description": details.PreProject.FileDescription,
+			"year":             details.PreProject.Year,
+			"season":           details.PreProject.Season,
+			"students":         students,
+			"advisor":          advisor,
+		},
+		"versions":   versions,
+		"expires_at": link.ExpiresAt,
+	})
+}
+
+// externalSheet is examinerSheet for the examiner of the link in context.
+func (app *application) externalSheet(w http.ResponseWriter, r *http.Request) (*data.PreProjectWithAdvisorDetails, *data.Rubric, *data.ScoreSheet, bool) {
+	link := r.Context().Value(ExternalLinkKey).(*data.ExternalExaminerLink)
+	return app.loadExaminerSheet(w, r, link.PreProjectID, link.ExaminerID, true)
+}
+
+func (app *application) GetExternalScoreSheetHandler(w http.ResponseWriter, r *http.Request) {
+	_, rubric, sheet, ok := app.externalSheet(w, r)
+	if !ok {
+		return
+	}
+	total := data.SheetTotal(rubric, sheet)
+	sheet.Total = &total
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rubric": rubric, "sheet": sheet})
+}
+
+func (app *application) SaveExternalScoresHandler(w http.ResponseWriter, r *http.Request) {
+	_, rubric, sheet, ok := app.externalSheet(w, r)
+	if !ok {
+		return
+	}
+	app.saveScores(w, r, rubric, sheet)
+}
+
+func (app *application) SubmitExternalScoreSheetHandler(w http.ResponseWriter, r *http.Request) {
+	details, rubric, sheet, ok := app.externalSheet(w, r)
+	if !ok {
+		return
+	}
+	app.submitSheet(w, r, details, rubric, sheet)
+}
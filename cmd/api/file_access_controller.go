@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"project/internal/data"
+	"project/utils"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// readBookFileAccess fills the download policy and embargo of a book from
+// the request form. Fields not sent keep their value, and an empty
+// embargo_until lifts the embargo.
+func readBookFileAccess(r *http.Request, book *data.Book) map[string]string {
+	errs := map[string]string{}
+	if access := r.FormValue("file_access"); access != "" {
+		book.FileAccess = strings.ToLower(access)
+	}
+	if _, ok := r.Form["embargo_until"]; ok {
+		value := r.FormValue("embargo_until")
+		if value == "" {
+			book.EmbargoUntil = nil
+		} else if t, err := parseTimeValue(value); err != nil {
+			errs["embargo_until"] = "تنسيق التاريخ غير صالح"
+		} else {
+			book.EmbargoUntil = &t
+		}
+	}
+	return errs
+}
+
+// uploadPath returns the stored path of an upload from its URL path, full
+// URL or stored path, as utils.SaveFile returns it.
+func uploadPath(value string) string {
+	if strings.Contains(value, "://") {
+		if u, err := url.Parse(value); err == nil {
+			value = u.Path
+		}
+	}
+	return "uploads" + path.Clean("/"+strings.TrimPrefix(strings.TrimPrefix(value, "/"), "uploads/"))
+}
+
+// signedFileURL turns the URL of a stored file, as the data layer returns
+// it, into a link that works for anyone until expires.
+func signedFileURL(file *string, expires time.Time) *string {
+	if file == nil || *file == "" {
+		return nil
+	}
+	signed := utils.SignFileURL(uploadPath(*file), expires)
+	return &signed
+}
+
+// requestUser returns the signed-in user of a request, uuid.Nil when there
+// is none, and whether they are an administrator.
+func requestUser(r *http.Request) (uuid.UUID, bool) {
+	userID, _ := r.Context().Value(UserIDKey).(string)
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	for _, role := range roles {
+		if role == "admin" {
+			return id, true
+		}
+	}
+	return id, false
+}
+
+// authorizeFile checks the policy of a stored file for the user of the
+// request, and writes the error response when they may not download it.
+func (app *application) authorizeFile(w http.ResponseWriter, r *http.Request, file string) bool {
+	policy, err := app.Model.FileAccessDB.GetFilePolicy(file)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return false
+	}
+
+	userID, isAdmin := requestUser(r)
+	now := time.Now()
+	switch {
+	case policy.Allows(userID, isAdmin, now):
+		return true
+	case userID == uuid.Nil:
+		app.unauthorizedResponse(w, r)
+	case policy.Embargoed(now):
+		app.errorResponse(w, r, http.StatusForbidden, utils.Envelope{
+			"error":         "الملف محجوب حتى انتهاء فترة الحظر",
+			"embargo_until": policy.EmbargoUntil,
+		})
+	default:
+		app.forbiddenResponse(w, r)
+	}
+	return false
+}
+
+// sendFileURL answers with a short-lived signed link to a stored file the
+// user of the request may download.
+func (app *application) sendFileURL(w http.ResponseWriter, r *http.Request, file string) {
+	if !app.authorizeFile(w, r, file) {
+		return
+	}
+	expires := time.Now().Add(utils.SignedURLLifetime)
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"url":        utils.SignFileURL(file, expires),
+		"expires_at": expires,
+	})
+}
+
+// FileURLHandler signs a download link for the upload in ?file, given as
+// its URL or stored path.
+func (app *application) FileURLHandler(w http.ResponseWriter, r *http.Request) {
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		app.badRequestResponse(w, r, errors.New("file is required"))
+		return
+	}
+	app.sendFileURL(w, r, uploadPath(file))
+}
+
+// BookFileHandler signs a download link for the file of a book, which is
+// how it is reached while it is not shown under embargo.
+func (app *application) BookFileHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid book ID"))
+		return
+	}
+	file, err := app.Model.BookDB.GetBookFile(bookID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if file == nil {
+		app.errorResponse(w, r, http.StatusNotFound, data.ErrRecordNotFound.Error())
+		return
+	}
+	app.sendFileURL(w, r, *file)
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type rubricInput struct {
+	Year            int                    `json:"year"`
+	Season          string                 `json:"season"`
+	Name            string                 `json:"name"`
+	AggregationRule string                 `json:"aggregation_rule"`
+	AdvisorShare    *float64               `json:"advisor_share"`
+	Criteria        []data.RubricCriterion `json:"criteria"`
+}
+
+type scoresInput struct {
+	Scores  []data.ScoreItem `json:"scores"`
+	Comment *string          `json:"comment"`
+}
+
+func (input *rubricInput) apply(rubric *data.Rubric) {
+	if input.Name != "" {
+		rubric.Name = input.Name
+	}
+	if input.AggregationRule != "" {
+		rubric.AggregationRule = input.AggregationRule
+	}
+	if input.AdvisorShare != nil {
+		rubric.AdvisorShare = *input.AdvisorShare
+	}
+	if input.Criteria != nil {
+		rubric.Criteria = input.Criteria
+	}
+}
+
+func (app *application) CreateRubricHandler(w http.ResponseWriter, r *http.Request) {
+	var input rubricInput
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	rubric := &data.Rubric{
+		Year:            input.Year,
+		Season:          strings.ToLower(input.Season),
+		AggregationRule: data.AggregationWeightedAverage,
+		AdvisorShare:    0.5,
+	}
+	input.apply(rubric)
+
+	v := validator.New()
+	data.ValidateRubric(v, rubric)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.GradingDB.InsertRubric(rubric); err != nil {
+		if errors.Is(err, data.ErrDuplicatedKey) {
+			app.errorResponse(w, r, http.StatusConflict, "A rubric already exists for this year and season")
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"rubric": rubric})
+}
+
+func (app *application) ListRubricsHandler(w http.ResponseWriter, r *http.Request) {
+	rubrics, err := app.Model.GradingDB.ListRubrics()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rubrics": rubrics})
+}
+
+func (app *application) GetRubricHandler(w http.ResponseWriter, r *http.Request) {
+	rubricID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid rubric ID"))
+		return
+	}
+
+	rubric, err := app.Model.GradingDB.GetRubric(rubricID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rubric": rubric})
+}
+
+func (app *application) UpdateRubricHandler(w http.ResponseWriter, r *http.Request) {
+	rubricID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid rubric ID"))
+		return
+	}
+
+	rubric, err := app.Model.GradingDB.GetRubric(rubricID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	var input rubricInput
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	input.apply(rubric)
+
+	v := validator.New()
+	data.ValidateRubric(v, rubric)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.GradingDB.UpdateRubric(rubric); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rubric": rubric})
+}
+
+func (app *application) DeleteRubricHandler(w http.ResponseWriter, r *http.Request) {
+	rubricID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid rubric ID"))
+		return
+	}
+
+	if err := app.Model.GradingDB.DeleteRubric(rubricID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "rubric deleted successfully"})
+}
+
+// examinerRole returns "advisor" or "discussant" when the user grades the
+// project, or an empty string. Only the accepted advisor grades as advisor.
+func examinerRole(details *data.PreProjectWithAdvisorDetails, userID uuid.UUID) string {
+	if details.PreProject.AcceptedAdvisor != nil && *details.PreProject.AcceptedAdvisor == userID {
+		return "advisor"
+	}
+	for _, discussant := range details.Discussants {
+		if discussant.DiscussantID == userID {
+			return "discussant"
+		}
+	}
+	for _, examiner := range details.ExternalDiscussants {
+		if examiner.ID == userID {
+			return "discussant"
+		}
+	}
+	return ""
+}
+
+// expectedExaminers is the number of score sheets needed before a degree can
+// be aggregated: the accepted advisor plus every discussant, external ones
+// included.
+func expectedExaminers(details *data.PreProjectWithAdvisorDetails) int {
+	n := len(details.Discussants) + len(details.ExternalDiscussants)
+	if details.PreProject.AcceptedAdvisor != nil {
+		n++
+	}
+	return n
+}
+
+// examinerSheet loads the project, its rubric and the current user's sheet.
+func (app *application) examinerSheet(w http.ResponseWriter, r *http.Request) (*data.PreProjectWithAdvisorDetails, *data.Rubric, *data.ScoreSheet, bool) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return nil, nil, nil, false
+	}
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, nil, nil, false
+	}
+	return app.loadExaminerSheet(w, r, preProjectID, userID, false)
+}
+
+// loadExaminerSheet is examinerSheet for a known examiner; external is set
+// for external examiners signed in through a link. The sheet is stored on
+// the first save or submit: reading it before returns an empty sheet that is
+// not stored yet.
+func (app *application) loadExaminerSheet(w http.ResponseWriter, r *http.Request, preProjectID, userID uuid.UUID, external bool) (*data.PreProjectWithAdvisorDetails, *data.Rubric, *data.ScoreSheet, bool) {
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, nil, nil, false
+	}
+	role := examinerRole(details, userID)
+	if role == "" {
+		app.errorResponse(w, r, http.StatusForbidden, "Only the accepted advisor and the discussants can grade this project")
+		return nil, nil, nil, false
+	}
+
+	rubric, err := app.Model.GradingDB.GetRubricForTerm(details.PreProject.Year, details.PreProject.Season)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusNotFound, "No grading rubric has been configured for this term")
+			return nil, nil, nil, false
+		}
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, nil, false
+	}
+
+	var sheet *data.ScoreSheet
+	if r.Method == http.MethodGet {
+		sheet, err = app.Model.GradingDB.FindSheet(preProjectID, userID, external)
+		if errors.Is(err, data.ErrRecordNotFound) {
+			sheet, err = &data.ScoreSheet{
+				PreProjectID: preProjectID,
+				ExaminerID:   userID,
+				External:     external,
+				Role:         role,
+				RubricID:     rubric.ID,
+				Items:        []data.ScoreItem{},
+			}, nil
+		}
+	} else {
+		sheet, err = app.Model.GradingDB.SheetFor(preProjectID, userID, role, rubric.ID, external)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, nil, false
+	}
+	// A sheet keeps the rubric it was opened with
+	if sheet.RubricID != rubric.ID {
+		rubric, err = app.Model.GradingDB.GetRubric(sheet.RubricID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, nil, nil, false
+		}
+	}
+	return details, rubric, sheet, true
+}
+
+func (app *application) GetMyScoreSheetHandler(w http.ResponseWriter, r *http.Request) {
+	_, rubric, sheet, ok := app.examinerSheet(w, r)
+	if !ok {
+		return
+	}
+	total := data.SheetTotal(rubric, sheet)
+	sheet.Total = &total
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"rubric": rubric, "sheet": sheet})
+}
+
+func (app *application) SaveScoresHandler(w http.ResponseWriter, r *http.Request) {
+	_, rubric, sheet, ok := app.examinerSheet(w, r)
+	if !ok {
+		return
+	}
+	app.saveScores(w, r, rubric, sheet)
+}
+
+// saveScores stores the draft scores in the request body on the sheet.
+func (app *application) saveScores(w http.ResponseWriter, r *http.Request, rubric *data.Rubric, sheet *data.ScoreSheet) {
+	var input scoresInput
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateScores(v, rubric, input.Scores)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.GradingDB.SaveScores(sheet.ID, input.Scores, input.Comment); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	sheet, err := app.Model.GradingDB.GetSheet(sheet.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	total := data.SheetTotal(rubric, sheet)
+	sheet.Total = &total
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"sheet": sheet})
+}
+
+func (app *application) SubmitScoreSheetHandler(w http.ResponseWriter, r *http.Request) {
+	details, rubric, sheet, ok := app.examinerSheet(w, r)
+	if !ok {
+		return
+	}
+	app.submitSheet(w, r, details, rubric, sheet)
+}
+
+// submitSheet locks the sheet and aggregates the degree when it was the last
+// one missing.
+func (app *application) submitSheet(w http.ResponseWriter, r *http.Request, details *data.PreProjectWithAdvisorDetails, rubric *data.Rubric, sheet *data.ScoreSheet) {
+	if err := app.Model.GradingDB.SubmitSheet(sheet.ID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	degree, err := app.finalizeGrade(details, rubric)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message": "score sheet submitted successfully",
+		"degree":  degree,
+	})
+}
+
+// finalizeGrade aggregates the degree once every examiner has submitted and
+// notifies the project members. It returns nil while sheets are missing.
+func (app *application) finalizeGrade(details *data.PreProjectWithAdvisorDetails, rubric *data.Rubric) (*int, error) {
+	sheets, err := app.Model.GradingDB.ListSheets(details.PreProject.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sheets of examiners who were since removed from the project do not count
+	submitted := []data.ScoreSheet{}
+	for _, sheet := range sheets {
+		if sheet.Submitted() && examinerRole(details, sheet.ExaminerID) != "" {
+			submitted = append(submitted, sheet)
+		}
+	}
+	if len(submitted) < expectedExaminers(details) {
+		return nil, nil
+	}
+
+	degree := data.AggregateDegree(rubric, submitted)
+	if err := app.Model.GradingDB.SetDegree(details.PreProject.ID, degree); err != nil {
+		return nil, err
+	}
+
+	notification := map[string]interface{}{
+		"type":           "grade_finalized",
+		"pre_project_id": details.PreProject.ID,
+		"message":        "تم اعتماد درجة المشروع",
+	}
+	for _, student := range details.Students {
+		app.wsManager.BroadcastMessage(student.StudentID, notification)
+	}
+	for _, sheet := range submitted {
+		app.wsManager.BroadcastMessage(sheet.ExaminerID, notification)
+	}
+	return &degree, nil
+}
+
+// GetGradesHandler shows the grading state of a project. Examiners see their
+// own sheet and only see the others once everyone has submitted; students
+// only see the final degree. Admins see everything.
+func (app *application) GetGradesHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	roles, _ := r.Context().Value(UserRoleKey).([]string)
+	isAdmin := false
+	for _, role := range roles {
+		if role == "admin" {
+			isAdmin = true
+			break
+		}
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	sheets, err := app.Model.GradingDB.ListSheets(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	submitted := 0
+	for _, sheet := range sheets {
+		if sheet.Submitted() && examinerRole(details, sheet.ExaminerID) != "" {
+			submitted++
+		}
+	}
+	expected := expectedExaminers(details)
+	complete := expected > 0 && submitted >= expected
+
+	visible := []data.ScoreSheet{}
+	if isAdmin || complete && examinerRole(details, userID) != "" {
+		visible = sheets
+	} else {
+		for _, sheet := range sheets {
+			if sheet.ExaminerID == userID {
+				visible = append(visible, sheet)
+			}
+		}
+	}
+
+	rubrics := map[uuid.UUID]*data.Rubric{}
+	for i := range visible {
+		rubric, ok := rubrics[visible[i].RubricID]
+		if !ok {
+			rubric, err = app.Model.GradingDB.GetRubric(visible[i].RubricID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			rubrics[visible[i].RubricID] = rubric
+		}
+		total := data.SheetTotal(rubric, &visible[i])
+		visible[i].Total = &total
+	}
+
+	var degree *int
+	if complete || isAdmin {
+		degree = details.PreProject.Degree
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"complete":  complete,
+		"submitted": submitted,
+		"expected":  expected,
+		"degree":    degree,
+		"sheets":    visible,
+	})
+}
+
+func (app *application) ReopenScoreSheetHandler(w http.ResponseWriter, r *http.Request) {
+	sheetID, err := uuid.Parse(r.PathValue("sheet_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid score sheet ID"))
+		return
+	}
+
+	sheet, err := app.Model.GradingDB.GetSheet(sheetID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if sheet.PreProjectID.String() != r.PathValue("id") {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+
+	if err := app.Model.GradingDB.ReopenSheet(sheetID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	app.wsManager.BroadcastMessage(sheet.ExaminerID, map[string]interface{}{
+		"type":           "score_sheet_reopened",
+		"pre_project_id": sheet.PreProjectID,
+		"message":        "تمت إعادة فتح ورقة الدرجات للتعديل",
+	})
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "score sheet reopened successfully"})
+}
+
+// gradedByRubric reports whether the project's term is graded with score
+// sheets, in which case the degree can no longer be typed in by hand.
+func (app *application) gradedByRubric(preProject *data.PreProject) (bool, error) {
+	_, err := app.Model.GradingDB.GetRubricForTerm(preProject.Year, preProject.Season)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
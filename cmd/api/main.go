@@ -28,6 +28,12 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 	}
+	// maxExtensions caps how many times a project may be carried over.
+	maxExtensions int
+	// institution is named as the publisher of cited theses.
+	institution string
+	// oaiAdminEmail is the contact harvesters see in the OAI-PMH Identify.
+	oaiAdminEmail string
 }
 
 type application struct {
@@ -53,6 +59,9 @@ func main() {
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
+	flag.IntVar(&cfg.maxExtensions, "max-extensions", 1, "Maximum number of times a pre-project may be carried over to the next term")
+	flag.StringVar(&cfg.institution, "institution", "جامعة بنغازي - فرع المرج", "Institution named in thesis citations")
+	flag.StringVar(&cfg.oaiAdminEmail, "oai-admin-email", os.Getenv("GMAIL_USER"), "Administrator email reported by the OAI-PMH endpoint")
 	flag.Parse()
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
@@ -73,6 +82,9 @@ func main() {
 		wsManager: NewWebSocketManager(),
 	}
 	utils.SetDB(db)
+	if err := model.ArchiveJobDB.FailInterruptedArchiveJobs(); err != nil {
+		logger.Printf("Failed to close interrupted archive jobs: %v", err)
+	}
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.port),
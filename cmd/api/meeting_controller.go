@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/pdf"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type actionItemInput struct {
+	ID          *uuid.UUID `json:"id"`
+	Description string     `json:"description"`
+	OwnerID     *uuid.UUID `json:"owner_id"`
+	DueDate     string     `json:"due_date"`
+}
+
+type meetingInput struct {
+	HeldAt      string            `json:"held_at"`
+	Agenda      string            `json:"agenda"`
+	Minutes     *string           `json:"minutes"`
+	Attendees   []uuid.UUID       `json:"attendees"`
+	ActionItems []actionItemInput `json:"action_items"`
+}
+
+// meetingTeam returns the students and the accepted advisor of a project:
+// the people a meeting can be held with.
+func meetingTeam(details *data.PreProjectWithAdvisorDetails) []data.MeetingParticipant {
+	team := []data.MeetingParticipant{}
+	if details.PreProject.AcceptedAdvisor != nil {
+		team = append(team, data.MeetingParticipant{UserID: *details.PreProject.AcceptedAdvisor, Role: "advisor"})
+	}
+	for _, student := range details.Students {
+		team = append(team, data.MeetingParticipant{UserID: student.StudentID, Role: "student"})
+	}
+	return team
+}
+
+// readMeeting fills meeting from the JSON body. Every team member becomes a
+// participant so that absent students still acknowledge the minutes.
+func readMeeting(input *meetingInput, meeting *data.Meeting, details *data.PreProjectWithAdvisorDetails) (map[string]string, []uuid.UUID) {
+	errs := map[string]string{}
+	if input.HeldAt != "" {
+		heldAt, err := parseTimeValue(input.HeldAt)
+		if err != nil {
+			errs["held_at"] = "تنسيق التاريخ غير صالح"
+		}
+		meeting.HeldAt = heldAt
+	}
+	if input.Agenda != "" {
+		meeting.Agenda = input.Agenda
+	}
+	if input.Minutes != nil {
+		meeting.Minutes = input.Minutes
+	}
+
+	team := meetingTeam(details)
+	teamIDs := make([]uuid.UUID, len(team))
+	for i, member := range team {
+		teamIDs[i] = member.UserID
+	}
+
+	if input.Attendees != nil {
+		for _, id := range input.Attendees {
+			if !validator.InUUID(id, teamIDs) {
+				errs["attendees"] = "يجب أن يكون الحضور من فريق المشروع"
+			}
+		}
+		for i := range team {
+			team[i].Attended = validator.InUUID(team[i].UserID, input.Attendees)
+		}
+		meeting.Participants = team
+	} else if meeting.Participants == nil {
+		meeting.Participants = team
+	}
+
+	if input.ActionItems != nil {
+		meeting.ActionItems = []data.ActionItem{}
+		for _, in := range input.ActionItems {
+			item := data.ActionItem{Description: in.Description, OwnerID: in.OwnerID}
+			if in.ID != nil {
+				item.ID = *in.ID
+			}
+			if in.DueDate != "" {
+				due, err := parseTimeValue(in.DueDate)
+				if err != nil {
+					errs["action_items"] = "تنسيق تاريخ المهمة غير صالح"
+				}
+				item.DueDate = &due
+			}
+			meeting.ActionItems = append(meeting.ActionItems, item)
+		}
+	}
+	return errs, teamIDs
+}
+
+// advisorProject loads the pre-project in the path and checks that the
+// current user is its accepted advisor.
+func (app *application) advisorProject(w http.ResponseWriter, r *http.Request) (*data.PreProjectWithAdvisorDetails, uuid.UUID, bool) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return nil, uuid.Nil, false
+	}
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, uuid.Nil, false
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, uuid.Nil, false
+	}
+	if details.PreProject.AcceptedAdvisor == nil || *details.PreProject.AcceptedAdvisor != userID {
+		app.errorResponse(w, r, http.StatusForbidden, "Only the accepted advisor can manage supervision meetings")
+		return nil, uuid.Nil, false
+	}
+	return details, userID, true
+}
+
+// projectMeeting loads the meeting in the path and checks it belongs to the
+// pre-project in the path.
+func (app *application) projectMeeting(w http.ResponseWriter, r *http.Request) (*data.Meeting, bool) {
+	meetingID, err := uuid.Parse(r.PathValue("meeting_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid meeting ID"))
+		return nil, false
+	}
+	meeting, err := app.Model.MeetingDB.GetMeeting(meetingID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if meeting.PreProjectID.String() != r.PathValue("id") {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return nil, false
+	}
+	return meeting, true
+}
+
+func (app *application) notifyMeeting(meeting *data.Meeting, kind, message string) {
+	notification := map[string]interface{}{
+		"type":           kind,
+		"pre_project_id": meeting.PreProjectID,
+		"meeting_id":     meeting.ID,
+		"message":        message,
+	}
+	for _, p := range meeting.Participants {
+		if p.Role == "student" {
+			app.wsManager.BroadcastMessage(p.UserID, notification)
+		}
+	}
+}
+
+func (app *application) CreateMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	details, advisorID, ok := app.advisorProject(w, r)
+	if !ok {
+		return
+	}
+
+	var input meetingInput
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	meeting := &data.Meeting{PreProjectID: details.PreProject.ID, CreatedBy: &advisorID}
+	errs, team := readMeeting(&input, meeting, details)
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateMeeting(v, meeting, team)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.MeetingDB.InsertMeeting(meeting); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	created, err := app.Model.MeetingDB.GetMeeting(meeting.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	app.notifyMeeting(created, "meeting_recorded", "تم تسجيل محضر اجتماع إشراف جديد، يرجى الاطلاع والتأكيد")
+
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"meeting": created})
+}
+
+func (app *application) UpdateMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	details, _, ok := app.advisorProject(w, r)
+	if !ok {
+		return
+	}
+	meeting, ok := app.projectMeeting(w, r)
+	if !ok {
+		return
+	}
+
+	var input meetingInput
+	if err := utils.ReadJSON(w, r, &input); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	oldAgenda, oldMinutes := meeting.Agenda, ""
+	if meeting.Minutes != nil {
+		oldMinutes = *meeting.Minutes
+	}
+	// Keep attendance as recorded unless the request changes it
+	current := map[uuid.UUID]bool{}
+	for _, p := range meeting.Participants {
+		current[p.UserID] = p.Attended
+	}
+	meeting.Participants = nil
+
+	errs, team := readMeeting(&input, meeting, details)
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+	if input.Attendees == nil {
+		for i := range meeting.Participants {
+			meeting.Participants[i].Attended = current[meeting.Participants[i].UserID]
+		}
+	}
+
+	v := validator.New()
+	data.ValidateMeeting(v, meeting, team)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	newMinutes := ""
+	if meeting.Minutes != nil {
+		newMinutes = *meeting.Minutes
+	}
+	contentChanged := oldAgenda != meeting.Agenda || oldMinutes != newMinutes
+
+	if err := app.Model.MeetingDB.UpdateMeeting(meeting, contentChanged); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	updated, err := app.Model.MeetingDB.GetMeeting(meeting.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if contentChanged {
+		app.notifyMeeting(updated, "meeting_updated", "تم تعديل محضر اجتماع الإشراف، يرجى إعادة التأكيد")
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"meeting": updated})
+}
+
+func (app *application) DeleteMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := app.advisorProject(w, r); !ok {
+		return
+	}
+	meeting, ok := app.projectMeeting(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Model.MeetingDB.DeleteMeeting(meeting.ID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "meeting deleted successfully"})
+}
+
+func (app *application) ListMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	meetings, err := app.Model.MeetingDB.ListMeetings(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"meetings": meetings})
+}
+
+func (app *application) GetMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	meeting, ok := app.projectMeeting(w, r)
+	if !ok {
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"meeting": meeting})
+}
+
+func (app *application) AcknowledgeMeetingHandler(w http.ResponseWriter, r *http.Request) {
+	studentID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	meeting, ok := app.projectMeeting(w, r)
+	if !ok {
+		return
+	}
+
+	if err := app.Model.MeetingDB.Acknowledge(meeting.ID, studentID); err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			app.errorResponse(w, r, http.StatusForbidden, "Only the project's students can acknowledge the minutes")
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "minutes acknowledged successfully"})
+}
+
+// UpdateActionItemHandler marks an action item done or not done. The owner
+// and the accepted advisor may do so.
+func (app *application) UpdateActionItemHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	meeting, ok := app.projectMeeting(w, r)
+	if !ok {
+		return
+	}
+	itemID, err := uuid.Parse(r.PathValue("item_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid action item ID"))
+		return
+	}
+	done, err := strconv.ParseBool(r.FormValue("done"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid done value"))
+		return
+	}
+
+	item, err := app.Model.MeetingDB.GetActionItem(itemID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if item.MeetingID != meeting.ID {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+
+	allowed := item.OwnerID != nil && *item.OwnerID == userID
+	for _, p := range meeting.Participants {
+		if p.Role == "advisor" && p.UserID == userID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.Model.MeetingDB.SetActionItemDone(itemID, done); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	item, err = app.Model.MeetingDB.GetActionItem(itemID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"action_item": item})
+}
+
+// ExportMeetingsHandler exports the advisor's meeting log for a term as a CSV
+// or PDF appendix, optionally for a single project (?pre_project_id=).
+func (app *application) ExportMeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	advisorID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	year, err := strconv.Atoi(query.Get("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return
+	}
+	season := strings.ToLower(query.Get("season"))
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "pdf" {
+		app.badRequestResponse(w, r, errors.New("format must be csv or pdf"))
+		return
+	}
+
+	var preProjectID *uuid.UUID
+	if value := query.Get("pre_project_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+			return
+		}
+		preProjectID = &id
+	}
+
+	meetings, err := app.Model.MeetingDB.ListAdvisorMeetings(advisorID, year, season, preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("meetings-%d-%s.%s", year, season, format)
+	var body []byte
+	contentType := "text/csv; charset=utf-8"
+	if format == "pdf" {
+		body = meetingsPDF(meetings, year, season)
+		contentType = "application/pdf"
+	} else {
+		body, err = meetingsCSV(meetings)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func participantNames(meeting *data.Meeting, attended bool) string {
+	var names []string
+	for _, p := range meeting.Participants {
+		if p.Attended == attended {
+			names = append(names, p.Name)
+		}
+	}
+	return strings.Join(names, "، ")
+}
+
+func acknowledgementSummary(meeting *data.Meeting) string {
+	var parts []string
+	for _, p := range meeting.Participants {
+		if p.Role != "student" {
+			continue
+		}
+		status := "لم يؤكد"
+		if p.AcknowledgedAt != nil {
+			status = "أكد " + p.AcknowledgedAt.Format("2006-01-02")
+		}
+		parts = append(parts, p.Name+" ("+status+")")
+	}
+	return strings.Join(parts, "، ")
+}
+
+func actionItemLine(item data.ActionItem) string {
+	line := item.Description
+	if item.OwnerName != "" {
+		line += " - " + item.OwnerName
+	}
+	if item.DueDate != nil {
+		line += " - " + item.DueDate.Format("2006-01-02")
+	}
+	if item.DoneAt != nil {
+		line += " ✓"
+	}
+	return line
+}
+
+func meetingsCSV(meetings []data.Meeting) ([]byte, error) {
+	var buf bytes.Buffer
+	// Byte order mark so spreadsheet software opens the Arabic text as UTF-8
+	buf.WriteString("\ufeff")
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"project", "held_at", "attended", "absent", "agenda", "minutes", "action_items", "acknowledgements"})
+	for i := range meetings {
+		m := &meetings[i]
+		minutes := ""
+		if m.Minutes != nil {
+			minutes = *m.Minutes
+		}
+		items := make([]string, len(m.ActionItems))
+		for j, item := range m.ActionItems {
+			items[j] = actionItemLine(item)
+		}
+		writer.Write([]string{
+			m.ProjectName,
+			m.HeldAt.Format(time.RFC3339),
+			participantNames(m, true),
+			participantNames(m, false),
+			m.Agenda,
+			minutes,
+			strings.Join(items, "\n"),
+			acknowledgementSummary(m),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func meetingsPDF(meetings []data.Meeting, year int, season string) []byte {
+	title := fmt.Sprintf("سجل اجتماعات الإشراف %d %s", year, season)
+	doc := pdf.New(title)
+	doc.Title(title)
+	if len(meetings) == 0 {
+		doc.Paragraph("لا توجد اجتماعات مسجلة")
+	}
+
+	project := ""
+	for i := range meetings {
+		m := &meetings[i]
+		if m.ProjectName != project {
+			project = m.ProjectName
+			doc.Heading(project)
+			doc.Rule()
+		}
+		doc.Heading(m.HeldAt.Format("2006-01-02 15:04"))
+		doc.Field("الحضور", participantNames(m, true))
+		if absent := participantNames(m, false); absent != "" {
+			doc.Field("الغياب", absent)
+		}
+		doc.Field("جدول الأعمال", m.Agenda)
+		if m.Minutes != nil {
+			doc.Field("المحضر", *m.Minutes)
+		}
+		for _, item := range m.ActionItems {
+			doc.Paragraph("- " + actionItemLine(item))
+		}
+		doc.Field("تأكيد الطلاب", acknowledgementSummary(m))
+		doc.Space(6)
+	}
+	return doc.Bytes()
+}
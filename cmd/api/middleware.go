@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"project/internal/data"
 	"project/utils"
 	"strings"
 	"sync"
@@ -17,6 +19,7 @@ type contextKey string
 
 const UserIDKey contextKey = "userID"
 const UserRoleKey contextKey = "userRole"
+const ExternalLinkKey contextKey = "externalLink"
 
 func (app *application) AuthMiddleware(next http.Handler) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -134,10 +137,35 @@ func secureHeaders(next http.Handler) http.Handler {
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method,
-			r.URL.RequestURI())
+			redactedURI(r))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// redactedURI is the request URI as it is logged, without the secrets it
+// may carry: the token of an external examiner link in the path and a token
+// passed in the query.
+func redactedURI(r *http.Request) string {
+	path := r.URL.EscapedPath()
+	if i := strings.Index(path, "/external/"); i >= 0 {
+		start := i + len("/external/")
+		end := len(path)
+		if j := strings.IndexByte(path[start:], '/'); j >= 0 {
+			end = start + j
+		}
+		path = path[:start] + "REDACTED" + path[end:]
+	}
+
+	if r.URL.RawQuery == "" {
+		return path
+	}
+	query := r.URL.Query()
+	if !query.Has("token") {
+		return path + "?" + r.URL.RawQuery
+	}
+	query.Set("token", "REDACTED")
+	return path + "?" + query.Encode()
+}
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -400,6 +428,84 @@ func (app *application) AdminOrProjectOwnerOnlyMiddleware(next http.Handler) htt
 		next.ServeHTTP(w, r)
 	})
 }
+
+// PreProjectMemberMiddleware allows admins and anyone involved in the
+// pre-project: its students, its advisors and its discussants.
+func (app *application) PreProjectMemberMiddleware(next http.Handler) http.Handler {
+	return app.preProjectMember(next, app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails)
+}
+
+// PreProjectHistoryMiddleware is PreProjectMemberMiddleware for the routes
+// reading a pre-project's history, which stay open to its members once it
+// is withdrawn or cancelled.
+func (app *application) PreProjectHistoryMiddleware(next http.Handler) http.Handler {
+	return app.preProjectMember(next, app.Model.PreProjectDB.GetClosedPreProjectWithAdvisorDetails)
+}
+
+func (app *application) preProjectMember(next http.Handler, load func(uuid.UUID) (*data.PreProjectWithAdvisorDetails, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userIDStr, ok := r.Context().Value(UserIDKey).(string)
+		if !ok {
+			app.unauthorizedResponse(w, r)
+			return
+		}
+
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		userRoles, _ := r.Context().Value(UserRoleKey).([]string)
+		for _, role := range userRoles {
+			if role == "admin" {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		preProjectID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		projectDetails, err := load(preProjectID)
+		if err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+
+		if !isPreProjectMember(projectDetails, userID) {
+			app.forbiddenResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isPreProjectMember(details *data.PreProjectWithAdvisorDetails, userID uuid.UUID) bool {
+	if details.PreProject.ProjectOwner == userID {
+		return true
+	}
+	for _, student := range details.Students {
+		if student.StudentID == userID {
+			return true
+		}
+	}
+	for _, advisor := range details.Advisors {
+		if advisor.AdvisorID == userID {
+			return true
+		}
+	}
+	for _, discussant := range details.Discussants {
+		if discussant.DiscussantID == userID {
+			return true
+		}
+	}
+	return false
+}
 func (app *application) ChatParticipantMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userIDStr, ok := r.Context().Value(UserIDKey).(string)
@@ -601,3 +707,23 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 		}
 	})
 }
+
+// ExternalLinkMiddleware lets external examiners in through the token of a
+// magic link instead of a session. The resolved link is stored in the
+// context under ExternalLinkKey.
+func (app *application) ExternalLinkMiddleware(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, err := app.Model.ExternalExaminerDB.ResolveLink(r.PathValue("token"), time.Now())
+		if err != nil {
+			if errors.Is(err, data.ErrInvalidLink) {
+				app.errorResponse(w, r, http.StatusUnauthorized, err.Error())
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ExternalLinkKey, link)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TimelineEvent is one dated entry of a pre-project's history.
+type TimelineEvent struct {
+	Type    string      `json:"type"`
+	At      time.Time   `json:"at"`
+	Title   string      `json:"title"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func readMilestoneTemplateForm(r *http.Request, t *data.MilestoneTemplate) map[string]string {
+	errs := map[string]string{}
+	if title := r.FormValue("title"); title != "" {
+		t.Title = title
+	}
+	if description := r.FormValue("description"); description != "" {
+		t.Description = &description
+	}
+	if due := r.FormValue("due_date"); due != "" {
+		dueDate, err := parseTimeValue(due)
+		if err != nil {
+			errs["due_date"] = "تنسيق التاريخ غير صالح"
+		} else {
+			t.DueDate = dueDate
+		}
+	}
+	if position := r.FormValue("position"); position != "" {
+		n, err := strconv.Atoi(position)
+		if err != nil {
+			errs["position"] = "الترتيب يجب أن يكون رقماً"
+		} else {
+			t.Position = n
+		}
+	}
+	return errs
+}
+
+func (app *application) CreateMilestoneTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	term, ok := app.termFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	template := &data.MilestoneTemplate{Year: term.Year, Season: term.Season}
+	if errs := readMilestoneTemplateForm(r, template); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateMilestoneTemplate(v, template)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.MilestoneDB.InsertTemplate(template); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"milestone": template})
+}
+
+func (app *application) ListMilestoneTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	year, err := strconv.Atoi(r.PathValue("year"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid year"))
+		return
+	}
+
+	templates, err := app.Model.MilestoneDB.ListTemplates(year, strings.ToLower(r.PathValue("season")))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"milestones": templates})
+}
+
+func (app *application) UpdateMilestoneTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	templateID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid milestone ID"))
+		return
+	}
+
+	template, err := app.Model.MilestoneDB.GetTemplate(templateID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if errs := readMilestoneTemplateForm(r, template); len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateMilestoneTemplate(v, template)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.MilestoneDB.UpdateTemplate(template); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"milestone": template})
+}
+
+func (app *application) DeleteMilestoneTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	templateID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid milestone ID"))
+		return
+	}
+
+	if err := app.Model.MilestoneDB.DeleteTemplate(templateID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "milestone deleted successfully"})
+}
+
+// projectMilestone loads a milestone from the path and checks it belongs to
+// the pre-project in the path.
+func (app *application) projectMilestone(w http.ResponseWriter, r *http.Request) (*data.PreProjectWithAdvisorDetails, *data.Milestone, bool) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return nil, nil, false
+	}
+	milestoneID, err := uuid.Parse(r.PathValue("milestone_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid milestone ID"))
+		return nil, nil, false
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, nil, false
+	}
+	milestone, err := app.Model.MilestoneDB.GetMilestone(milestoneID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, nil, false
+	}
+	if milestone.PreProjectID != preProjectID {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return nil, nil, false
+	}
+	return details, milestone, true
+}
+
+func (app *application) SubmitProgressReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	details, milestone, ok := app.projectMilestone(w, r)
+	if !ok {
+		return
+	}
+
+	isStudent := details.PreProject.ProjectOwner == userID
+	for _, student := range details.Students {
+		if student.StudentID == userID {
+			isStudent = true
+			break
+		}
+	}
+	if !isStudent {
+		app.errorResponse(w, r, http.StatusForbidden, "Only the project's students can submit progress reports")
+		return
+	}
+	if milestone.Status == data.MilestoneApproved {
+		app.errorResponse(w, r, http.StatusConflict, "This milestone has already been approved")
+		return
+	}
+
+	report := &data.ProgressReport{
+		MilestoneID: milestone.ID,
+		SubmittedBy: &userID,
+		Content:     r.FormValue("content"),
+	}
+
+	v := validator.New()
+	data.ValidateProgressReport(v, report)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if uploadedFile, fileHeader, err := r.FormFile("file"); err == nil {
+		defer uploadedFile.Close()
+		fileName, err := utils.SaveFile(uploadedFile, "progress_reports", fileHeader.Filename)
+		if err != nil {
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid file")
+			return
+		}
+		report.File = &fileName
+	} else if err != http.ErrMissingFile {
+		app.errorResponse(w, r, http.StatusBadRequest, "Invalid file upload")
+		return
+	}
+
+	if err := app.Model.MilestoneDB.InsertReport(report); err != nil {
+		if report.File != nil {
+			utils.DeleteFile(*report.File)
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if details.PreProject.AcceptedAdvisor != nil {
+		app.wsManager.BroadcastMessage(*details.PreProject.AcceptedAdvisor, map[string]interface{}{
+			"type":           "progress_report_submitted",
+			"pre_project_id": details.PreProject.ID,
+			"milestone_id":   milestone.ID,
+			"message":        "تم تسليم تقرير إنجاز جديد: " + milestone.Title,
+		})
+	}
+
+	created, err := app.Model.MilestoneDB.GetReport(report.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"report": created})
+}
+
+func (app *application) ReviewProgressReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	reportID, err := uuid.Parse(r.PathValue("report_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid report ID"))
+		return
+	}
+
+	details, milestone, ok := app.projectMilestone(w, r)
+	if !ok {
+		return
+	}
+	if details.PreProject.AcceptedAdvisor == nil || *details.PreProject.AcceptedAdvisor != userID {
+		app.errorResponse(w, r, http.StatusForbidden, "Only the accepted advisor can review progress reports")
+		return
+	}
+
+	report, err := app.Model.MilestoneDB.GetReport(reportID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if report.MilestoneID != milestone.ID {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+
+	status := r.FormValue("status")
+	var comment *string
+	if c := r.FormValue("comment"); c != "" {
+		comment = &c
+	}
+
+	v := validator.New()
+	data.ValidateReportReview(v, status, comment)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.MilestoneDB.ReviewReport(reportID, userID, status, comment); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	notification := map[string]interface{}{
+		"type":           "progress_report_reviewed",
+		"pre_project_id": details.PreProject.ID,
+		"milestone_id":   milestone.ID,
+		"status":         status,
+		"message":        "قام المشرف بمراجعة تقرير الإنجاز: " + milestone.Title,
+	}
+	for _, student := range details.Students {
+		app.wsManager.BroadcastMessage(student.StudentID, notification)
+	}
+
+	reviewed, err := app.Model.MilestoneDB.GetReport(reportID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"report": reviewed})
+}
+
+// GetPreProjectTimelineHandler returns the milestones of the project with
+// their reports, and a chronological list of everything that happened to it.
+func (app *application) GetPreProjectTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	milestones, err := app.Model.MilestoneDB.ListMilestones(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	versions, err := app.Model.PreProjectDB.ListPreProjectVersions(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	events := []TimelineEvent{{Type: "created", At: details.PreProject.CreatedAt, Title: details.PreProject.Name}}
+	for _, version := range versions {
+		if version.Version == 1 {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			Type:  "version",
+			At:    version.CreatedAt,
+			Title: "الإصدار " + strconv.Itoa(version.Version),
+		})
+	}
+	for _, advisor := range details.Advisors {
+		if advisor.Status == "pending" {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			Type:    "advisor_response",
+			At:      advisor.UpdatedAt,
+			Title:   advisor.AdvisorName,
+			Details: map[string]string{"status": advisor.Status},
+		})
+	}
+	for _, milestone := range milestones {
+		events = append(events, TimelineEvent{
+			Type:    "milestone_due",
+			At:      milestone.DueDate,
+			Title:   milestone.Title,
+			Details: map[string]interface{}{"status": milestone.Status, "overdue": milestone.Overdue},
+		})
+		for _, report := range milestone.Reports {
+			events = append(events, TimelineEvent{Type: "progress_report", At: report.CreatedAt, Title: milestone.Title})
+			if report.ReviewedAt != nil {
+				events = append(events, TimelineEvent{
+					Type:    "progress_review",
+					At:      *report.ReviewedAt,
+					Title:   milestone.Title,
+					Details: map[string]string{"status": report.Status},
+				})
+			}
+		}
+	}
+	if defense, err := app.Model.DefenseDB.GetDefenseByPreProject(preProjectID); err == nil {
+		events = append(events, TimelineEvent{
+			Type:    "defense",
+			At:      defense.StartsAt,
+			Title:   defense.Room,
+			Details: map[string]interface{}{"ends_at": defense.EndsAt},
+		})
+	} else if !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"milestones": milestones,
+		"events":     events,
+	})
+}
+
+func (app *application) ListOverdueMilestonesHandler(w http.ResponseWriter, r *http.Request) {
+	advisorID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	overdue, err := app.Model.MilestoneDB.ListOverdueForAdvisor(advisorID, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"milestones": overdue})
+}
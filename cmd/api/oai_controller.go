@@ -0,0 +1,571 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"project/internal/data"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	oaiPageSize       = 100
+	oaiDayGranularity = "2006-01-02"
+	oaiGranularity    = "2006-01-02T15:04:05Z"
+	oaiDCPrefix       = "oai_dc"
+)
+
+type oaiError struct {
+	Code    string `xml:"code,attr"`
+	Message string `xml:",chardata"`
+}
+
+func (e *oaiError) Error() string { return e.Code + ": " + e.Message }
+
+func oaiBadArgument(message string) *oaiError {
+	return &oaiError{"badArgument", message}
+}
+
+type oaiRequest struct {
+	Verb            string `xml:"verb,attr,omitempty"`
+	Identifier      string `xml:"identifier,attr,omitempty"`
+	MetadataPrefix  string `xml:"metadataPrefix,attr,omitempty"`
+	From            string `xml:"from,attr,omitempty"`
+	Until           string `xml:"until,attr,omitempty"`
+	Set             string `xml:"set,attr,omitempty"`
+	ResumptionToken string `xml:"resumptionToken,attr,omitempty"`
+	BaseURL         string `xml:",chardata"`
+}
+
+type oaiResponse struct {
+	XMLName             xml.Name                `xml:"OAI-PMH"`
+	Xmlns               string                  `xml:"xmlns,attr"`
+	XmlnsXsi            string                  `xml:"xmlns:xsi,attr"`
+	SchemaLocation      string                  `xml:"xsi:schemaLocation,attr"`
+	ResponseDate        string                  `xml:"responseDate"`
+	Request             oaiRequest              `xml:"request"`
+	Errors              []*oaiError             `xml:"error,omitempty"`
+	Identify            *oaiIdentify            `xml:"Identify,omitempty"`
+	ListMetadataFormats *oaiListMetadataFormats `xml:"ListMetadataFormats,omitempty"`
+	ListSets            *oaiListSets            `xml:"ListSets,omitempty"`
+	ListIdentifiers     *oaiListIdentifiers     `xml:"ListIdentifiers,omitempty"`
+	ListRecords         *oaiListRecords         `xml:"ListRecords,omitempty"`
+	GetRecord           *oaiGetRecord           `xml:"GetRecord,omitempty"`
+}
+
+type oaiIdentify struct {
+	RepositoryName    string `xml:"repositoryName"`
+	BaseURL           string `xml:"baseURL"`
+	ProtocolVersion   string `xml:"protocolVersion"`
+	AdminEmail        string `xml:"adminEmail"`
+	EarliestDatestamp string `xml:"earliestDatestamp"`
+	DeletedRecord     string `xml:"deletedRecord"`
+	Granularity       string `xml:"granularity"`
+}
+
+type oaiMetadataFormat struct {
+	MetadataPrefix    string `xml:"metadataPrefix"`
+	Schema            string `xml:"schema"`
+	MetadataNamespace string `xml:"metadataNamespace"`
+}
+
+type oaiListMetadataFormats struct {
+	Formats []oaiMetadataFormat `xml:"metadataFormat"`
+}
+
+type oaiSet struct {
+	Spec string `xml:"setSpec"`
+	Name string `xml:"setName"`
+}
+
+type oaiListSets struct {
+	Sets []oaiSet `xml:"set"`
+}
+
+type oaiHeader struct {
+	Identifier string   `xml:"identifier"`
+	Datestamp  string   `xml:"datestamp"`
+	SetSpecs   []string `xml:"setSpec"`
+}
+
+// oaiDC is a Dublin Core record in the oai_dc format.
+type oaiDC struct {
+	XmlnsOAIDC     string   `xml:"xmlns:oai_dc,attr"`
+	XmlnsDC        string   `xml:"xmlns:dc,attr"`
+	XmlnsXsi       string   `xml:"xmlns:xsi,attr"`
+	SchemaLocation string   `xml:"xsi:schemaLocation,attr"`
+	Title          []string `xml:"dc:title"`
+	Creator        []string `xml:"dc:creator"`
+	Contributor    []string `xml:"dc:contributor"`
+	Subject        []string `xml:"dc:subject"`
+	Description    []string `xml:"dc:description"`
+	Publisher      []string `xml:"dc:publisher"`
+	Date           []string `xml:"dc:date"`
+	Type           []string `xml:"dc:type"`
+	Format         []string `xml:"dc:format"`
+	Identifier     []string `xml:"dc:identifier"`
+	Language       []string `xml:"dc:language"`
+}
+
+type oaiRecord struct {
+	Header   oaiHeader `xml:"header"`
+	Metadata struct {
+		DC oaiDC `xml:"oai_dc:dc"`
+	} `xml:"metadata"`
+}
+
+type oaiResumptionToken struct {
+	Token            string `xml:",chardata"`
+	CompleteListSize int    `xml:"completeListSize,attr"`
+	Cursor           int    `xml:"cursor,attr"`
+}
+
+type oaiListIdentifiers struct {
+	Headers         []oaiHeader         `xml:"header"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiListRecords struct {
+	Records         []oaiRecord         `xml:"record"`
+	ResumptionToken *oaiResumptionToken `xml:"resumptionToken,omitempty"`
+}
+
+type oaiGetRecord struct {
+	Record oaiRecord `xml:"record"`
+}
+
+// oaiArguments lists the arguments each verb accepts; the ones marked true
+// are required.
+var oaiArguments = map[string]map[string]bool{
+	"Identify":            {},
+	"ListMetadataFormats": {"identifier": false},
+	"ListSets":            {"resumptionToken": false},
+	"ListIdentifiers":     {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"ListRecords":         {"metadataPrefix": true, "from": false, "until": false, "set": false, "resumptionToken": false},
+	"GetRecord":           {"identifier": true, "metadataPrefix": true},
+}
+
+// oaiListState is what a resumption token carries: the original arguments
+// and where the list stopped.
+type oaiListState struct {
+	MetadataPrefix string    `json:"m"`
+	From           string    `json:"f,omitempty"`
+	Until          string    `json:"u,omitempty"`
+	Set            string    `json:"s,omitempty"`
+	UpdatedAt      time.Time `json:"t"`
+	ID             uuid.UUID `json:"i"`
+	Cursor         int       `json:"c"`
+}
+
+func (s *oaiListState) token() string {
+	encoded, _ := json.Marshal(s)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func parseOAIToken(token string) (*oaiListState, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, &oaiError{"badResumptionToken", "The resumption token is invalid"}
+	}
+	var state oaiListState
+	if err := json.Unmarshal(decoded, &state); err != nil || state.ID == uuid.Nil {
+		return nil, &oaiError{"badResumptionToken", "The resumption token is invalid"}
+	}
+	return &state, nil
+}
+
+// oaiRepositoryIdentifier is the domain part of the OAI identifiers.
+func oaiRepositoryIdentifier() string {
+	if u, err := url.Parse(data.Domain); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "localhost"
+}
+
+func oaiIdentifier(bookID uuid.UUID) string {
+	return "oai:" + oaiRepositoryIdentifier() + ":" + bookID.String()
+}
+
+func parseOAIIdentifier(identifier string) (uuid.UUID, error) {
+	prefix := "oai:" + oaiRepositoryIdentifier() + ":"
+	if !strings.HasPrefix(identifier, prefix) {
+		return uuid.Nil, &oaiError{"idDoesNotExist", "Unknown identifier"}
+	}
+	id, err := uuid.Parse(strings.TrimPrefix(identifier, prefix))
+	if err != nil {
+		return uuid.Nil, &oaiError{"idDoesNotExist", "Unknown identifier"}
+	}
+	return id, nil
+}
+
+// parseOAIDate reads a from/until argument and returns it with its layout.
+func parseOAIDate(value string) (time.Time, string, error) {
+	for _, layout := range []string{oaiDayGranularity, oaiGranularity} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, layout, nil
+		}
+	}
+	return time.Time{}, "", oaiBadArgument("Dates must be YYYY-MM-DD or YYYY-MM-DDThh:mm:ssZ")
+}
+
+// oaiFilter turns the from, until and set arguments of a list request into
+// a book filter.
+func (app *application) oaiFilter(from, until, set string) (data.OAIFilter, error) {
+	var filter data.OAIFilter
+	var fromLayout, untilLayout string
+	if from != "" {
+		t, layout, err := parseOAIDate(from)
+		if err != nil {
+			return filter, err
+		}
+		filter.From, fromLayout = &t, layout
+	}
+	if until != "" {
+		t, layout, err := parseOAIDate(until)
+		if err != nil {
+			return filter, err
+		}
+		// until is inclusive at its own granularity
+		if layout == oaiDayGranularity {
+			t = t.AddDate(0, 0, 1)
+		} else {
+			t = t.Add(time.Second)
+		}
+		filter.Before, untilLayout = &t, layout
+	}
+	if filter.From != nil && filter.Before != nil {
+		if fromLayout != untilLayout {
+			return filter, oaiBadArgument("from and until must have the same granularity")
+		}
+		if !filter.From.Before(*filter.Before) {
+			return filter, oaiBadArgument("from must not be later than until")
+		}
+	}
+
+	switch {
+	case set == "":
+	case set == "year":
+	case set == "subject":
+		filter.AnySubject = true
+	case strings.HasPrefix(set, "year:"):
+		year, err := strconv.Atoi(strings.TrimPrefix(set, "year:"))
+		if err != nil {
+			return filter, oaiBadArgument("Unknown set")
+		}
+		filter.Year = &year
+	case strings.HasPrefix(set, "subject:"):
+		id, err := uuid.Parse(strings.TrimPrefix(set, "subject:"))
+		if err != nil {
+			return filter, oaiBadArgument("Unknown set")
+		}
+		filter.SubjectID = &id
+	default:
+		return filter, oaiBadArgument("Unknown set")
+	}
+	return filter, nil
+}
+
+// oaiBookRecord maps a book to its header and Dublin Core record.
+func (app *application) oaiBookRecord(book *data.BookWithDetails) oaiRecord {
+	header := oaiHeader{
+		Identifier: oaiIdentifier(book.ID),
+		Datestamp:  book.UpdatedAt.UTC().Format(oaiGranularity),
+		SetSpecs:   []string{"year", fmt.Sprintf("year:%d", book.Year)},
+	}
+	if len(book.Subjects) > 0 {
+		header.SetSpecs = append(header.SetSpecs, "subject")
+	}
+
+	dc := oaiDC{
+		XmlnsOAIDC:     "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		XmlnsDC:        "http://purl.org/dc/elements/1.1/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/oai_dc/ http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+		Title:          []string{book.Name},
+		Creator:        citationNames(book.Students),
+		Contributor:    citationNames(book.Advisors),
+		Publisher:      []string{app.cfg.institution},
+		Date:           []string{strconv.Itoa(book.Year)},
+		Type:           []string{"Text", "info:eu-repo/semantics/bachelorThesis"},
+		Identifier:     []string{oaiIdentifier(book.ID)},
+		Language:       []string{"ar"},
+	}
+	for _, subject := range book.Subjects {
+		header.SetSpecs = append(header.SetSpecs, "subject:"+subject.ID.String())
+		dc.Subject = append(dc.Subject, subject.Name)
+	}
+	dc.Subject = append(dc.Subject, book.Keywords...)
+	if book.Description != nil && *book.Description != "" {
+		dc.Description = []string{*book.Description}
+	}
+	if book.File != nil {
+		dc.Identifier = append(dc.Identifier, *book.File)
+		if contentType := mime.TypeByExtension(path.Ext(*book.File)); contentType != "" {
+			dc.Format = []string{contentType}
+		}
+	}
+
+	record := oaiRecord{Header: header}
+	record.Metadata.DC = dc
+	return record
+}
+
+// OAIHandler is the OAI-PMH 2.0 endpoint through which library and national
+// repositories harvest the archive. Books are exposed in oai_dc with their
+// updated_at as datestamp, grouped in sets per year (year:2024) and per
+// subject (subject:<id>).
+func (app *application) OAIHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	response := &oaiResponse{
+		Xmlns:          "http://www.openarchives.org/OAI/2.0/",
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.openarchives.org/OAI/2.0/ http://www.openarchives.org/OAI/2.0/OAI-PMH.xsd",
+		ResponseDate:   time.Now().UTC().Format(oaiGranularity),
+		Request:        oaiRequest{BaseURL: data.Domain + "/oai"},
+	}
+
+	if err := app.oaiDispatch(r.Form, response); err != nil {
+		var oaiErr *oaiError
+		if !errors.As(err, &oaiErr) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		response.Errors = []*oaiError{oaiErr}
+		// The request element keeps no arguments when they were not valid
+		if oaiErr.Code == "badVerb" || oaiErr.Code == "badArgument" {
+			response.Request = oaiRequest{BaseURL: response.Request.BaseURL}
+		}
+	}
+
+	body, err := xml.MarshalIndent(response, "", "  ")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (app *application) oaiDispatch(form url.Values, response *oaiResponse) error {
+	verb := form.Get("verb")
+	allowed, ok := oaiArguments[verb]
+	if !ok || len(form["verb"]) > 1 {
+		return &oaiError{"badVerb", "Illegal or missing verb"}
+	}
+
+	args := map[string]string{}
+	for name, values := range form {
+		if name == "verb" {
+			continue
+		}
+		if _, ok := allowed[name]; !ok {
+			return oaiBadArgument("Illegal argument: " + name)
+		}
+		if len(values) > 1 {
+			return oaiBadArgument("Repeated argument: " + name)
+		}
+		args[name] = values[0]
+	}
+	if token, ok := args["resumptionToken"]; ok {
+		if len(args) > 1 {
+			return oaiBadArgument("resumptionToken is an exclusive argument")
+		}
+		if token == "" {
+			return &oaiError{"badResumptionToken", "The resumption token is invalid"}
+		}
+	} else {
+		for name, required := range allowed {
+			if required && args[name] == "" {
+				return oaiBadArgument("Missing argument: " + name)
+			}
+		}
+	}
+
+	response.Request = oaiRequest{
+		Verb:            verb,
+		Identifier:      args["identifier"],
+		MetadataPrefix:  args["metadataPrefix"],
+		From:            args["from"],
+		Until:           args["until"],
+		Set:             args["set"],
+		ResumptionToken: args["resumptionToken"],
+		BaseURL:         response.Request.BaseURL,
+	}
+
+	switch verb {
+	case "Identify":
+		earliest, err := app.Model.BookDB.EarliestDatestamp()
+		if err != nil {
+			return err
+		}
+		response.Identify = &oaiIdentify{
+			RepositoryName:    app.cfg.institution + " - أرشيف مشاريع التخرج",
+			BaseURL:           response.Request.BaseURL,
+			ProtocolVersion:   "2.0",
+			AdminEmail:        app.cfg.oaiAdminEmail,
+			EarliestDatestamp: earliest.UTC().Format(oaiGranularity),
+			DeletedRecord:     "no",
+			Granularity:       "YYYY-MM-DDThh:mm:ssZ",
+		}
+		return nil
+
+	case "ListMetadataFormats":
+		if identifier := args["identifier"]; identifier != "" {
+			if err := app.oaiCheckIdentifier(identifier); err != nil {
+				return err
+			}
+		}
+		response.ListMetadataFormats = &oaiListMetadataFormats{Formats: []oaiMetadataFormat{{
+			MetadataPrefix:    oaiDCPrefix,
+			Schema:            "http://www.openarchives.org/OAI/2.0/oai_dc.xsd",
+			MetadataNamespace: "http://www.openarchives.org/OAI/2.0/oai_dc/",
+		}}}
+		return nil
+
+	case "ListSets":
+		// Every set is sent in one response
+		if args["resumptionToken"] != "" {
+			return &oaiError{"badResumptionToken", "The resumption token is invalid"}
+		}
+		sets, err := app.oaiSets()
+		if err != nil {
+			return err
+		}
+		response.ListSets = &oaiListSets{Sets: sets}
+		return nil
+
+	case "GetRecord":
+		if args["metadataPrefix"] != oaiDCPrefix {
+			return &oaiError{"cannotDisseminateFormat", "Only oai_dc is supported"}
+		}
+		bookID, err := parseOAIIdentifier(args["identifier"])
+		if err != nil {
+			return err
+		}
+		book, err := app.Model.BookDB.GetBook(bookID)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				return &oaiError{"idDoesNotExist", "Unknown identifier"}
+			}
+			return err
+		}
+		response.GetRecord = &oaiGetRecord{Record: app.oaiBookRecord(book)}
+		return nil
+	}
+
+	// ListIdentifiers and ListRecords
+	state := &oaiListState{
+		MetadataPrefix: args["metadataPrefix"],
+		From:           args["from"],
+		Until:          args["until"],
+		Set:            args["set"],
+	}
+	var after *data.OAICursor
+	if token := args["resumptionToken"]; token != "" {
+		var err error
+		if state, err = parseOAIToken(token); err != nil {
+			return err
+		}
+		after = &data.OAICursor{UpdatedAt: state.UpdatedAt, ID: state.ID}
+	}
+	if state.MetadataPrefix != oaiDCPrefix {
+		return &oaiError{"cannotDisseminateFormat", "Only oai_dc is supported"}
+	}
+	filter, err := app.oaiFilter(state.From, state.Until, state.Set)
+	if err != nil {
+		if after != nil {
+			return &oaiError{"badResumptionToken", "The resumption token is invalid"}
+		}
+		return err
+	}
+
+	books, total, err := app.Model.BookDB.ListOAIBooks(filter, after, oaiPageSize)
+	if err != nil {
+		return err
+	}
+	if len(books) == 0 {
+		if after != nil {
+			return &oaiError{"badResumptionToken", "The resumption token is invalid"}
+		}
+		return &oaiError{"noRecordsMatch", "No records match the request"}
+	}
+
+	// A list split over several responses ends with an empty token
+	var resumption *oaiResumptionToken
+	cursor := state.Cursor
+	if after != nil || cursor+len(books) < total {
+		resumption = &oaiResumptionToken{CompleteListSize: total, Cursor: cursor}
+		if cursor+len(books) < total {
+			last := books[len(books)-1]
+			state.UpdatedAt, state.ID, state.Cursor = last.UpdatedAt, last.ID, cursor+len(books)
+			resumption.Token = state.token()
+		}
+	}
+
+	if verb == "ListIdentifiers" {
+		list := &oaiListIdentifiers{ResumptionToken: resumption}
+		for i := range books {
+			list.Headers = append(list.Headers, app.oaiBookRecord(&books[i]).Header)
+		}
+		response.ListIdentifiers = list
+		return nil
+	}
+	list := &oaiListRecords{ResumptionToken: resumption}
+	for i := range books {
+		list.Records = append(list.Records, app.oaiBookRecord(&books[i]))
+	}
+	response.ListRecords = list
+	return nil
+}
+
+func (app *application) oaiCheckIdentifier(identifier string) error {
+	bookID, err := parseOAIIdentifier(identifier)
+	if err != nil {
+		return err
+	}
+	if _, err := app.Model.BookDB.GetBook(bookID); err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			return &oaiError{"idDoesNotExist", "Unknown identifier"}
+		}
+		return err
+	}
+	return nil
+}
+
+// oaiSets lists the year and subject sets with their parents.
+func (app *application) oaiSets() ([]oaiSet, error) {
+	years, err := app.Model.BookDB.ListBookYears()
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := app.Model.SubjectDB.ListSubjects("")
+	if err != nil {
+		return nil, err
+	}
+
+	sets := []oaiSet{{"year", "السنة"}}
+	for _, year := range years {
+		sets = append(sets, oaiSet{fmt.Sprintf("year:%d", year), strconv.Itoa(year)})
+	}
+	sets = append(sets, oaiSet{"subject", "التخصص"})
+	for _, subject := range subjects {
+		sets = append(sets, oaiSet{"subject:" + subject.ID.String(), subject.Name})
+	}
+	return sets, nil
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"project/internal/data"
 	"project/utils"
+	"project/utils/pdf"
 	"project/utils/validator"
 	"strconv"
 	"strings"
@@ -48,10 +50,36 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 	season := r.FormValue("season")
 
+	// Like checkWindow, a term without a configured calendar is not
+	// restricted
+	term, err := app.Model.AcademicTermDB.GetTerm(year, season)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if term != nil && !term.IsOpen(data.WindowProposal, time.Now()) {
+		app.errorResponse(w, r, http.StatusForbidden, fmt.Sprintf("Proposal submissions for %s %d are open from %s to %s",
+			term.Season, term.Year, term.ProposalOpen.Format("2006-01-02"), term.ProposalClose.Format("2006-01-02")))
+		return
+	}
+
+	// Proposals may be any document; a PDF's text is sent to the similarity
+	// check
 	var file *string
+	var doc *pdf.Info
 	if uploadedFile, fileHeader, err := r.FormFile("file"); err == nil {
 		defer uploadedFile.Close()
-		fileName, err := utils.SaveFile(uploadedFile, "pre_projects", fileHeader.Filename)
+		var content []byte
+		content, doc, err = readDocument(uploadedFile)
+		if err != nil {
+			if errors.Is(err, errDocumentTooLarge) {
+				app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+				return
+			}
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid file")
+			return
+		}
+		fileName, err := utils.SaveFile(bytes.NewReader(content), "pre_projects", fileHeader.Filename)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "Invalid file")
 			return
@@ -106,6 +134,11 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 	fileDescription := r.FormValue("file_description")
 
+	subjectIDs, ok := app.readSubjects(w, r)
+	if !ok {
+		return
+	}
+
 	preProject := data.PreProject{
 		ID:              uuid.New(),
 		Name:            name,
@@ -116,6 +149,8 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		Season:          season,
 		ProjectOwner:    usersID,
 		CanUpdate:       true,
+		Keywords:        utils.ParseKeywords(r.FormValue("keywords")),
+		SubjectIDs:      subjectIDs,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
@@ -126,7 +161,7 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, 0.3)
+	similarityCheckResp, err := utils.CheckProjectSimilarity(name, description, documentText(doc), 0.3)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -173,6 +208,12 @@ func (app *application) CreatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"pre_project": preProject})
 }
+
+// GetPreProjectsHandler lists pre-projects with optional year, season,
+// status and advisor filters. Signed-in users can also ask for mine=true
+// (their projects as a student), awaiting=true (waiting for their response as
+// an advisor) and discussant=true. Files, grades and advisor responses are
+// only shown to admins and to the people involved in a project.
 func (app *application) GetPreProjectsHandler(w http.ResponseWriter, r *http.Request) {
 	queryParams := r.URL.Query()
 	id := queryParams.Get("id")
@@ -186,15 +227,105 @@ func (app *application) GetPreProjectsHandler(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	preProjects, meta, err := app.Model.PreProjectDB.ListPreProjects(queryParams)
+	var userID *uuid.UUID
+	if raw, ok := r.Context().Value(UserIDKey).(string); ok {
+		if parsed, err := uuid.Parse(raw); err == nil {
+			userID = &parsed
+		}
+	}
+	isAdmin := isAdminRequest(r)
+
+	filter := data.PreProjectFilter{
+		Season: strings.ToLower(queryParams.Get("season")),
+		Status: strings.ToLower(queryParams.Get("status")),
+	}
+	v := validator.New()
+	if year := queryParams.Get("year"); year != "" {
+		parsed, err := strconv.Atoi(year)
+		v.Check(err == nil, "year", "السنة غير صالحة")
+		filter.Year = &parsed
+	}
+	if advisor := queryParams.Get("advisor"); advisor != "" {
+		parsed, err := uuid.Parse(advisor)
+		v.Check(err == nil, "advisor", "معرف المشرف غير صالح")
+		filter.AcceptedAdvisor = &parsed
+	}
+	for param, target := range map[string]**uuid.UUID{
+		"mine":       &filter.Student,
+		"awaiting":   &filter.AwaitingAdvisor,
+		"discussant": &filter.Discussant,
+	} {
+		if on, _ := strconv.ParseBool(queryParams.Get(param)); on {
+			if userID == nil {
+				app.unauthorizedResponse(w, r)
+				return
+			}
+			*target = userID
+		}
+	}
+	data.ValidatePreProjectFilter(v, &filter)
+	if !isAdmin {
+		checkPublicPreProjectColumns(v, queryParams)
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	preProjects, meta, err := app.Model.PreProjectDB.ListPreProjects(queryParams, filter)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	for i := range preProjects {
+		if isAdmin || userID != nil && preProjects[i].Involves(*userID) {
+			continue
+		}
+		redactPreProject(&preProjects[i])
+	}
+
 	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"pre_projects": preProjects, "meta": meta})
 }
 
+// publicPreProjectColumns are the columns anyone may filter and sort the
+// listing by. The others hold what redactPreProject hides, which could be
+// told apart by filtering on them.
+var publicPreProjectColumns = map[string]bool{
+	"id": true, "b.id": true,
+	"name": true, "b.name": true,
+	"year": true, "b.year": true,
+	"season": true, "b.season": true,
+	"created_at": true, "b.created_at": true,
+	"updated_at": true, "b.updated_at": true,
+}
+
+func checkPublicPreProjectColumns(v *validator.Validator, queryParams url.Values) {
+	if filters := queryParams.Get("filters"); filters != "" {
+		for _, pair := range strings.Split(filters, ",") {
+			column, _, _ := strings.Cut(pair, ":")
+			v.Check(publicPreProjectColumns[column], "filters", "لا يمكن التصفية حسب هذا الحقل")
+		}
+	}
+	if sort := queryParams.Get("sort"); sort != "" {
+		v.Check(publicPreProjectColumns[strings.TrimPrefix(sort, "-")], "sort", "لا يمكن الترتيب حسب هذا الحقل")
+	}
+}
+
+// redactPreProject keeps what is public about a project: its title, term,
+// status and who is on it, without contact details, files or grades.
+func redactPreProject(item *data.PreProjectListItem) {
+	item.File = nil
+	item.ThumbnailURL = nil
+	item.FileDescription = nil
+	item.Degree = nil
+	for i := range item.Students {
+		item.Students[i].Email = ""
+	}
+	item.Advisors = nil
+	item.Discussants = nil
+}
+
 func (app *application) GetPreProjectsHandlerByID(w http.ResponseWriter, r *http.Request) {
 	preProjectID := uuid.MustParse(r.PathValue("id"))
 
@@ -230,10 +361,22 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if !isAdmin {
+		if !existingPreProject.PreProject.CanUpdate {
+			app.errorResponse(w, r, http.StatusForbidden, "This pre-project has been locked by an admin")
+			return
+		}
+		if err := app.checkWindow(&existingPreProject.PreProject, submissionWindow(&existingPreProject.PreProject)); err != nil {
+			app.handleRetrievalError(w, r, err)
+			return
+		}
+	}
+
 	preProject := &data.PreProject{
-		ID:           preProjectID,
-		ProjectOwner: existingPreProject.PreProject.ProjectOwner,
-		UpdatedAt:    time.Now(),
+		ID:              preProjectID,
+		ProjectOwner:    existingPreProject.PreProject.ProjectOwner,
+		AcceptedAdvisor: existingPreProject.PreProject.AcceptedAdvisor,
+		UpdatedAt:       time.Now(),
 	}
 	nameChanged := false
 	descriptionChanged := false
@@ -247,7 +390,7 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 	canUpdateStr := r.FormValue("can_update")
 	var canUpdate bool
-	if canUpdateStr != "" {
+	if canUpdateStr != "" && isAdmin {
 		var err error
 		canUpdate, err = strconv.ParseBool(canUpdateStr)
 		if err != nil {
@@ -266,9 +409,11 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		preProject.Description = existingPreProject.PreProject.Description
 	}
 
+	// The term decides which submission windows apply, so only an admin
+	// may move a project to another one
 	yearStr := r.FormValue("year")
 	var year int
-	if yearStr != "" {
+	if yearStr != "" && isAdmin {
 		year, err = strconv.Atoi(yearStr)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "Invalid year")
@@ -280,7 +425,7 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 	}
 
 	season := r.FormValue("season")
-	if season != "" {
+	if season != "" && isAdmin {
 		preProject.Season = season
 	} else {
 		preProject.Season = existingPreProject.PreProject.Season
@@ -288,6 +433,15 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 
 	degree := r.FormValue("degree")
 	if degree != "" && isAdmin {
+		graded, err := app.gradedByRubric(&existingPreProject.PreProject)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if graded {
+			app.errorResponse(w, r, http.StatusConflict, "The degree of this project is computed from the examiners' score sheets")
+			return
+		}
 		intDegree, err := strconv.Atoi(degree)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "Invalid degree")
@@ -298,16 +452,25 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		preProject.Degree = existingPreProject.PreProject.Degree
 	}
 	var file *string
-	var oldFile *string
+	var doc *pdf.Info
 	if uploadedFile, fileHeader, err := r.FormFile("file"); err == nil {
 		defer uploadedFile.Close()
-		fileName, err := utils.SaveFile(uploadedFile, "pre_projects", fileHeader.Filename)
+		var content []byte
+		content, doc, err = readDocument(uploadedFile)
+		if err != nil {
+			if errors.Is(err, errDocumentTooLarge) {
+				app.failedValidationResponse(w, r, map[string]string{"file": err.Error()})
+				return
+			}
+			app.errorResponse(w, r, http.StatusBadRequest, "Invalid file")
+			return
+		}
+		fileName, err := utils.SaveFile(bytes.NewReader(content), "pre_projects", fileHeader.Filename)
 		if err != nil {
 			app.errorResponse(w, r, http.StatusBadRequest, "Invalid file")
 			return
 		}
 		file = &fileName
-		oldFile = existingPreProject.PreProject.File
 		preProject.File = file
 	} else if err != http.ErrMissingFile {
 		app.errorResponse(w, r, http.StatusBadRequest, "Invalid file upload")
@@ -325,6 +488,18 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 	} else if existingPreProject.PreProject.FileDescription != nil {
 		preProject.FileDescription = existingPreProject.PreProject.FileDescription
 	}
+	// Keywords and subjects are replaced whenever the field is sent, so an
+	// empty value clears them
+	if _, ok := r.Form["keywords"]; ok {
+		preProject.Keywords = utils.ParseKeywords(r.FormValue("keywords"))
+	} else {
+		preProject.Keywords = existingPreProject.PreProject.Keywords
+	}
+	subjectIDs, ok := app.readSubjects(w, r)
+	if !ok {
+		return
+	}
+	preProject.SubjectIDs = subjectIDs
 	// Determine if user lists are being updated
 	studentsProvided := r.FormValue("students") != ""
 	advisorsProvided := r.FormValue("advisors") != ""
@@ -424,13 +599,26 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 
 	v := validator.New()
 	data.ValidatePreProject(v, preProject, students, advisors)
+	data.ValidateDiscussants(v, discutants, len(existingPreProject.ExternalDiscussants), advisors, students)
+	if v.Valid() && discutantsProvided {
+		ineligible, err := app.Model.DiscussantDB.IneligibleDiscussants(preProject.ID, discutants)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, id := range discutants {
+			if reason, ok := ineligible[id]; ok {
+				v.AddError("discutant", reason)
+			}
+		}
+	}
 	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	if nameChanged || descriptionChanged {
-		similarityCheckResp, err := utils.CheckProjectSimilarity(preProject.Name, *preProject.Description, 0.3)
+	if nameChanged || descriptionChanged || doc != nil {
+		similarityCheckResp, err := utils.CheckProjectSimilarity(preProject.Name, *preProject.Description, documentText(doc), 0.3)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -468,18 +656,20 @@ func (app *application) UpdatePreProjectHandler(w http.ResponseWriter, r *http.R
 		}
 	}
 
-	err = app.Model.PreProjectDB.UpdatePreProject(preProject, advisors, students, discutants)
+	submittedBy, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	if oldFile != nil {
-		*oldFile = strings.TrimPrefix(*oldFile, data.Domain+"/")
-
-		if err := utils.DeleteFile(*oldFile); err != nil {
-			log.Printf("Failed to delete old file %s: %v", *oldFile, err)
+	// The previous file stays on disk: it belongs to an earlier version.
+	err = app.Model.PreProjectDB.UpdatePreProject(preProject, advisors, students, discutants, submittedBy)
+	if err != nil {
+		if file != nil {
+			utils.DeleteFile(*file)
 		}
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
 	updatedPreProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
@@ -510,6 +700,8 @@ func (app *application) DeletePreProjectHandler(w http.ResponseWriter, r *http.R
 			app.errorResponse(w, r, http.StatusNotFound, "Pre-project not found")
 		case strings.Contains(err.Error(), "unauthorized to delete"):
 			app.errorResponse(w, r, http.StatusForbidden, "Unauthorized to delete this pre-project")
+		case errors.Is(err, data.ErrArchivedProject):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -520,6 +712,9 @@ func (app *application) DeletePreProjectHandler(w http.ResponseWriter, r *http.R
 		"message": "Pre-project deleted successfully",
 	})
 }
+
+// RespondToPreProjectHandler records the advisor's answer to one project,
+// with the same checks as each item of RespondToAdvisorRequestsHandler.
 func (app *application) RespondToPreProjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Context() == nil {
 		app.errorResponse(w, r, http.StatusInternalServerError, "Invalid context")
@@ -559,41 +754,9 @@ func (app *application) RespondToPreProjectHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectUUID)
-	if err != nil {
-		app.handleRetrievalError(w, r, err)
-		return
-	}
-
-	if preProject.PreProject.AcceptedAdvisor != nil && *preProject.PreProject.AcceptedAdvisor == advisorUUID {
-		app.errorResponse(w, r, http.StatusConflict, "You Already Accepeted the Project!")
-		return
-	}
-
-	if preProject == nil {
-		app.errorResponse(w, r, http.StatusNotFound, "Pre-project not found")
-		return
-	}
-	v := validator.New()
-	advisorIDs := make([]uuid.UUID, len(preProject.Advisors))
-	for i, advisor := range preProject.Advisors {
-		advisorIDs[i] = advisor.AdvisorID
-	}
-
-	data.ValidateAdvisorResponse(v, advisorUUID, status, advisorIDs)
-	if !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
-	}
-	err = app.Model.PreProjectDB.InsertAdvisorResponse(preProjectUUID, advisorUUID, status)
-	if err != nil {
-		switch {
-
-		case strings.Contains(err.Error(), "already been accepted by another advisor"):
-			app.errorResponse(w, r, http.StatusConflict, "Pre-project has already been accepted by another advisor")
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	result := app.respondAsAdvisor(advisorUUID, advisorResponseInput{PreProjectID: preProjectUUID, Status: status})
+	if !result.Recorded {
+		app.errorResponse(w, r, result.Code, result.Error)
 		return
 	}
 	message := "Advisor response recorded successfully"
@@ -633,33 +796,44 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		app.handleRetrievalError(w, r, err)
 		return
 	}
-	degreeStr := r.FormValue("degree")
-	Degree, err := strconv.Atoi(degreeStr)
+	// Terms graded with a rubric carry the aggregated degree over; older
+	// terms still take the degree from the form
+	graded, err := app.gradedByRubric(&preProject.PreProject)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid Degree"))
+		app.serverErrorResponse(w, r, err)
 		return
 	}
-	if Degree == 0 {
-		app.badRequestResponse(w, r, errors.New("لا يمكن ترك الدرجة فارغة"))
-		return
+	Degree := preProject.PreProject.Degree
+	if graded {
+		if Degree == nil {
+			app.errorResponse(w, r, http.StatusConflict, "All examiners must submit their score sheets before the project can be archived")
+			return
+		}
+	} else {
+		degreeStr := r.FormValue("degree")
+		formDegree, err := strconv.Atoi(degreeStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid Degree"))
+			return
+		}
+		if formDegree == 0 {
+			app.badRequestResponse(w, r, errors.New("لا يمكن ترك الدرجة فارغة"))
+			return
+		}
+		Degree = &formDegree
 	}
 
-	cleanedFilePath := ""
-	if preProject.PreProject.File != nil {
-		cleanedFilePath = strings.TrimPrefix(*preProject.PreProject.File, data.Domain+"/")
-
+	if preProject.PreProject.AcceptedAdvisor == nil {
+		app.failedValidationResponse(w, r, map[string]string{"advisors": data.ErrNoAcceptedAdvisor.Error()})
+		return
 	}
 
 	book := &data.Book{
-		ID:          uuid.New(),
 		Name:        preProject.PreProject.Name,
 		Description: preProject.PreProject.Description,
-		File:        &cleanedFilePath,
 		Year:        preProject.PreProject.Year,
 		Season:      preProject.PreProject.Season,
-		Degree:      preProject.PreProject.Degree,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		Degree:      Degree,
 	}
 	studentIDs := make([]uuid.UUID, len(preProject.Students))
 	for i, student := range preProject.Students {
@@ -671,7 +845,6 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		Discussants[i] = dis.DiscussantID
 	}
 	advisorIDs := []uuid.UUID{*preProject.PreProject.AcceptedAdvisor}
-	fmt.Print("ASDASD")
 
 	v := validator.New()
 	data.ValidateBook(v, book, studentIDs, advisorIDs, Discussants, false)
@@ -680,17 +853,17 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = app.Model.BookDB.InsertBook(book, Discussants, advisorIDs, studentIDs)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-		return
-	}
-
-	err = app.Model.PreProjectDB.DeletePreProject(preProjectID, preProject.PreProject.ProjectOwner)
+	book, err = app.Model.BookDB.ArchivePreProject(preProjectID, *Degree)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrNoAcceptedAdvisor):
+			app.failedValidationResponse(w, r, map[string]string{"advisors": err.Error()})
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
 		return
 	}
+	app.ingestBookFile(book.ID, book.File)
 
 	createdBook, err := app.Model.BookDB.GetBookWithDetails(book.ID)
 	if err != nil {
@@ -703,9 +876,12 @@ func (app *application) MovePreProjectToBookHandler(w http.ResponseWriter, r *ht
 		"message": "Pre-project successfully moved to book",
 	})
 }
+
+// CanUpdate is the admin exception mechanism on top of the academic calendar.
+// Setting Can_update to true unlocks the project and grants an override for
+// its current submission window, optionally until a date; false locks it.
 func (app *application) CanUpdate(w http.ResponseWriter, r *http.Request) {
 	canupdate := r.FormValue("Can_update")
-	fmt.Println("Received Can_update value:", canupdate) // Debugging line
 	var canUpdate bool
 
 	canUpdate, err := strconv.ParseBool(canupdate)
@@ -714,11 +890,42 @@ func (app *application) CanUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id := uuid.MustParse(r.PathValue("id"))
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	adminID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	override := &data.WindowOverride{
+		PreProjectID: id,
+		GrantedBy:    &adminID,
+	}
+	if until := r.FormValue("until"); until != "" {
+		expiresAt, err := parseTimeValue(until)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid until date"))
+			return
+		}
+		override.ExpiresAt = &expiresAt
+	}
+	if reason := r.FormValue("reason"); reason != "" {
+		override.Reason = &reason
+	}
 
-	err = app.Model.PreProjectDB.UpdateCanUpdate(canUpdate, id)
+	preProject, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(id)
 	if err != nil {
-		app.errorResponse(w, r, http.StatusBadRequest, "Error while updating the pre project canUpdate!")
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	override.Window = submissionWindow(&preProject.PreProject)
+
+	if err := app.Model.PreProjectDB.UpdateCanUpdate(canUpdate, id, override); err != nil {
+		app.handleRetrievalError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+func (app *application) ListPreProjectVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	versions, err := app.Model.PreProjectDB.ListPreProjectVersions(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"versions": versions})
+}
+
+func (app *application) GetPreProjectVersionHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+	version, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || version < 1 {
+		app.badRequestResponse(w, r, errors.New("invalid version number"))
+		return
+	}
+
+	preProjectVersion, err := app.Model.PreProjectDB.GetPreProjectVersion(preProjectID, version)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"version": preProjectVersion})
+}
+
+// DiffPreProjectVersionsHandler compares two versions given by ?from=&to=.
+// "to" defaults to the latest version. "from" defaults to the version the
+// requesting advisor last responded to, or to the version before "to".
+func (app *application) DiffPreProjectVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	preProjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid pre-project ID"))
+		return
+	}
+
+	latest, err := app.Model.PreProjectDB.LatestPreProjectVersion(preProjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if latest == 0 {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return
+	}
+
+	to := latest
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err = strconv.Atoi(toStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid 'to' version"))
+			return
+		}
+	}
+
+	from := to - 1
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		from, err = strconv.Atoi(fromStr)
+		if err != nil {
+			app.badRequestResponse(w, r, errors.New("invalid 'from' version"))
+			return
+		}
+	} else if reviewed := app.reviewedVersion(r, preProjectID); reviewed > 0 && reviewed < to {
+		from = reviewed
+	}
+	if from < 1 {
+		from = 1
+	}
+
+	fromVersion, err := app.Model.PreProjectDB.GetPreProjectVersion(preProjectID, from)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	toVersion, err := app.Model.PreProjectDB.GetPreProjectVersion(preProjectID, to)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"diff": data.DiffPreProjectVersions(fromVersion, toVersion),
+	})
+}
+
+// reviewedVersion returns the version the current user last responded to as
+// an advisor of the pre-project, or 0.
+func (app *application) reviewedVersion(r *http.Request, preProjectID uuid.UUID) int {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		return 0
+	}
+	details, err := app.Model.PreProjectDB.GetPreProjectWithAdvisorDetails(preProjectID)
+	if err != nil {
+		return 0
+	}
+	for _, advisor := range details.Advisors {
+		if advisor.AdvisorID == userID && advisor.ReviewedVersion != nil {
+			return *advisor.ReviewedVersion
+		}
+	}
+	return 0
+}
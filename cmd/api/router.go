@@ -35,14 +35,24 @@ func (app *application) Router() *michi.Router {
 
 	r.Use(rateLimiter.Limit)
 
-	r.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads"))))
+	r.Handle("/uploads/", app.uploadsHandler())
 
 	r.Route("/", func(sub *michi.Router) {
 		sub.HandleFunc("GET book", http.HandlerFunc(app.ListBooksHandler))
-		sub.HandleFunc("GET book/{id}", http.HandlerFunc(app.GetBookWithDetailsHandler))
+		sub.HandleFunc("GET book/search", http.HandlerFunc(app.SearchBooksHandler))
+		sub.HandleFunc("GET book/browse", http.HandlerFunc(app.BrowseBooksHandler))
+		sub.HandleFunc("GET book/cite", http.HandlerFunc(app.ExportCitationsHandler))
+		sub.HandleFunc("GET book/popular", http.HandlerFunc(app.PopularBooksHandler))
+		sub.HandleFunc("GET book/{id}/cite", http.HandlerFunc(app.CiteBookHandler))
+		sub.HandleFunc("GET book/{id}/stats", http.HandlerFunc(app.BookStatsHandler))
+		sub.HandleFunc("GET book/{id}/related", http.HandlerFunc(app.RelatedBooksHandler))
+		sub.HandleFunc("GET book/{id}/file", app.PassTokenMiddleware(app.BookFileHandler))
+		sub.HandleFunc("GET book/{id}", app.PassTokenMiddleware(app.GetBookWithDetailsHandler))
 		sub.HandleFunc("POST book", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateBookHandler))))
 		sub.HandleFunc("DELETE book/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteBookHandler))))
 		sub.HandleFunc("PUT book/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateBookHandler))))
+		sub.HandleFunc("GET book/{id}/history", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GetBookHistoryHandler))))
+		sub.HandleFunc("POST book/{id}/restore", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RestoreBookHandler))))
 		sub.HandleFunc("GET post", http.HandlerFunc(app.ListPostsHandler))
 		sub.HandleFunc("GET post/{id}", http.HandlerFunc(app.GetPostHandler))
 		sub.HandleFunc("POST post", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.CreatePostHandler))))
@@ -75,7 +85,7 @@ func (app *application) Router() *michi.Router {
 		sub.HandleFunc("GET me", app.AuthMiddleware(http.HandlerFunc((app.MeHandler))))
 		//graduation projects!
 		sub.HandleFunc("POST preproject", app.AuthMiddleware(app.GradStudentOnlyMiddleware(http.HandlerFunc(app.CreatePreProjectHandler))))
-		sub.HandleFunc("GET preproject", http.HandlerFunc(app.GetPreProjectsHandler))
+		sub.HandleFunc("GET preproject", app.PassTokenMiddleware(app.GetPreProjectsHandler))
 		sub.HandleFunc("GET preproject/{id}", app.AuthMiddleware(http.HandlerFunc(app.GetPreProjectsHandlerByID)))
 		sub.HandleFunc("PUT preproject/{id}", app.AuthMiddleware(app.AdminOrProjectOwnerOnlyMiddleware(http.HandlerFunc(app.UpdatePreProjectHandler))))
 		sub.HandleFunc("DELETE preproject/{id}", app.AuthMiddleware(app.AdminOrProjectOwnerOnlyMiddleware(http.HandlerFunc(app.DeletePreProjectHandler))))
@@ -83,7 +93,116 @@ func (app *application) Router() *michi.Router {
 		sub.HandleFunc("POST advisorresponse", app.AuthMiddleware(app.AdvisorsOnlyMiddleware(http.HandlerFunc(app.RespondToPreProjectHandler))))
 		sub.HandleFunc("DELETE preproject/{id}/reset-advisors", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ResetPreProjectAdvisorsHandler))))
 		sub.HandleFunc("PUT canupdate/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CanUpdate))))
+		sub.HandleFunc("GET preproject/{id}/overrides", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListWindowOverridesHandler))))
+		sub.HandleFunc("POST preproject/{id}/overrides", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GrantWindowOverrideHandler))))
+		sub.HandleFunc("DELETE preproject/{id}/overrides/{window}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RevokeWindowOverrideHandler))))
 
+		sub.HandleFunc("GET terms", http.HandlerFunc(app.ListAcademicTermsHandler))
+		sub.HandleFunc("GET terms/current", http.HandlerFunc(app.GetCurrentAcademicTermHandler))
+		sub.HandleFunc("GET terms/{year}/{season}", http.HandlerFunc(app.GetAcademicTermHandler))
+		sub.HandleFunc("POST terms", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateAcademicTermHandler))))
+		sub.HandleFunc("PUT terms/{year}/{season}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateAcademicTermHandler))))
+		sub.HandleFunc("DELETE terms/{year}/{season}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteAcademicTermHandler))))
+
+		sub.HandleFunc("GET preproject/{id}/versions", app.AuthMiddleware(app.PreProjectHistoryMiddleware(http.HandlerFunc(app.ListPreProjectVersionsHandler))))
+		sub.HandleFunc("GET preproject/{id}/versions/diff", app.AuthMiddleware(app.PreProjectHistoryMiddleware(http.HandlerFunc(app.DiffPreProjectVersionsHandler))))
+		sub.HandleFunc("GET preproject/{id}/versions/{n}", app.AuthMiddleware(app.PreProjectHistoryMiddleware(http.HandlerFunc(app.GetPreProjectVersionHandler))))
+
+		sub.HandleFunc("GET schedule", http.HandlerFunc(app.PublicScheduleHandler))
+		sub.HandleFunc("GET preproject/{id}/defense", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.GetPreProjectDefenseHandler))))
+		sub.HandleFunc("POST preproject/{id}/defense", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ScheduleDefenseHandler))))
+		sub.HandleFunc("POST defenses/auto-schedule", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.AutoScheduleDefensesHandler))))
+		sub.HandleFunc("PUT defenses/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RescheduleDefenseHandler))))
+		sub.HandleFunc("DELETE defenses/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteDefenseHandler))))
+		sub.HandleFunc("GET me/availability", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.ListAvailabilityHandler))))
+		sub.HandleFunc("POST me/availability", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.AddAvailabilityHandler))))
+		sub.HandleFunc("DELETE me/availability/{id}", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.DeleteAvailabilityHandler))))
+
+		sub.HandleFunc("GET rubrics", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.ListRubricsHandler))))
+		sub.HandleFunc("GET rubrics/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.GetRubricHandler))))
+		sub.HandleFunc("POST rubrics", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateRubricHandler))))
+		sub.HandleFunc("PUT rubrics/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateRubricHandler))))
+		sub.HandleFunc("DELETE rubrics/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteRubricHandler))))
+		sub.HandleFunc("GET preproject/{id}/score-sheet", app.AuthMiddleware(http.HandlerFunc(app.GetMyScoreSheetHandler)))
+		sub.HandleFunc("PUT preproject/{id}/score-sheet", app.AuthMiddleware(http.HandlerFunc(app.SaveScoresHandler)))
+		sub.HandleFunc("POST preproject/{id}/score-sheet/submit", app.AuthMiddleware(http.HandlerFunc(app.SubmitScoreSheetHandler)))
+		sub.HandleFunc("GET preproject/{id}/grades", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.GetGradesHandler))))
+		sub.HandleFunc("POST preproject/{id}/score-sheets/{sheet_id}/reopen", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ReopenScoreSheetHandler))))
+
+		sub.HandleFunc("GET terms/{year}/{season}/milestones", app.AuthMiddleware(http.HandlerFunc(app.ListMilestoneTemplatesHandler)))
+		sub.HandleFunc("POST terms/{year}/{season}/milestones", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateMilestoneTemplateHandler))))
+		sub.HandleFunc("POST terms/{year}/{season}/archive", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ArchiveTermHandler))))
+		sub.HandleFunc("GET terms/{year}/{season}/archive-jobs", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListArchiveJobsHandler))))
+		sub.HandleFunc("GET archive-jobs/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GetArchiveJobHandler))))
+		sub.HandleFunc("PUT milestones/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateMilestoneTemplateHandler))))
+		sub.HandleFunc("DELETE milestones/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteMilestoneTemplateHandler))))
+		sub.HandleFunc("GET preproject/{id}/timeline", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.GetPreProjectTimelineHandler))))
+		sub.HandleFunc("POST preproject/{id}/milestones/{milestone_id}/reports", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.SubmitProgressReportHandler))))
+		sub.HandleFunc("PUT preproject/{id}/milestones/{milestone_id}/reports/{report_id}", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.ReviewProgressReportHandler))))
+		sub.HandleFunc("GET me/overdue-milestones", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.ListOverdueMilestonesHandler))))
+
+		sub.HandleFunc("GET preproject/{id}/meetings", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.ListMeetingsHandler))))
+		sub.HandleFunc("POST preproject/{id}/meetings", app.AuthMiddleware(http.HandlerFunc(app.CreateMeetingHandler)))
+		sub.HandleFunc("GET preproject/{id}/meetings/{meeting_id}", app.AuthMiddleware(app.PreProjectMemberMiddleware(http.HandlerFunc(app.GetMeetingHandler))))
+		sub.HandleFunc("PUT preproject/{id}/meetings/{meeting_id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateMeetingHandler)))
+		sub.HandleFunc("DELETE preproject/{id}/meetings/{meeting_id}", app.AuthMiddleware(http.HandlerFunc(app.DeleteMeetingHandler)))
+		sub.HandleFunc("POST preproject/{id}/meetings/{meeting_id}/acknowledge", app.AuthMiddleware(http.HandlerFunc(app.AcknowledgeMeetingHandler)))
+		sub.HandleFunc("PUT preproject/{id}/meetings/{meeting_id}/action-items/{item_id}", app.AuthMiddleware(http.HandlerFunc(app.UpdateActionItemHandler)))
+		sub.HandleFunc("GET me/meetings/export", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.ExportMeetingsHandler))))
+
+		sub.HandleFunc("GET me/advisor-requests", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.ListAdvisorRequestsHandler))))
+		sub.HandleFunc("POST me/advisor-requests/respond", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.RespondToAdvisorRequestsHandler))))
+		sub.HandleFunc("GET topics", app.AuthMiddleware(http.HandlerFunc(app.ListTopicsHandler)))
+		sub.HandleFunc("GET topics/{id}", app.AuthMiddleware(http.HandlerFunc(app.GetTopicHandler)))
+		sub.HandleFunc("POST topics", app.AuthMiddleware(app.TeacherOnlyMiddleware(http.HandlerFunc(app.CreateTopicHandler))))
+		sub.HandleFunc("PUT topics/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.UpdateTopicHandler))))
+		sub.HandleFunc("DELETE topics/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.DeleteTopicHandler))))
+		sub.HandleFunc("POST topics/{id}/applications", app.AuthMiddleware(app.GradStudentOnlyMiddleware(http.HandlerFunc(app.ApplyToTopicHandler))))
+		sub.HandleFunc("GET topics/{id}/applications", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.ListTopicApplicationsHandler))))
+		sub.HandleFunc("PUT topics/{id}/applications/{application_id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.ReviewTopicApplicationHandler))))
+		sub.HandleFunc("DELETE topics/{id}/applications/{application_id}", app.AuthMiddleware(app.GradStudentOnlyMiddleware(http.HandlerFunc(app.WithdrawTopicApplicationHandler))))
+		sub.HandleFunc("GET me/topic-applications", app.AuthMiddleware(app.GradStudentOnlyMiddleware(http.HandlerFunc(app.ListMyTopicApplicationsHandler))))
+		sub.HandleFunc("GET discussant-conflicts", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.ListDiscussantConflictsHandler))))
+		sub.HandleFunc("POST discussant-conflicts", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.CreateDiscussantConflictHandler))))
+		sub.HandleFunc("DELETE discussant-conflicts/{id}", app.AuthMiddleware(app.AdminOrTeacherMiddleware(http.HandlerFunc(app.DeleteDiscussantConflictHandler))))
+		sub.HandleFunc("GET terms/{year}/{season}/examiners", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListExaminerLoadsHandler))))
+		sub.HandleFunc("POST terms/{year}/{season}/discussants/assign", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.AssignDiscussantsHandler))))
+		sub.HandleFunc("GET external-examiners", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListExternalExaminersHandler))))
+		sub.HandleFunc("POST external-examiners", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateExternalExaminerHandler))))
+		sub.HandleFunc("GET external-examiners/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.GetExternalExaminerHandler))))
+		sub.HandleFunc("PUT external-examiners/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateExternalExaminerHandler))))
+		sub.HandleFunc("DELETE external-examiners/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteExternalExaminerHandler))))
+		sub.HandleFunc("POST preproject/{id}/external-examiners", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.AssignPreProjectExternalExaminerHandler))))
+		sub.HandleFunc("DELETE preproject/{id}/external-examiners/{examiner_id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UnassignPreProjectExternalExaminerHandler))))
+		sub.HandleFunc("GET preproject/{id}/external-examiners/{examiner_id}/links", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListExternalLinksHandler))))
+		sub.HandleFunc("POST preproject/{id}/external-examiners/{examiner_id}/links", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateExternalLinkHandler))))
+		sub.HandleFunc("DELETE preproject/{id}/external-examiners/{examiner_id}/links", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RevokeExternalLinksHandler))))
+		sub.HandleFunc("POST book/{id}/external-examiners", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.AssignBookExternalExaminerHandler))))
+		sub.HandleFunc("DELETE book/{id}/external-examiners/{examiner_id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UnassignBookExternalExaminerHandler))))
+		sub.HandleFunc("GET external/{token}", app.ExternalLinkMiddleware(http.HandlerFunc(app.GetExternalProjectHandler)))
+		sub.HandleFunc("GET external/{token}/score-sheet", app.ExternalLinkMiddleware(http.HandlerFunc(app.GetExternalScoreSheetHandler)))
+		sub.HandleFunc("PUT external/{token}/score-sheet", app.ExternalLinkMiddleware(http.HandlerFunc(app.SaveExternalScoresHandler)))
+		sub.HandleFunc("POST external/{token}/score-sheet/submit", app.ExternalLinkMiddleware(http.HandlerFunc(app.SubmitExternalScoreSheetHandler)))
+		sub.HandleFunc("POST preproject/{id}/withdrawal", app.AuthMiddleware(http.HandlerFunc(app.RequestWithdrawalHandler)))
+		sub.HandleFunc("GET preproject/{id}/closures", app.AuthMiddleware(app.PreProjectHistoryMiddleware(http.HandlerFunc(app.ListPreProjectClosuresHandler))))
+		sub.HandleFunc("POST preproject/{id}/cancel", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CancelPreProjectHandler))))
+		sub.HandleFunc("GET withdrawals", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListWithdrawalsHandler))))
+		sub.HandleFunc("POST withdrawals/{id}/approve", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ApproveWithdrawalHandler))))
+		sub.HandleFunc("POST withdrawals/{id}/reject", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.RejectWithdrawalHandler))))
+		sub.HandleFunc("DELETE withdrawals/{id}", app.AuthMiddleware(http.HandlerFunc(app.RetractWithdrawalHandler)))
+		sub.HandleFunc("POST preproject/{id}/extend", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ExtendPreProjectHandler))))
+		sub.HandleFunc("GET preproject/{id}/extensions", app.AuthMiddleware(app.PreProjectHistoryMiddleware(http.HandlerFunc(app.ListPreProjectExtensionsHandler))))
+		sub.HandleFunc("GET terms/{year}/{season}/extensions", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.ListTermExtensionsHandler))))
+		sub.HandleFunc("GET subjects", http.HandlerFunc(app.ListSubjectsHandler))
+		sub.HandleFunc("POST subjects", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.CreateSubjectHandler))))
+		sub.HandleFunc("PUT subjects/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.UpdateSubjectHandler))))
+		sub.HandleFunc("DELETE subjects/{id}", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.DeleteSubjectHandler))))
+		sub.HandleFunc("POST subjects/{id}/merge", app.AuthMiddleware(app.AdminOnlyMiddleware(http.HandlerFunc(app.MergeSubjectHandler))))
+		sub.HandleFunc("GET subjects/{id}/books", http.HandlerFunc(app.ListSubjectBooksHandler))
+		sub.HandleFunc("GET keywords/suggest", http.HandlerFunc(app.SuggestKeywordsHandler))
+		sub.HandleFunc("GET oai", http.HandlerFunc(app.OAIHandler))
+		sub.HandleFunc("POST oai", http.HandlerFunc(app.OAIHandler))
+		sub.HandleFunc("GET files/url", app.PassTokenMiddleware(app.FileURLHandler))
 		sub.HandleFunc("POST chats", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.CreateChatHandler))))                       // Create a new chat
 		sub.HandleFunc("DELETE chats/{chat_id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.DeleteChatHandler))))           // Delete a specific chat message
 		sub.HandleFunc("GET conversation/{conversation_id}", app.AuthMiddleware(app.ChatParticipantMiddleware(http.HandlerFunc(app.GetChatsHandler)))) // Get conversation between two users
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// readSubjects parses the comma-separated subject ids of a project or book
+// form and checks that they exist. It returns nil when the field was not
+// sent, so updates keep the current subjects.
+func (app *application) readSubjects(w http.ResponseWriter, r *http.Request) ([]uuid.UUID, bool) {
+	if _, ok := r.Form["subjects"]; !ok {
+		return nil, true
+	}
+
+	subjectIDs := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, value := range strings.Split(r.FormValue("subjects"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			app.failedValidationResponse(w, r, map[string]string{"subjects": data.ErrSubjectNotFound.Error()})
+			return nil, false
+		}
+		if !seen[id] {
+			seen[id] = true
+			subjectIDs = append(subjectIDs, id)
+		}
+	}
+
+	if err := app.Model.SubjectDB.CheckSubjects(subjectIDs); err != nil {
+		if errors.Is(err, data.ErrSubjectNotFound) {
+			app.failedValidationResponse(w, r, map[string]string{"subjects": err.Error()})
+		} else {
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	return subjectIDs, true
+}
+
+func (app *application) ListSubjectsHandler(w http.ResponseWriter, r *http.Request) {
+	subjects, err := app.Model.SubjectDB.ListSubjects(r.URL.Query().Get("q"))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"subjects": subjects})
+}
+
+func (app *application) CreateSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	subject := &data.Subject{Name: strings.TrimSpace(r.FormValue("name"))}
+	if description := strings.TrimSpace(r.FormValue("description")); description != "" {
+		subject.Description = &description
+	}
+
+	v := validator.New()
+	data.ValidateSubject(v, subject)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.SubjectDB.InsertSubject(subject); err != nil {
+		if errors.Is(err, data.ErrDuplicatedKey) {
+			app.failedValidationResponse(w, r, map[string]string{"name": "يوجد تخصص بهذا الاسم"})
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"subject": subject})
+}
+
+// UpdateSubjectHandler renames a subject or changes its description.
+func (app *application) UpdateSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	subjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid subject ID"))
+		return
+	}
+	subject, err := app.Model.SubjectDB.GetSubject(subjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	if name := strings.TrimSpace(r.FormValue("name")); name != "" {
+		subject.Name = name
+	}
+	if _, ok := r.Form["description"]; ok {
+		subject.Description = nil
+		if description := strings.TrimSpace(r.FormValue("description")); description != "" {
+			subject.Description = &description
+		}
+	}
+
+	v := validator.New()
+	data.ValidateSubject(v, subject)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.SubjectDB.UpdateSubject(subject); err != nil {
+		if errors.Is(err, data.ErrDuplicatedKey) {
+			app.failedValidationResponse(w, r, map[string]string{"name": "يوجد تخصص بهذا الاسم"})
+			return
+		}
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	updated, err := app.Model.SubjectDB.GetSubject(subjectID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"subject": updated})
+}
+
+func (app *application) DeleteSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	subjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid subject ID"))
+		return
+	}
+	if err := app.Model.SubjectDB.DeleteSubject(subjectID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Subject deleted successfully"})
+}
+
+// MergeSubjectHandler folds the subject in the path into the one given as
+// "into"; its projects and books are moved over and it is removed.
+func (app *application) MergeSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid subject ID"))
+		return
+	}
+	targetID, err := uuid.Parse(r.FormValue("into"))
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"into": "التخصص المراد الدمج فيه مطلوب"})
+		return
+	}
+
+	if err := app.Model.SubjectDB.MergeSubjects(sourceID, targetID); err != nil {
+		if errors.Is(err, data.ErrSubjectMergeSelf) {
+			app.failedValidationResponse(w, r, map[string]string{"into": err.Error()})
+			return
+		}
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+
+	subject, err := app.Model.SubjectDB.GetSubject(targetID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"subject": subject})
+}
+
+func (app *application) ListSubjectBooksHandler(w http.ResponseWriter, r *http.Request) {
+	subjectID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid subject ID"))
+		return
+	}
+	v := validator.New()
+	page, perPage := readPage(v, r.URL.Query())
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	subject, err := app.Model.SubjectDB.GetSubject(subjectID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	books, meta, err := app.Model.SubjectDB.ListSubjectBooks(subjectID, page, perPage)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"subject": subject, "books": books, "meta": meta})
+}
+
+// SuggestKeywordsHandler autocompletes keywords from ?q=.
+func (app *application) SuggestKeywordsHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 50 {
+			app.failedValidationResponse(w, r, map[string]string{"limit": "يجب أن يكون الحد بين 1 و 50"})
+			return
+		}
+		limit = parsed
+	}
+
+	suggestions, err := app.Model.SubjectDB.SuggestKeywords(r.URL.Query().Get("q"), limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"keywords": suggestions})
+}
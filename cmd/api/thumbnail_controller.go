@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"project/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxThumbnailRenders is how many previews are made at once, so a page of
+// new thumbnails does not render them all together
+const maxThumbnailRenders = 2
+
+var (
+	// thumbnailLocks holds a lock for each file being previewed, so the
+	// requests for one thumbnail render it once without waiting on others
+	thumbnailLocks = struct {
+		sync.Mutex
+		files map[string]*thumbnailLock
+	}{files: map[string]*thumbnailLock{}}
+	thumbnailRenders = make(chan struct{}, maxThumbnailRenders)
+	// thumbnailFailures remembers the files that could not be previewed so
+	// they are not rendered again on every request
+	thumbnailFailures sync.Map
+)
+
+// uploadsHandler serves uploaded files to whoever the policy of the resource
+// they belong to lets download them, or to anyone holding a signed link. A
+// thumbnail follows the policy of its file and, when it does not exist yet,
+// is rendered on this first request. A book file that is served counts as a
+// download of the book.
+func (app *application) uploadsHandler() http.Handler {
+	files := http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads")))
+	return app.PassTokenMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		file := uploadPath(r.URL.Path)
+		if !utils.VerifyFileSignature(file, r.URL.Query(), time.Now()) &&
+			!app.authorizeFile(w, r, strings.TrimSuffix(file, utils.ThumbnailSuffix)) {
+			return
+		}
+
+		if strings.HasSuffix(r.URL.Path, utils.ThumbnailSuffix) {
+			app.ensureThumbnail(r.URL.Path)
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		files.ServeHTTP(rec, r)
+		if r.Method == http.MethodGet && (rec.status == http.StatusOK || rec.status == http.StatusPartialContent) {
+			app.recordBookDownload(r, file)
+		}
+	})
+}
+
+func (app *application) ensureThumbnail(urlPath string) {
+	rel := path.Clean("/" + strings.TrimPrefix(urlPath, "/uploads/"))
+	thumbnail := filepath.Join("uploads", filepath.FromSlash(rel))
+	file := strings.TrimSuffix(thumbnail, utils.ThumbnailSuffix)
+	if !utils.HasPreview(file) || strings.HasSuffix(file, utils.ThumbnailSuffix) {
+		return
+	}
+	if _, failed := thumbnailFailures.Load(file); failed {
+		return
+	}
+	if _, err := os.Stat(thumbnail); err == nil {
+		return
+	}
+
+	unlock := lockThumbnail(file)
+	defer unlock()
+	// Another request may have made it while this one waited
+	if _, err := os.Stat(thumbnail); err == nil {
+		return
+	}
+	if _, failed := thumbnailFailures.Load(file); failed {
+		return
+	}
+
+	thumbnailRenders <- struct{}{}
+	defer func() { <-thumbnailRenders }()
+	if _, err := utils.GenerateThumbnail(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		app.log.Printf("thumbnail %s: %v", file, err)
+		thumbnailFailures.Store(file, true)
+	}
+}
+
+type thumbnailLock struct {
+	sync.Mutex
+	waiting int
+}
+
+// lockThumbnail locks the preview of file and returns its unlock. The lock
+// is dropped once nobody holds or waits for it.
+func lockThumbnail(file string) func() {
+	thumbnailLocks.Lock()
+	lock, ok := thumbnailLocks.files[file]
+	if !ok {
+		lock = &thumbnailLock{}
+		thumbnailLocks.files[file] = lock
+	}
+	lock.waiting++
+	thumbnailLocks.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		thumbnailLocks.Lock()
+		lock.waiting--
+		if lock.waiting == 0 {
+			delete(thumbnailLocks.files, file)
+		}
+		thumbnailLocks.Unlock()
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"project/internal/data"
+	"project/utils"
+	"project/utils/validator"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// topicOwnerOrAdmin reports whether the current user may manage the topic:
+// the teacher who published it or an admin.
+func topicOwnerOrAdmin(r *http.Request, topic *data.ProjectTopic) bool {
+	if isAdminRequest(r) {
+		return true
+	}
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	return err == nil && userID == topic.TeacherID
+}
+
+// readTopicForm fills topic from the request form; fields that are missing
+// keep their current value so PUT can send only what changed.
+func readTopicForm(r *http.Request, topic *data.ProjectTopic) error {
+	if title := r.FormValue("title"); title != "" {
+		topic.Title = title
+	}
+	if description := r.FormValue("description"); description != "" {
+		topic.Description = description
+	}
+	if _, ok := r.Form["prerequisites"]; ok {
+		prerequisites := r.FormValue("prerequisites")
+		topic.Prerequisites = &prerequisites
+		if prerequisites == "" {
+			topic.Prerequisites = nil
+		}
+	}
+	if size := r.FormValue("max_team_size"); size != "" {
+		maxTeamSize, err := strconv.Atoi(size)
+		if err != nil {
+			return errors.New("invalid max_team_size")
+		}
+		topic.MaxTeamSize = maxTeamSize
+	}
+	if year := r.FormValue("year"); year != "" {
+		y, err := strconv.Atoi(year)
+		if err != nil {
+			return errors.New("invalid year")
+		}
+		topic.Year = y
+	}
+	if season := r.FormValue("season"); season != "" {
+		topic.Season = strings.ToLower(season)
+	}
+	if status := r.FormValue("status"); status != "" {
+		topic.Status = strings.ToLower(status)
+	}
+	return nil
+}
+
+func (app *application) CreateTopicHandler(w http.ResponseWriter, r *http.Request) {
+	teacherID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	topic := &data.ProjectTopic{TeacherID: teacherID, MaxTeamSize: 3, Status: data.TopicOpen}
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := readTopicForm(r, topic); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTopic(v, topic)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.TopicDB.InsertTopic(topic); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"topic": topic})
+}
+
+// ListTopicsHandler lists published topics. Students see open topics by
+// default; ?status, ?year, ?season and ?teacher narrow the list, and
+// ?mine=true shows the caller's own topics.
+func (app *application) ListTopicsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := data.TopicFilter{
+		Season: strings.ToLower(query.Get("season")),
+		Status: strings.ToLower(query.Get("status")),
+	}
+
+	v := validator.New()
+	if year := query.Get("year"); year != "" {
+		y, err := strconv.Atoi(year)
+		v.Check(err == nil, "year", "السنة غير صالحة")
+		filter.Year = y
+	}
+	if filter.Season != "" {
+		v.Check(validator.In(filter.Season, "spring", "fall"), "season", "يجب اختيار موسم ربيع أو خريف")
+	}
+	if filter.Status != "" {
+		v.Check(validator.In(filter.Status, data.TopicOpen, data.TopicClosed, data.TopicAssigned), "status", "حالة الموضوع غير صالحة")
+	}
+	if teacher := query.Get("teacher"); teacher != "" {
+		teacherID, err := uuid.Parse(teacher)
+		v.Check(err == nil, "teacher", "معرف المشرف غير صالح")
+		filter.TeacherID = &teacherID
+	}
+	if mine, _ := strconv.ParseBool(query.Get("mine")); mine {
+		userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		filter.TeacherID = &userID
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	if filter.Status == "" && filter.TeacherID == nil {
+		filter.Status = data.TopicOpen
+	}
+
+	topics, err := app.Model.TopicDB.ListTopics(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"topics": topics})
+}
+
+func (app *application) GetTopicHandler(w http.ResponseWriter, r *http.Request) {
+	topicID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid topic ID"))
+		return
+	}
+	topic, err := app.Model.TopicDB.GetTopic(topicID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"topic": topic})
+}
+
+// getManagedTopic loads the topic in the path and checks that the caller
+// may manage it, writing the error response otherwise.
+func (app *application) getManagedTopic(w http.ResponseWriter, r *http.Request) (*data.ProjectTopic, bool) {
+	topicID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid topic ID"))
+		return nil, false
+	}
+	topic, err := app.Model.TopicDB.GetTopic(topicID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if !topicOwnerOrAdmin(r, topic) {
+		app.forbiddenResponse(w, r)
+		return nil, false
+	}
+	return topic, true
+}
+
+func (app *application) UpdateTopicHandler(w http.ResponseWriter, r *http.Request) {
+	topic, ok := app.getManagedTopic(w, r)
+	if !ok {
+		return
+	}
+	if topic.Status == data.TopicAssigned {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrTopicHasApplicants.Error())
+		return
+	}
+
+	if err := r.ParseMultipartForm(10 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := readTopicForm(r, topic); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateTopic(v, topic)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if err := app.Model.TopicDB.UpdateTopic(topic); err != nil {
+		if errors.Is(err, data.ErrTopicHasApplicants) {
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"topic": topic})
+}
+
+func (app *application) DeleteTopicHandler(w http.ResponseWriter, r *http.Request) {
+	topic, ok := app.getManagedTopic(w, r)
+	if !ok {
+		return
+	}
+	if err := app.Model.TopicDB.DeleteTopic(topic.ID); err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Topic deleted successfully"})
+}
+
+// ApplyToTopicHandler files an application for the current student and the
+// teammates listed in "students" (comma-separated emails), while the
+// term's proposal window is open.
+func (app *application) ApplyToTopicHandler(w http.ResponseWriter, r *http.Request) {
+	applicantID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	topicID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid topic ID"))
+		return
+	}
+	topic, err := app.Model.TopicDB.GetTopic(topicID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return
+	}
+	if topic.Status != data.TopicOpen {
+		app.errorResponse(w, r, http.StatusConflict, data.ErrTopicUnavailable.Error())
+		return
+	}
+
+	term, err := app.Model.AcademicTermDB.GetTerm(topic.Year, topic.Season)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if term != nil && !term.IsOpen(data.WindowProposal, time.Now()) {
+		app.errorResponse(w, r, http.StatusForbidden, fmt.Sprintf("Proposal submissions for %s %d are open from %s to %s",
+			term.Season, term.Year, term.ProposalOpen.Format("2006-01-02"), term.ProposalClose.Format("2006-01-02")))
+		return
+	}
+
+	existing, err := app.Model.PreProjectDB.CheckExistingPreProject(applicantID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if existing != nil {
+		app.errorResponse(w, r, http.StatusConflict, "You already have an existing pre-project")
+		return
+	}
+
+	studentIDs := []uuid.UUID{applicantID}
+	added := map[uuid.UUID]bool{applicantID: true}
+	if r.FormValue("students") != "" {
+		for _, email := range strings.Split(r.FormValue("students"), ",") {
+			email = strings.TrimSpace(email)
+			student, err := app.Model.UserDB.GetUserByEmail(email)
+			if err != nil {
+				app.errorResponse(w, r, http.StatusBadRequest, "Invalid student email")
+				return
+			}
+			existing, err := app.Model.PreProjectDB.CheckExistingPreProject(student.ID)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if existing != nil {
+				app.errorResponse(w, r, http.StatusConflict, fmt.Sprintf("Student with email %s already has an existing pre-project", email))
+				return
+			}
+			if !added[student.ID] {
+				studentIDs = append(studentIDs, student.ID)
+				added[student.ID] = true
+			}
+		}
+	}
+
+	v := validator.New()
+	v.Check(len(studentIDs) <= topic.MaxTeamSize, "students", data.ErrTeamTooLarge.Error())
+	var message *string
+	if m := r.FormValue("message"); m != "" {
+		v.Check(len(m) <= 1500, "message", "الرسالة طويلة جداً")
+		message = &m
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	application := &data.TopicApplication{
+		TopicID:     topic.ID,
+		TopicTitle:  topic.Title,
+		ApplicantID: applicantID,
+		Message:     message,
+	}
+	if err := app.Model.TopicDB.InsertApplication(application, studentIDs); err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicatedKey):
+			app.errorResponse(w, r, http.StatusConflict, "You have already applied to this topic")
+		case errors.Is(err, data.ErrTopicUnavailable):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrTeamTooLarge):
+			app.failedValidationResponse(w, r, map[string]string{"students": err.Error()})
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
+		return
+	}
+
+	app.wsManager.BroadcastMessage(topic.TeacherID, map[string]interface{}{
+		"type":     "topic_application",
+		"topic_id": topic.ID,
+		"message":  fmt.Sprintf("طلب جديد على الموضوع: %s", topic.Title),
+	})
+
+	created, err := app.Model.TopicDB.GetApplication(application.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusCreated, utils.Envelope{"application": created})
+}
+
+func (app *application) ListTopicApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	topic, ok := app.getManagedTopic(w, r)
+	if !ok {
+		return
+	}
+	applications, err := app.Model.TopicDB.ListApplications(topic.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"topic": topic, "applications": applications})
+}
+
+// getTopicApplication loads the application in the path and checks that it
+// belongs to the topic in the path.
+func (app *application) getTopicApplication(w http.ResponseWriter, r *http.Request, topicID uuid.UUID) (*data.TopicApplication, bool) {
+	applicationID, err := uuid.Parse(r.PathValue("application_id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid application ID"))
+		return nil, false
+	}
+	application, err := app.Model.TopicDB.GetApplication(applicationID)
+	if err != nil {
+		app.handleRetrievalError(w, r, err)
+		return nil, false
+	}
+	if application.TopicID != topicID {
+		app.handleRetrievalError(w, r, data.ErrRecordNotFound)
+		return nil, false
+	}
+	return application, true
+}
+
+// ReviewTopicApplicationHandler accepts or rejects an application. Accepting
+// creates the team's pre-project with the topic's teacher as its accepted
+// advisor and declines the topic's other pending applications.
+func (app *application) ReviewTopicApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	topic, ok := app.getManagedTopic(w, r)
+	if !ok {
+		return
+	}
+	application, ok := app.getTopicApplication(w, r, topic.ID)
+	if !ok {
+		return
+	}
+
+	status := strings.ToLower(r.FormValue("status"))
+	if !validator.In(status, data.ApplicationAccepted, data.ApplicationRejected) {
+		app.failedValidationResponse(w, r, map[string]string{"status": "Invalid status. Must be 'accepted' or 'rejected'"})
+		return
+	}
+
+	if status == data.ApplicationRejected {
+		if err := app.Model.TopicDB.RejectApplication(application.ID); err != nil {
+			if errors.Is(err, data.ErrApplicationClosed) {
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+				return
+			}
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		for _, student := range application.Students {
+			app.wsManager.BroadcastMessage(student.ID, map[string]interface{}{
+				"type":     "topic_application_rejected",
+				"topic_id": topic.ID,
+				"message":  fmt.Sprintf("تم رفض طلبكم على الموضوع: %s", topic.Title),
+			})
+		}
+		utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Application rejected"})
+		return
+	}
+
+	preProject, err := app.Model.TopicDB.AcceptApplication(application.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrApplicationClosed), errors.Is(err, data.ErrTopicAlreadyTaken),
+			errors.Is(err, data.ErrStudentHasProject):
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+		case errors.Is(err, data.ErrTeamTooLarge):
+			app.failedValidationResponse(w, r, map[string]string{"students": err.Error()})
+		default:
+			app.handleRetrievalError(w, r, err)
+		}
+		return
+	}
+	if err := app.Model.MilestoneDB.InstantiateMilestones(preProject.ID); err != nil {
+		app.logError(r, err)
+	}
+
+	for _, student := range application.Students {
+		app.wsManager.BroadcastMessage(student.ID, map[string]interface{}{
+			"type":           "topic_application_accepted",
+			"topic_id":       topic.ID,
+			"pre_project_id": preProject.ID,
+			"message":        fmt.Sprintf("تم قبول طلبكم على الموضوع: %s", topic.Title),
+		})
+	}
+
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{
+		"message":     "Application accepted",
+		"pre_project": preProject,
+	})
+}
+
+// WithdrawTopicApplicationHandler lets the applicant take back a pending
+// application.
+func (app *application) WithdrawTopicApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	topicID, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid topic ID"))
+		return
+	}
+	application, ok := app.getTopicApplication(w, r, topicID)
+	if !ok {
+		return
+	}
+	if application.ApplicantID != userID {
+		app.forbiddenResponse(w, r)
+		return
+	}
+
+	if err := app.Model.TopicDB.WithdrawApplication(application.ID); err != nil {
+		if errors.Is(err, data.ErrApplicationClosed) {
+			app.errorResponse(w, r, http.StatusConflict, err.Error())
+			return
+		}
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"message": "Application withdrawn"})
+}
+
+func (app *application) ListMyTopicApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.Context().Value(UserIDKey).(string))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	applications, err := app.Model.TopicDB.ListStudentApplications(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	utils.SendJSONResponse(w, http.StatusOK, utils.Envelope{"applications": applications})
+}
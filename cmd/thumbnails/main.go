@@ -0,0 +1,55 @@
+// Command thumbnails makes the previews of uploaded files that do not have
+// one yet, such as files uploaded before previews existed. The API makes
+// missing ones on first request; this avoids the wait on a first visit.
+//
+//	go run ./cmd/thumbnails [-uploads ./uploads] [-dirs books,posts,pre_projects] [-force]
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"project/utils"
+	"strings"
+)
+
+func main() {
+	uploads := flag.String("uploads", "./uploads", "Directory the API stores uploads in")
+	dirs := flag.String("dirs", "books,posts,pre_projects", "Comma-separated upload folders whose files get previews")
+	force := flag.Bool("force", false, "Render previews again even if they exist")
+	flag.Parse()
+
+	var made, skipped, failed int
+	for _, dir := range strings.Split(*dirs, ",") {
+		root := filepath.Join(*uploads, strings.TrimSpace(dir))
+		err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() || strings.HasSuffix(file, utils.ThumbnailSuffix) || !utils.HasPreview(file) {
+				return nil
+			}
+			if _, err := os.Stat(utils.ThumbnailPath(file)); err == nil && !*force {
+				skipped++
+				return nil
+			}
+			if _, err := utils.GenerateThumbnail(file); err != nil {
+				log.Printf("%s: %v", file, err)
+				failed++
+				return nil
+			}
+			made++
+			return nil
+		})
+		if err != nil {
+			log.Fatalf("walking %s: %v", root, err)
+		}
+	}
+	log.Printf("thumbnails: %d made, %d already present, %d failed", made, skipped, failed)
+}
@@ -0,0 +1,318 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	WindowProposal        = "proposal"
+	WindowAdvisorResponse = "advisor_response"
+	WindowFinalSubmission = "final_submission"
+)
+
+type AcademicTermDB struct {
+	db *sqlx.DB
+}
+
+type AcademicTerm struct {
+	ID                      uuid.UUID `db:"id" json:"id"`
+	Year                    int       `db:"year" json:"year"`
+	Season                  string    `db:"season" json:"season"`
+	ProposalOpen            time.Time `db:"proposal_open" json:"proposal_open"`
+	ProposalClose           time.Time `db:"proposal_close" json:"proposal_close"`
+	AdvisorResponseDeadline time.Time `db:"advisor_response_deadline" json:"advisor_response_deadline"`
+	FinalSubmissionDeadline time.Time `db:"final_submission_deadline" json:"final_submission_deadline"`
+	DefenseStart            time.Time `db:"defense_start" json:"defense_start"`
+	DefenseEnd              time.Time `db:"defense_end" json:"defense_end"`
+	CreatedAt               time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt               time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type WindowOverride struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	PreProjectID uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	Window       string     `db:"window_name" json:"window"`
+	ExpiresAt    *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	Reason       *string    `db:"reason" json:"reason,omitempty"`
+	GrantedBy    *uuid.UUID `db:"granted_by" json:"granted_by,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+func ValidateAcademicTerm(v *validator.Validator, term *AcademicTerm) {
+	v.Check(term.Year > 2000, "year", "السنة مطلوبة")
+	v.Check(term.Season == "spring" || term.Season == "fall", "season", "يجب اختيار موسم ربيع أو خريف")
+	v.Check(term.ProposalOpen.Before(term.ProposalClose), "proposal_close", "يجب أن يكون إغلاق التقديم بعد فتحه")
+	v.Check(!term.AdvisorResponseDeadline.Before(term.ProposalClose), "advisor_response_deadline", "يجب أن يكون موعد رد المشرف بعد إغلاق التقديم")
+	v.Check(!term.FinalSubmissionDeadline.Before(term.AdvisorResponseDeadline), "final_submission_deadline", "يجب أن يكون موعد التسليم النهائي بعد موعد رد المشرف")
+	v.Check(!term.DefenseEnd.Before(term.DefenseStart), "defense_end", "يجب أن تكون نهاية فترة المناقشة بعد بدايتها")
+}
+
+func ValidateWindow(v *validator.Validator, window string) {
+	v.Check(validator.In(window, WindowProposal, WindowAdvisorResponse, WindowFinalSubmission), "window", "نوع الفترة غير صالح")
+}
+
+// WindowBounds returns when a window opens and closes in this term.
+func (t *AcademicTerm) WindowBounds(window string) (time.Time, time.Time) {
+	switch window {
+	case WindowAdvisorResponse:
+		return t.ProposalOpen, t.AdvisorResponseDeadline
+	case WindowFinalSubmission:
+		return t.ProposalOpen, t.FinalSubmissionDeadline
+	default:
+		return t.ProposalOpen, t.ProposalClose
+	}
+}
+
+// IsOpen reports whether now falls inside the given window.
+func (t *AcademicTerm) IsOpen(window string, now time.Time) bool {
+	open, close := t.WindowBounds(window)
+	return !now.Before(open) && !now.After(close)
+}
+
+// DefensePeriodEnd is when the defense period is over. A defense_end given
+// as a plain date covers the whole of that day.
+func (t *AcademicTerm) DefensePeriodEnd() time.Time {
+	if t.DefenseEnd.Equal(t.DefenseEnd.Truncate(24 * time.Hour)) {
+		return t.DefenseEnd.Add(24 * time.Hour)
+	}
+	return t.DefenseEnd
+}
+
+// defensePeriodEnd is DefensePeriodEnd for the defense_end column given.
+func defensePeriodEnd(column string) string {
+	return fmt.Sprintf("(CASE WHEN %[1]s = date_trunc('day', %[1]s) THEN %[1]s + INTERVAL '1 day' ELSE %[1]s END)", column)
+}
+
+// InDefensePeriod reports whether the given time is inside the defense period.
+func (t *AcademicTerm) InDefensePeriod(at time.Time) bool {
+	return !at.Before(t.DefenseStart) && !at.After(t.DefensePeriodEnd())
+}
+
+func (a *AcademicTermDB) InsertTerm(term *AcademicTerm) error {
+	query, args, err := QB.Insert("academic_terms").
+		Columns("year", "season", "proposal_open", "proposal_close", "advisor_response_deadline",
+			"final_submission_deadline", "defense_start", "defense_end").
+		Values(term.Year, term.Season, term.ProposalOpen, term.ProposalClose, term.AdvisorResponseDeadline,
+			term.FinalSubmissionDeadline, term.DefenseStart, term.DefenseEnd).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	err = a.db.QueryRowx(query, args...).StructScan(term)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to insert academic term: %w", err)
+	}
+	return nil
+}
+
+func (a *AcademicTermDB) UpdateTerm(term *AcademicTerm) error {
+	query, args, err := QB.Update("academic_terms").
+		SetMap(map[string]interface{}{
+			"proposal_open":             term.ProposalOpen,
+			"proposal_close":            term.ProposalClose,
+			"advisor_response_deadline": term.AdvisorResponseDeadline,
+			"final_submission_deadline": term.FinalSubmissionDeadline,
+			"defense_start":             term.DefenseStart,
+			"defense_end":               term.DefenseEnd,
+			"updated_at":                time.Now(),
+		}).
+		Where(squirrel.Eq{"id": term.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	result, err := a.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update academic term: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (a *AcademicTermDB) GetTerm(year int, season string) (*AcademicTerm, error) {
+	query, args, err := QB.Select("*").
+		From("academic_terms").
+		Where(squirrel.Eq{"year": year, "season": season}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var term AcademicTerm
+	if err := a.db.Get(&term, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get academic term: %w", err)
+	}
+	return &term, nil
+}
+
+// GetCurrentTerm returns the term whose calendar (from proposal opening to
+// the end of the defense period) contains now, or the next upcoming one.
+func (a *AcademicTermDB) GetCurrentTerm(now time.Time) (*AcademicTerm, error) {
+	query, args, err := QB.Select("*").
+		From("academic_terms").
+		Where(defensePeriodEnd("defense_end")+" > ?", now).
+		OrderBy("proposal_open ASC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var term AcademicTerm
+	if err := a.db.Get(&term, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get current academic term: %w", err)
+	}
+	return &term, nil
+}
+
+// GetLatestTerm returns the last term that has started by now, running or
+// over, unlike GetCurrentTerm which may return one that is still ahead.
+func (a *AcademicTermDB) GetLatestTerm(now time.Time) (*AcademicTerm, error) {
+	query, args, err := QB.Select("*").
+		From("academic_terms").
+		Where("proposal_open <= ?", now).
+		OrderBy("proposal_open DESC").
+		Limit(1).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var term AcademicTerm
+	if err := a.db.Get(&term, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get latest academic term: %w", err)
+	}
+	return &term, nil
+}
+
+func (a *AcademicTermDB) ListTerms() ([]AcademicTerm, error) {
+	query, args, err := QB.Select("*").
+		From("academic_terms").
+		OrderBy("year DESC", "proposal_open DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	terms := []AcademicTerm{}
+	if err := a.db.Select(&terms, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list academic terms: %w", err)
+	}
+	return terms, nil
+}
+
+func (a *AcademicTermDB) DeleteTerm(year int, season string) error {
+	result, err := a.db.Exec("DELETE FROM academic_terms WHERE year = $1 AND season = $2", year, season)
+	if err != nil {
+		return fmt.Errorf("failed to delete academic term: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GrantOverride lets a pre-project act outside the calendar for one window,
+// until expiresAt or indefinitely when it is nil.
+func (a *AcademicTermDB) GrantOverride(override *WindowOverride) error {
+	return grantOverride(a.db, override)
+}
+
+func grantOverride(q sqlx.Queryer, override *WindowOverride) error {
+	query, args, err := QB.Insert("pre_project_window_overrides").
+		Columns("pre_project_id", "window_name", "expires_at", "reason", "granted_by").
+		Values(override.PreProjectID, override.Window, override.ExpiresAt, override.Reason, override.GrantedBy).
+		Suffix(`
+            ON CONFLICT (pre_project_id, window_name)
+            DO UPDATE SET
+                expires_at = EXCLUDED.expires_at,
+                reason = EXCLUDED.reason,
+                granted_by = EXCLUDED.granted_by,
+                created_at = CURRENT_TIMESTAMP
+            RETURNING id, created_at`).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+
+	if err := q.QueryRowx(query, args...).StructScan(override); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to grant window override: %w", err)
+	}
+	return nil
+}
+
+func (a *AcademicTermDB) RevokeOverride(preProjectID uuid.UUID, window string) error {
+	return revokeOverride(a.db, preProjectID, window)
+}
+
+func revokeOverride(e sqlx.Execer, preProjectID uuid.UUID, window string) error {
+	_, err := e.Exec("DELETE FROM pre_project_window_overrides WHERE pre_project_id = $1 AND window_name = $2", preProjectID, window)
+	if err != nil {
+		return fmt.Errorf("failed to revoke window override: %w", err)
+	}
+	return nil
+}
+
+func (a *AcademicTermDB) ListOverrides(preProjectID uuid.UUID) ([]WindowOverride, error) {
+	overrides := []WindowOverride{}
+	err := a.db.Select(&overrides, "SELECT * FROM pre_project_window_overrides WHERE pre_project_id = $1 ORDER BY created_at", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list window overrides: %w", err)
+	}
+	return overrides, nil
+}
+
+// HasActiveOverride reports whether the pre-project has an unexpired override for the window.
+func (a *AcademicTermDB) HasActiveOverride(preProjectID uuid.UUID, window string, now time.Time) (bool, error) {
+	query, args, err := QB.Select("COUNT(*) > 0").
+		From("pre_project_window_overrides").
+		Where(squirrel.Eq{"pre_project_id": preProjectID, "window_name": window}).
+		Where(squirrel.Or{squirrel.Eq{"expires_at": nil}, squirrel.GtOrEq{"expires_at": now}}).
+		ToSql()
+	if err != nil {
+		return false, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var active bool
+	if err := a.db.Get(&active, query, args...); err != nil {
+		return false, fmt.Errorf("failed to check window override: %w", err)
+	}
+	return active, nil
+}
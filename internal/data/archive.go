@@ -0,0 +1,149 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoAcceptedAdvisor = errors.New("لا يمكن أرشفة مشروع لم يقبله أي مشرف")
+	ErrNotArchived       = errors.New("هذا الكتاب لم يُنقل من مشروع ويمكن حذفه فقط")
+	ErrStudentHasProject = errors.New("أحد طلاب المشروع لديه مشروع آخر قيد العمل")
+	ErrArchivedProject   = errors.New("تم نقل هذا المشروع إلى الأرشيف ولا يمكن حذفه")
+)
+
+// ArchivePreProject turns a pre-project into a book in one transaction. The
+// pre-project row is kept but hidden, so its versions, score sheets and
+// progress-report comments stay attached to the book through
+// source_pre_project_id and the uploaded file is shared rather than deleted.
+func (b *BookDB) ArchivePreProject(preProjectID uuid.UUID, degree int) (*Book, error) {
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var preProject PreProject
+	err = tx.Get(&preProject, "SELECT pp.* FROM pre_project pp WHERE pp.id = $1 AND "+preProjectActive("pp")+" FOR UPDATE", preProjectID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to lock pre-project: %w", err)
+	}
+	if preProject.AcceptedAdvisor == nil {
+		return nil, ErrNoAcceptedAdvisor
+	}
+
+	var studentIDs, discussantIDs []uuid.UUID
+	err = tx.Select(&studentIDs, "SELECT student_id FROM pre_project_students WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get students: %w", err)
+	}
+	err = tx.Select(&discussantIDs, "SELECT discussant_id FROM pre_project_discussants WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get discussants: %w", err)
+	}
+	var subjectIDs []uuid.UUID
+	err = tx.Select(&subjectIDs, "SELECT subject_id FROM pre_project_subjects WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subjects: %w", err)
+	}
+
+	book := &Book{
+		Name:               preProject.Name,
+		Description:        preProject.Description,
+		File:               preProject.File,
+		Year:               preProject.Year,
+		Season:             preProject.Season,
+		Degree:             &degree,
+		Keywords:           preProject.Keywords,
+		SubjectIDs:         subjectIDs,
+		SourcePreProjectID: &preProject.ID,
+	}
+	err = insertBook(tx, book, discussantIDs, []uuid.UUID{*preProject.AcceptedAdvisor}, studentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO book_external_discussants (book_id, examiner_id)
+        SELECT $1, examiner_id FROM pre_project_external_discussants WHERE pre_project_id = $2`, book.ID, preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy external examiners: %w", err)
+	}
+
+	now := time.Now()
+	_, err = tx.Exec("UPDATE pre_project SET archived_at = $1, can_update = false, updated_at = $1 WHERE id = $2", now, preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive pre-project: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return book, nil
+}
+
+// RestorePreProject undoes ArchivePreProject: the book is removed and its
+// source pre-project becomes active again, taking the book's current file in
+// case it was replaced after the transfer. It returns the pre-project id.
+func (b *BookDB) RestorePreProject(bookID uuid.UUID) (uuid.UUID, error) {
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var book struct {
+		SourcePreProjectID *uuid.UUID `db:"source_pre_project_id"`
+		File               *string    `db:"file"`
+	}
+	err = tx.Get(&book, "SELECT source_pre_project_id, file FROM book WHERE id = $1 FOR UPDATE", bookID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrRecordNotFound
+		}
+		return uuid.Nil, fmt.Errorf("failed to lock book: %w", err)
+	}
+	if book.SourcePreProjectID == nil {
+		return uuid.Nil, ErrNotArchived
+	}
+	preProjectID := *book.SourcePreProjectID
+
+	// A student may have started a new project since the transfer, and a
+	// student can only belong to one active project
+	var busy bool
+	err = tx.Get(&busy, `
+        SELECT EXISTS (
+            SELECT 1
+            FROM pre_project_students ps
+            JOIN pre_project pp ON pp.id = ps.pre_project_id
+            WHERE `+preProjectActive("pp")+` AND pp.id <> $1
+              AND ps.student_id IN (SELECT student_id FROM pre_project_students WHERE pre_project_id = $1)
+        )`, preProjectID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to check students: %w", err)
+	}
+	if busy {
+		return uuid.Nil, ErrStudentHasProject
+	}
+
+	if _, err := tx.Exec("DELETE FROM book WHERE id = $1", bookID); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to delete book: %w", err)
+	}
+	_, err = tx.Exec("UPDATE pre_project SET archived_at = NULL, file = $1, updated_at = $2 WHERE id = $3",
+		book.File, time.Now(), preProjectID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to restore pre-project: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return preProjectID, nil
+}
@@ -0,0 +1,194 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	ArchiveJobRunning   = "running"
+	ArchiveJobCompleted = "completed"
+	ArchiveJobFailed    = "failed"
+
+	ArchiveItemArchived = "archived"
+	ArchiveItemFailed   = "failed"
+	// ArchiveItemReady is only reported by dry runs and never stored
+	ArchiveItemReady = "ready"
+)
+
+var ErrArchiveJobRunning = errors.New("توجد عملية أرشفة قيد التنفيذ لهذا الفصل")
+
+type ArchiveJobDB struct {
+	db *sqlx.DB
+}
+
+type ArchiveJob struct {
+	ID         uuid.UUID        `db:"id" json:"id"`
+	Year       int              `db:"year" json:"year"`
+	Season     string           `db:"season" json:"season"`
+	Status     string           `db:"status" json:"status"`
+	Total      int              `db:"total" json:"total"`
+	Processed  int              `db:"processed" json:"processed"`
+	Succeeded  int              `db:"succeeded" json:"succeeded"`
+	Failed     int              `db:"failed" json:"failed"`
+	Error      *string          `db:"error" json:"error,omitempty"`
+	StartedBy  *uuid.UUID       `db:"started_by" json:"started_by,omitempty"`
+	CreatedAt  time.Time        `db:"created_at" json:"created_at"`
+	FinishedAt *time.Time       `db:"finished_at" json:"finished_at,omitempty"`
+	Items      []ArchiveJobItem `db:"-" json:"items,omitempty"`
+}
+
+type ArchiveJobItem struct {
+	ID           uuid.UUID  `db:"id" json:"id,omitempty"`
+	JobID        uuid.UUID  `db:"job_id" json:"-"`
+	PreProjectID uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	ProjectName  string     `db:"project_name" json:"project_name"`
+	Status       string     `db:"status" json:"status"`
+	BookID       *uuid.UUID `db:"book_id" json:"book_id,omitempty"`
+	Message      *string    `db:"message" json:"message,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ArchiveCandidate is a pre-project of the term that is ready to become a
+// book: it has a degree, or it has been defended. A project defended but not
+// graded yet is still listed, so the run reports it as a failure instead of
+// silently skipping it.
+type ArchiveCandidate struct {
+	ID       uuid.UUID `db:"id" json:"id"`
+	Name     string    `db:"name" json:"name"`
+	Degree   *int      `db:"degree" json:"degree,omitempty"`
+	Defended bool      `db:"defended" json:"defended"`
+}
+
+func (a *ArchiveJobDB) ListArchiveCandidates(year int, season string, now time.Time) ([]ArchiveCandidate, error) {
+	defended := "d.id IS NOT NULL AND d.starts_at + d.duration_minutes * INTERVAL '1 minute' <= ?"
+	query, args, err := QB.Select("pp.id", "pp.name", "pp.degree").
+		Column(squirrel.Expr(fmt.Sprintf("COALESCE(%s, false) AS defended", defended), now)).
+		From("pre_project pp").
+		LeftJoin("defenses d ON d.pre_project_id = pp.id").
+		Where(squirrel.Eq{"pp.year": year, "pp.season": season}).
+		Where(preProjectActive("pp")).
+		Where(squirrel.Or{
+			squirrel.NotEq{"pp.degree": nil},
+			squirrel.Expr(defended, now),
+		}).
+		OrderBy("pp.name ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	candidates := []ArchiveCandidate{}
+	if err := a.db.Select(&candidates, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list archive candidates: %w", err)
+	}
+	return candidates, nil
+}
+
+func (a *ArchiveJobDB) InsertArchiveJob(job *ArchiveJob) error {
+	query, args, err := QB.Insert("archive_jobs").
+		Columns("year", "season", "status", "total", "started_by").
+		Values(job.Year, job.Season, ArchiveJobRunning, job.Total, job.StartedBy).
+		Suffix("RETURNING id, status, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := a.db.QueryRowx(query, args...).StructScan(job); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrArchiveJobRunning
+		}
+		return fmt.Errorf("failed to insert archive job: %w", err)
+	}
+	return nil
+}
+
+// RecordArchiveResult stores the outcome of one project and advances the
+// job's progress counters.
+func (a *ArchiveJobDB) RecordArchiveResult(item *ArchiveJobItem) error {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Insert("archive_job_items").
+		Columns("job_id", "pre_project_id", "project_name", "status", "book_id", "message").
+		Values(item.JobID, item.PreProjectID, item.ProjectName, item.Status, item.BookID, item.Message).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(item); err != nil {
+		return fmt.Errorf("failed to insert archive job item: %w", err)
+	}
+
+	counter := "succeeded"
+	if item.Status == ArchiveItemFailed {
+		counter = "failed"
+	}
+	_, err = tx.Exec(fmt.Sprintf("UPDATE archive_jobs SET processed = processed + 1, %[1]s = %[1]s + 1 WHERE id = $1", counter), item.JobID)
+	if err != nil {
+		return fmt.Errorf("failed to update archive job progress: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// FinishArchiveJob closes a job; jobErr is set when the run stopped early.
+func (a *ArchiveJobDB) FinishArchiveJob(jobID uuid.UUID, status string, jobErr *string) error {
+	_, err := a.db.Exec("UPDATE archive_jobs SET status = $1, error = $2, finished_at = $3 WHERE id = $4",
+		status, jobErr, time.Now(), jobID)
+	if err != nil {
+		return fmt.Errorf("failed to finish archive job: %w", err)
+	}
+	return nil
+}
+
+// FailInterruptedArchiveJobs closes the jobs a previous process left running,
+// so the term can be archived again after a restart.
+func (a *ArchiveJobDB) FailInterruptedArchiveJobs() error {
+	_, err := a.db.Exec("UPDATE archive_jobs SET status = $1, error = $2, finished_at = $3 WHERE status = $4",
+		ArchiveJobFailed, "interrupted by a server restart", time.Now(), ArchiveJobRunning)
+	if err != nil {
+		return fmt.Errorf("failed to close interrupted archive jobs: %w", err)
+	}
+	return nil
+}
+
+func (a *ArchiveJobDB) GetArchiveJob(jobID uuid.UUID) (*ArchiveJob, error) {
+	var job ArchiveJob
+	if err := a.db.Get(&job, "SELECT * FROM archive_jobs WHERE id = $1", jobID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get archive job: %w", err)
+	}
+
+	job.Items = []ArchiveJobItem{}
+	err := a.db.Select(&job.Items, "SELECT * FROM archive_job_items WHERE job_id = $1 ORDER BY created_at", jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get archive job items: %w", err)
+	}
+	return &job, nil
+}
+
+func (a *ArchiveJobDB) ListArchiveJobs(year int, season string) ([]ArchiveJob, error) {
+	jobs := []ArchiveJob{}
+	err := a.db.Select(&jobs, "SELECT * FROM archive_jobs WHERE year = $1 AND season = $2 ORDER BY created_at DESC", year, season)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive jobs: %w", err)
+	}
+	return jobs, nil
+}
@@ -4,14 +4,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"net/url"
 	"project/utils"
+	"project/utils/pdf"
 	"project/utils/validator"
 	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type BookDB struct {
@@ -26,8 +29,26 @@ type Book struct {
 	Year        int       `db:"year" json:"year"`
 	Season      string    `db:"season" json:"season"`
 	Degree      *int      `db:"degree" json:"degree,omitempty"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+
+	// Read out of the uploaded PDF; the extracted text is stored in
+	// full_text for search and similarity and not sent with the book.
+	PageCount  *int    `db:"page_count" json:"page_count,omitempty"`
+	FileTitle  *string `db:"file_title" json:"file_title,omitempty"`
+	FileAuthor *string `db:"file_author" json:"file_author,omitempty"`
+
+	// ThumbnailURL is the preview of the file, made on first request
+	ThumbnailURL *string `db:"thumbnail_url" json:"thumbnail_url,omitempty"`
+
+	// FileAccess is who may download the file; until EmbargoUntil only the
+	// book's members and administrators may, and File is not shown
+	FileAccess   string     `db:"file_access" json:"file_access,omitempty"`
+	EmbargoUntil *time.Time `db:"embargo_until" json:"embargo_until,omitempty"`
+
+	Keywords           pq.StringArray `db:"keywords" json:"keywords"`
+	SubjectIDs         []uuid.UUID    `db:"-" json:"-"`
+	SourcePreProjectID *uuid.UUID     `db:"source_pre_project_id" json:"source_pre_project_id,omitempty"`
+	CreatedAt          time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time      `db:"updated_at" json:"updated_at"`
 }
 
 func ValidateBook(v *validator.Validator, book *Book,
@@ -37,8 +58,7 @@ func ValidateBook(v *validator.Validator, book *Book,
 	v.Check(len(book.Name) >= 3, "name", "يجب أن يكون اسم المشروع على الأقل 3 أحرف")
 	v.Check(len(book.Name) <= 600, "name", "يجب أن يكون اسم المشروع أقل من 600 حرف")
 
-	v.Check(len(*book.Description) >= 10, "description", "يجب أن يكون وصف المشروع على الأقل 10 أحرف")
-	v.Check(len(*book.Description) <= 1500, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 1000 حرف")
+	v.Check(book.Description != nil, "description", "وصف المشروع مطلوب")
 
 	v.Check(book.Year > 0, "year", "السنة مطلوبة")
 	v.Check(book.Season != "", "season", "الموسم مطلوب")
@@ -53,8 +73,13 @@ func ValidateBook(v *validator.Validator, book *Book,
 	v.Check(len(discussantIDs) <= 3, "discutant", "لا يمكن إضافة أكثر من 3 مناقشين")
 
 	if book.Description != nil {
+		v.Check(len(*book.Description) >= 10, "description", "يجب أن يكون وصف المشروع على الأقل 10 أحرف")
 		v.Check(len(*book.Description) <= 1000, "description", "لا يمكن لوصف المشروع أن يكون أكثر من 1000 حرف")
 	}
+	v.Check(validator.In(book.FileAccess, FileAccessLevels...), "file_access", "يجب أن يكون الوصول إلى الملف عاما أو للمستخدمين المسجلين أو لأعضاء المشروع")
+	ValidateKeywords(v, book.Keywords)
+	ValidateSubjects(v, book.SubjectIDs)
+	ValidateDiscussants(v, discussantIDs, 0, advisorIDs, studentIDs)
 	// if !isUpdate || (len(studentIDs) > 0 || len(advisorIDs) > 0 || len(discussantIDs) > 0) {
 	// 	if len(studentIDs) > 0 {
 	// 		v.Check(len(studentIDs) > 0, "students", "At least one student is required")
@@ -63,11 +88,21 @@ func ValidateBook(v *validator.Validator, book *Book,
 	// }
 }
 
+func ValidateKeywords(v *validator.Validator, keywords []string) {
+	v.Check(len(keywords) <= 10, "keywords", "لا يمكن إضافة أكثر من 10 كلمات مفتاحية")
+	for _, keyword := range keywords {
+		v.Check(len(keyword) <= 100, "keywords", "يجب أن تكون الكلمة المفتاحية أقل من 100 حرف")
+	}
+}
+
 type BookWithDetails struct {
 	Book
 	Discussants []UserDetails `json:"discutants"`
 	Advisors    []UserDetails `json:"advisors"`
 	Students    []UserDetails `json:"students"`
+
+	ExternalDiscussants []ExternalExaminer `json:"external_discutants"`
+	Subjects            []Subject          `json:"subjects"`
 }
 
 type UserDetails struct {
@@ -82,6 +117,21 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback()
+
+	if err := insertBook(tx, book, discussantIDs, advisorIDs, studentIDs); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func insertBook(tx *sqlx.Tx, book *Book, discussantIDs, advisorIDs, studentIDs []uuid.UUID) error {
+	var err error
 	if book.ID == uuid.Nil {
 		book.ID, err = uuid.NewUUID()
 		if err != nil {
@@ -89,8 +139,15 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		}
 	}
 
+	if book.Keywords == nil {
+		book.Keywords = pq.StringArray{}
+	}
+	if book.FileAccess == "" {
+		book.FileAccess = FileAccessPublic
+	}
+
 	query, args, err := QB.Insert("book").
-		Columns("id,name, description, file, year, season", "degree").
+		Columns("id,name, description, file, year, season", "degree", "keywords", "source_pre_project_id", "file_access", "embargo_until").
 		Values(
 			book.ID,
 			book.Name,
@@ -99,6 +156,10 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 			book.Year,
 			book.Season,
 			book.Degree,
+			book.Keywords,
+			book.SourcePreProjectID,
+			book.FileAccess,
+			book.EmbargoUntil,
 		).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
@@ -142,12 +203,7 @@ func (b *BookDB) InsertBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		}
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return nil
+	return setSubjects(tx, "book_subjects", "book_id", book.ID, book.SubjectIDs)
 }
 
 func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error) {
@@ -159,12 +215,20 @@ func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error)
 		"b.id",
 		"b.name",
 		"b.description",
-		fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/%%s', b.file) ELSE NULL END AS file", Domain),
+		bookFileColumn,
+		thumbnailColumn(bookFile),
 		"b.year",
 		"b.season",
 		"b.created_at",
 		"COALESCE(b.degree, NULL) AS degree",
+		"b.keywords",
+		"b.source_pre_project_id",
 		"b.updated_at",
+		"b.page_count",
+		"b.file_title",
+		"b.file_author",
+		"b.file_access",
+		"b.embargo_until",
 		"discussant.id AS discussant_id",
 		"COALESCE(discussant.name, '') AS discussant_name",
 		"COALESCE(discussant.email, '') AS discussant_email",
@@ -267,6 +331,15 @@ func (b *BookDB) GetBookWithDetails(bookID uuid.UUID) (*BookWithDetails, error)
 		return nil, fmt.Errorf("book not found")
 	}
 
+	result.ExternalDiscussants, err = externalDiscussants(b.db, "book_external_discussants", "book_id", bookID)
+	if err != nil {
+		return nil, err
+	}
+	result.Subjects, err = subjectsOf(b.db, "book_subjects", "book_id", bookID)
+	if err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []uuid.UUID) error {
@@ -276,6 +349,10 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 	}
 	defer tx.Rollback()
 
+	if book.Keywords == nil {
+		book.Keywords = pq.StringArray{}
+	}
+
 	updateQuery, updateArgs, err := QB.Update("book").
 		Set("name", book.Name).
 		Set("description", book.Description).
@@ -283,6 +360,9 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		Set("year", book.Year).
 		Set("degree", book.Degree).
 		Set("season", book.Season).
+		Set("keywords", book.Keywords).
+		Set("file_access", book.FileAccess).
+		Set("embargo_until", book.EmbargoUntil).
 		Set("updated_at", time.Now()).
 		Where(squirrel.Eq{"id": book.ID}).
 		ToSql()
@@ -338,6 +418,12 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 		}
 	}
 
+	if book.SubjectIDs != nil {
+		if err := setSubjects(tx, "book_subjects", "book_id", book.ID, book.SubjectIDs); err != nil {
+			return err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -346,11 +432,106 @@ func (b *BookDB) UpdateBook(book *Book, discussantIDs, advisorIDs, studentIDs []
 	return nil
 }
 
+// GetBookFile returns the stored path of a book's file, nil when it has
+// none, whatever its embargo.
+func (b *BookDB) GetBookFile(bookID uuid.UUID) (*string, error) {
+	var file *string
+	if err := b.db.Get(&file, "SELECT NULLIF(file, '') FROM book WHERE id = $1", bookID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get book file: %w", err)
+	}
+	return file, nil
+}
+
+// SetBookDocument stores what was read out of the book's PDF. A nil doc
+// clears it, as when the file is removed or is not a PDF.
+func (b *BookDB) SetBookDocument(bookID uuid.UUID, doc *pdf.Info) error {
+	builder := QB.Update("book").
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": bookID})
+	if doc == nil {
+		builder = builder.
+			Set("page_count", nil).
+			Set("file_title", nil).
+			Set("file_author", nil).
+			Set("full_text", "")
+	} else {
+		builder = builder.
+			Set("page_count", doc.Pages).
+			Set("file_title", nullIfEmpty(doc.Title)).
+			Set("file_author", nullIfEmpty(doc.Author)).
+			Set("full_text", doc.Text)
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if _, err := b.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to store book document: %w", err)
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// DeleteBook removes a book. A book archived from a pre-project takes the
+// pre-project with it, as it is only kept as the book's history, along with
+// the files of its versions and progress reports.
 func (b *BookDB) DeleteBook(bookID uuid.UUID) error {
-	_, err := b.db.Exec("DELETE FROM book WHERE id = $1", bookID)
+	tx, err := b.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sourceID *uuid.UUID
+	err = tx.Get(&sourceID, "DELETE FROM book WHERE id = $1 RETURNING source_pre_project_id", bookID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
 		return fmt.Errorf("failed to delete book: %w", err)
 	}
+
+	var files []string
+	if sourceID != nil {
+		files, err = preProjectVersionFiles(tx, *sourceID)
+		if err != nil {
+			return err
+		}
+		reportFiles, err := progressReportFiles(tx, *sourceID)
+		if err != nil {
+			return err
+		}
+		files = append(files, reportFiles...)
+
+		if _, err := tx.Exec("DELETE FROM pre_project WHERE id = $1 AND archived_at IS NOT NULL", *sourceID); err != nil {
+			return fmt.Errorf("failed to delete source pre-project: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	deleted := map[string]bool{}
+	for _, file := range files {
+		if deleted[file] {
+			continue
+		}
+		deleted[file] = true
+		if err := utils.DeleteFile(file); err != nil {
+			log.Printf("Failed to delete file %s: %v", file, err)
+		}
+	}
 	return nil
 }
 func (b *BookDB) DeleteDiscussantFromBook(bookID uuid.UUID, discussantID uuid.UUID) error {
@@ -454,6 +635,8 @@ func (b *BookDB) ListBooks(queryParams url.Values) ([]Book, *utils.Meta, error)
 		"b.name",
 		"COALESCE(b.description, '') AS description",
 		"COALESCE(b.degree, NULL) AS degree",
+		"b.keywords",
+		thumbnailColumn(bookFile),
 	}
 
 	meta, err := utils.BuildQuery(&books, table, nil, bookJoinColumns, searchCols, queryParams, nil)
@@ -466,8 +649,10 @@ func (b *BookDB) ListBooks(queryParams url.Values) ([]Book, *utils.Meta, error)
 func (b *BookDB) GetBook(bookID uuid.UUID) (*BookWithDetails, error) {
 	query, args, err := QB.Select(
 		"b.id", "b.name", "b.description",
-		fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/%%s', b.file) ELSE NULL END AS file", Domain),
-		"b.year", "b.season", "b.created_at", "b.updated_at",
+		bookFileColumn,
+		thumbnailColumn(bookFile),
+		"b.year", "b.season", "b.keywords", "b.source_pre_project_id", "b.created_at", "b.updated_at",
+		"b.page_count", "b.file_title", "b.file_author", "b.file_access", "b.embargo_until",
 		"COALESCE(discussant.id, '00000000-0000-0000-0000-000000000000') AS discussant_id",
 		"COALESCE(discussant.name, '') AS discussant_name",
 		"COALESCE(discussant.email, '') AS discussant_email",
@@ -575,6 +760,11 @@ func (b *BookDB) GetBook(bookID uuid.UUID) (*BookWithDetails, error) {
 		return nil, ErrRecordNotFound
 	}
 
+	result.Subjects, err = subjectsOf(b.db, "book_subjects", "book_id", bookID)
+	if err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 func (p *PreProjectDB) CountBooks() (int, error) {
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// MaxCitationExport caps how many books one bulk citation export may hold.
+const MaxCitationExport = 1000
+
+var ErrTooManyCitations = fmt.Errorf("لا يمكن تصدير أكثر من %d مرجع في المرة الواحدة، يرجى تضييق البحث", MaxCitationExport)
+
+// ListCitationBooks returns every book matching the filter with its students
+// and advisors, in the order BrowseBooks lists them. It fails with
+// ErrTooManyCitations rather than cutting the result set short.
+func (b *BookDB) ListCitationBooks(filter BookBrowseFilter) ([]BookWithDetails, error) {
+	books, meta, err := b.browseBooks(filter, 1, MaxCitationExport+1)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Total > MaxCitationExport {
+		return nil, ErrTooManyCitations
+	}
+
+	return b.withDetails(books)
+}
+
+// withDetails loads the students, advisors and subjects of a list of books
+// with one query each.
+func (b *BookDB) withDetails(books []Book) ([]BookWithDetails, error) {
+	results := make([]BookWithDetails, len(books))
+	index := make(map[uuid.UUID]*BookWithDetails, len(books))
+	ids := make([]uuid.UUID, len(books))
+	for i, book := range books {
+		results[i] = BookWithDetails{Book: book, Students: []UserDetails{}, Advisors: []UserDetails{}, Subjects: []Subject{}}
+		index[book.ID] = &results[i]
+		ids[i] = book.ID
+	}
+	if len(ids) == 0 {
+		return results, nil
+	}
+
+	for _, table := range []struct{ name, column string }{
+		{"book_students", "student_id"},
+		{"book_advisors", "advisor_id"},
+	} {
+		var people []struct {
+			BookID uuid.UUID `db:"book_id"`
+			ID     uuid.UUID `db:"id"`
+			Name   string    `db:"name"`
+			Email  string    `db:"email"`
+		}
+		err := b.db.Select(&people, fmt.Sprintf(`
+            SELECT t.book_id, u.id, u.name, u.email
+            FROM %s t
+            JOIN users u ON u.id = t.%s
+            WHERE t.book_id = ANY($1)
+            ORDER BY u.name`, table.name, table.column), pq.Array(ids))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", strings.TrimPrefix(table.name, "book_"), err)
+		}
+		for _, person := range people {
+			book := index[person.BookID]
+			details := UserDetails{ID: person.ID, Name: person.Name, Email: person.Email}
+			if table.name == "book_students" {
+				book.Students = append(book.Students, details)
+			} else {
+				book.Advisors = append(book.Advisors, details)
+			}
+		}
+	}
+
+	var subjects []struct {
+		BookID uuid.UUID `db:"book_id"`
+		Subject
+	}
+	err := b.db.Select(&subjects, `
+        SELECT t.book_id, s.id, s.name, s.description, s.created_at, s.updated_at
+        FROM book_subjects t
+        JOIN subjects s ON s.id = t.subject_id
+        WHERE t.book_id = ANY($1)
+        ORDER BY s.name`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subjects: %w", err)
+	}
+	for _, subject := range subjects {
+		book := index[subject.BookID]
+		book.Subjects = append(book.Subjects, subject.Subject)
+	}
+	return results, nil
+}
@@ -0,0 +1,250 @@
+package data
+
+import (
+	"fmt"
+	"project/utils"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const facetLimit = 50
+
+// GradeBand is a range of final degrees (out of 100) used to browse books.
+type GradeBand struct {
+	Name  string
+	Label string
+	Min   int
+	Max   int
+}
+
+var GradeBands = []GradeBand{
+	{"excellent", "ممتاز", 85, 100},
+	{"very_good", "جيد جداً", 75, 84},
+	{"good", "جيد", 65, 74},
+	{"pass", "مقبول", 50, 64},
+	{"fail", "ضعيف", 0, 49},
+}
+
+func gradeBand(name string) (GradeBand, bool) {
+	for _, band := range GradeBands {
+		if band.Name == name {
+			return band, true
+		}
+	}
+	return GradeBand{}, false
+}
+
+// gradeBandExpr names the band of b.degree.
+func gradeBandExpr() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	for _, band := range GradeBands {
+		fmt.Fprintf(&sb, " WHEN b.degree BETWEEN %d AND %d THEN '%s'", band.Min, band.Max, band.Name)
+	}
+	sb.WriteString(" END")
+	return sb.String()
+}
+
+const (
+	FacetYear       = "year"
+	FacetSeason     = "season"
+	FacetAdvisor    = "advisor"
+	FacetDiscussant = "discussant"
+	FacetKeyword    = "keyword"
+	FacetGrade      = "grade"
+)
+
+// BookBrowseFilter holds the drill-down selections. Values within one facet
+// are alternatives (any of them matches); different facets must all match.
+type BookBrowseFilter struct {
+	Search      string
+	Years       []int
+	Seasons     []string
+	Advisors    []uuid.UUID
+	Discussants []uuid.UUID // users or external examiners
+	Keywords    []string
+	Grades      []string
+}
+
+// conditions returns the WHERE clauses of the filter, leaving out the one of
+// the except facet.
+func (f *BookBrowseFilter) conditions(except string) squirrel.And {
+	where := squirrel.And{}
+	if f.Search != "" {
+		where = append(where, bookSearchCondition(f.Search))
+	}
+	if len(f.Years) > 0 && except != FacetYear {
+		where = append(where, squirrel.Eq{"b.year": f.Years})
+	}
+	if len(f.Seasons) > 0 && except != FacetSeason {
+		where = append(where, squirrel.Eq{"b.season": f.Seasons})
+	}
+	if len(f.Advisors) > 0 && except != FacetAdvisor {
+		where = append(where, squirrel.Expr(
+			"EXISTS (SELECT 1 FROM book_advisors ba WHERE ba.book_id = b.id AND ba.advisor_id = ANY(?))", pq.Array(f.Advisors)))
+	}
+	if len(f.Discussants) > 0 && except != FacetDiscussant {
+		where = append(where, squirrel.Expr(
+			"(EXISTS (SELECT 1 FROM book_discussants bd WHERE bd.book_id = b.id AND bd.discussant_id = ANY(?))"+
+				" OR EXISTS (SELECT 1 FROM book_external_discussants be WHERE be.book_id = b.id AND be.examiner_id = ANY(?)))",
+			pq.Array(f.Discussants), pq.Array(f.Discussants)))
+	}
+	if len(f.Keywords) > 0 && except != FacetKeyword {
+		where = append(where, squirrel.Expr("b.keywords && ?", pq.Array(f.Keywords)))
+	}
+	if len(f.Grades) > 0 && except != FacetGrade {
+		bands := squirrel.Or{}
+		for _, name := range f.Grades {
+			if band, ok := gradeBand(name); ok {
+				bands = append(bands, squirrel.Expr("b.degree BETWEEN ? AND ?", band.Min, band.Max))
+			}
+		}
+		where = append(where, bands)
+	}
+	return where
+}
+
+type FacetCount struct {
+	Value string `db:"value" json:"value"`
+	Label string `db:"label" json:"label,omitempty"`
+	Count int    `db:"count" json:"count"`
+}
+
+type BookFacets struct {
+	Years       []FacetCount `json:"year"`
+	Seasons     []FacetCount `json:"season"`
+	Advisors    []FacetCount `json:"advisor"`
+	Discussants []FacetCount `json:"discussant"`
+	Keywords    []FacetCount `json:"keyword"`
+	Grades      []FacetCount `json:"grade"`
+}
+
+// BrowseBooks returns a page of the books matching the filter together with
+// the facet counts of the drill-down sidebar. Each facet is counted under
+// every selection except its own, so the other values of a facet stay
+// visible with the number of books they would add.
+func (b *BookDB) BrowseBooks(filter BookBrowseFilter, page, perPage int) ([]Book, *BookFacets, *utils.Meta, error) {
+	books, meta, err := b.browseBooks(filter, page, perPage)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	facets, err := b.bookFacets(&filter)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return books, facets, meta, nil
+}
+
+// browseBooks returns a page of the books matching the filter, the best
+// matches of a search first and then the newest.
+func (b *BookDB) browseBooks(filter BookBrowseFilter, page, perPage int) ([]Book, *utils.Meta, error) {
+	where := filter.conditions("")
+
+	countQuery, countArgs, err := QB.Select("COUNT(*)").From("book b").Where(where).ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build count query: %w", err)
+	}
+	var total int
+	if err := b.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, nil, fmt.Errorf("failed to count books: %w", err)
+	}
+
+	builder := QB.Select(
+		"b.id",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		thumbnailColumn(bookFile),
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.keywords",
+		"b.source_pre_project_id",
+		"b.created_at",
+		"b.updated_at",
+	).From("book b").Where(where)
+	if filter.Search != "" {
+		normalized := utils.NormalizeArabicText(strings.ToLower(filter.Search))
+		builder = builder.OrderByClause("ts_rank("+bookSearchVector+", "+bookTSQuery+") DESC", normalized, normalized, normalized)
+	}
+	builder = builder.OrderBy("b.year DESC", "b.name")
+	if page > 0 && perPage > 0 {
+		builder = builder.Limit(uint64(perPage)).Offset(uint64((page - 1) * perPage))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	books := []Book{}
+	if err := b.db.Select(&books, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to browse books: %w", err)
+	}
+	return books, utils.NewMeta(total, page, perPage), nil
+}
+
+// bookDiscussantsQuery lists the discussants of every book, the external
+// examiners among them.
+const bookDiscussantsQuery = `
+    SELECT bd.book_id, u.id, u.name FROM book_discussants bd JOIN users u ON u.id = bd.discussant_id
+    UNION ALL
+    SELECT be.book_id, x.id, x.name FROM book_external_discussants be JOIN external_examiners x ON x.id = be.examiner_id`
+
+func (b *BookDB) bookFacets(filter *BookBrowseFilter) (*BookFacets, error) {
+	facets := &BookFacets{}
+	queries := []struct {
+		facet   string
+		builder squirrel.SelectBuilder
+		target  *[]FacetCount
+	}{
+		{FacetYear, QB.Select("b.year::text AS value", "'' AS label", "COUNT(*) AS count").
+			From("book b").GroupBy("b.year").OrderBy("b.year DESC"), &facets.Years},
+		{FacetSeason, QB.Select("b.season AS value", "'' AS label", "COUNT(*) AS count").
+			From("book b").GroupBy("b.season").OrderBy("b.season"), &facets.Seasons},
+		{FacetAdvisor, QB.Select("u.id::text AS value", "u.name AS label", "COUNT(DISTINCT b.id) AS count").
+			From("book b").
+			Join("book_advisors ba ON ba.book_id = b.id").
+			Join("users u ON u.id = ba.advisor_id").
+			GroupBy("u.id", "u.name").OrderBy("count DESC", "label").Limit(facetLimit), &facets.Advisors},
+		{FacetDiscussant, QB.Select("d.id::text AS value", "d.name AS label", "COUNT(DISTINCT b.id) AS count").
+			From("book b").
+			Join("("+bookDiscussantsQuery+") d ON d.book_id = b.id").
+			GroupBy("d.id", "d.name").OrderBy("count DESC", "label").Limit(facetLimit), &facets.Discussants},
+		{FacetKeyword, QB.Select("k.keyword AS value", "'' AS label", "COUNT(*) AS count").
+			From("book b").
+			Join("LATERAL unnest(b.keywords) AS k(keyword) ON true").
+			GroupBy("k.keyword").OrderBy("count DESC", "value").Limit(facetLimit), &facets.Keywords},
+		{FacetGrade, QB.Select(gradeBandExpr()+" AS value", "'' AS label", "COUNT(*) AS count").
+			From("book b").
+			Where("b.degree BETWEEN 0 AND 100").
+			GroupBy("value"), &facets.Grades},
+	}
+
+	for _, q := range queries {
+		query, args, err := q.builder.Where(filter.conditions(q.facet)).ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build %s facet query: %w", q.facet, err)
+		}
+		counts := []FacetCount{}
+		if err := b.db.Select(&counts, query, args...); err != nil {
+			return nil, fmt.Errorf("failed to count %s facet: %w", q.facet, err)
+		}
+		*q.target = counts
+	}
+
+	// Grade bands are listed best first with their Arabic labels
+	grades := make([]FacetCount, 0, len(facets.Grades))
+	for _, band := range GradeBands {
+		for _, count := range facets.Grades {
+			if count.Value == band.Name {
+				count.Label = band.Label
+				grades = append(grades, count)
+			}
+		}
+	}
+	facets.Grades = grades
+	return facets, nil
+}
@@ -0,0 +1,216 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultRelatedBooks = 6
+	MaxRelatedBooks     = 20
+
+	// relatedTerms is how many of a book's most significant lexemes are
+	// compared with the archive
+	relatedTerms = 40
+	// minRelatedScore leaves out books that share little more than a
+	// common word
+	minRelatedScore = 0.05
+)
+
+// RelatedBook is a book related to another, with a score between 0 and 1
+// and what they have in common: "text", "subjects", "keywords" and
+// "advisors".
+type RelatedBook struct {
+	Book
+	Score   float64        `db:"score" json:"score"`
+	Reasons pq.StringArray `db:"reasons" json:"reasons"`
+}
+
+// RelatedScore is a RelatedBook without the book, which GetRelatedBooks
+// loads: a score only changes with the archive, while how a book is shown
+// also changes when its embargo ends.
+type RelatedScore struct {
+	ID      uuid.UUID      `db:"id"`
+	Score   float64        `db:"score"`
+	Reasons pq.StringArray `db:"reasons"`
+}
+
+// relatedBooksQuery scores every book that has something in common with
+// book $1:
+//   - text: TF-IDF over the most significant lexemes of its search vector,
+//     the full text only counting for books whose file anyone may read,
+//     where a lexeme counts by the best weight it has (name over keywords
+//     over description over full text) and grows with its repetitions, and
+//     is worth less the more books have it. The score is relative to the
+//     book itself.
+//   - subjects and keywords: the share of the book's subjects and keywords
+//     the other book has too, keywords compared normalized.
+//   - advisors: whether they share an advisor.
+const relatedBooksQuery = `
+	WITH src AS (
+		SELECT b.id, %[3]s AS search_vector, b.keywords FROM book b WHERE b.id = $1
+	),
+	terms AS (
+		SELECT t.lexeme,
+			(SELECT MAX(CASE w WHEN 'A' THEN 1.0 WHEN 'B' THEN 0.4 WHEN 'C' THEN 0.2 ELSE 0.1 END) FROM unnest(t.weights) w)
+				* (1 + ln(GREATEST(cardinality(t.positions), 1))) AS tf
+		FROM src, unnest(src.search_vector) t
+		ORDER BY tf DESC, t.lexeme
+		LIMIT %[1]d
+	),
+	matches AS (
+		SELECT terms.lexeme, terms.tf, b.id
+		FROM terms JOIN book b
+			ON b.search_vector @@ quote_literal(terms.lexeme)::tsquery
+			AND %[3]s @@ quote_literal(terms.lexeme)::tsquery
+	),
+	weighted AS (
+		SELECT lexeme, tf * ln((SELECT COUNT(*) FROM book)::float / COUNT(*)) AS weight
+		FROM matches GROUP BY lexeme, tf
+	),
+	text_scores AS (
+		SELECT m.id, SUM(w.weight) / NULLIF((SELECT SUM(weight) FROM weighted), 0) AS score
+		FROM matches m JOIN weighted w USING (lexeme)
+		WHERE m.id <> $1
+		GROUP BY m.id
+	),
+	subject_scores AS (
+		SELECT other.book_id AS id,
+			COUNT(*)::float / (SELECT COUNT(*) FROM book_subjects WHERE book_id = $1) AS score
+		FROM book_subjects own
+		JOIN book_subjects other ON other.subject_id = own.subject_id AND other.book_id <> $1
+		WHERE own.book_id = $1
+		GROUP BY other.book_id
+	),
+	src_keywords AS (
+		SELECT DISTINCT lower(normalize_arabic(k)) AS keyword FROM src, unnest(src.keywords) k
+	),
+	keyword_scores AS (
+		SELECT b.id,
+			COUNT(DISTINCT sk.keyword)::float / (SELECT COUNT(*) FROM src_keywords) AS score
+		FROM book b
+		CROSS JOIN LATERAL unnest(b.keywords) k
+		JOIN src_keywords sk ON sk.keyword = lower(normalize_arabic(k))
+		WHERE b.id <> $1
+		GROUP BY b.id
+	),
+	advisor_scores AS (
+		SELECT DISTINCT other.book_id AS id, 1.0::float AS score
+		FROM book_advisors own
+		JOIN book_advisors other ON other.advisor_id = own.advisor_id AND other.book_id <> $1
+		WHERE own.book_id = $1
+	),
+	scored AS (
+		SELECT id,
+			SUM(text_score) AS text_score, SUM(subject_score) AS subject_score,
+			SUM(keyword_score) AS keyword_score, SUM(advisor_score) AS advisor_score
+		FROM (
+			SELECT id, COALESCE(score, 0) AS text_score, 0 AS subject_score, 0 AS keyword_score, 0 AS advisor_score FROM text_scores
+			UNION ALL SELECT id, 0, score, 0, 0 FROM subject_scores
+			UNION ALL SELECT id, 0, 0, score, 0 FROM keyword_scores
+			UNION ALL SELECT id, 0, 0, 0, score FROM advisor_scores
+		) c
+		GROUP BY id
+	)
+	SELECT s.id, s.score,
+		array_remove(ARRAY[
+			CASE WHEN s.text_score >= %[2]g THEN 'text' END,
+			CASE WHEN s.subject_score > 0 THEN 'subjects' END,
+			CASE WHEN s.keyword_score > 0 THEN 'keywords' END,
+			CASE WHEN s.advisor_score > 0 THEN 'advisors' END
+		], NULL) AS reasons
+	FROM (
+		SELECT id, text_score, subject_score, keyword_score, advisor_score,
+			0.5 * text_score + 0.2 * subject_score + 0.15 * keyword_score + 0.15 * advisor_score AS score
+		FROM scored
+	) s
+	JOIN book b ON b.id = s.id
+	WHERE s.score >= %[2]g
+	ORDER BY s.score DESC, b.year DESC, b.name
+	LIMIT $2`
+
+// ListRelatedScores scores the books most related to a book by their text,
+// subjects, keywords and advisors, best first.
+func (b *BookDB) ListRelatedScores(bookID uuid.UUID, limit int) ([]RelatedScore, error) {
+	var exists bool
+	if err := b.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM book WHERE id = $1)", bookID); err != nil {
+		return nil, fmt.Errorf("failed to check book: %w", err)
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(relatedBooksQuery, relatedTerms, minRelatedScore, bookSearchVector)
+	scores := []RelatedScore{}
+	if err := b.db.Select(&scores, query, bookID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list related books: %w", err)
+	}
+	return scores, nil
+}
+
+// GetRelatedBooks loads the books of scores in their order. A book deleted
+// since it was scored is left out.
+func (b *BookDB) GetRelatedBooks(scores []RelatedScore) ([]RelatedBook, error) {
+	related := []RelatedBook{}
+	if len(scores) == 0 {
+		return related, nil
+	}
+	ids := make([]uuid.UUID, len(scores))
+	for i, score := range scores {
+		ids[i] = score.ID
+	}
+
+	query, args, err := QB.Select(
+		"b.id",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		thumbnailColumn(bookFile),
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.keywords",
+		"b.source_pre_project_id",
+		"b.created_at",
+		"b.updated_at",
+	).
+		From("book b").
+		Where("b.id = ANY(?)", pq.Array(ids)).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var books []Book
+	if err := b.db.Select(&books, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to get related books: %w", err)
+	}
+
+	byID := make(map[uuid.UUID]Book, len(books))
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+	for _, score := range scores {
+		if book, ok := byID[score.ID]; ok {
+			related = append(related, RelatedBook{Book: book, Score: score.Score, Reasons: score.Reasons})
+		}
+	}
+	return related, nil
+}
+
+// GetArchiveStamp identifies the state of the archive: the stamp changes
+// whenever a book is added, removed or updated, or an embargo ends and its
+// full text starts to count, which is when what is computed over all books
+// has to be computed again.
+func (b *BookDB) GetArchiveStamp() (string, error) {
+	var stamp string
+	err := b.db.Get(&stamp, `
+        SELECT COUNT(*) || '/' || COALESCE(MAX(updated_at)::text, '')
+            || '/' || COALESCE((MIN(embargo_until) FILTER (WHERE embargo_until > NOW()))::text, '')
+        FROM book`)
+	if err != nil {
+		return "", fmt.Errorf("failed to get archive stamp: %w", err)
+	}
+	return stamp, nil
+}
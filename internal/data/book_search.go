@@ -0,0 +1,121 @@
+package data
+
+import (
+	"fmt"
+	"project/utils"
+	"strings"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// bookTSQuery turns the user's search into one tsquery matching the Arabic
+// and English stems of search_vector as well as the unstemmed keyword and
+// author lexemes. It takes the normalized search three times.
+const bookTSQuery = "websearch_to_tsquery('arabic', ?) || websearch_to_tsquery('english', ?) || websearch_to_tsquery('simple', ?)"
+
+const bookSearchQuery = "WITH q AS (SELECT " + bookTSQuery + " AS query)"
+
+// bookTextPublic holds for the books whose file anyone may read. Only their
+// full text is searched and quoted: the others match on their metadata
+// alone, so a search does not reveal what their file says.
+const bookTextPublic = "(b.file_access = 'public' AND (b.embargo_until IS NULL OR b.embargo_until <= NOW()))"
+
+// bookSearchVector is what a search matches and ranks the book b against.
+// The indexed search_vector is matched first so the index still narrows
+// the books, and this one rechecks them.
+const bookSearchVector = "(CASE WHEN " + bookTextPublic + " THEN b.search_vector ELSE book_search_vector(b.name, b.description, b.keywords, b.authors, '') END)"
+
+// bookSearchCondition matches the books of a search without ranking them.
+func bookSearchCondition(search string) squirrel.Sqlizer {
+	normalized := utils.NormalizeArabicText(strings.ToLower(search))
+	return squirrel.Expr("b.search_vector @@ ("+bookTSQuery+") AND "+bookSearchVector+" @@ ("+bookTSQuery+")",
+		normalized, normalized, normalized, normalized, normalized, normalized)
+}
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>"
+
+// escapeHTML escapes the text a headline is made of, so the <mark> tags
+// ts_headline adds are the only markup in it and a stored name or PDF text
+// cannot inject any. The parser reads the entities as single tokens.
+func escapeHTML(expr string) string {
+	return fmt.Sprintf(`replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;')`, expr)
+}
+
+type BookSearchFilter struct {
+	Year   *int
+	Season string
+}
+
+// BookSearchResult is a book matching a search with its relevance and the
+// name and description fragments that matched, HTML-escaped with the
+// matches wrapped in <mark> tags. When
+// only the text of the book's file matched, the snippet is taken from it if
+// anyone may read the file.
+type BookSearchResult struct {
+	Book
+	Rank          float64 `db:"rank" json:"rank"`
+	NameHighlight string  `db:"name_highlight" json:"name_highlight"`
+	Snippet       string  `db:"snippet" json:"snippet"`
+}
+
+// SearchBooks runs a full-text search over the archive. The search is
+// normalized like the index (diacritics, alef/ya/ta marbuta folding) and
+// results are ordered by ts_rank, newest first among equal ranks.
+func (b *BookDB) SearchBooks(search string, filter BookSearchFilter, page, perPage int) ([]BookSearchResult, *utils.Meta, error) {
+	normalized := utils.NormalizeArabicText(strings.ToLower(search))
+
+	base := QB.Select().
+		Prefix(bookSearchQuery, normalized, normalized, normalized).
+		From("book b, q").
+		Where("b.search_vector @@ q.query AND " + bookSearchVector + " @@ q.query")
+	if filter.Year != nil {
+		base = base.Where(squirrel.Eq{"b.year": *filter.Year})
+	}
+	if filter.Season != "" {
+		base = base.Where(squirrel.Eq{"b.season": filter.Season})
+	}
+
+	countQuery, countArgs, err := base.Column("COUNT(*)").ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build count query: %w", err)
+	}
+	var total int
+	if err := b.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, nil, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	builder := base.Columns(
+		"b.id",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		thumbnailColumn(bookFile),
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.keywords",
+		"b.source_pre_project_id",
+		"b.created_at",
+		"b.updated_at",
+		"ts_rank("+bookSearchVector+", q.query) AS rank",
+		fmt.Sprintf("ts_headline('arabic', %s, q.query, 'HighlightAll=true, %s') AS name_highlight", escapeHTML("b.name"), headlineOptions),
+		fmt.Sprintf(`CASE WHEN b.full_text = '' OR NOT `+bookTextPublic+` OR to_tsvector('arabic', normalize_arabic(COALESCE(b.description, ''))) || to_tsvector('english', normalize_arabic(COALESCE(b.description, ''))) @@ q.query
+			THEN ts_headline('arabic', %[2]s, q.query, 'MaxFragments=2, MaxWords=35, MinWords=15, %[1]s')
+			ELSE ts_headline('arabic', %[3]s, q.query, 'MaxFragments=2, MaxWords=35, MinWords=15, %[1]s') END AS snippet`,
+			headlineOptions, escapeHTML("COALESCE(b.description, '')"), escapeHTML("left(b.full_text, 100000)")),
+	).OrderBy("rank DESC", "b.year DESC", "b.name")
+	if page > 0 && perPage > 0 {
+		builder = builder.Limit(uint64(perPage)).Offset(uint64((page - 1) * perPage))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	results := []BookSearchResult{}
+	if err := b.db.Select(&results, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to search books: %w", err)
+	}
+
+	return results, utils.NewMeta(total, page, perPage), nil
+}
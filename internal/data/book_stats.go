@@ -0,0 +1,153 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BookEventView     = "view"
+	BookEventDownload = "download"
+
+	MaxStatsDays       = 365
+	MaxPopularBooks    = 50
+	DefaultStatsDays   = 30
+	DefaultPopularSize = 10
+)
+
+// BookStats are the unique views and downloads of a book: all-time totals
+// and a day-by-day series ending today.
+type BookStats struct {
+	BookID    uuid.UUID      `json:"book_id"`
+	Views     int            `db:"views" json:"views"`
+	Downloads int            `db:"downloads" json:"downloads"`
+	Daily     []BookDayStats `json:"daily"`
+}
+
+type BookDayStats struct {
+	Day       string `db:"day" json:"day"`
+	Views     int    `db:"views" json:"views"`
+	Downloads int    `db:"downloads" json:"downloads"`
+}
+
+// PopularBook is a book with its unique downloads and views over a period.
+type PopularBook struct {
+	Book
+	Downloads int `db:"downloads" json:"downloads"`
+	Views     int `db:"views" json:"views"`
+}
+
+// RecordBookEvent counts a view or download of a book, once per visitor
+// and day. It reports whether the event was new.
+func (b *BookDB) RecordBookEvent(bookID uuid.UUID, kind, visitor string) (bool, error) {
+	result, err := b.db.Exec(`
+		WITH inserted AS (
+			INSERT INTO book_events (book_id, kind, visitor) VALUES ($1, $2::text, $3)
+			ON CONFLICT DO NOTHING
+			RETURNING book_id, day
+		)
+		INSERT INTO book_daily_stats (book_id, day, views, downloads)
+		SELECT book_id, day,
+			CASE WHEN $2::text = 'view' THEN 1 ELSE 0 END,
+			CASE WHEN $2::text = 'download' THEN 1 ELSE 0 END
+		FROM inserted
+		ON CONFLICT (book_id, day) DO UPDATE SET
+			views = book_daily_stats.views + EXCLUDED.views,
+			downloads = book_daily_stats.downloads + EXCLUDED.downloads`,
+		bookID, kind, visitor)
+	if err != nil {
+		return false, fmt.Errorf("failed to record book %s: %w", kind, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// PruneBookEvents drops the deduplication rows of past days, which the
+// daily counts no longer need.
+func (b *BookDB) PruneBookEvents() error {
+	if _, err := b.db.Exec("DELETE FROM book_events WHERE day < CURRENT_DATE"); err != nil {
+		return fmt.Errorf("failed to prune book events: %w", err)
+	}
+	return nil
+}
+
+// GetBookIDByFile returns the book whose file is stored at path, relative
+// to the working directory as utils.SaveFile returns it.
+func (b *BookDB) GetBookIDByFile(path string) (uuid.UUID, error) {
+	var id uuid.UUID
+	if err := b.db.Get(&id, "SELECT id FROM book WHERE file = $1 LIMIT 1", path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrRecordNotFound
+		}
+		return uuid.Nil, fmt.Errorf("failed to find book by file: %w", err)
+	}
+	return id, nil
+}
+
+// GetBookStats returns the totals of a book and its counts for each of the
+// last days, today included, with zeros on days without activity.
+func (b *BookDB) GetBookStats(bookID uuid.UUID, days int) (*BookStats, error) {
+	var exists bool
+	if err := b.db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM book WHERE id = $1)", bookID); err != nil {
+		return nil, fmt.Errorf("failed to check book: %w", err)
+	}
+	if !exists {
+		return nil, ErrRecordNotFound
+	}
+
+	stats := &BookStats{BookID: bookID}
+	err := b.db.Get(stats, `
+		SELECT COALESCE(SUM(views), 0) AS views, COALESCE(SUM(downloads), 0) AS downloads
+		FROM book_daily_stats WHERE book_id = $1`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get book totals: %w", err)
+	}
+
+	stats.Daily = []BookDayStats{}
+	err = b.db.Select(&stats.Daily, `
+		SELECT to_char(d.day, 'YYYY-MM-DD') AS day,
+			COALESCE(s.views, 0) AS views,
+			COALESCE(s.downloads, 0) AS downloads
+		FROM generate_series(CURRENT_DATE - ($2::int - 1), CURRENT_DATE, interval '1 day') AS d(day)
+		LEFT JOIN book_daily_stats s ON s.book_id = $1 AND s.day = d.day::date
+		ORDER BY d.day`, bookID, days)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get book daily stats: %w", err)
+	}
+	return stats, nil
+}
+
+// ListPopularBooks returns the most downloaded books between two dates,
+// inclusive, then the most viewed. It aggregates the daily counts of the
+// period only, whatever the size of the archive.
+func (b *BookDB) ListPopularBooks(from, to time.Time, limit int) ([]PopularBook, error) {
+	query := fmt.Sprintf(`
+		SELECT b.id, b.name, b.description,
+			%s,
+			%s,
+			b.year, b.season, b.degree, b.keywords, b.source_pre_project_id, b.created_at, b.updated_at,
+			s.downloads, s.views
+		FROM (
+			SELECT book_id, SUM(downloads) AS downloads, SUM(views) AS views
+			FROM book_daily_stats
+			WHERE day BETWEEN $1::date AND $2::date
+			GROUP BY book_id
+			ORDER BY downloads DESC, views DESC
+			LIMIT $3
+		) s
+		JOIN book b ON b.id = s.book_id
+		ORDER BY s.downloads DESC, s.views DESC, b.name`, bookFileColumn, thumbnailColumn(bookFile))
+
+	books := []PopularBook{}
+	if err := b.db.Select(&books, query, from.Format("2006-01-02"), to.Format("2006-01-02"), limit); err != nil {
+		return nil, fmt.Errorf("failed to list popular books: %w", err)
+	}
+	return books, nil
+}
@@ -9,6 +9,7 @@ import (
 	"project/utils/validator"
 	"time"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
@@ -76,7 +77,7 @@ func (c *ChatDB) GetChatsByConversationID(conversationID uuid.UUID, queryParams
 		"sender.name AS sender_name", "sender.email AS sender_email", "receiver.name AS receiver_name", "receiver.email AS receiver_email"}
 
 	// Add additional filters for conversation ID
-	additionalFilters := []string{fmt.Sprintf("chats.conversation_id = '%s'", conversationID)}
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"chats.conversation_id": conversationID}}
 
 	meta, err := utils.BuildQuery(&chats, "chats", joins, columns, nil, queryParams, additionalFilters)
 	if err != nil {
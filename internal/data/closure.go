@@ -0,0 +1,279 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	ClosureWithdrawal   = "withdrawal"
+	ClosureCancellation = "cancellation"
+
+	ClosurePending    = "pending"
+	ClosureApproved   = "approved"
+	ClosureRejected   = "rejected"
+	ClosureRetracted  = "retracted"
+	ClosureSuperseded = "superseded"
+)
+
+var (
+	ErrClosurePending  = errors.New("يوجد طلب انسحاب قيد المراجعة لهذا المشروع")
+	ErrClosureReviewed = errors.New("تمت مراجعة هذا الطلب بالفعل")
+)
+
+type ClosureDB struct {
+	db *sqlx.DB
+}
+
+// Closure is a withdrawal request or an admin cancellation of a pre-project.
+type Closure struct {
+	ID              uuid.UUID  `db:"id" json:"id"`
+	PreProjectID    uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	ProjectName     string     `db:"project_name" json:"project_name"`
+	Kind            string     `db:"kind" json:"kind"`
+	Status          string     `db:"status" json:"status"`
+	Reason          string     `db:"reason" json:"reason"`
+	RequestedBy     *uuid.UUID `db:"requested_by" json:"requested_by,omitempty"`
+	RequestedByName *string    `db:"requested_by_name" json:"requested_by_name,omitempty"`
+	ReviewedBy      *uuid.UUID `db:"reviewed_by" json:"reviewed_by,omitempty"`
+	ReviewNote      *string    `db:"review_note" json:"review_note,omitempty"`
+	ReviewedAt      *time.Time `db:"reviewed_at" json:"reviewed_at,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ClosureOutcome is what closing a project released.
+type ClosureOutcome struct {
+	DefenseCancelled bool `json:"defense_cancelled"`
+	TopicReopened    bool `json:"topic_reopened"`
+}
+
+func ValidateClosureReason(v *validator.Validator, reason string) {
+	v.Check(len(reason) >= 10, "reason", "يجب أن يكون السبب على الأقل 10 أحرف")
+	v.Check(len(reason) <= 1500, "reason", "لا يمكن للسبب أن يكون أكثر من 1500 حرف")
+}
+
+var closureColumns = []string{
+	"c.*",
+	"pp.name AS project_name",
+	"u.name AS requested_by_name",
+}
+
+func (c *ClosureDB) listClosures(where squirrel.Sqlizer) ([]Closure, error) {
+	query, args, err := QB.Select(closureColumns...).
+		From("project_closures c").
+		Join("pre_project pp ON pp.id = c.pre_project_id").
+		LeftJoin("users u ON u.id = c.requested_by").
+		Where(where).
+		OrderBy("c.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	closures := []Closure{}
+	if err := c.db.Select(&closures, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list closures: %w", err)
+	}
+	return closures, nil
+}
+
+func (c *ClosureDB) GetClosure(closureID uuid.UUID) (*Closure, error) {
+	closures, err := c.listClosures(squirrel.Eq{"c.id": closureID})
+	if err != nil {
+		return nil, err
+	}
+	if len(closures) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &closures[0], nil
+}
+
+// ListClosures returns withdrawal requests and cancellations, optionally
+// only those with the given status or of the given project.
+func (c *ClosureDB) ListClosures(status string, preProjectID *uuid.UUID) ([]Closure, error) {
+	where := squirrel.Eq{}
+	if status != "" {
+		where["c.status"] = status
+	}
+	if preProjectID != nil {
+		where["c.pre_project_id"] = *preProjectID
+	}
+	return c.listClosures(where)
+}
+
+// RequestWithdrawal files a student's request to withdraw an active project.
+func (c *ClosureDB) RequestWithdrawal(closure *Closure) error {
+	var active bool
+	err := c.db.Get(&active, "SELECT EXISTS (SELECT 1 FROM pre_project pp WHERE pp.id = $1 AND "+preProjectActive("pp")+")", closure.PreProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to check pre-project: %w", err)
+	}
+	if !active {
+		return ErrRecordNotFound
+	}
+
+	query, args, err := QB.Insert("project_closures").
+		Columns("pre_project_id", "kind", "reason", "requested_by").
+		Values(closure.PreProjectID, ClosureWithdrawal, closure.Reason, closure.RequestedBy).
+		Suffix("RETURNING id, kind, status, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := c.db.QueryRowx(query, args...).StructScan(closure); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrClosurePending
+		}
+		return fmt.Errorf("failed to insert withdrawal request: %w", err)
+	}
+	return nil
+}
+
+// reviewClosure moves a pending request to status. It returns
+// ErrClosureReviewed when the request is no longer pending.
+func reviewClosure(q sqlx.Execer, closureID uuid.UUID, status string, reviewedBy *uuid.UUID, note *string) error {
+	result, err := q.Exec(`
+        UPDATE project_closures
+        SET status = $1, reviewed_by = $2, review_note = $3, reviewed_at = $4
+        WHERE id = $5 AND status = 'pending'`, status, reviewedBy, note, time.Now(), closureID)
+	if err != nil {
+		return fmt.Errorf("failed to review closure: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrClosureReviewed
+	}
+	return nil
+}
+
+// RetractWithdrawal lets the student take back their pending request.
+func (c *ClosureDB) RetractWithdrawal(closureID uuid.UUID) error {
+	return reviewClosure(c.db, closureID, ClosureRetracted, nil, nil)
+}
+
+func (c *ClosureDB) RejectWithdrawal(closureID, adminID uuid.UUID, note *string) error {
+	return reviewClosure(c.db, closureID, ClosureRejected, &adminID, note)
+}
+
+// ApproveWithdrawal accepts a pending request and closes its project.
+func (c *ClosureDB) ApproveWithdrawal(closureID, adminID uuid.UUID, note *string) (*ClosureOutcome, error) {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var preProjectID uuid.UUID
+	err = tx.Get(&preProjectID, "SELECT pre_project_id FROM project_closures WHERE id = $1 FOR UPDATE", closureID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to lock closure: %w", err)
+	}
+	if err := reviewClosure(tx, closureID, ClosureApproved, &adminID, note); err != nil {
+		return nil, err
+	}
+	outcome, err := closePreProject(tx, preProjectID, "withdrawn")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return outcome, nil
+}
+
+// CancelPreProject records an admin cancellation and closes the project. A
+// pending withdrawal request of the project is superseded.
+func (c *ClosureDB) CancelPreProject(closure *Closure) (*ClosureOutcome, error) {
+	tx, err := c.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`
+        UPDATE project_closures SET status = $1, reviewed_by = $2, reviewed_at = $3
+        WHERE pre_project_id = $4 AND status = 'pending'`,
+		ClosureSuperseded, closure.RequestedBy, now, closure.PreProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to supersede withdrawal requests: %w", err)
+	}
+
+	query, args, err := QB.Insert("project_closures").
+		Columns("pre_project_id", "kind", "status", "reason", "requested_by", "reviewed_by", "reviewed_at").
+		Values(closure.PreProjectID, ClosureCancellation, ClosureApproved, closure.Reason,
+			closure.RequestedBy, closure.RequestedBy, now).
+		Suffix("RETURNING id, kind, status, reviewed_by, reviewed_at, created_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(closure); err != nil {
+		return nil, fmt.Errorf("failed to insert cancellation: %w", err)
+	}
+
+	outcome, err := closePreProject(tx, closure.PreProjectID, "cancelled")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return outcome, nil
+}
+
+// closePreProject ends an active project without a book. The row is kept
+// and only marked through closed_at, so its members can still read its
+// history. Once closed, CheckExistingPreProject lets its students propose
+// again, and it drops out of advisors' inboxes and workloads. An upcoming
+// defense is cancelled, external examiner links are revoked and a topic it
+// was created from is opened again.
+func closePreProject(tx *sqlx.Tx, preProjectID uuid.UUID, closure string) (*ClosureOutcome, error) {
+	now := time.Now()
+	result, err := tx.Exec(`
+        UPDATE pre_project pp SET closed_at = $1, closure = $2, can_update = false, updated_at = $1
+        WHERE pp.id = $3 AND `+preProjectActive("pp"), now, closure, preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to close pre-project: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, ErrRecordNotFound
+	}
+
+	outcome := &ClosureOutcome{}
+	result, err = tx.Exec("DELETE FROM defenses WHERE pre_project_id = $1 AND starts_at > $2", preProjectID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel defense: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		outcome.DefenseCancelled = true
+	}
+
+	_, err = tx.Exec("UPDATE external_examiner_links SET revoked_at = $1 WHERE pre_project_id = $2 AND revoked_at IS NULL", now, preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke external links: %w", err)
+	}
+
+	result, err = tx.Exec(`
+        UPDATE project_topics SET status = $1, pre_project_id = NULL, updated_at = $2
+        WHERE pre_project_id = $3 AND status = $4`, TopicOpen, now, preProjectID, TopicAssigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen topic: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		outcome.TopicReopened = true
+	}
+	return outcome, nil
+}
@@ -0,0 +1,780 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrDefenseAlreadyScheduled = errors.New("تم تحديد موعد مناقشة لهذا المشروع بالفعل")
+
+type DefenseDB struct {
+	db *sqlx.DB
+}
+
+type Defense struct {
+	ID              uuid.UUID  `db:"id" json:"id"`
+	PreProjectID    uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	StartsAt        time.Time  `db:"starts_at" json:"starts_at"`
+	DurationMinutes int        `db:"duration_minutes" json:"duration_minutes"`
+	Room            string     `db:"room" json:"room"`
+	AdvisorID       uuid.UUID  `db:"advisor_id" json:"advisor_id"`
+	CreatedBy       *uuid.UUID `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+func (d *Defense) EndsAt() time.Time {
+	return d.StartsAt.Add(time.Duration(d.DurationMinutes) * time.Minute)
+}
+
+type DefenseMember struct {
+	UserID uuid.UUID `db:"user_id" json:"user_id"`
+	Name   string    `db:"name" json:"name"`
+	Email  string    `db:"email" json:"email"`
+	Role   string    `db:"role" json:"role"`
+}
+
+type DefenseWithDetails struct {
+	Defense
+	EndsAt      time.Time       `json:"ends_at"`
+	ProjectName string          `db:"project_name" json:"project_name"`
+	Year        int             `db:"year" json:"year"`
+	Season      string          `db:"season" json:"season"`
+	Committee   []DefenseMember `json:"committee"`
+	Students    []UserDetails   `json:"students"`
+}
+
+// PublicDefense is a defense as announced on the public schedule: when and
+// where, and who sits it by name and role, without any contact details.
+type PublicDefense struct {
+	ID          uuid.UUID      `db:"id" json:"id"`
+	ProjectName string         `db:"project_name" json:"project_name"`
+	Year        int            `db:"year" json:"year"`
+	Season      string         `db:"season" json:"season"`
+	Room        string         `db:"room" json:"room"`
+	StartsAt    time.Time      `db:"starts_at" json:"starts_at"`
+	EndsAt      time.Time      `db:"ends_at" json:"ends_at"`
+	People      []PublicPerson `db:"-" json:"people"`
+}
+
+// PublicPerson is someone on a public defense: a student, the advisor or a
+// discussant.
+type PublicPerson struct {
+	Name string `db:"name" json:"name"`
+	Role string `db:"role" json:"role"`
+}
+
+// ScheduleConflict describes one reason a defense cannot take place at the requested time.
+type ScheduleConflict struct {
+	Kind      string    `json:"kind"` // "room" or "committee"
+	DefenseID uuid.UUID `json:"defense_id"`
+	UserID    uuid.UUID `json:"user_id,omitempty"`
+	Room      string    `json:"room,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+type ScheduleConflictError struct {
+	Conflicts []ScheduleConflict
+}
+
+func (e *ScheduleConflictError) Error() string {
+	kinds := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		kinds[i] = c.Kind
+	}
+	return fmt.Sprintf("defense schedule conflicts: %s", strings.Join(kinds, ", "))
+}
+
+type Availability struct {
+	ID        uuid.UUID `db:"id" json:"id"`
+	UserID    uuid.UUID `db:"user_id" json:"user_id"`
+	StartsAt  time.Time `db:"starts_at" json:"starts_at"`
+	EndsAt    time.Time `db:"ends_at" json:"ends_at"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// DefenseCandidate is an accepted pre-project that still needs a defense slot.
+type DefenseCandidate struct {
+	PreProjectID uuid.UUID   `db:"id" json:"pre_project_id"`
+	Name         string      `db:"name" json:"name"`
+	AdvisorID    uuid.UUID   `db:"accepted_advisor" json:"advisor_id"`
+	Discussants  []uuid.UUID `json:"discussants"`
+}
+
+func ValidateDefense(v *validator.Validator, defense *Defense) {
+	v.Check(!defense.StartsAt.IsZero(), "starts_at", "موعد المناقشة مطلوب")
+	v.Check(defense.DurationMinutes >= 15, "duration", "يجب أن تكون مدة المناقشة 15 دقيقة على الأقل")
+	v.Check(defense.DurationMinutes <= 240, "duration", "لا يمكن أن تتجاوز مدة المناقشة 4 ساعات")
+	v.Check(strings.TrimSpace(defense.Room) != "", "room", "القاعة مطلوبة")
+	v.Check(len(defense.Room) <= 100, "room", "اسم القاعة طويل جداً")
+	v.Check(defense.AdvisorID != uuid.Nil, "advisor", "يجب أن يكون للمشروع مشرف مقبول")
+}
+
+func ValidateAvailability(v *validator.Validator, a *Availability) {
+	v.Check(!a.StartsAt.IsZero(), "starts_at", "وقت البداية مطلوب")
+	v.Check(a.EndsAt.After(a.StartsAt), "ends_at", "يجب أن يكون وقت النهاية بعد وقت البداية")
+}
+
+// lockSchedule serialises every scheduling write so two admins cannot book
+// the same room or examiner concurrently.
+func lockSchedule(tx *sqlx.Tx) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('defenses'))")
+	if err != nil {
+		return fmt.Errorf("failed to lock defense schedule: %w", err)
+	}
+	return nil
+}
+
+// findConflicts returns the defenses that overlap [start, end) and either use
+// the same room or share a committee member.
+func findConflicts(q sqlx.Queryer, start, end time.Time, room string, members []uuid.UUID, excludeID uuid.UUID) ([]ScheduleConflict, error) {
+	overlap := squirrel.And{
+		squirrel.Lt{"d.starts_at": end},
+		squirrel.Expr("d.starts_at + d.duration_minutes * INTERVAL '1 minute' > ?", start),
+		squirrel.NotEq{"d.id": excludeID},
+	}
+
+	conflicts := []ScheduleConflict{}
+
+	roomQuery, roomArgs, err := QB.Select("d.id", "d.room", "d.starts_at", "d.duration_minutes").
+		From("defenses d").
+		Where(overlap).
+		Where("LOWER(d.room) = LOWER(?)", room).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build room conflict query: %w", err)
+	}
+	var rooms []Defense
+	if err := sqlx.Select(q, &rooms, roomQuery, roomArgs...); err != nil {
+		return nil, fmt.Errorf("failed to check room conflicts: %w", err)
+	}
+	for _, d := range rooms {
+		conflicts = append(conflicts, ScheduleConflict{
+			Kind: "room", DefenseID: d.ID, Room: d.Room, StartsAt: d.StartsAt, EndsAt: d.EndsAt(),
+		})
+	}
+
+	if len(members) == 0 {
+		return conflicts, nil
+	}
+
+	memberQuery, memberArgs, err := QB.Select("d.id", "d.starts_at", "d.duration_minutes", "dc.user_id").
+		From("defenses d").
+		Join("defense_committee dc ON dc.defense_id = d.id").
+		Where(overlap).
+		Where(squirrel.Eq{"dc.user_id": members}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build committee conflict query: %w", err)
+	}
+	var busy []struct {
+		Defense
+		UserID uuid.UUID `db:"user_id"`
+	}
+	if err := sqlx.Select(q, &busy, memberQuery, memberArgs...); err != nil {
+		return nil, fmt.Errorf("failed to check committee conflicts: %w", err)
+	}
+	for _, b := range busy {
+		conflicts = append(conflicts, ScheduleConflict{
+			Kind: "committee", DefenseID: b.ID, UserID: b.UserID, StartsAt: b.StartsAt, EndsAt: b.EndsAt(),
+		})
+	}
+
+	return conflicts, nil
+}
+
+func committeeIDs(advisorID uuid.UUID, discussantIDs []uuid.UUID) []uuid.UUID {
+	ids := []uuid.UUID{advisorID}
+	for _, id := range discussantIDs {
+		if id != advisorID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ScheduleDefense books a defense after checking room and committee conflicts.
+func (d *DefenseDB) ScheduleDefense(defense *Defense, discussantIDs []uuid.UUID) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx); err != nil {
+		return err
+	}
+
+	conflicts, err := findConflicts(tx, defense.StartsAt, defense.EndsAt(), defense.Room,
+		committeeIDs(defense.AdvisorID, discussantIDs), uuid.Nil)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ScheduleConflictError{Conflicts: conflicts}
+	}
+
+	query, args, err := QB.Insert("defenses").
+		Columns("pre_project_id", "starts_at", "duration_minutes", "room", "advisor_id", "created_by").
+		Values(defense.PreProjectID, defense.StartsAt, defense.DurationMinutes, defense.Room, defense.AdvisorID, defense.CreatedBy).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(defense); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDefenseAlreadyScheduled
+		}
+		return fmt.Errorf("failed to insert defense: %w", err)
+	}
+
+	if _, err := tx.Exec("INSERT INTO defense_committee (defense_id, user_id, role) VALUES ($1, $2, 'advisor')", defense.ID, defense.AdvisorID); err != nil {
+		return fmt.Errorf("failed to insert advisor into committee: %w", err)
+	}
+	for _, id := range committeeIDs(defense.AdvisorID, discussantIDs)[1:] {
+		if _, err := tx.Exec("INSERT INTO defense_committee (defense_id, user_id, role) VALUES ($1, $2, 'discussant')", defense.ID, id); err != nil {
+			return fmt.Errorf("failed to insert discussant %s into committee: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RescheduleDefense moves an existing defense, checking conflicts against
+// every other defense for its current committee.
+func (d *DefenseDB) RescheduleDefense(defense *Defense) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockSchedule(tx); err != nil {
+		return err
+	}
+
+	var members []uuid.UUID
+	if err := tx.Select(&members, "SELECT user_id FROM defense_committee WHERE defense_id = $1", defense.ID); err != nil {
+		return fmt.Errorf("failed to load committee: %w", err)
+	}
+
+	conflicts, err := findConflicts(tx, defense.StartsAt, defense.EndsAt(), defense.Room, members, defense.ID)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return &ScheduleConflictError{Conflicts: conflicts}
+	}
+
+	query, args, err := QB.Update("defenses").
+		Set("starts_at", defense.StartsAt).
+		Set("duration_minutes", defense.DurationMinutes).
+		Set("room", defense.Room).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": defense.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update defense: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (d *DefenseDB) DeleteDefense(defenseID uuid.UUID) error {
+	result, err := d.db.Exec("DELETE FROM defenses WHERE id = $1", defenseID)
+	if err != nil {
+		return fmt.Errorf("failed to delete defense: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+var defenseDetailColumns = []string{
+	"d.*",
+	"pp.name AS project_name",
+	"pp.year",
+	"pp.season",
+}
+
+func (d *DefenseDB) GetDefense(defenseID uuid.UUID) (*DefenseWithDetails, error) {
+	defenses, err := d.listDefenses(squirrel.Eq{"d.id": defenseID}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(defenses) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &defenses[0], nil
+}
+
+func (d *DefenseDB) GetDefenseByPreProject(preProjectID uuid.UUID) (*DefenseWithDetails, error) {
+	defenses, err := d.listDefenses(squirrel.Eq{"d.pre_project_id": preProjectID}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(defenses) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &defenses[0], nil
+}
+
+// ListUpcomingDefenses returns the public schedule: the defenses of active
+// projects ending after from, optionally narrowed to a term or a room, in
+// chronological order.
+func (d *DefenseDB) ListUpcomingDefenses(from time.Time, year int, season, room string, limit uint64) ([]PublicDefense, error) {
+	where := squirrel.And{
+		squirrel.Expr("d.starts_at + d.duration_minutes * INTERVAL '1 minute' >= ?", from),
+		squirrel.Expr(preProjectActive("pp")),
+	}
+	if year > 0 {
+		where = append(where, squirrel.Eq{"pp.year": year})
+	}
+	if season != "" {
+		where = append(where, squirrel.Eq{"pp.season": season})
+	}
+	if room != "" {
+		where = append(where, squirrel.Expr("LOWER(d.room) = LOWER(?)", room))
+	}
+
+	query, args, err := QB.Select(
+		"d.id",
+		"pp.name AS project_name",
+		"pp.year",
+		"pp.season",
+		"d.room",
+		"d.starts_at",
+		"d.starts_at + d.duration_minutes * INTERVAL '1 minute' AS ends_at",
+	).
+		From("defenses d").
+		Join("pre_project pp ON pp.id = d.pre_project_id").
+		Where(where).
+		OrderBy("d.starts_at ASC").
+		Limit(limit).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	defenses := []PublicDefense{}
+	if err := d.db.Select(&defenses, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list defenses: %w", err)
+	}
+	if len(defenses) == 0 {
+		return defenses, nil
+	}
+
+	ids := make([]uuid.UUID, len(defenses))
+	index := map[uuid.UUID]int{}
+	for i := range defenses {
+		defenses[i].People = []PublicPerson{}
+		ids[i] = defenses[i].ID
+		index[defenses[i].ID] = i
+	}
+	var people []struct {
+		DefenseID uuid.UUID `db:"defense_id"`
+		PublicPerson
+	}
+	err = d.db.Select(&people, `
+        SELECT d.id AS defense_id, u.name, 'student' AS role
+        FROM defenses d
+        JOIN pre_project_students pps ON pps.pre_project_id = d.pre_project_id
+        JOIN users u ON u.id = pps.student_id
+        WHERE d.id = ANY($1)
+        UNION ALL
+        SELECT dc.defense_id, u.name, dc.role
+        FROM defense_committee dc
+        JOIN users u ON u.id = dc.user_id
+        WHERE dc.defense_id = ANY($1)
+        ORDER BY role, name`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load defense people: %w", err)
+	}
+	for _, person := range people {
+		i := index[person.DefenseID]
+		defenses[i].People = append(defenses[i].People, person.PublicPerson)
+	}
+	return defenses, nil
+}
+
+func (d *DefenseDB) listDefenses(where squirrel.Sqlizer, limit uint64) ([]DefenseWithDetails, error) {
+	sb := QB.Select(defenseDetailColumns...).
+		From("defenses d").
+		Join("pre_project pp ON pp.id = d.pre_project_id").
+		Where(where).
+		OrderBy("d.starts_at ASC")
+	if limit > 0 {
+		sb = sb.Limit(limit)
+	}
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	defenses := []DefenseWithDetails{}
+	if err := d.db.Select(&defenses, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list defenses: %w", err)
+	}
+	if len(defenses) == 0 {
+		return defenses, nil
+	}
+
+	defenseIDs := make([]uuid.UUID, len(defenses))
+	projectIDs := make([]uuid.UUID, len(defenses))
+	index := map[uuid.UUID]int{}
+	byProject := map[uuid.UUID]int{}
+	for i := range defenses {
+		defenses[i].EndsAt = defenses[i].Defense.EndsAt()
+		defenses[i].Committee = []DefenseMember{}
+		defenses[i].Students = []UserDetails{}
+		defenseIDs[i] = defenses[i].ID
+		projectIDs[i] = defenses[i].PreProjectID
+		index[defenses[i].ID] = i
+		byProject[defenses[i].PreProjectID] = i
+	}
+
+	committeeQuery, committeeArgs, err := QB.Select("dc.defense_id", "dc.user_id", "u.name", "u.email", "dc.role").
+		From("defense_committee dc").
+		Join("users u ON u.id = dc.user_id").
+		Where(squirrel.Eq{"dc.defense_id": defenseIDs}).
+		OrderBy("dc.role ASC", "u.name ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build committee query: %w", err)
+	}
+	var members []struct {
+		DefenseID uuid.UUID `db:"defense_id"`
+		DefenseMember
+	}
+	if err := d.db.Select(&members, committeeQuery, committeeArgs...); err != nil {
+		return nil, fmt.Errorf("failed to load committees: %w", err)
+	}
+	for _, m := range members {
+		i := index[m.DefenseID]
+		defenses[i].Committee = append(defenses[i].Committee, m.DefenseMember)
+	}
+
+	studentQuery, studentArgs, err := QB.Select("pps.pre_project_id", "u.id", "u.name", "u.email").
+		From("pre_project_students pps").
+		Join("users u ON u.id = pps.student_id").
+		Where(squirrel.Eq{"pps.pre_project_id": projectIDs}).
+		OrderBy("u.name ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build student query: %w", err)
+	}
+	var students []struct {
+		PreProjectID uuid.UUID `db:"pre_project_id"`
+		ID           uuid.UUID `db:"id"`
+		Name         string    `db:"name"`
+		Email        string    `db:"email"`
+	}
+	if err := d.db.Select(&students, studentQuery, studentArgs...); err != nil {
+		return nil, fmt.Errorf("failed to load students: %w", err)
+	}
+	for _, s := range students {
+		i := byProject[s.PreProjectID]
+		defenses[i].Students = append(defenses[i].Students, UserDetails{ID: s.ID, Name: s.Name, Email: s.Email})
+	}
+
+	return defenses, nil
+}
+
+// ListDefenseCandidates returns accepted pre-projects of a term that have no defense yet.
+func (d *DefenseDB) ListDefenseCandidates(year int, season string) ([]DefenseCandidate, error) {
+	query, args, err := QB.Select("pp.id", "pp.name", "pp.accepted_advisor").
+		From("pre_project pp").
+		LeftJoin("defenses d ON d.pre_project_id = pp.id").
+		Where(squirrel.Eq{"pp.year": year, "pp.season": season, "d.id": nil}).
+		Where(preProjectActive("pp")).
+		Where(squirrel.NotEq{"pp.accepted_advisor": nil}).
+		OrderBy("pp.created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	candidates := []DefenseCandidate{}
+	if err := d.db.Select(&candidates, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list defense candidates: %w", err)
+	}
+	for i := range candidates {
+		candidates[i].Discussants = []uuid.UUID{}
+		err := d.db.Select(&candidates[i].Discussants,
+			"SELECT discussant_id FROM pre_project_discussants WHERE pre_project_id = $1", candidates[i].PreProjectID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load discussants: %w", err)
+		}
+	}
+	return candidates, nil
+}
+
+// busySlot is a committee member or room occupied between Start and End.
+type busySlot struct {
+	Start, End time.Time
+}
+
+// loadBusySlots returns, for the given period, the slots already taken per
+// committee member and per (lower-cased) room.
+func (d *DefenseDB) loadBusySlots(from, to time.Time) (map[uuid.UUID][]busySlot, map[string][]busySlot, error) {
+	query, args, err := QB.Select("d.id", "d.room", "d.starts_at", "d.duration_minutes", "COALESCE(dc.user_id, '00000000-0000-0000-0000-000000000000') AS user_id").
+		From("defenses d").
+		LeftJoin("defense_committee dc ON dc.defense_id = d.id").
+		Where(squirrel.Lt{"d.starts_at": to}).
+		Where(squirrel.Expr("d.starts_at + d.duration_minutes * INTERVAL '1 minute' > ?", from)).
+		ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var rows []struct {
+		Defense
+		UserID uuid.UUID `db:"user_id"`
+	}
+	if err := d.db.Select(&rows, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to load booked defenses: %w", err)
+	}
+
+	members := map[uuid.UUID][]busySlot{}
+	rooms := map[string][]busySlot{}
+	seen := map[uuid.UUID]bool{}
+	for _, row := range rows {
+		slot := busySlot{row.StartsAt, row.EndsAt()}
+		if row.UserID != uuid.Nil {
+			members[row.UserID] = append(members[row.UserID], slot)
+		}
+		if !seen[row.ID] {
+			room := strings.ToLower(row.Room)
+			rooms[room] = append(rooms[room], slot)
+			seen[row.ID] = true
+		}
+	}
+	return members, rooms, nil
+}
+
+func (d *DefenseDB) InsertAvailability(a *Availability) error {
+	query, args, err := QB.Insert("committee_availability").
+		Columns("user_id", "starts_at", "ends_at").
+		Values(a.UserID, a.StartsAt, a.EndsAt).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := d.db.QueryRowx(query, args...).StructScan(a); err != nil {
+		return fmt.Errorf("failed to insert availability: %w", err)
+	}
+	return nil
+}
+
+func (d *DefenseDB) ListAvailability(userID uuid.UUID) ([]Availability, error) {
+	slots := []Availability{}
+	err := d.db.Select(&slots, "SELECT * FROM committee_availability WHERE user_id = $1 ORDER BY starts_at", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list availability: %w", err)
+	}
+	return slots, nil
+}
+
+func (d *DefenseDB) DeleteAvailability(id, userID uuid.UUID) error {
+	result, err := d.db.Exec("DELETE FROM committee_availability WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete availability: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (d *DefenseDB) loadAvailability(from, to time.Time) (map[uuid.UUID][]busySlot, error) {
+	var rows []Availability
+	err := d.db.Select(&rows, "SELECT * FROM committee_availability WHERE starts_at < $1 AND ends_at > $2", to, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load availability: %w", err)
+	}
+	available := map[uuid.UUID][]busySlot{}
+	for _, a := range rows {
+		available[a.UserID] = append(available[a.UserID], busySlot{a.StartsAt, a.EndsAt})
+	}
+	return available, nil
+}
+
+// AutoScheduleOptions describes the week to fill and how to slice it.
+type AutoScheduleOptions struct {
+	From            time.Time
+	To              time.Time
+	Rooms           []string
+	DurationMinutes int
+	DayStart        time.Duration // offset from midnight, e.g. 9h
+	DayEnd          time.Duration // offset from midnight, e.g. 15h
+}
+
+type PlannedDefense struct {
+	PreProjectID uuid.UUID   `json:"pre_project_id"`
+	ProjectName  string      `json:"project_name"`
+	StartsAt     time.Time   `json:"starts_at"`
+	EndsAt       time.Time   `json:"ends_at"`
+	Room         string      `json:"room"`
+	AdvisorID    uuid.UUID   `json:"advisor_id"`
+	Discussants  []uuid.UUID `json:"discussants"`
+}
+
+type UnplannedDefense struct {
+	PreProjectID uuid.UUID `json:"pre_project_id"`
+	ProjectName  string    `json:"project_name"`
+	Reason       string    `json:"reason"`
+}
+
+// PlanDefenses greedily assigns each candidate the earliest slot in which a
+// room is free and every committee member is both free and, if they declared
+// any availability for the period, available. Members who declared nothing
+// are treated as available at any time.
+func (d *DefenseDB) PlanDefenses(candidates []DefenseCandidate, opts AutoScheduleOptions) ([]PlannedDefense, []UnplannedDefense, error) {
+	memberBusy, roomBusy, err := d.loadBusySlots(opts.From, opts.To)
+	if err != nil {
+		return nil, nil, err
+	}
+	available, err := d.loadAvailability(opts.From, opts.To)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slots := defenseSlots(opts)
+	planned := []PlannedDefense{}
+	unplanned := []UnplannedDefense{}
+
+	// Projects with bigger committees are the hardest to place, so go first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return len(candidates[i].Discussants) > len(candidates[j].Discussants)
+	})
+
+	for _, c := range candidates {
+		members := committeeIDs(c.AdvisorID, c.Discussants)
+		placed := false
+		for _, slot := range slots {
+			if !membersFree(members, slot, memberBusy, available) {
+				continue
+			}
+			for _, room := range opts.Rooms {
+				key := strings.ToLower(room)
+				if overlapsAny(slot, roomBusy[key]) {
+					continue
+				}
+				planned = append(planned, PlannedDefense{
+					PreProjectID: c.PreProjectID,
+					ProjectName:  c.Name,
+					StartsAt:     slot.Start,
+					EndsAt:       slot.End,
+					Room:         room,
+					AdvisorID:    c.AdvisorID,
+					Discussants:  c.Discussants,
+				})
+				roomBusy[key] = append(roomBusy[key], slot)
+				for _, m := range members {
+					memberBusy[m] = append(memberBusy[m], slot)
+				}
+				placed = true
+				break
+			}
+			if placed {
+				break
+			}
+		}
+		if !placed {
+			unplanned = append(unplanned, UnplannedDefense{
+				PreProjectID: c.PreProjectID,
+				ProjectName:  c.Name,
+				Reason:       "no slot where a room and the whole committee are free",
+			})
+		}
+	}
+
+	return planned, unplanned, nil
+}
+
+func defenseSlots(opts AutoScheduleOptions) []busySlot {
+	duration := time.Duration(opts.DurationMinutes) * time.Minute
+	var slots []busySlot
+	day := time.Date(opts.From.Year(), opts.From.Month(), opts.From.Day(), 0, 0, 0, 0, opts.From.Location())
+	for ; !day.After(opts.To); day = day.AddDate(0, 0, 1) {
+		// Friday is the weekend
+		if day.Weekday() == time.Friday {
+			continue
+		}
+		for start := day.Add(opts.DayStart); !start.Add(duration).After(day.Add(opts.DayEnd)); start = start.Add(duration) {
+			if start.Before(opts.From) || start.Add(duration).After(opts.To) {
+				continue
+			}
+			slots = append(slots, busySlot{start, start.Add(duration)})
+		}
+	}
+	return slots
+}
+
+func overlapsAny(slot busySlot, taken []busySlot) bool {
+	for _, t := range taken {
+		if slot.Start.Before(t.End) && slot.End.After(t.Start) {
+			return true
+		}
+	}
+	return false
+}
+
+func membersFree(members []uuid.UUID, slot busySlot, busy, available map[uuid.UUID][]busySlot) bool {
+	for _, m := range members {
+		if overlapsAny(slot, busy[m]) {
+			return false
+		}
+		windows, declared := available[m]
+		if !declared {
+			continue
+		}
+		inside := false
+		for _, w := range windows {
+			if !slot.Start.Before(w.Start) && !slot.End.After(w.End) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	return true
+}
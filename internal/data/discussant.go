@@ -0,0 +1,351 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// RoleTeacher is the role whose holders may examine projects as
+// discussants. Examiners from outside the department are ExternalExaminers.
+const RoleTeacher = 2
+
+// maxDiscussants caps the discussants of a project or a book, counting
+// teachers and external examiners alike.
+const maxDiscussants = 3
+
+var ErrDiscussantLimit = errors.New("لا يمكن إضافة أكثر من 3 مناقشين")
+
+type DiscussantDB struct {
+	db *sqlx.DB
+}
+
+// DiscussantConflict is a declared conflict of interest: the discussant must
+// not examine projects that involve UserID, or the project PreProjectID.
+type DiscussantConflict struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	DiscussantID   uuid.UUID  `db:"discussant_id" json:"discussant_id"`
+	DiscussantName string     `db:"discussant_name" json:"discussant_name"`
+	UserID         *uuid.UUID `db:"user_id" json:"user_id,omitempty"`
+	UserName       *string    `db:"user_name" json:"user_name,omitempty"`
+	PreProjectID   *uuid.UUID `db:"pre_project_id" json:"pre_project_id,omitempty"`
+	ProjectName    *string    `db:"project_name" json:"project_name,omitempty"`
+	Reason         *string    `db:"reason" json:"reason,omitempty"`
+	DeclaredBy     *uuid.UUID `db:"declared_by" json:"declared_by,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+}
+
+// DiscussantAssignment is the outcome of automatic assignment for one
+// project. Missing counts the seats no eligible examiner could fill.
+type DiscussantAssignment struct {
+	PreProjectID uuid.UUID     `json:"pre_project_id"`
+	ProjectName  string        `json:"project_name"`
+	Added        []UserDetails `json:"added"`
+	Missing      int           `json:"missing"`
+}
+
+// ExaminerLoad is how many projects of a term an examiner discusses.
+type ExaminerLoad struct {
+	UserDetails
+	Load int `db:"load" json:"load"`
+}
+
+// ValidateDiscussants checks the rules that need no lookup: the external
+// examiners already assigned count towards the discussant limit, nobody can
+// discuss a project they advise or belong to, and nobody is listed twice.
+func ValidateDiscussants(v *validator.Validator, discussantIDs []uuid.UUID, externalCount int, advisorIDs, studentIDs []uuid.UUID) {
+	v.Check(len(discussantIDs)+externalCount <= maxDiscussants, "discutant", ErrDiscussantLimit.Error())
+	seen := map[uuid.UUID]bool{}
+	for _, id := range discussantIDs {
+		v.Check(!validator.InUUID(id, advisorIDs), "discutant", "لا يمكن أن يكون المشرف مناقشاً للمشروع نفسه")
+		v.Check(!validator.InUUID(id, studentIDs), "discutant", "لا يمكن لطالب في المشروع أن يكون مناقشاً")
+		v.Check(!seen[id], "discutant", "لا يمكن تكرار المناقش")
+		seen[id] = true
+	}
+}
+
+func ValidateDiscussantConflict(v *validator.Validator, conflict *DiscussantConflict) {
+	v.Check(conflict.UserID != nil || conflict.PreProjectID != nil, "user_id", "يجب تحديد مستخدم أو مشروع")
+	if conflict.UserID != nil {
+		v.Check(*conflict.UserID != conflict.DiscussantID, "user_id", "لا يمكن التعارض مع النفس")
+	}
+	if conflict.Reason != nil {
+		v.Check(len(*conflict.Reason) <= 1000, "reason", "السبب طويل جداً")
+	}
+}
+
+// IneligibleDiscussants returns, for each of discussantIDs that may not
+// discuss the project, the reason why: they do not hold the teacher role, or
+// they declared a conflict with the project, one of its students or one of
+// its advisors.
+func (d *DiscussantDB) IneligibleDiscussants(preProjectID uuid.UUID, discussantIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	reasons := map[uuid.UUID]string{}
+	if len(discussantIDs) == 0 {
+		return reasons, nil
+	}
+
+	var rows []struct {
+		ID       uuid.UUID `db:"id"`
+		Eligible bool      `db:"eligible"`
+		Conflict bool      `db:"conflict"`
+	}
+	err := d.db.Select(&rows, `
+        SELECT u.id,
+               EXISTS (
+                   SELECT 1 FROM user_roles ur
+                   WHERE ur.user_id = u.id AND ur.role_id = $3
+               ) AS eligible,
+               EXISTS (
+                   SELECT 1 FROM discussant_conflicts c
+                   WHERE c.discussant_id = u.id
+                     AND (c.pre_project_id = $2
+                          OR c.user_id IN (SELECT student_id FROM pre_project_students WHERE pre_project_id = $2)
+                          OR c.user_id IN (SELECT advisor_id FROM advisor_responses WHERE pre_project_id = $2)
+                          OR c.user_id = (SELECT accepted_advisor FROM pre_project WHERE id = $2))
+               ) AS conflict
+        FROM users u
+        WHERE u.id = ANY($1)`, pq.Array(discussantIDs), preProjectID, RoleTeacher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check discussants: %w", err)
+	}
+
+	for _, row := range rows {
+		switch {
+		case !row.Eligible:
+			reasons[row.ID] = "يجب أن يكون المناقش عضو هيئة تدريس"
+		case row.Conflict:
+			reasons[row.ID] = "يوجد تعارض مصالح معلن بين المناقش والمشروع"
+		}
+	}
+	return reasons, nil
+}
+
+var conflictColumns = []string{
+	"c.*",
+	"d.name AS discussant_name",
+	"u.name AS user_name",
+	"pp.name AS project_name",
+}
+
+func (d *DiscussantDB) InsertConflict(conflict *DiscussantConflict) error {
+	query, args, err := QB.Insert("discussant_conflicts").
+		Columns("discussant_id", "user_id", "pre_project_id", "reason", "declared_by").
+		Values(conflict.DiscussantID, conflict.UserID, conflict.PreProjectID, conflict.Reason, conflict.DeclaredBy).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := d.db.QueryRowx(query, args...).StructScan(conflict); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return ErrDuplicatedKey
+			case "23503":
+				return ErrForeignKeyViolation
+			}
+		}
+		return fmt.Errorf("failed to insert conflict: %w", err)
+	}
+	return nil
+}
+
+func (d *DiscussantDB) GetConflict(conflictID uuid.UUID) (*DiscussantConflict, error) {
+	conflicts, err := d.listConflicts(squirrel.Eq{"c.id": conflictID})
+	if err != nil {
+		return nil, err
+	}
+	if len(conflicts) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &conflicts[0], nil
+}
+
+// ListConflicts returns the declared conflicts, only the given discussant's
+// when discussantID is set.
+func (d *DiscussantDB) ListConflicts(discussantID *uuid.UUID) ([]DiscussantConflict, error) {
+	where := squirrel.Eq{}
+	if discussantID != nil {
+		where["c.discussant_id"] = *discussantID
+	}
+	return d.listConflicts(where)
+}
+
+func (d *DiscussantDB) listConflicts(where squirrel.Eq) ([]DiscussantConflict, error) {
+	query, args, err := QB.Select(conflictColumns...).
+		From("discussant_conflicts c").
+		Join("users d ON d.id = c.discussant_id").
+		LeftJoin("users u ON u.id = c.user_id").
+		LeftJoin("pre_project pp ON pp.id = c.pre_project_id").
+		Where(where).
+		OrderBy("d.name ASC", "c.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	conflicts := []DiscussantConflict{}
+	if err := d.db.Select(&conflicts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+func (d *DiscussantDB) DeleteConflict(conflictID uuid.UUID) error {
+	result, err := d.db.Exec("DELETE FROM discussant_conflicts WHERE id = $1", conflictID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conflict: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListExaminerLoads returns every eligible examiner with the number of
+// projects of the term they discuss, least loaded first.
+func (d *DiscussantDB) ListExaminerLoads(year int, season string) ([]ExaminerLoad, error) {
+	loads := []ExaminerLoad{}
+	err := d.db.Select(&loads, examinerLoadQuery, year, season, RoleTeacher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list examiner loads: %w", err)
+	}
+	return loads, nil
+}
+
+const examinerLoadQuery = `
+    SELECT u.id, u.name, u.email,
+           (SELECT COUNT(*)
+            FROM pre_project_discussants pd
+            JOIN pre_project pp ON pp.id = pd.pre_project_id
+            WHERE pd.discussant_id = u.id AND pp.year = $1 AND pp.season = $2) AS load
+    FROM users u
+    WHERE EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id AND ur.role_id = $3)
+    ORDER BY load ASC, u.name ASC`
+
+// AssignDiscussants gives every active, accepted project of the term up to
+// perProject discussants. Each seat goes to the least loaded eligible
+// examiner, so examining spreads evenly across the term; advisors, team
+// members and declared conflicts are skipped. With dryRun nothing is
+// written.
+func (d *DiscussantDB) AssignDiscussants(year int, season string, perProject int, dryRun bool) ([]DiscussantAssignment, error) {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var projects []struct {
+		ID   uuid.UUID `db:"id"`
+		Name string    `db:"name"`
+	}
+	err = tx.Select(&projects, `
+        SELECT pp.id, pp.name FROM pre_project pp
+        WHERE pp.year = $1 AND pp.season = $2 AND `+preProjectActive("pp")+` AND pp.accepted_advisor IS NOT NULL
+        ORDER BY pp.created_at ASC
+        FOR UPDATE`, year, season)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock projects: %w", err)
+	}
+
+	var examiners []ExaminerLoad
+	if err := tx.Select(&examiners, examinerLoadQuery, year, season, RoleTeacher); err != nil {
+		return nil, fmt.Errorf("failed to list examiner loads: %w", err)
+	}
+
+	assignments := []DiscussantAssignment{}
+	for _, project := range projects {
+		excluded, current, err := assignmentExclusions(tx, project.ID)
+		if err != nil {
+			return nil, err
+		}
+		needed := perProject - current
+		if needed <= 0 {
+			continue
+		}
+
+		// Least loaded first; the name keeps ties stable between runs
+		sort.SliceStable(examiners, func(i, j int) bool {
+			if examiners[i].Load != examiners[j].Load {
+				return examiners[i].Load < examiners[j].Load
+			}
+			return examiners[i].Name < examiners[j].Name
+		})
+
+		assignment := DiscussantAssignment{PreProjectID: project.ID, ProjectName: project.Name, Added: []UserDetails{}}
+		for i := range examiners {
+			if needed == 0 {
+				break
+			}
+			if excluded[examiners[i].ID] {
+				continue
+			}
+			if !dryRun {
+				_, err := tx.Exec("INSERT INTO pre_project_discussants (pre_project_id, discussant_id) VALUES ($1, $2)",
+					project.ID, examiners[i].ID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to insert discussant %s: %w", examiners[i].ID, err)
+				}
+			}
+			examiners[i].Load++
+			assignment.Added = append(assignment.Added, examiners[i].UserDetails)
+			needed--
+		}
+		assignment.Missing = needed
+		assignments = append(assignments, assignment)
+	}
+
+	if dryRun {
+		return assignments, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return assignments, nil
+}
+
+// assignmentExclusions returns who may not be added as a discussant of the
+// project (current discussants, advisors, students and anyone with a declared
+// conflict) and how many discussant seats are already taken, external
+// examiners included.
+func assignmentExclusions(tx *sqlx.Tx, preProjectID uuid.UUID) (map[uuid.UUID]bool, int, error) {
+	var current []uuid.UUID
+	err := tx.Select(&current, "SELECT discussant_id FROM pre_project_discussants WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get discussants: %w", err)
+	}
+	var external int
+	err = tx.Get(&external, "SELECT COUNT(*) FROM pre_project_external_discussants WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count external examiners: %w", err)
+	}
+
+	var excludedIDs []uuid.UUID
+	err = tx.Select(&excludedIDs, `
+        SELECT student_id FROM pre_project_students WHERE pre_project_id = $1
+        UNION
+        SELECT advisor_id FROM advisor_responses WHERE pre_project_id = $1
+        UNION
+        SELECT c.discussant_id FROM discussant_conflicts c
+        WHERE c.pre_project_id = $1
+           OR c.user_id IN (SELECT student_id FROM pre_project_students WHERE pre_project_id = $1)
+           OR c.user_id IN (SELECT advisor_id FROM advisor_responses WHERE pre_project_id = $1)
+           OR c.user_id = (SELECT accepted_advisor FROM pre_project WHERE id = $1)`, preProjectID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, fmt.Errorf("failed to get excluded examiners: %w", err)
+	}
+
+	excluded := map[uuid.UUID]bool{}
+	for _, id := range append(excludedIDs, current...) {
+		excluded[id] = true
+	}
+	return excluded, len(current) + external, nil
+}
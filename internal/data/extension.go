@@ -0,0 +1,177 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrExtensionLimit    = errors.New("تم تجاوز الحد الأقصى لعدد مرات تمديد المشروع")
+	ErrExtensionGraded   = errors.New("لا يمكن تمديد مشروع تم رصد درجته")
+	ErrExtensionDefended = errors.New("لا يمكن تمديد مشروع تمت مناقشته")
+	ErrExtensionTooEarly = errors.New("لا يمكن تمديد المشروع قبل انتهاء فترة المناقشة")
+)
+
+type ExtensionDB struct {
+	db *sqlx.DB
+}
+
+// Extension records one carry-over of a pre-project to the following term.
+type Extension struct {
+	ID             uuid.UUID  `db:"id" json:"id"`
+	PreProjectID   uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	ProjectName    string     `db:"project_name" json:"project_name"`
+	FromYear       int        `db:"from_year" json:"from_year"`
+	FromSeason     string     `db:"from_season" json:"from_season"`
+	ToYear         int        `db:"to_year" json:"to_year"`
+	ToSeason       string     `db:"to_season" json:"to_season"`
+	Reason         *string    `db:"reason" json:"reason,omitempty"`
+	ExtendedBy     *uuid.UUID `db:"extended_by" json:"extended_by,omitempty"`
+	ExtendedByName *string    `db:"extended_by_name" json:"extended_by_name,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+}
+
+// NextTerm returns the term that follows year/season: spring is followed by
+// fall of the same year, and fall by spring of the next one.
+func NextTerm(year int, season string) (int, string) {
+	if season == "spring" {
+		return year, "fall"
+	}
+	return year + 1, "spring"
+}
+
+func (e *ExtensionDB) listExtensions(where squirrel.Sqlizer) ([]Extension, error) {
+	query, args, err := QB.Select(
+		"e.*",
+		"pp.name AS project_name",
+		"u.name AS extended_by_name",
+	).
+		From("project_extensions e").
+		Join("pre_project pp ON pp.id = e.pre_project_id").
+		LeftJoin("users u ON u.id = e.extended_by").
+		Where(where).
+		OrderBy("e.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	extensions := []Extension{}
+	if err := e.db.Select(&extensions, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list extensions: %w", err)
+	}
+	return extensions, nil
+}
+
+func (e *ExtensionDB) ListExtensions(preProjectID uuid.UUID) ([]Extension, error) {
+	return e.listExtensions(squirrel.Eq{"e.pre_project_id": preProjectID})
+}
+
+// ListTermExtensions returns the projects carried over out of a term.
+func (e *ExtensionDB) ListTermExtensions(year int, season string) ([]Extension, error) {
+	return e.listExtensions(squirrel.Eq{"e.from_year": year, "e.from_season": season})
+}
+
+// ExtendPreProject moves an active project to the term after its current
+// one and records the move. Advisors, students, versions, milestones and
+// score sheets stay attached to the project; the milestones of the new term
+// are added next to the existing ones. A project is extended at most
+// maxExtensions times, and only once the defense period of its term is over
+// without it being defended or graded; a defense still scheduled for it is
+// cancelled.
+func (e *ExtensionDB) ExtendPreProject(extension *Extension, maxExtensions int) error {
+	tx, err := e.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var project struct {
+		Year       int    `db:"year"`
+		Season     string `db:"season"`
+		Degree     *int   `db:"degree"`
+		Extensions int    `db:"extensions"`
+		Defended   bool   `db:"defended"`
+		TooEarly   bool   `db:"too_early"`
+	}
+	err = tx.Get(&project, fmt.Sprintf(`
+        SELECT year, season, degree,
+               (SELECT COUNT(*) FROM project_extensions e WHERE e.pre_project_id = pp.id) AS extensions,
+               EXISTS (SELECT 1 FROM defenses d WHERE d.pre_project_id = pp.id AND d.starts_at <= $2) AS defended,
+               EXISTS (SELECT 1 FROM academic_terms t
+                       WHERE t.year = pp.year AND t.season = pp.season AND $2 < %s) AS too_early
+        FROM pre_project pp
+        WHERE pp.id = $1 AND `+preProjectActive("pp")+`
+        FOR UPDATE`, defensePeriodEnd("t.defense_end")), extension.PreProjectID, now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to lock pre-project: %w", err)
+	}
+	switch {
+	case project.Degree != nil:
+		return ErrExtensionGraded
+	case project.Defended:
+		return ErrExtensionDefended
+	case project.TooEarly:
+		return ErrExtensionTooEarly
+	case project.Extensions >= maxExtensions:
+		return ErrExtensionLimit
+	}
+
+	extension.FromYear, extension.FromSeason = project.Year, project.Season
+	extension.ToYear, extension.ToSeason = NextTerm(project.Year, project.Season)
+
+	query, args, err := QB.Insert("project_extensions").
+		Columns("pre_project_id", "from_year", "from_season", "to_year", "to_season", "reason", "extended_by").
+		Values(extension.PreProjectID, extension.FromYear, extension.FromSeason,
+			extension.ToYear, extension.ToSeason, extension.Reason, extension.ExtendedBy).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(extension); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to insert extension: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE pre_project SET year = $1, season = $2, updated_at = $3 WHERE id = $4",
+		extension.ToYear, extension.ToSeason, now, extension.PreProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to move pre-project: %w", err)
+	}
+
+	// The defense has to be scheduled again within the new term
+	if _, err := tx.Exec("DELETE FROM defenses WHERE pre_project_id = $1", extension.PreProjectID); err != nil {
+		return fmt.Errorf("failed to cancel defense: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO project_milestones (pre_project_id, template_id, title, description, due_date, position)
+        SELECT $1, t.id, t.title, t.description, t.due_date, t.position
+        FROM milestone_templates t
+        WHERE t.year = $2 AND t.season = $3
+          AND EXISTS (SELECT 1 FROM pre_project pp WHERE pp.id = $1 AND pp.accepted_advisor IS NOT NULL)
+        ON CONFLICT (pre_project_id, template_id) DO NOTHING`,
+		extension.PreProjectID, extension.ToYear, extension.ToSeason)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate milestones: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var ErrInvalidLink = errors.New("الرابط غير صالح أو انتهت صلاحيته")
+
+type ExternalExaminerDB struct {
+	db *sqlx.DB
+}
+
+type ExternalExaminer struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	Name        string     `db:"name" json:"name"`
+	Email       string     `db:"email" json:"email"`
+	Affiliation *string    `db:"affiliation" json:"affiliation,omitempty"`
+	CreatedBy   *uuid.UUID `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// ExternalExaminerLink grants one examiner access to one project until it
+// expires or is revoked. Token is only set right after creation.
+type ExternalExaminerLink struct {
+	ID           uuid.UUID  `db:"id" json:"id"`
+	ExaminerID   uuid.UUID  `db:"examiner_id" json:"examiner_id"`
+	PreProjectID uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	ExpiresAt    time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt    *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	LastUsedAt   *time.Time `db:"last_used_at" json:"last_used_at,omitempty"`
+	CreatedBy    *uuid.UUID `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	Token        string     `db:"-" json:"-"`
+}
+
+func ValidateExternalExaminer(v *validator.Validator, examiner *ExternalExaminer) {
+	v.Check(len(examiner.Name) >= 3, "name", "يجب أن يكون الاسم على الأقل 3 أحرف")
+	v.Check(len(examiner.Name) <= 255, "name", "يجب أن يكون الاسم أقل من 255 حرف")
+	v.Check(validator.Matches(examiner.Email, validator.GeneralEmailRX), "email", "تنسيق البريد الإلكتروني غير صالح")
+	if examiner.Affiliation != nil {
+		v.Check(len(*examiner.Affiliation) <= 255, "affiliation", "اسم الجهة طويل جداً")
+	}
+}
+
+// HashLinkToken is how link tokens are stored and looked up.
+func HashLinkToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func (e *ExternalExaminerDB) InsertExaminer(examiner *ExternalExaminer) error {
+	examiner.Email = strings.ToLower(strings.TrimSpace(examiner.Email))
+	query, args, err := QB.Insert("external_examiners").
+		Columns("name", "email", "affiliation", "created_by").
+		Values(examiner.Name, examiner.Email, examiner.Affiliation, examiner.CreatedBy).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := e.db.QueryRowx(query, args...).StructScan(examiner); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrEmailAlreadyInserted
+		}
+		return fmt.Errorf("failed to insert external examiner: %w", err)
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) UpdateExaminer(examiner *ExternalExaminer) error {
+	examiner.Email = strings.ToLower(strings.TrimSpace(examiner.Email))
+	examiner.UpdatedAt = time.Now()
+	_, err := e.db.Exec("UPDATE external_examiners SET name = $1, email = $2, affiliation = $3, updated_at = $4 WHERE id = $5",
+		examiner.Name, examiner.Email, examiner.Affiliation, examiner.UpdatedAt, examiner.ID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrEmailAlreadyInserted
+		}
+		return fmt.Errorf("failed to update external examiner: %w", err)
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) DeleteExaminer(examinerID uuid.UUID) error {
+	result, err := e.db.Exec("DELETE FROM external_examiners WHERE id = $1", examinerID)
+	if err != nil {
+		return fmt.Errorf("failed to delete external examiner: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) GetExaminer(examinerID uuid.UUID) (*ExternalExaminer, error) {
+	var examiner ExternalExaminer
+	if err := e.db.Get(&examiner, "SELECT * FROM external_examiners WHERE id = $1", examinerID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get external examiner: %w", err)
+	}
+	return &examiner, nil
+}
+
+// ListExaminers returns every external examiner, filtered by a name, email
+// or affiliation fragment when search is set.
+func (e *ExternalExaminerDB) ListExaminers(search string) ([]ExternalExaminer, error) {
+	builder := QB.Select("*").From("external_examiners").OrderBy("name ASC")
+	if search != "" {
+		pattern := "%" + search + "%"
+		builder = builder.Where(squirrel.Or{
+			squirrel.ILike{"name": pattern},
+			squirrel.ILike{"email": pattern},
+			squirrel.ILike{"affiliation": pattern},
+		})
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	examiners := []ExternalExaminer{}
+	if err := e.db.Select(&examiners, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list external examiners: %w", err)
+	}
+	return examiners, nil
+}
+
+// assignExaminer links an examiner to a project or a book through one of
+// the *_external_discussants tables. The project or book is locked while its
+// teacher and external discussants are counted, so the examiner is only added
+// while a discussant seat is free.
+func (e *ExternalExaminerDB) assignExaminer(parent, discussants, table, column string, id, examinerID uuid.UUID) error {
+	tx, err := e.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var seats int
+	err = tx.Get(&seats, fmt.Sprintf(`
+        SELECT (SELECT COUNT(*) FROM %[2]s WHERE %[4]s = p.id)
+             + (SELECT COUNT(*) FROM %[3]s WHERE %[4]s = p.id)
+        FROM %[1]s p
+        WHERE p.id = $1
+        FOR UPDATE`, parent, discussants, table, column), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to count discussants: %w", err)
+	}
+	if seats >= maxDiscussants {
+		return ErrDiscussantLimit
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s, examiner_id) VALUES ($1, $2)", table, column), id, examinerID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return ErrDuplicatedKey
+			case "23503":
+				return ErrRecordNotFound
+			}
+		}
+		return fmt.Errorf("failed to assign external examiner: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) unassignExaminer(table, column string, id, examinerID uuid.UUID) error {
+	result, err := e.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = $1 AND examiner_id = $2", table, column), id, examinerID)
+	if err != nil {
+		return fmt.Errorf("failed to remove external examiner: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) AssignToPreProject(preProjectID, examinerID uuid.UUID) error {
+	return e.assignExaminer("pre_project", "pre_project_discussants", "pre_project_external_discussants", "pre_project_id", preProjectID, examinerID)
+}
+
+// UnassignFromPreProject removes the examiner from the project and revokes
+// the links that gave them access to it.
+func (e *ExternalExaminerDB) UnassignFromPreProject(preProjectID, examinerID uuid.UUID) error {
+	if err := e.unassignExaminer("pre_project_external_discussants", "pre_project_id", preProjectID, examinerID); err != nil {
+		return err
+	}
+	return e.RevokeLinks(preProjectID, examinerID)
+}
+
+func (e *ExternalExaminerDB) AssignToBook(bookID, examinerID uuid.UUID) error {
+	return e.assignExaminer("book", "book_discussants", "book_external_discussants", "book_id", bookID, examinerID)
+}
+
+func (e *ExternalExaminerDB) UnassignFromBook(bookID, examinerID uuid.UUID) error {
+	return e.unassignExaminer("book_external_discussants", "book_id", bookID, examinerID)
+}
+
+// InsertLink stores a new access link; only the hash of link.Token is kept.
+func (e *ExternalExaminerDB) InsertLink(link *ExternalExaminerLink) error {
+	var assigned bool
+	err := e.db.Get(&assigned, `
+        SELECT EXISTS (
+            SELECT 1 FROM pre_project_external_discussants
+            WHERE pre_project_id = $1 AND examiner_id = $2
+        )`, link.PreProjectID, link.ExaminerID)
+	if err != nil {
+		return fmt.Errorf("failed to check assignment: %w", err)
+	}
+	if !assigned {
+		return ErrRecordNotFound
+	}
+
+	query, args, err := QB.Insert("external_examiner_links").
+		Columns("examiner_id", "pre_project_id", "token_hash", "expires_at", "created_by").
+		Values(link.ExaminerID, link.PreProjectID, HashLinkToken(link.Token), link.ExpiresAt, link.CreatedBy).
+		Suffix("RETURNING id, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := e.db.QueryRowx(query, args...).StructScan(link); err != nil {
+		return fmt.Errorf("failed to insert link: %w", err)
+	}
+	return nil
+}
+
+func (e *ExternalExaminerDB) ListLinks(preProjectID, examinerID uuid.UUID) ([]ExternalExaminerLink, error) {
+	links := []ExternalExaminerLink{}
+	err := e.db.Select(&links, `
+        SELECT id, examiner_id, pre_project_id, expires_at, revoked_at, last_used_at, created_by, created_at
+        FROM external_examiner_links
+        WHERE pre_project_id = $1 AND examiner_id = $2
+        ORDER BY created_at DESC`, preProjectID, examinerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+	return links, nil
+}
+
+func (e *ExternalExaminerDB) RevokeLinks(preProjectID, examinerID uuid.UUID) error {
+	_, err := e.db.Exec(`
+        UPDATE external_examiner_links SET revoked_at = $1
+        WHERE pre_project_id = $2 AND examiner_id = $3 AND revoked_at IS NULL`,
+		time.Now(), preProjectID, examinerID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke links: %w", err)
+	}
+	return nil
+}
+
+// ResolveLink returns the link a token belongs to when it is still valid:
+// not expired, not revoked, and the examiner is still assigned to an active
+// project. Every other case is ErrInvalidLink.
+func (e *ExternalExaminerDB) ResolveLink(token string, now time.Time) (*ExternalExaminerLink, error) {
+	var link ExternalExaminerLink
+	err := e.db.Get(&link, `
+        UPDATE external_examiner_links l
+        SET last_used_at = $2
+        FROM pre_project_external_discussants d, pre_project pp
+        WHERE l.token_hash = $1 AND l.revoked_at IS NULL AND l.expires_at > $2
+          AND d.pre_project_id = l.pre_project_id AND d.examiner_id = l.examiner_id
+          AND pp.id = l.pre_project_id AND `+preProjectActive("pp")+`
+        RETURNING l.id, l.examiner_id, l.pre_project_id, l.expires_at, l.revoked_at, l.last_used_at, l.created_by, l.created_at`,
+		HashLinkToken(token), now)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidLink
+		}
+		return nil, fmt.Errorf("failed to resolve link: %w", err)
+	}
+	return &link, nil
+}
+
+// externalDiscussants loads the external examiners of a project or a book.
+func externalDiscussants(q sqlx.Queryer, table, column string, id uuid.UUID) ([]ExternalExaminer, error) {
+	examiners := []ExternalExaminer{}
+	err := sqlx.Select(q, &examiners, fmt.Sprintf(`
+        SELECT x.*
+        FROM %s d
+        JOIN external_examiners x ON x.id = d.examiner_id
+        WHERE d.%s = $1
+        ORDER BY x.name`, table, column), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load external examiners: %w", err)
+	}
+	return examiners, nil
+}
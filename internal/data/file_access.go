@@ -0,0 +1,192 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	FileAccessPublic        = "public"
+	FileAccessAuthenticated = "authenticated"
+	FileAccessMembers       = "members"
+)
+
+// FileAccessLevels are the policies a book can give its file; the other
+// uploads have theirs fixed by the resource they belong to.
+var FileAccessLevels = []string{FileAccessPublic, FileAccessAuthenticated, FileAccessMembers}
+
+type FileAccessDB struct {
+	db *sqlx.DB
+}
+
+// FilePolicy is who may download an uploaded file. Members are the users
+// of the resource it belongs to; administrators may download any file.
+type FilePolicy struct {
+	Access       string
+	EmbargoUntil *time.Time
+	Members      []uuid.UUID
+}
+
+// Embargoed reports whether the file is still held back at now.
+func (p *FilePolicy) Embargoed(now time.Time) bool {
+	return p.EmbargoUntil != nil && now.Before(*p.EmbargoUntil)
+}
+
+func (p *FilePolicy) IsMember(userID uuid.UUID) bool {
+	for _, member := range p.Members {
+		if member == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether a user, uuid.Nil when signed out, may download
+// the file at now.
+func (p *FilePolicy) Allows(userID uuid.UUID, isAdmin bool, now time.Time) bool {
+	if isAdmin {
+		return true
+	}
+	if p.Embargoed(now) {
+		return userID != uuid.Nil && p.IsMember(userID)
+	}
+	switch p.Access {
+	case FileAccessPublic:
+		return true
+	case FileAccessAuthenticated:
+		return userID != uuid.Nil
+	default:
+		return userID != uuid.Nil && p.IsMember(userID)
+	}
+}
+
+const (
+	bookMembersQuery = `
+		SELECT student_id FROM book_students WHERE book_id = $1
+		UNION SELECT advisor_id FROM book_advisors WHERE book_id = $1
+		UNION SELECT discussant_id FROM book_discussants WHERE book_id = $1`
+
+	// Everyone working on or examining a pre-project, including the
+	// advisors it was sent to who have not answered yet
+	preProjectMembersQuery = `
+		SELECT project_owner FROM pre_project WHERE id = $1
+		UNION SELECT accepted_advisor FROM pre_project WHERE id = $1 AND accepted_advisor IS NOT NULL
+		UNION SELECT student_id FROM pre_project_students WHERE pre_project_id = $1
+		UNION SELECT discussant_id FROM pre_project_discussants WHERE pre_project_id = $1
+		UNION SELECT advisor_id FROM advisor_responses WHERE pre_project_id = $1
+		UNION SELECT dc.user_id FROM defense_committee dc
+			JOIN defenses d ON d.id = dc.defense_id
+			WHERE d.pre_project_id = $1`
+)
+
+// GetFilePolicy finds the resource an upload belongs to from its stored
+// path, such as uploads/books/books_1700000000_42.pdf, and returns who may
+// download it. Files no resource refers to are not found.
+func (f *FileAccessDB) GetFilePolicy(file string) (*FilePolicy, error) {
+	parts := strings.SplitN(file, "/", 3)
+	if len(parts) != 3 || parts[0] != "uploads" {
+		return nil, ErrRecordNotFound
+	}
+
+	switch parts[1] {
+	case "users":
+		return &FilePolicy{Access: FileAccessPublic}, nil
+	case "posts":
+		return f.policyOf(file, "SELECT EXISTS (SELECT 1 FROM post WHERE file = $1)")
+	case "books":
+		return f.bookPolicy(file)
+	case "pre_projects":
+		// An archived project's file became its book's
+		policy, err := f.bookPolicy(file)
+		if !errors.Is(err, ErrRecordNotFound) {
+			return policy, err
+		}
+		return f.preProjectPolicy(file, `
+			SELECT id FROM pre_project WHERE file = $1
+			UNION SELECT pre_project_id FROM pre_project_versions WHERE file = $1
+			LIMIT 1`)
+	case "progress_reports":
+		return f.preProjectPolicy(file, `
+			SELECT pm.pre_project_id FROM progress_reports pr
+			JOIN project_milestones pm ON pm.id = pr.milestone_id
+			WHERE pr.file = $1
+			LIMIT 1`)
+	case "chats":
+		var chat struct {
+			SenderID   uuid.UUID `db:"sender_id"`
+			ReceiverID uuid.UUID `db:"receiver_id"`
+		}
+		if err := f.db.Get(&chat, "SELECT sender_id, receiver_id FROM chats WHERE file = $1 LIMIT 1", file); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrRecordNotFound
+			}
+			return nil, fmt.Errorf("failed to get chat file: %w", err)
+		}
+		return &FilePolicy{Access: FileAccessMembers, Members: []uuid.UUID{chat.SenderID, chat.ReceiverID}}, nil
+	}
+	return nil, ErrRecordNotFound
+}
+
+// policyOf returns a public policy when the exists query finds the file.
+func (f *FileAccessDB) policyOf(file, exists string) (*FilePolicy, error) {
+	var found bool
+	if err := f.db.Get(&found, exists, file); err != nil {
+		return nil, fmt.Errorf("failed to find file: %w", err)
+	}
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return &FilePolicy{Access: FileAccessPublic}, nil
+}
+
+func (f *FileAccessDB) bookPolicy(file string) (*FilePolicy, error) {
+	var book struct {
+		ID           uuid.UUID  `db:"id"`
+		FileAccess   string     `db:"file_access"`
+		EmbargoUntil *time.Time `db:"embargo_until"`
+	}
+	if err := f.db.Get(&book, "SELECT id, file_access, embargo_until FROM book WHERE file = $1 LIMIT 1", file); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get book file: %w", err)
+	}
+	return f.GetBookFilePolicy(book.ID, book.FileAccess, book.EmbargoUntil)
+}
+
+// GetBookFilePolicy returns who may download the file of a book with the
+// given access and embargo.
+func (f *FileAccessDB) GetBookFilePolicy(bookID uuid.UUID, access string, embargoUntil *time.Time) (*FilePolicy, error) {
+	policy := &FilePolicy{Access: access, EmbargoUntil: embargoUntil}
+	if access == FileAccessPublic && embargoUntil == nil {
+		return policy, nil
+	}
+	if err := f.db.Select(&policy.Members, bookMembersQuery, bookID); err != nil {
+		return nil, fmt.Errorf("failed to get book members: %w", err)
+	}
+	return policy, nil
+}
+
+// preProjectPolicy returns a members-only policy for the pre-project the
+// lookup query finds the file in.
+func (f *FileAccessDB) preProjectPolicy(file, lookup string) (*FilePolicy, error) {
+	var preProjectID uuid.UUID
+	if err := f.db.Get(&preProjectID, lookup, file); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to find pre-project file: %w", err)
+	}
+
+	policy := &FilePolicy{Access: FileAccessMembers}
+	if err := f.db.Select(&policy.Members, preProjectMembersQuery, preProjectID); err != nil {
+		return nil, fmt.Errorf("failed to get pre-project members: %w", err)
+	}
+	return policy, nil
+}
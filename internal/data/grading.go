@@ -0,0 +1,603 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"project/utils/validator"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	AggregationWeightedAverage = "weighted_average"
+	AggregationDropOutlier     = "drop_outlier"
+	AggregationAdvisorShare    = "advisor_share"
+)
+
+var (
+	ErrSheetLocked     = errors.New("تم تسليم ورقة الدرجات ولا يمكن تعديلها")
+	ErrSheetIncomplete = errors.New("يجب تقييم جميع المعايير قبل التسليم")
+	ErrRubricInUse     = errors.New("لا يمكن تعديل معايير التقييم بعد تسليم أوراق درجات عليها")
+)
+
+type GradingDB struct {
+	db *sqlx.DB
+}
+
+type Rubric struct {
+	ID              uuid.UUID         `db:"id" json:"id"`
+	Year            int               `db:"year" json:"year"`
+	Season          string            `db:"season" json:"season"`
+	Name            string            `db:"name" json:"name"`
+	AggregationRule string            `db:"aggregation_rule" json:"aggregation_rule"`
+	AdvisorShare    float64           `db:"advisor_share" json:"advisor_share"`
+	CreatedAt       time.Time         `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time         `db:"updated_at" json:"updated_at"`
+	Criteria        []RubricCriterion `db:"-" json:"criteria"`
+}
+
+type RubricCriterion struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	RubricID    uuid.UUID `db:"rubric_id" json:"rubric_id"`
+	Name        string    `db:"name" json:"name"`
+	Description *string   `db:"description" json:"description,omitempty"`
+	Weight      float64   `db:"weight" json:"weight"`
+	MaxPoints   int       `db:"max_points" json:"max_points"`
+	Position    int       `db:"position" json:"position"`
+}
+
+type ScoreItem struct {
+	CriterionID uuid.UUID `db:"criterion_id" json:"criterion_id"`
+	Points      float64   `db:"points" json:"points"`
+}
+
+type ScoreSheet struct {
+	ID           uuid.UUID   `db:"id" json:"id"`
+	PreProjectID uuid.UUID   `db:"pre_project_id" json:"pre_project_id"`
+	ExaminerID   uuid.UUID   `db:"examiner_id" json:"examiner_id"`
+	ExaminerName string      `db:"examiner_name" json:"examiner_name"`
+	External     bool        `db:"external" json:"external,omitempty"`
+	Role         string      `db:"role" json:"role"`
+	RubricID     uuid.UUID   `db:"rubric_id" json:"rubric_id"`
+	Comment      *string     `db:"comment" json:"comment,omitempty"`
+	SubmittedAt  *time.Time  `db:"submitted_at" json:"submitted_at,omitempty"`
+	CreatedAt    time.Time   `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time   `db:"updated_at" json:"updated_at"`
+	Items        []ScoreItem `db:"-" json:"items"`
+	Total        *float64    `db:"-" json:"total,omitempty"`
+}
+
+func (s *ScoreSheet) Submitted() bool {
+	return s.SubmittedAt != nil
+}
+
+func ValidateRubric(v *validator.Validator, rubric *Rubric) {
+	v.Check(rubric.Year > 2000, "year", "السنة مطلوبة")
+	v.Check(rubric.Season == "spring" || rubric.Season == "fall", "season", "يجب اختيار موسم ربيع أو خريف")
+	v.Check(rubric.Name != "", "name", "اسم نموذج التقييم مطلوب")
+	v.Check(len(rubric.Name) <= 200, "name", "اسم نموذج التقييم طويل جداً")
+	v.Check(validator.In(rubric.AggregationRule, AggregationWeightedAverage, AggregationDropOutlier, AggregationAdvisorShare),
+		"aggregation_rule", "طريقة احتساب الدرجة غير صالحة")
+	v.Check(rubric.AdvisorShare >= 0 && rubric.AdvisorShare <= 1, "advisor_share", "يجب أن تكون حصة المشرف بين 0 و 1")
+	v.Check(len(rubric.Criteria) > 0, "criteria", "يجب إضافة معيار واحد على الأقل")
+	for _, c := range rubric.Criteria {
+		v.Check(c.Name != "", "criteria", "اسم المعيار مطلوب")
+		v.Check(c.Weight > 0, "criteria", "يجب أن يكون وزن المعيار أكبر من صفر")
+		v.Check(c.MaxPoints > 0, "criteria", "يجب أن تكون الدرجة القصوى للمعيار أكبر من صفر")
+	}
+}
+
+// ValidateScores checks that every item targets a criterion of the rubric and
+// stays within its maximum.
+func ValidateScores(v *validator.Validator, rubric *Rubric, items []ScoreItem) {
+	maxPoints := map[uuid.UUID]int{}
+	for _, c := range rubric.Criteria {
+		maxPoints[c.ID] = c.MaxPoints
+	}
+	for _, item := range items {
+		max, ok := maxPoints[item.CriterionID]
+		if !ok {
+			v.AddError("scores", "المعيار غير موجود في نموذج التقييم")
+			continue
+		}
+		v.Check(item.Points >= 0 && item.Points <= float64(max), "scores",
+			fmt.Sprintf("يجب أن تكون الدرجة بين 0 و %d", max))
+	}
+}
+
+func insertCriteria(tx *sqlx.Tx, rubric *Rubric) error {
+	for i := range rubric.Criteria {
+		c := &rubric.Criteria[i]
+		c.RubricID = rubric.ID
+		c.Position = i
+		query, args, err := QB.Insert("rubric_criteria").
+			Columns("rubric_id", "name", "description", "weight", "max_points", "position").
+			Values(c.RubricID, c.Name, c.Description, c.Weight, c.MaxPoints, c.Position).
+			Suffix("RETURNING id").
+			ToSql()
+		if err != nil {
+			return fmt.Errorf("failed to build query: %w", err)
+		}
+		if err := tx.Get(&c.ID, query, args...); err != nil {
+			return fmt.Errorf("failed to insert criterion: %w", err)
+		}
+	}
+	return nil
+}
+
+func (g *GradingDB) InsertRubric(rubric *Rubric) error {
+	tx, err := g.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Insert("rubrics").
+		Columns("year", "season", "name", "aggregation_rule", "advisor_share").
+		Values(rubric.Year, rubric.Season, rubric.Name, rubric.AggregationRule, rubric.AdvisorShare).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(rubric); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to insert rubric: %w", err)
+	}
+
+	if err := insertCriteria(tx, rubric); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateRubric replaces the rubric settings and criteria. It is refused once
+// any examiner has submitted a sheet against it; draft scores are discarded.
+func (g *GradingDB) UpdateRubric(rubric *Rubric) error {
+	tx, err := g.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var submitted bool
+	err = tx.Get(&submitted, "SELECT EXISTS (SELECT 1 FROM score_sheets WHERE rubric_id = $1 AND submitted_at IS NOT NULL)", rubric.ID)
+	if err != nil {
+		return fmt.Errorf("failed to check rubric usage: %w", err)
+	}
+	if submitted {
+		return ErrRubricInUse
+	}
+
+	query, args, err := QB.Update("rubrics").
+		Set("name", rubric.Name).
+		Set("aggregation_rule", rubric.AggregationRule).
+		Set("advisor_share", rubric.AdvisorShare).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": rubric.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update rubric: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if _, err := tx.Exec("DELETE FROM rubric_criteria WHERE rubric_id = $1", rubric.ID); err != nil {
+		return fmt.Errorf("failed to remove old criteria: %w", err)
+	}
+	if err := insertCriteria(tx, rubric); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (g *GradingDB) DeleteRubric(rubricID uuid.UUID) error {
+	result, err := g.db.Exec("DELETE FROM rubrics WHERE id = $1", rubricID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrRubricInUse
+		}
+		return fmt.Errorf("failed to delete rubric: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (g *GradingDB) getRubric(where squirrel.Eq) (*Rubric, error) {
+	query, args, err := QB.Select("*").From("rubrics").Where(where).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var rubric Rubric
+	if err := g.db.Get(&rubric, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get rubric: %w", err)
+	}
+
+	rubric.Criteria = []RubricCriterion{}
+	err = g.db.Select(&rubric.Criteria, "SELECT * FROM rubric_criteria WHERE rubric_id = $1 ORDER BY position", rubric.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load criteria: %w", err)
+	}
+	return &rubric, nil
+}
+
+func (g *GradingDB) GetRubric(rubricID uuid.UUID) (*Rubric, error) {
+	return g.getRubric(squirrel.Eq{"id": rubricID})
+}
+
+// GetRubricForTerm returns the rubric used to grade projects of a term.
+func (g *GradingDB) GetRubricForTerm(year int, season string) (*Rubric, error) {
+	return g.getRubric(squirrel.Eq{"year": year, "season": season})
+}
+
+func (g *GradingDB) ListRubrics() ([]Rubric, error) {
+	rubrics := []Rubric{}
+	if err := g.db.Select(&rubrics, "SELECT * FROM rubrics ORDER BY year DESC, season"); err != nil {
+		return nil, fmt.Errorf("failed to list rubrics: %w", err)
+	}
+	for i := range rubrics {
+		rubrics[i].Criteria = []RubricCriterion{}
+		err := g.db.Select(&rubrics[i].Criteria, "SELECT * FROM rubric_criteria WHERE rubric_id = $1 ORDER BY position", rubrics[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load criteria: %w", err)
+		}
+	}
+	return rubrics, nil
+}
+
+// Sheets of external examiners report their examiner id in examiner_id too,
+// so callers can treat both kinds alike.
+var scoreSheetColumns = []string{
+	"s.id",
+	"s.pre_project_id",
+	"COALESCE(s.examiner_id, s.external_examiner_id) AS examiner_id",
+	"s.role",
+	"s.rubric_id",
+	"s.comment",
+	"s.submitted_at",
+	"s.created_at",
+	"s.updated_at",
+	"COALESCE(u.name, x.name, '') AS examiner_name",
+	"s.external_examiner_id IS NOT NULL AS external",
+}
+
+func (g *GradingDB) loadItems(sheets []ScoreSheet) error {
+	for i := range sheets {
+		sheets[i].Items = []ScoreItem{}
+		err := g.db.Select(&sheets[i].Items, "SELECT criterion_id, points FROM score_sheet_items WHERE sheet_id = $1", sheets[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to load score items: %w", err)
+		}
+	}
+	return nil
+}
+
+func sheetExaminerColumn(external bool) string {
+	if external {
+		return "external_examiner_id"
+	}
+	return "examiner_id"
+}
+
+// SheetFor returns the examiner's sheet for a project, creating an empty one
+// against the given rubric the first time. external is set when examinerID
+// is an external examiner rather than a user.
+func (g *GradingDB) SheetFor(preProjectID, examinerID uuid.UUID, role string, rubricID uuid.UUID, external bool) (*ScoreSheet, error) {
+	_, err := g.db.Exec(fmt.Sprintf(`
+        INSERT INTO score_sheets (pre_project_id, %[1]s, role, rubric_id)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (pre_project_id, %[1]s) DO NOTHING`, sheetExaminerColumn(external)),
+		preProjectID, examinerID, role, rubricID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create score sheet: %w", err)
+	}
+	return g.FindSheet(preProjectID, examinerID, external)
+}
+
+// FindSheet returns the examiner's sheet for a project without creating it.
+func (g *GradingDB) FindSheet(preProjectID, examinerID uuid.UUID, external bool) (*ScoreSheet, error) {
+	sheets, err := g.listSheets(squirrel.Eq{"s.pre_project_id": preProjectID, "s." + sheetExaminerColumn(external): examinerID})
+	if err != nil {
+		return nil, err
+	}
+	if len(sheets) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &sheets[0], nil
+}
+
+func (g *GradingDB) GetSheet(sheetID uuid.UUID) (*ScoreSheet, error) {
+	sheets, err := g.listSheets(squirrel.Eq{"s.id": sheetID})
+	if err != nil {
+		return nil, err
+	}
+	if len(sheets) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &sheets[0], nil
+}
+
+func (g *GradingDB) ListSheets(preProjectID uuid.UUID) ([]ScoreSheet, error) {
+	return g.listSheets(squirrel.Eq{"s.pre_project_id": preProjectID})
+}
+
+func (g *GradingDB) listSheets(where squirrel.Eq) ([]ScoreSheet, error) {
+	query, args, err := QB.Select(scoreSheetColumns...).
+		From("score_sheets s").
+		LeftJoin("users u ON u.id = s.examiner_id").
+		LeftJoin("external_examiners x ON x.id = s.external_examiner_id").
+		Where(where).
+		OrderBy("s.role ASC", "s.created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	sheets := []ScoreSheet{}
+	if err := g.db.Select(&sheets, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list score sheets: %w", err)
+	}
+	if err := g.loadItems(sheets); err != nil {
+		return nil, err
+	}
+	return sheets, nil
+}
+
+// lockSheet locks the sheet row for the rest of the transaction and fails if
+// it has already been submitted.
+func lockSheet(tx *sqlx.Tx, sheetID uuid.UUID) error {
+	var submittedAt *time.Time
+	err := tx.Get(&submittedAt, "SELECT submitted_at FROM score_sheets WHERE id = $1 FOR UPDATE", sheetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to lock score sheet: %w", err)
+	}
+	if submittedAt != nil {
+		return ErrSheetLocked
+	}
+	return nil
+}
+
+// SaveScores stores draft scores on a sheet that has not been submitted yet.
+func (g *GradingDB) SaveScores(sheetID uuid.UUID, items []ScoreItem, comment *string) error {
+	tx, err := g.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockSheet(tx, sheetID); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		_, err := tx.Exec(`
+            INSERT INTO score_sheet_items (sheet_id, criterion_id, points)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (sheet_id, criterion_id) DO UPDATE SET points = EXCLUDED.points`,
+			sheetID, item.CriterionID, item.Points)
+		if err != nil {
+			return fmt.Errorf("failed to save score: %w", err)
+		}
+	}
+
+	update := QB.Update("score_sheets").Set("updated_at", time.Now()).Where(squirrel.Eq{"id": sheetID})
+	if comment != nil {
+		update = update.Set("comment", comment)
+	}
+	query, args, err := update.ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to update score sheet: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SubmitSheet locks a complete sheet; it can only be reopened by an admin.
+func (g *GradingDB) SubmitSheet(sheetID uuid.UUID) error {
+	tx, err := g.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockSheet(tx, sheetID); err != nil {
+		return err
+	}
+
+	var missing int
+	err = tx.Get(&missing, `
+        SELECT COUNT(*)
+        FROM score_sheets s
+        JOIN rubric_criteria c ON c.rubric_id = s.rubric_id
+        LEFT JOIN score_sheet_items i ON i.sheet_id = s.id AND i.criterion_id = c.id
+        WHERE s.id = $1 AND i.criterion_id IS NULL`, sheetID)
+	if err != nil {
+		return fmt.Errorf("failed to check sheet completeness: %w", err)
+	}
+	if missing > 0 {
+		return ErrSheetIncomplete
+	}
+
+	if _, err := tx.Exec("UPDATE score_sheets SET submitted_at = $1, updated_at = $1 WHERE id = $2", time.Now(), sheetID); err != nil {
+		return fmt.Errorf("failed to submit score sheet: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ReopenSheet unlocks a submitted sheet and clears the aggregated degree of
+// its project, which is recomputed when the sheet is submitted again.
+func (g *GradingDB) ReopenSheet(sheetID uuid.UUID) error {
+	tx, err := g.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var preProjectID uuid.UUID
+	err = tx.Get(&preProjectID, "UPDATE score_sheets SET submitted_at = NULL, updated_at = $1 WHERE id = $2 RETURNING pre_project_id", time.Now(), sheetID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to reopen score sheet: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE pre_project SET degree = NULL WHERE id = $1", preProjectID); err != nil {
+		return fmt.Errorf("failed to clear degree: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SetDegree stores the aggregated degree on the pre-project.
+func (g *GradingDB) SetDegree(preProjectID uuid.UUID, degree int) error {
+	_, err := g.db.Exec("UPDATE pre_project SET degree = $1, updated_at = $2 WHERE id = $3", degree, time.Now(), preProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to set degree: %w", err)
+	}
+	return nil
+}
+
+// SheetTotal scores a sheet out of 100: each criterion contributes its
+// points/max ratio in proportion to its weight.
+func SheetTotal(rubric *Rubric, sheet *ScoreSheet) float64 {
+	points := map[uuid.UUID]float64{}
+	for _, item := range sheet.Items {
+		points[item.CriterionID] = item.Points
+	}
+
+	var total, weights float64
+	for _, c := range rubric.Criteria {
+		total += points[c.ID] / float64(c.MaxPoints) * c.Weight
+		weights += c.Weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return total / weights * 100
+}
+
+// AggregateDegree combines the totals of submitted sheets into the final
+// degree according to the rubric's aggregation rule:
+//   - weighted_average: every examiner counts equally
+//   - drop_outlier: with three or more examiners, the total furthest from
+//     the median is ignored before averaging
+//   - advisor_share: the advisor's total weighs advisor_share, the mean of
+//     the discussants the rest
+func AggregateDegree(rubric *Rubric, sheets []ScoreSheet) int {
+	var advisor []float64
+	var discussants []float64
+	var all []float64
+	for i := range sheets {
+		total := SheetTotal(rubric, &sheets[i])
+		all = append(all, total)
+		if sheets[i].Role == "advisor" {
+			advisor = append(advisor, total)
+		} else {
+			discussants = append(discussants, total)
+		}
+	}
+	if len(all) == 0 {
+		return 0
+	}
+
+	var degree float64
+	switch rubric.AggregationRule {
+	case AggregationDropOutlier:
+		degree = mean(dropOutlier(all))
+	case AggregationAdvisorShare:
+		switch {
+		case len(advisor) == 0:
+			degree = mean(discussants)
+		case len(discussants) == 0:
+			degree = mean(advisor)
+		default:
+			degree = mean(advisor)*rubric.AdvisorShare + mean(discussants)*(1-rubric.AdvisorShare)
+		}
+	default:
+		degree = mean(all)
+	}
+	return int(math.Round(degree))
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func dropOutlier(values []float64) []float64 {
+	if len(values) < 3 {
+		return values
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+
+	outlier := 0
+	for i, v := range sorted {
+		if math.Abs(v-median) > math.Abs(sorted[outlier]-median) {
+			outlier = i
+		}
+	}
+	return append(sorted[:outlier:outlier], sorted[outlier+1:]...)
+}
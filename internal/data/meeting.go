@@ -0,0 +1,377 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type MeetingDB struct {
+	db *sqlx.DB
+}
+
+type Meeting struct {
+	ID           uuid.UUID            `db:"id" json:"id"`
+	PreProjectID uuid.UUID            `db:"pre_project_id" json:"pre_project_id"`
+	ProjectName  string               `db:"project_name" json:"project_name"`
+	HeldAt       time.Time            `db:"held_at" json:"held_at"`
+	Agenda       string               `db:"agenda" json:"agenda"`
+	Minutes      *string              `db:"minutes" json:"minutes,omitempty"`
+	CreatedBy    *uuid.UUID           `db:"created_by" json:"created_by,omitempty"`
+	CreatedAt    time.Time            `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time            `db:"updated_at" json:"updated_at"`
+	Participants []MeetingParticipant `db:"-" json:"participants"`
+	ActionItems  []ActionItem         `db:"-" json:"action_items"`
+}
+
+type MeetingParticipant struct {
+	MeetingID      uuid.UUID  `db:"meeting_id" json:"-"`
+	UserID         uuid.UUID  `db:"user_id" json:"user_id"`
+	Name           string     `db:"name" json:"name"`
+	Email          string     `db:"email" json:"email"`
+	Role           string     `db:"role" json:"role"`
+	Attended       bool       `db:"attended" json:"attended"`
+	AcknowledgedAt *time.Time `db:"acknowledged_at" json:"acknowledged_at,omitempty"`
+}
+
+type ActionItem struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	MeetingID   uuid.UUID  `db:"meeting_id" json:"meeting_id"`
+	Description string     `db:"description" json:"description"`
+	OwnerID     *uuid.UUID `db:"owner_id" json:"owner_id,omitempty"`
+	OwnerName   string     `db:"owner_name" json:"owner_name"`
+	DueDate     *time.Time `db:"due_date" json:"due_date,omitempty"`
+	DoneAt      *time.Time `db:"done_at" json:"done_at,omitempty"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+}
+
+// ValidateMeeting checks the meeting content; team lists the users that may
+// attend or own action items (the students and the accepted advisor).
+func ValidateMeeting(v *validator.Validator, meeting *Meeting, team []uuid.UUID) {
+	v.Check(!meeting.HeldAt.IsZero(), "held_at", "تاريخ الاجتماع مطلوب")
+	v.Check(meeting.Agenda != "", "agenda", "جدول أعمال الاجتماع مطلوب")
+	v.Check(len(meeting.Agenda) <= 5000, "agenda", "جدول الأعمال طويل جداً")
+	if meeting.Minutes != nil {
+		v.Check(len(*meeting.Minutes) <= 20000, "minutes", "محضر الاجتماع طويل جداً")
+	}
+	for _, item := range meeting.ActionItems {
+		v.Check(item.Description != "", "action_items", "وصف المهمة مطلوب")
+		if item.OwnerID != nil {
+			v.Check(validator.InUUID(*item.OwnerID, team), "action_items", "يجب أن يكون المسؤول عن المهمة من فريق المشروع")
+		}
+	}
+	for _, p := range meeting.Participants {
+		v.Check(validator.InUUID(p.UserID, team), "attendees", "يجب أن يكون الحضور من فريق المشروع")
+	}
+}
+
+func insertParticipants(tx *sqlx.Tx, meeting *Meeting, acknowledged map[uuid.UUID]*time.Time) error {
+	for _, p := range meeting.Participants {
+		_, err := tx.Exec(`
+            INSERT INTO meeting_participants (meeting_id, user_id, role, attended, acknowledged_at)
+            VALUES ($1, $2, $3, $4, $5)`,
+			meeting.ID, p.UserID, p.Role, p.Attended, acknowledged[p.UserID])
+		if err != nil {
+			return fmt.Errorf("failed to insert meeting participant: %w", err)
+		}
+	}
+	return nil
+}
+
+func (m *MeetingDB) InsertMeeting(meeting *Meeting) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Insert("supervision_meetings").
+		Columns("pre_project_id", "held_at", "agenda", "minutes", "created_by").
+		Values(meeting.PreProjectID, meeting.HeldAt, meeting.Agenda, meeting.Minutes, meeting.CreatedBy).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(meeting); err != nil {
+		return fmt.Errorf("failed to insert meeting: %w", err)
+	}
+
+	if err := insertParticipants(tx, meeting, nil); err != nil {
+		return err
+	}
+	for i := range meeting.ActionItems {
+		item := &meeting.ActionItems[i]
+		err := tx.Get(&item.ID, `
+            INSERT INTO meeting_action_items (meeting_id, description, owner_id, due_date)
+            VALUES ($1, $2, $3, $4) RETURNING id`,
+			meeting.ID, item.Description, item.OwnerID, item.DueDate)
+		if err != nil {
+			return fmt.Errorf("failed to insert action item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateMeeting rewrites a meeting. Action items sent with an id are kept
+// (with their done state), the others are added and missing ones removed.
+// Students have to acknowledge again when the agenda or minutes change.
+func (m *MeetingDB) UpdateMeeting(meeting *Meeting, contentChanged bool) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Update("supervision_meetings").
+		Set("held_at", meeting.HeldAt).
+		Set("agenda", meeting.Agenda).
+		Set("minutes", meeting.Minutes).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": meeting.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update meeting: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	acknowledged := map[uuid.UUID]*time.Time{}
+	if !contentChanged {
+		var previous []MeetingParticipant
+		err := tx.Select(&previous, "SELECT user_id, acknowledged_at FROM meeting_participants WHERE meeting_id = $1", meeting.ID)
+		if err != nil {
+			return fmt.Errorf("failed to load participants: %w", err)
+		}
+		for _, p := range previous {
+			acknowledged[p.UserID] = p.AcknowledgedAt
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM meeting_participants WHERE meeting_id = $1", meeting.ID); err != nil {
+		return fmt.Errorf("failed to clear participants: %w", err)
+	}
+	if err := insertParticipants(tx, meeting, acknowledged); err != nil {
+		return err
+	}
+
+	keep := []uuid.UUID{}
+	for i := range meeting.ActionItems {
+		item := &meeting.ActionItems[i]
+		if item.ID == uuid.Nil {
+			continue
+		}
+		result, err := tx.Exec(`
+            UPDATE meeting_action_items SET description = $1, owner_id = $2, due_date = $3
+            WHERE id = $4 AND meeting_id = $5`,
+			item.Description, item.OwnerID, item.DueDate, item.ID, meeting.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update action item: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			item.ID = uuid.Nil
+			continue
+		}
+		keep = append(keep, item.ID)
+	}
+	deleteQuery, deleteArgs, err := QB.Delete("meeting_action_items").
+		Where(squirrel.Eq{"meeting_id": meeting.ID}).
+		Where(squirrel.NotEq{"id": keep}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if _, err := tx.Exec(deleteQuery, deleteArgs...); err != nil {
+		return fmt.Errorf("failed to remove action items: %w", err)
+	}
+	for i := range meeting.ActionItems {
+		item := &meeting.ActionItems[i]
+		if item.ID != uuid.Nil {
+			continue
+		}
+		err := tx.Get(&item.ID, `
+            INSERT INTO meeting_action_items (meeting_id, description, owner_id, due_date)
+            VALUES ($1, $2, $3, $4) RETURNING id`,
+			meeting.ID, item.Description, item.OwnerID, item.DueDate)
+		if err != nil {
+			return fmt.Errorf("failed to insert action item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *MeetingDB) DeleteMeeting(meetingID uuid.UUID) error {
+	result, err := m.db.Exec("DELETE FROM supervision_meetings WHERE id = $1", meetingID)
+	if err != nil {
+		return fmt.Errorf("failed to delete meeting: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// Acknowledge records that a student has read and agrees with the minutes.
+func (m *MeetingDB) Acknowledge(meetingID, studentID uuid.UUID) error {
+	result, err := m.db.Exec(`
+        UPDATE meeting_participants SET acknowledged_at = $1
+        WHERE meeting_id = $2 AND user_id = $3 AND role = 'student'`,
+		time.Now(), meetingID, studentID)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge meeting: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m *MeetingDB) GetActionItem(itemID uuid.UUID) (*ActionItem, error) {
+	var item ActionItem
+	err := m.db.Get(&item, `
+        SELECT ai.*, COALESCE(u.name, '') AS owner_name
+        FROM meeting_action_items ai
+        LEFT JOIN users u ON u.id = ai.owner_id
+        WHERE ai.id = $1`, itemID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get action item: %w", err)
+	}
+	return &item, nil
+}
+
+func (m *MeetingDB) SetActionItemDone(itemID uuid.UUID, done bool) error {
+	var doneAt *time.Time
+	if done {
+		now := time.Now()
+		doneAt = &now
+	}
+	if _, err := m.db.Exec("UPDATE meeting_action_items SET done_at = $1 WHERE id = $2", doneAt, itemID); err != nil {
+		return fmt.Errorf("failed to update action item: %w", err)
+	}
+	return nil
+}
+
+func (m *MeetingDB) GetMeeting(meetingID uuid.UUID) (*Meeting, error) {
+	meetings, err := m.listMeetings(squirrel.Eq{"sm.id": meetingID})
+	if err != nil {
+		return nil, err
+	}
+	if len(meetings) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &meetings[0], nil
+}
+
+func (m *MeetingDB) ListMeetings(preProjectID uuid.UUID) ([]Meeting, error) {
+	return m.listMeetings(squirrel.Eq{"sm.pre_project_id": preProjectID})
+}
+
+// ListAdvisorMeetings returns the meetings of every project the advisor has
+// accepted in a term, optionally narrowed to one project.
+func (m *MeetingDB) ListAdvisorMeetings(advisorID uuid.UUID, year int, season string, preProjectID *uuid.UUID) ([]Meeting, error) {
+	where := squirrel.Eq{"pp.accepted_advisor": advisorID, "pp.year": year, "pp.season": season}
+	if preProjectID != nil {
+		where["sm.pre_project_id"] = *preProjectID
+	}
+	return m.listMeetings(where)
+}
+
+func (m *MeetingDB) listMeetings(where squirrel.Eq) ([]Meeting, error) {
+	query, args, err := QB.Select("sm.*", "pp.name AS project_name").
+		From("supervision_meetings sm").
+		Join("pre_project pp ON pp.id = sm.pre_project_id").
+		Where(where).
+		OrderBy("pp.name ASC", "sm.held_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	meetings := []Meeting{}
+	if err := m.db.Select(&meetings, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list meetings: %w", err)
+	}
+	if len(meetings) == 0 {
+		return meetings, nil
+	}
+
+	ids := make([]uuid.UUID, len(meetings))
+	index := map[uuid.UUID]int{}
+	for i := range meetings {
+		ids[i] = meetings[i].ID
+		index[meetings[i].ID] = i
+		meetings[i].Participants = []MeetingParticipant{}
+		meetings[i].ActionItems = []ActionItem{}
+	}
+
+	participantQuery, participantArgs, err := QB.Select("mp.*", "u.name", "u.email").
+		From("meeting_participants mp").
+		Join("users u ON u.id = mp.user_id").
+		Where(squirrel.Eq{"mp.meeting_id": ids}).
+		OrderBy("mp.role ASC", "u.name ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var participants []MeetingParticipant
+	if err := m.db.Select(&participants, participantQuery, participantArgs...); err != nil {
+		return nil, fmt.Errorf("failed to load participants: %w", err)
+	}
+	for _, p := range participants {
+		i := index[p.MeetingID]
+		meetings[i].Participants = append(meetings[i].Participants, p)
+	}
+
+	itemQuery, itemArgs, err := QB.Select("ai.*", "COALESCE(u.name, '') AS owner_name").
+		From("meeting_action_items ai").
+		LeftJoin("users u ON u.id = ai.owner_id").
+		Where(squirrel.Eq{"ai.meeting_id": ids}).
+		OrderBy("ai.created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var items []ActionItem
+	if err := m.db.Select(&items, itemQuery, itemArgs...); err != nil {
+		return nil, fmt.Errorf("failed to load action items: %w", err)
+	}
+	for _, item := range items {
+		i := index[item.MeetingID]
+		meetings[i].ActionItems = append(meetings[i].ActionItems, item)
+	}
+
+	return meetings, nil
+}
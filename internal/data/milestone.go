@@ -0,0 +1,455 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	MilestonePending          = "pending"
+	MilestoneSubmitted        = "submitted"
+	MilestoneApproved         = "approved"
+	MilestoneChangesRequested = "changes_requested"
+)
+
+type MilestoneDB struct {
+	db *sqlx.DB
+}
+
+type MilestoneTemplate struct {
+	ID          uuid.UUID `db:"id" json:"id"`
+	Year        int       `db:"year" json:"year"`
+	Season      string    `db:"season" json:"season"`
+	Title       string    `db:"title" json:"title"`
+	Description *string   `db:"description" json:"description,omitempty"`
+	DueDate     time.Time `db:"due_date" json:"due_date"`
+	Position    int       `db:"position" json:"position"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type Milestone struct {
+	ID           uuid.UUID        `db:"id" json:"id"`
+	PreProjectID uuid.UUID        `db:"pre_project_id" json:"pre_project_id"`
+	TemplateID   *uuid.UUID       `db:"template_id" json:"template_id,omitempty"`
+	Title        string           `db:"title" json:"title"`
+	Description  *string          `db:"description" json:"description,omitempty"`
+	DueDate      time.Time        `db:"due_date" json:"due_date"`
+	Position     int              `db:"position" json:"position"`
+	Status       string           `db:"status" json:"status"`
+	CompletedAt  *time.Time       `db:"completed_at" json:"completed_at,omitempty"`
+	CreatedAt    time.Time        `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time        `db:"updated_at" json:"updated_at"`
+	Overdue      bool             `db:"-" json:"overdue"`
+	Reports      []ProgressReport `db:"-" json:"reports,omitempty"`
+}
+
+type ProgressReport struct {
+	ID              uuid.UUID  `db:"id" json:"id"`
+	MilestoneID     uuid.UUID  `db:"milestone_id" json:"milestone_id"`
+	SubmittedBy     *uuid.UUID `db:"submitted_by" json:"submitted_by,omitempty"`
+	SubmittedByName string     `db:"submitted_by_name" json:"submitted_by_name"`
+	Content         string     `db:"content" json:"content"`
+	File            *string    `db:"file" json:"file,omitempty"`
+	Status          string     `db:"status" json:"status"`
+	AdvisorComment  *string    `db:"advisor_comment" json:"advisor_comment,omitempty"`
+	ReviewedBy      *uuid.UUID `db:"reviewed_by" json:"reviewed_by,omitempty"`
+	ReviewedAt      *time.Time `db:"reviewed_at" json:"reviewed_at,omitempty"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+}
+
+// OverdueMilestone is a milestone past its due date on one of an advisor's projects.
+type OverdueMilestone struct {
+	Milestone
+	ProjectName string `db:"project_name" json:"project_name"`
+	DaysOverdue int    `db:"-" json:"days_overdue"`
+}
+
+func ValidateMilestoneTemplate(v *validator.Validator, t *MilestoneTemplate) {
+	v.Check(t.Year > 2000, "year", "السنة مطلوبة")
+	v.Check(t.Season == "spring" || t.Season == "fall", "season", "يجب اختيار موسم ربيع أو خريف")
+	v.Check(t.Title != "", "title", "عنوان المرحلة مطلوب")
+	v.Check(len(t.Title) <= 200, "title", "عنوان المرحلة طويل جداً")
+	v.Check(!t.DueDate.IsZero(), "due_date", "تاريخ التسليم مطلوب")
+}
+
+func ValidateProgressReport(v *validator.Validator, report *ProgressReport) {
+	v.Check(report.Content != "", "content", "محتوى التقرير مطلوب")
+	v.Check(len(report.Content) <= 5000, "content", "لا يمكن أن يتجاوز التقرير 5000 حرف")
+}
+
+func ValidateReportReview(v *validator.Validator, status string, comment *string) {
+	v.Check(validator.In(status, MilestoneApproved, MilestoneChangesRequested), "status", "يجب أن تكون الحالة approved أو changes_requested")
+	if status == MilestoneChangesRequested {
+		v.Check(comment != nil && *comment != "", "comment", "يجب توضيح التعديلات المطلوبة")
+	}
+}
+
+// InsertTemplate adds a milestone to a term and instantiates it for every
+// project of that term that already has an accepted advisor.
+func (m *MilestoneDB) InsertTemplate(t *MilestoneTemplate) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Insert("milestone_templates").
+		Columns("year", "season", "title", "description", "due_date", "position").
+		Values(t.Year, t.Season, t.Title, t.Description, t.DueDate, t.Position).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(t); err != nil {
+		return fmt.Errorf("failed to insert milestone template: %w", err)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO project_milestones (pre_project_id, template_id, title, description, due_date, position)
+        SELECT pp.id, $1, $2, $3, $4, $5
+        FROM pre_project pp
+        WHERE pp.year = $6 AND pp.season = $7 AND pp.accepted_advisor IS NOT NULL AND `+preProjectActive("pp")+`
+        ON CONFLICT (pre_project_id, template_id) DO NOTHING`,
+		t.ID, t.Title, t.Description, t.DueDate, t.Position, t.Year, t.Season)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate milestone: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// UpdateTemplate changes a template and the milestones instantiated from it
+// that have not been approved yet.
+func (m *MilestoneDB) UpdateTemplate(t *MilestoneTemplate) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Update("milestone_templates").
+		Set("title", t.Title).
+		Set("description", t.Description).
+		Set("due_date", t.DueDate).
+		Set("position", t.Position).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": t.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update milestone template: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = tx.Exec(`
+        UPDATE project_milestones
+        SET title = $1, description = $2, due_date = $3, position = $4, updated_at = $5
+        WHERE template_id = $6 AND status <> 'approved'`,
+		t.Title, t.Description, t.DueDate, t.Position, time.Now(), t.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update project milestones: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *MilestoneDB) GetTemplate(id uuid.UUID) (*MilestoneTemplate, error) {
+	var t MilestoneTemplate
+	if err := m.db.Get(&t, "SELECT * FROM milestone_templates WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get milestone template: %w", err)
+	}
+	return &t, nil
+}
+
+func (m *MilestoneDB) ListTemplates(year int, season string) ([]MilestoneTemplate, error) {
+	templates := []MilestoneTemplate{}
+	err := m.db.Select(&templates,
+		"SELECT * FROM milestone_templates WHERE year = $1 AND season = $2 ORDER BY position, due_date", year, season)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list milestone templates: %w", err)
+	}
+	return templates, nil
+}
+
+// DeleteTemplate removes a template together with the milestones created
+// from it that have no reports yet. Milestones with reports are kept.
+func (m *MilestoneDB) DeleteTemplate(id uuid.UUID) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        DELETE FROM project_milestones pm
+        WHERE pm.template_id = $1
+          AND NOT EXISTS (SELECT 1 FROM progress_reports pr WHERE pr.milestone_id = pm.id)`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete project milestones: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM milestone_templates WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete milestone template: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// InstantiateMilestones copies the templates of the project's term onto the
+// project. It is idempotent and is called when an advisor accepts.
+func (m *MilestoneDB) InstantiateMilestones(preProjectID uuid.UUID) error {
+	_, err := m.db.Exec(`
+        INSERT INTO project_milestones (pre_project_id, template_id, title, description, due_date, position)
+        SELECT pp.id, t.id, t.title, t.description, t.due_date, t.position
+        FROM pre_project pp
+        JOIN milestone_templates t ON t.year = pp.year AND t.season = pp.season
+        WHERE pp.id = $1
+        ON CONFLICT (pre_project_id, template_id) DO NOTHING`, preProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to instantiate milestones: %w", err)
+	}
+	return nil
+}
+
+func (m *MilestoneDB) GetMilestone(id uuid.UUID) (*Milestone, error) {
+	var milestone Milestone
+	if err := m.db.Get(&milestone, "SELECT * FROM project_milestones WHERE id = $1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get milestone: %w", err)
+	}
+	milestone.Overdue = milestone.isOverdue(time.Now())
+	return &milestone, nil
+}
+
+func (ms *Milestone) isOverdue(now time.Time) bool {
+	return ms.Status != MilestoneApproved && ms.DueDate.Before(now)
+}
+
+var progressReportColumns = []string{
+	"pr.id",
+	"pr.milestone_id",
+	"pr.submitted_by",
+	"COALESCE(u.name, '') AS submitted_by_name",
+	"pr.content",
+	fmt.Sprintf("CASE WHEN NULLIF(pr.file, '') IS NOT NULL THEN FORMAT('%s/%%s', pr.file) ELSE NULL END AS file", Domain),
+	"pr.status",
+	"pr.advisor_comment",
+	"pr.reviewed_by",
+	"pr.reviewed_at",
+	"pr.created_at",
+}
+
+// ListMilestones returns the project's milestones in order, each with its
+// progress reports, newest first.
+func (m *MilestoneDB) ListMilestones(preProjectID uuid.UUID) ([]Milestone, error) {
+	milestones := []Milestone{}
+	err := m.db.Select(&milestones,
+		"SELECT * FROM project_milestones WHERE pre_project_id = $1 ORDER BY position, due_date", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list milestones: %w", err)
+	}
+	if len(milestones) == 0 {
+		return milestones, nil
+	}
+
+	ids := make([]uuid.UUID, len(milestones))
+	index := map[uuid.UUID]int{}
+	now := time.Now()
+	for i := range milestones {
+		ids[i] = milestones[i].ID
+		index[milestones[i].ID] = i
+		milestones[i].Overdue = milestones[i].isOverdue(now)
+		milestones[i].Reports = []ProgressReport{}
+	}
+
+	query, args, err := QB.Select(progressReportColumns...).
+		From("progress_reports pr").
+		LeftJoin("users u ON u.id = pr.submitted_by").
+		Where(squirrel.Eq{"pr.milestone_id": ids}).
+		OrderBy("pr.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	var reports []ProgressReport
+	if err := m.db.Select(&reports, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list progress reports: %w", err)
+	}
+	for _, report := range reports {
+		i := index[report.MilestoneID]
+		milestones[i].Reports = append(milestones[i].Reports, report)
+	}
+	return milestones, nil
+}
+
+// InsertReport records a progress report and marks its milestone as submitted.
+func (m *MilestoneDB) InsertReport(report *ProgressReport) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Insert("progress_reports").
+		Columns("milestone_id", "submitted_by", "content", "file").
+		Values(report.MilestoneID, report.SubmittedBy, report.Content, report.File).
+		Suffix("RETURNING id, status, created_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(report); err != nil {
+		return fmt.Errorf("failed to insert progress report: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE project_milestones SET status = 'submitted', updated_at = $1 WHERE id = $2", time.Now(), report.MilestoneID)
+	if err != nil {
+		return fmt.Errorf("failed to update milestone status: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (m *MilestoneDB) GetReport(id uuid.UUID) (*ProgressReport, error) {
+	query, args, err := QB.Select(progressReportColumns...).
+		From("progress_reports pr").
+		LeftJoin("users u ON u.id = pr.submitted_by").
+		Where(squirrel.Eq{"pr.id": id}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var report ProgressReport
+	if err := m.db.Get(&report, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get progress report: %w", err)
+	}
+	return &report, nil
+}
+
+// ReviewReport stores the advisor's decision on a report and carries it over
+// to the milestone; an approval completes the milestone.
+func (m *MilestoneDB) ReviewReport(reportID, advisorID uuid.UUID, status string, comment *string) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var milestoneID uuid.UUID
+	err = tx.Get(&milestoneID, `
+        UPDATE progress_reports
+        SET status = $1, advisor_comment = $2, reviewed_by = $3, reviewed_at = $4
+        WHERE id = $5
+        RETURNING milestone_id`, status, comment, advisorID, now, reportID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to review progress report: %w", err)
+	}
+
+	var completedAt *time.Time
+	if status == MilestoneApproved {
+		completedAt = &now
+	}
+	_, err = tx.Exec("UPDATE project_milestones SET status = $1, completed_at = $2, updated_at = $3 WHERE id = $4",
+		status, completedAt, now, milestoneID)
+	if err != nil {
+		return fmt.Errorf("failed to update milestone status: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListOverdueForAdvisor returns the unapproved milestones past their due date
+// across every project the advisor has accepted, most overdue first.
+func (m *MilestoneDB) ListOverdueForAdvisor(advisorID uuid.UUID, now time.Time) ([]OverdueMilestone, error) {
+	query, args, err := QB.Select("pm.*", "pp.name AS project_name").
+		From("project_milestones pm").
+		Join("pre_project pp ON pp.id = pm.pre_project_id").
+		Where(squirrel.Eq{"pp.accepted_advisor": advisorID}).
+		Where(preProjectActive("pp")).
+		Where(squirrel.NotEq{"pm.status": MilestoneApproved}).
+		Where(squirrel.Lt{"pm.due_date": now}).
+		OrderBy("pm.due_date ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	overdue := []OverdueMilestone{}
+	if err := m.db.Select(&overdue, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list overdue milestones: %w", err)
+	}
+	for i := range overdue {
+		overdue[i].Overdue = true
+		overdue[i].DaysOverdue = int(now.Sub(overdue[i].DueDate).Hours() / 24)
+	}
+	return overdue, nil
+}
+
+// progressReportFiles lists the attachments of every progress report of a
+// pre-project, so they can be removed with it.
+func progressReportFiles(q sqlx.Queryer, preProjectID uuid.UUID) ([]string, error) {
+	var files []string
+	err := sqlx.Select(q, &files, `
+        SELECT pr.file
+        FROM progress_reports pr
+        JOIN project_milestones pm ON pm.id = pr.milestone_id
+        WHERE pm.pre_project_id = $1 AND NULLIF(pr.file, '') IS NOT NULL`, preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list progress report files: %w", err)
+	}
+	return files, nil
+}
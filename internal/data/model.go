@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"project/utils"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jmoiron/sqlx"
@@ -25,6 +26,8 @@ var (
 	ErrRecordNotFoundOrders  = errors.New("لا توجد طلبات متاحة")
 	ErrDescriptionMissing    = errors.New("الوصف مطلوب")
 	ErrDuplicatedPhone       = errors.New("رقم الهاتف موجود بالفعل")
+	ErrWindowClosed          = errors.New("الفترة المحددة لهذا الإجراء مغلقة")
+	ErrAlreadyAccepted       = errors.New("تم قبول المشروع من قبل مشرف آخر بالفعل")
 	QB                       = squirrel.StatementBuilder.PlaceholderFormat(squirrel.Dollar)
 	Domain                   = os.Getenv("DOMAIN")
 
@@ -62,6 +65,7 @@ var (
 		"created_at",
 		"updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(file, '') IS NOT NULL THEN FORMAT('%s/%%s', file) ELSE NULL END AS file", Domain),
+		thumbnailColumn("file"),
 	}
 	users_column = []string{
 		"id",
@@ -83,12 +87,14 @@ var (
 		"pp.description",
 		"pp.file_description",
 		fmt.Sprintf("CASE WHEN NULLIF(pp.file, '') IS NOT NULL THEN FORMAT('%s/%%s', pp.file) ELSE NULL END AS file", Domain),
+		thumbnailColumn("pp.file"),
 		"pp.project_owner",
 		"pp.accepted_advisor",
 		"pp.year",
 		"COALESCE(pp.degree, NULL) AS degree",
 		"pp.season",
 		"pp.can_update",
+		"pp.keywords",
 		"pp.created_at",
 		"pp.updated_at",
 		"u.id AS advisor_id",
@@ -101,6 +107,7 @@ var (
 		"COALESCE(student.name, '') AS student_name",
 		"COALESCE(student.email, '') AS student_email",
 		"COALESCE(ar.status, 'pending') AS response_status",
+		"ar.reviewed_version",
 		"COALESCE(ar.created_at, pp.created_at) AS response_created_at",
 		"COALESCE(discussant.id,'00000000-0000-0000-0000-000000000000') AS discussant_id",
 		"COALESCE(discussant.name, '') AS discussant_name",
@@ -113,14 +120,41 @@ var (
 
 )
 
+// bookFile is the file of the book aliased b as it is shown: none while
+// the book is under embargo, whose metadata stays listed.
+const bookFile = "(CASE WHEN b.embargo_until IS NULL OR b.embargo_until <= NOW() THEN b.file END)"
+
+var bookFileColumn = fmt.Sprintf("CASE WHEN NULLIF(%[1]s, '') IS NOT NULL THEN FORMAT('%[2]s/%%s', %[1]s) ELSE NULL END AS file", bookFile, Domain)
+
+// thumbnailColumn selects the preview URL of a file column as thumbnail_url.
+// Previews are made on first request, so every file of a type that has one
+// (see utils.HasPreview) gets the URL.
+func thumbnailColumn(column string) string {
+	return fmt.Sprintf(`CASE WHEN %[1]s ~* '\.(pdf|jpe?g|png|gif)$' THEN FORMAT('%[2]s/%%s%[3]s', %[1]s) ELSE NULL END AS thumbnail_url`,
+		column, Domain, utils.ThumbnailSuffix)
+}
+
 type Model struct {
-	BookDB         BookDB
-	PostDB         PostDB
-	UserDB         UserDB
-	UserRoleDB     UserRoleDB
-	ConversationDB ConversationDB
-	PreProjectDB   PreProjectDB
-	ChatDB         ChatDB
+	BookDB             BookDB
+	PostDB             PostDB
+	UserDB             UserDB
+	UserRoleDB         UserRoleDB
+	ConversationDB     ConversationDB
+	PreProjectDB       PreProjectDB
+	ChatDB             ChatDB
+	AcademicTermDB     AcademicTermDB
+	DefenseDB          DefenseDB
+	GradingDB          GradingDB
+	MilestoneDB        MilestoneDB
+	MeetingDB          MeetingDB
+	ArchiveJobDB       ArchiveJobDB
+	TopicDB            TopicDB
+	DiscussantDB       DiscussantDB
+	ExternalExaminerDB ExternalExaminerDB
+	ClosureDB          ClosureDB
+	ExtensionDB        ExtensionDB
+	SubjectDB          SubjectDB
+	FileAccessDB       FileAccessDB
 }
 
 func NewModels(db *sqlx.DB) Model {
@@ -133,6 +167,20 @@ func NewModels(db *sqlx.DB) Model {
 		ChatDB:       ChatDB{db},
 		PreProjectDB: PreProjectDB{db},
 
+		AcademicTermDB:     AcademicTermDB{db},
+		DefenseDB:          DefenseDB{db},
+		GradingDB:          GradingDB{db},
+		MilestoneDB:        MilestoneDB{db},
+		MeetingDB:          MeetingDB{db},
+		ArchiveJobDB:       ArchiveJobDB{db},
+		TopicDB:            TopicDB{db},
+		DiscussantDB:       DiscussantDB{db},
+		ExternalExaminerDB: ExternalExaminerDB{db},
+		ClosureDB:          ClosureDB{db},
+		ExtensionDB:        ExtensionDB{db},
+		SubjectDB:          SubjectDB{db},
+		FileAccessDB:       FileAccessDB{db},
+
 		ConversationDB: ConversationDB{db},
 	}
 }
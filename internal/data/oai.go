@@ -0,0 +1,121 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+)
+
+// oaiTimestamp is how datestamps are passed to Postgres: updated_at is a
+// TIMESTAMP without time zone and is compared as stored, in UTC.
+const oaiTimestamp = "2006-01-02 15:04:05.999999"
+
+// OAIFilter selects the books of an OAI-PMH list request. From is inclusive
+// and Before exclusive; both compare updated_at.
+type OAIFilter struct {
+	From      *time.Time
+	Before    *time.Time
+	Year      *int
+	SubjectID *uuid.UUID
+	// AnySubject keeps only books filed under at least one subject.
+	AnySubject bool
+}
+
+// OAICursor is the position after which a list continues: the datestamp
+// and id of the last book sent.
+type OAICursor struct {
+	UpdatedAt time.Time
+	ID        uuid.UUID
+}
+
+func (f *OAIFilter) conditions() squirrel.And {
+	where := squirrel.And{}
+	if f.From != nil {
+		where = append(where, squirrel.Expr("b.updated_at >= ?::timestamp", f.From.UTC().Format(oaiTimestamp)))
+	}
+	if f.Before != nil {
+		where = append(where, squirrel.Expr("b.updated_at < ?::timestamp", f.Before.UTC().Format(oaiTimestamp)))
+	}
+	if f.Year != nil {
+		where = append(where, squirrel.Eq{"b.year": *f.Year})
+	}
+	if f.SubjectID != nil {
+		where = append(where, squirrel.Expr(
+			"EXISTS (SELECT 1 FROM book_subjects bs WHERE bs.book_id = b.id AND bs.subject_id = ?)", *f.SubjectID))
+	} else if f.AnySubject {
+		where = append(where, squirrel.Expr("EXISTS (SELECT 1 FROM book_subjects bs WHERE bs.book_id = b.id)"))
+	}
+	return where
+}
+
+// ListOAIBooks returns up to limit books of the filter after the cursor in
+// datestamp order, with their people and subjects, and the size of the
+// whole list.
+func (b *BookDB) ListOAIBooks(filter OAIFilter, after *OAICursor, limit int) ([]BookWithDetails, int, error) {
+	where := filter.conditions()
+
+	countQuery, countArgs, err := QB.Select("COUNT(*)").From("book b").Where(where).ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build count query: %w", err)
+	}
+	var total int
+	if err := b.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count books: %w", err)
+	}
+
+	builder := QB.Select(
+		"b.id",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.keywords",
+		"b.source_pre_project_id",
+		"b.created_at",
+		"b.updated_at",
+	).From("book b").Where(where)
+	if after != nil {
+		builder = builder.Where("(b.updated_at, b.id) > (?::timestamp, ?)", after.UpdatedAt.UTC().Format(oaiTimestamp), after.ID)
+	}
+	query, args, err := builder.OrderBy("b.updated_at", "b.id").Limit(uint64(limit)).ToSql()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	books := []Book{}
+	if err := b.db.Select(&books, query, args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to list books: %w", err)
+	}
+	results, err := b.withDetails(books)
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, total, nil
+}
+
+// EarliestDatestamp returns the oldest updated_at of the archive, or now
+// when it is empty.
+func (b *BookDB) EarliestDatestamp() (time.Time, error) {
+	var earliest sql.NullTime
+	if err := b.db.Get(&earliest, "SELECT MIN(updated_at) FROM book"); err != nil {
+		return time.Time{}, fmt.Errorf("failed to get earliest datestamp: %w", err)
+	}
+	if !earliest.Valid {
+		return time.Now().UTC(), nil
+	}
+	return earliest.Time, nil
+}
+
+// ListBookYears returns the years that have books, newest first.
+func (b *BookDB) ListBookYears() ([]int, error) {
+	years := []int{}
+	if err := b.db.Select(&years, "SELECT DISTINCT year FROM book ORDER BY year DESC"); err != nil {
+		return nil, fmt.Errorf("failed to list book years: %w", err)
+	}
+	return years, nil
+}
@@ -20,8 +20,10 @@ type Post struct {
 	ID          uuid.UUID `db:"id" json:"id"`
 	Description string    `db:"description" json:"description"`
 	File        *string   `db:"file" json:"file"`
-	CreatedAt   time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+	// ThumbnailURL is the preview of the file, made on first request
+	ThumbnailURL *string   `db:"thumbnail_url" json:"thumbnail_url"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
 }
 
 type PostDB struct {
@@ -16,6 +16,13 @@ import (
 	"github.com/lib/pq"
 )
 
+// preProjectActive holds for the pre-project aliased alias while it is still
+// under way: neither moved to the archive as a book nor withdrawn or
+// cancelled.
+func preProjectActive(alias string) string {
+	return fmt.Sprintf("(%[1]s.archived_at IS NULL AND %[1]s.closed_at IS NULL)", alias)
+}
+
 type PreProjectDB struct {
 	db *sqlx.DB
 }
@@ -25,14 +32,23 @@ type PreProject struct {
 	Description     *string    `db:"description" json:"description,omitempty"`
 	File            *string    `db:"file" json:"file,omitempty"`
 	FileDescription *string    `db:"file_description" json:"file_description"`
+	ThumbnailURL    *string    `db:"thumbnail_url" json:"thumbnail_url,omitempty"`
 	ProjectOwner    uuid.UUID  `db:"project_owner" json:"project_owner"`
 	AcceptedAdvisor *uuid.UUID `db:"accepted_advisor" json:"accepted_advisor"`
 	Year            int        `db:"year" json:"year"`
 	Season          string     `db:"season" json:"season"`
 	CanUpdate       bool       `db:"can_update" json:"can_update"`
 	Degree          *int       `db:"degree" json:"degree,omitempty"`
+	ArchivedAt      *time.Time `db:"archived_at" json:"archived_at,omitempty"`
+	ClosedAt        *time.Time `db:"closed_at" json:"closed_at,omitempty"`
+	Closure         *string    `db:"closure" json:"closure,omitempty"`
 	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
 	UpdatedAt       time.Time  `db:"updated_at" json:"updated_at"`
+
+	Keywords pq.StringArray `db:"keywords" json:"keywords"`
+	// SubjectIDs replaces the project's subjects on insert and update when
+	// not nil.
+	SubjectIDs []uuid.UUID `db:"-" json:"-"`
 }
 
 func ValidatePreProject(v *validator.Validator, preProject *PreProject, students, advisors []uuid.UUID) {
@@ -63,6 +79,9 @@ func ValidatePreProject(v *validator.Validator, preProject *PreProject, students
 
 	v.Check(len(advisors) > 0, "advisors", "يجب إضافة مشرف واحد على الأقل")
 	v.Check(len(advisors) <= 3, "advisors", "لا يمكن إضافة أكثر من 3 مشرفين")
+
+	ValidateKeywords(v, preProject.Keywords)
+	ValidateSubjects(v, preProject.SubjectIDs)
 }
 
 type UUIDArray []uuid.UUID
@@ -98,8 +117,11 @@ func (p *PreProjectDB) InsertPreProject(preProject *PreProject, studentIDs []uui
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer tx.Rollback()
+	if preProject.Keywords == nil {
+		preProject.Keywords = pq.StringArray{}
+	}
 	query, args, err := QB.Insert("pre_project").
-		Columns("name, description, file, file_description, project_owner, year, season, can_update").
+		Columns("name, description, file, file_description, project_owner, year, season, can_update", "keywords").
 		Values(
 			preProject.Name,
 			preProject.Description,
@@ -109,6 +131,7 @@ func (p *PreProjectDB) InsertPreProject(preProject *PreProject, studentIDs []uui
 			preProject.Year,
 			preProject.Season,
 			true,
+			preProject.Keywords,
 		).
 		Suffix("RETURNING id, created_at, updated_at").
 		ToSql()
@@ -143,6 +166,14 @@ func (p *PreProjectDB) InsertPreProject(preProject *PreProject, studentIDs []uui
 		}
 	}
 
+	if err := setSubjects(tx, "pre_project_subjects", "pre_project_id", preProject.ID, preProject.SubjectIDs); err != nil {
+		return err
+	}
+
+	if err := insertPreProjectVersion(tx, preProject, preProject.ProjectOwner); err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -156,6 +187,8 @@ type PreProjectWithAdvisorDetails struct {
 	Advisors            []AdvisorResponseDetails `json:"advisors"`
 	Students            []StudentDetails         `json:"students"`
 	Discussants         []DiscussantDetails      `json:"discussants"` // Add this line
+	ExternalDiscussants []ExternalExaminer       `json:"external_discussants"`
+	Subjects            []Subject                `json:"subjects"`
 	AcceptedAdvisorInfo *AdvisorInfo             `json:"accepted_advisor_info,omitempty"`
 }
 
@@ -175,15 +208,27 @@ type DiscussantDetails struct {
 	DiscussantEmail string    `json:"discussant_email"`
 }
 type AdvisorResponseDetails struct {
-	AdvisorID    uuid.UUID `json:"advisor_id"`
-	AdvisorName  string    `json:"advisor_name"`
-	AdvisorEmail string    `json:"advisor_email"`
-	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	AdvisorID       uuid.UUID `json:"advisor_id"`
+	AdvisorName     string    `json:"advisor_name"`
+	AdvisorEmail    string    `json:"advisor_email"`
+	Status          string    `json:"status"`
+	ReviewedVersion *int      `json:"reviewed_version,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (*PreProjectWithAdvisorDetails, error) {
+	return p.getPreProjectWithAdvisorDetails(preProjectID, squirrel.Expr(preProjectActive("pp")))
+}
+
+// GetClosedPreProjectWithAdvisorDetails is GetPreProjectWithAdvisorDetails
+// that also finds a withdrawn or cancelled pre-project, whose history its
+// members can still read.
+func (p *PreProjectDB) GetClosedPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (*PreProjectWithAdvisorDetails, error) {
+	return p.getPreProjectWithAdvisorDetails(preProjectID, squirrel.Eq{"pp.archived_at": nil})
+}
+
+func (p *PreProjectDB) getPreProjectWithAdvisorDetails(preProjectID uuid.UUID, state squirrel.Sqlizer) (*PreProjectWithAdvisorDetails, error) {
 	query, args, err := QB.Select(
 		preProjectJoinColumns...,
 	).
@@ -196,6 +241,7 @@ func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (
 		LeftJoin("pre_project_discussants ppd ON ppd.pre_project_id = pp.id").
 		LeftJoin("users discussant ON discussant.id = ppd.discussant_id").
 		Where("pp.id = ?", preProjectID).
+		Where(state).
 		ToSql()
 
 	if err != nil {
@@ -229,6 +275,7 @@ func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (
 			AdvisorName          string    `db:"advisor_name"`
 			AdvisorEmail         string    `db:"advisor_email"`
 			ResponseStatus       string    `db:"response_status"`
+			ReviewedVersion      *int      `db:"reviewed_version"`
 			ResponseCreatedAt    time.Time `db:"response_created_at"`
 			ResponseUpdatedAt    time.Time `db:"response_updated_at"`
 			StudentID            uuid.UUID `db:"student_id"`
@@ -255,12 +302,13 @@ func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (
 		// Populate advisor details, avoiding duplicates
 		if row.AdvisorID != uuid.Nil && !advisorSet[row.AdvisorID] {
 			result.Advisors = append(result.Advisors, AdvisorResponseDetails{
-				AdvisorID:    row.AdvisorID,
-				AdvisorName:  row.AdvisorName,
-				AdvisorEmail: row.AdvisorEmail,
-				Status:       row.ResponseStatus,
-				CreatedAt:    row.ResponseCreatedAt,
-				UpdatedAt:    row.ResponseUpdatedAt,
+				AdvisorID:       row.AdvisorID,
+				AdvisorName:     row.AdvisorName,
+				AdvisorEmail:    row.AdvisorEmail,
+				Status:          row.ResponseStatus,
+				ReviewedVersion: row.ReviewedVersion,
+				CreatedAt:       row.ResponseCreatedAt,
+				UpdatedAt:       row.ResponseUpdatedAt,
 			})
 			advisorSet[row.AdvisorID] = true // Mark this advisor as added
 		}
@@ -296,6 +344,15 @@ func (p *PreProjectDB) GetPreProjectWithAdvisorDetails(preProjectID uuid.UUID) (
 		return nil, ErrRecordNotFound
 	}
 
+	result.ExternalDiscussants, err = externalDiscussants(p.db, "pre_project_external_discussants", "pre_project_id", preProjectID)
+	if err != nil {
+		return nil, err
+	}
+	result.Subjects, err = subjectsOf(p.db, "pre_project_subjects", "pre_project_id", preProjectID)
+	if err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
 
@@ -323,26 +380,194 @@ type PreProjectWithStudentAdvisorDetails struct {
 	ResponseUpdatedAt time.Time `db:"response_updated_at"`
 }
 
-func (p *PreProjectDB) ListPreProjects(queryParams url.Values) ([]PreProject, *utils.Meta, error) {
-	var preProjects []PreProject
+// Statuses a pre-project goes through, derived from its advisors' responses
+// and grading
+const (
+	PreProjectPending  = "pending"
+	PreProjectAccepted = "accepted"
+	PreProjectRejected = "rejected"
+	PreProjectGraded   = "graded"
+)
+
+var PreProjectStatuses = []string{PreProjectPending, PreProjectAccepted, PreProjectRejected, PreProjectGraded}
+
+const preProjectStatusExpr = `CASE
+    WHEN b.degree IS NOT NULL THEN 'graded'
+    WHEN b.accepted_advisor IS NOT NULL THEN 'accepted'
+    WHEN EXISTS (SELECT 1 FROM advisor_responses ar WHERE ar.pre_project_id = b.id AND ar.status = 'pending') THEN 'pending'
+    ELSE 'rejected'
+END`
+
+// PreProjectFilter narrows ListPreProjects. The user-relative filters hold
+// the id of the user asking.
+type PreProjectFilter struct {
+	Year            *int
+	Season          string
+	Status          string
+	AcceptedAdvisor *uuid.UUID
+	Student         *uuid.UUID
+	AwaitingAdvisor *uuid.UUID
+	Discussant      *uuid.UUID
+}
+
+func ValidatePreProjectFilter(v *validator.Validator, filter *PreProjectFilter) {
+	if filter.Season != "" {
+		v.Check(validator.In(filter.Season, "spring", "fall"), "season", "يجب اختيار موسم ربيع أو خريف")
+	}
+	if filter.Status != "" {
+		v.Check(validator.In(filter.Status, PreProjectStatuses...), "status", "حالة المشروع غير صالحة")
+	}
+}
+
+func (f *PreProjectFilter) conditions() []squirrel.Sqlizer {
+	var where []squirrel.Sqlizer
+	if f.Year != nil {
+		where = append(where, squirrel.Eq{"b.year": *f.Year})
+	}
+	if f.Season != "" {
+		where = append(where, squirrel.Eq{"b.season": f.Season})
+	}
+	if f.Status != "" {
+		where = append(where, squirrel.Expr("("+preProjectStatusExpr+") = ?", f.Status))
+	}
+	if f.AcceptedAdvisor != nil {
+		where = append(where, squirrel.Eq{"b.accepted_advisor": *f.AcceptedAdvisor})
+	}
+	if f.Student != nil {
+		where = append(where, squirrel.Expr(
+			"EXISTS (SELECT 1 FROM pre_project_students ps WHERE ps.pre_project_id = b.id AND ps.student_id = ?)", *f.Student))
+	}
+	if f.AwaitingAdvisor != nil {
+		where = append(where, squirrel.Expr(
+			"b.accepted_advisor IS NULL AND EXISTS (SELECT 1 FROM advisor_responses ar WHERE ar.pre_project_id = b.id AND ar.advisor_id = ? AND ar.status = 'pending')", *f.AwaitingAdvisor))
+	}
+	if f.Discussant != nil {
+		where = append(where, squirrel.Expr(
+			"EXISTS (SELECT 1 FROM pre_project_discussants pd WHERE pd.pre_project_id = b.id AND pd.discussant_id = ?)", *f.Discussant))
+	}
+	return where
+}
+
+// PreProjectMember is a person on a pre-project as shown in listings;
+// Status is the advisor's response and is empty for students and discussants.
+type PreProjectMember struct {
+	PreProjectID uuid.UUID `db:"pre_project_id" json:"-"`
+	ID           uuid.UUID `db:"id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	Email        string    `db:"email" json:"email,omitempty"`
+	Status       string    `db:"status" json:"status,omitempty"`
+}
+
+type PreProjectListItem struct {
+	PreProject
+	Status      string             `db:"status" json:"status"`
+	Extensions  int                `db:"extensions" json:"extensions"`
+	Students    []PreProjectMember `db:"-" json:"students"`
+	Advisors    []PreProjectMember `db:"-" json:"advisors,omitempty"`
+	Discussants []PreProjectMember `db:"-" json:"discussants,omitempty"`
+}
+
+// Involves reports whether the user owns, studies in, advises (or was asked
+// to) or discusses the project.
+func (item *PreProjectListItem) Involves(userID uuid.UUID) bool {
+	if item.ProjectOwner == userID {
+		return true
+	}
+	for _, group := range [][]PreProjectMember{item.Students, item.Advisors, item.Discussants} {
+		for _, member := range group {
+			if member.ID == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *PreProjectDB) ListPreProjects(queryParams url.Values, filter PreProjectFilter) ([]PreProjectListItem, *utils.Meta, error) {
+	preProjects := []PreProjectListItem{}
 	searchCols := []string{"b.name", "b.description"}
 	table := "pre_project b"
 
-	// Define columns to include in the result
-	bookJoinColumns := []string{
+	columns := []string{
 		"b.id",
 		"b.name",
-		"COALESCE(b.description, '') AS description",
-	}
-
-	meta, err := utils.BuildQuery(&preProjects, table, nil, bookJoinColumns, searchCols, queryParams, nil)
+		"b.description",
+		"b.file_description",
+		fmt.Sprintf("CASE WHEN NULLIF(b.file, '') IS NOT NULL THEN FORMAT('%s/%%s', b.file) ELSE NULL END AS file", Domain),
+		thumbnailColumn("b.file"),
+		"b.project_owner",
+		"b.accepted_advisor",
+		"b.year",
+		"b.season",
+		"b.can_update",
+		"b.degree",
+		"b.keywords",
+		"b.created_at",
+		"b.updated_at",
+		preProjectStatusExpr + " AS status",
+		"(SELECT COUNT(*) FROM project_extensions e WHERE e.pre_project_id = b.id) AS extensions",
+	}
+	where := append([]squirrel.Sqlizer{squirrel.Expr(preProjectActive("b"))}, filter.conditions()...)
+
+	meta, err := utils.BuildQuery(&preProjects, table, nil, columns, searchCols, queryParams, where)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error building query: %v", err)
 	}
+	if err := p.loadMembers(preProjects); err != nil {
+		return nil, nil, err
+	}
 
 	return preProjects, meta, nil
 }
 
+// loadMembers fills the students, advisor responses and discussants of a page
+// of pre-projects with one query each.
+func (p *PreProjectDB) loadMembers(items []PreProjectListItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(items))
+	index := map[uuid.UUID]*PreProjectListItem{}
+	for i := range items {
+		ids[i] = items[i].ID
+		index[items[i].ID] = &items[i]
+		items[i].Students = []PreProjectMember{}
+		items[i].Advisors = []PreProjectMember{}
+		items[i].Discussants = []PreProjectMember{}
+	}
+
+	queries := []struct {
+		sql    string
+		target func(*PreProjectListItem) *[]PreProjectMember
+	}{
+		{`SELECT ps.pre_project_id, u.id, u.name, u.email, '' AS status
+            FROM pre_project_students ps JOIN users u ON u.id = ps.student_id
+            WHERE ps.pre_project_id = ANY($1) ORDER BY u.name`,
+			func(item *PreProjectListItem) *[]PreProjectMember { return &item.Students }},
+		{`SELECT ar.pre_project_id, u.id, u.name, u.email, ar.status
+            FROM advisor_responses ar JOIN users u ON u.id = ar.advisor_id
+            WHERE ar.pre_project_id = ANY($1) ORDER BY ar.created_at`,
+			func(item *PreProjectListItem) *[]PreProjectMember { return &item.Advisors }},
+		{`SELECT pd.pre_project_id, u.id, u.name, u.email, '' AS status
+            FROM pre_project_discussants pd JOIN users u ON u.id = pd.discussant_id
+            WHERE pd.pre_project_id = ANY($1) ORDER BY u.name`,
+			func(item *PreProjectListItem) *[]PreProjectMember { return &item.Discussants }},
+	}
+	for _, q := range queries {
+		var members []PreProjectMember
+		if err := p.db.Select(&members, q.sql, pq.Array(ids)); err != nil {
+			return fmt.Errorf("failed to load pre-project members: %w", err)
+		}
+		for _, member := range members {
+			if item, ok := index[member.PreProjectID]; ok {
+				list := q.target(item)
+				*list = append(*list, member)
+			}
+		}
+	}
+	return nil
+}
+
 type AdvisorResponse struct {
 	ID           uuid.UUID `db:"id" json:"id"`
 	PreProjectID uuid.UUID `db:"pre_project_id" json:"pre_project_id"`
@@ -375,7 +600,7 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 	if err != nil {
 		return fmt.Errorf("failed to lock pre-project: %w", err)
 	}
-	existenceQuery, existenceArgs, err := QB.Select("COUNT(*) > 0").
+	existenceQuery, existenceArgs, err := QB.Select("COUNT(*) > 0", "COALESCE(bool_or(archived_at IS NOT NULL), false)").
 		From("pre_project").
 		Where(squirrel.Eq{"id": preProjectID}).
 		ToSql()
@@ -383,8 +608,8 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 		return fmt.Errorf("failed to build existence check query: %w", err)
 	}
 
-	var preProjectExists bool
-	err = p.db.Get(&preProjectExists, existenceQuery, existenceArgs...)
+	var preProjectExists, archived bool
+	err = tx.QueryRowx(existenceQuery, existenceArgs...).Scan(&preProjectExists, &archived)
 	if err != nil {
 		return fmt.Errorf("failed to check pre-project existence: %w", err)
 	}
@@ -392,6 +617,10 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 	if !preProjectExists {
 		return fmt.Errorf("pre-project not found")
 	}
+	// Its book shares the file and its history; it goes with the book
+	if archived {
+		return ErrArchivedProject
+	}
 
 	ownerQuery, ownerArgs, err := QB.Select("COUNT(*) > 0").
 		From("pre_project").
@@ -424,6 +653,16 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 		return fmt.Errorf("failed to retrieve file information: %w", err)
 	}
 
+	versionFiles, err := preProjectVersionFiles(tx, preProjectID)
+	if err != nil {
+		return err
+	}
+	reportFiles, err := progressReportFiles(tx, preProjectID)
+	if err != nil {
+		return err
+	}
+	versionFiles = append(versionFiles, reportFiles...)
+
 	deleteQuery, deleteArgs, err := QB.Delete("pre_project").
 		Where(squirrel.Eq{"id": preProjectID}).
 		ToSql()
@@ -446,15 +685,25 @@ func (p *PreProjectDB) DeletePreProject(preProjectID, studentID uuid.UUID) error
 	}
 
 	if existingFile != nil && *existingFile != "" {
-		if err := utils.DeleteFile(*existingFile); err != nil {
-			log.Printf("Failed to delete file %s: %v", *existingFile, err)
+		versionFiles = append(versionFiles, *existingFile)
+	}
+	deleted := map[string]bool{}
+	for _, file := range versionFiles {
+		if deleted[file] {
+			continue
+		}
+		deleted[file] = true
+		if err := utils.DeleteFile(file); err != nil {
+			log.Printf("Failed to delete file %s: %v", file, err)
 		}
 	}
 
 	return nil
 }
 
-func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, studentIDs []uuid.UUID, discussantIDs []uuid.UUID) error {
+// UpdatePreProject writes the new state of a pre-project and, when the
+// submitted content changed, records it as a new version by submittedBy.
+func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, studentIDs []uuid.UUID, discussantIDs []uuid.UUID, submittedBy uuid.UUID) error {
 	tx, err := p.db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
@@ -484,6 +733,9 @@ func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, stud
 	} else {
 		fileValue = nil
 	}
+	if preProject.Keywords == nil {
+		preProject.Keywords = pq.StringArray{}
+	}
 	updateQuery, updateArgs, err := QB.Update("pre_project").
 		Set("name", preProject.Name).
 		Set("description", preProject.Description).
@@ -494,6 +746,7 @@ func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, stud
 		Set("updated_at", time.Now()).
 		Set("can_update", preProject.CanUpdate).
 		Set("degree", preProject.Degree).
+		Set("keywords", preProject.Keywords).
 		Where(squirrel.Eq{"id": preProject.ID}).
 		ToSql()
 	if err != nil {
@@ -514,6 +767,22 @@ func (p *PreProjectDB) UpdatePreProject(preProject *PreProject, advisorIDs, stud
 		return fmt.Errorf("no pre-project found to update")
 	}
 
+	changed, err := versionContentChanged(tx, preProject)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err = insertPreProjectVersion(tx, preProject, submittedBy); err != nil {
+			return err
+		}
+	}
+
+	if preProject.SubjectIDs != nil {
+		if err = setSubjects(tx, "pre_project_subjects", "pre_project_id", preProject.ID, preProject.SubjectIDs); err != nil {
+			return err
+		}
+	}
+
 	if len(studentIDs) > 0 {
 		// Remove existing students
 		_, err = tx.Exec("DELETE FROM pre_project_students WHERE pre_project_id = $1", preProject.ID)
@@ -564,44 +833,50 @@ func ValidateAdvisorResponse(v *validator.Validator, advisorID uuid.UUID, status
 	v.Check(validator.In(status, validStatuses...), "status", "Invalid status. Must be 'pending', 'accepted', or 'rejected'")
 	v.Check(validator.InUUID(advisorID, advisors), "advisor", "The advisor is not assigned to this pre-project")
 }
+
+// InsertAdvisorResponse records an advisor's answer. The project row is
+// locked first so that of two advisors accepting at once only one wins; the
+// other gets ErrAlreadyAccepted and nothing of theirs is written.
 func (p *PreProjectDB) InsertAdvisorResponse(preProjectID, advisorID uuid.UUID, status string) error {
 	tx, err := p.db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-		err = tx.Commit()
-	}()
+	defer tx.Rollback()
 
 	var existingAcceptedAdvisor uuid.UUID
-	checkQuery, checkArgs, err := QB.Select("accepted_advisor").
-		From("pre_project").
-		Where(squirrel.Eq{"id": preProjectID}).
+	checkQuery, checkArgs, err := QB.Select("pp.accepted_advisor").
+		From("pre_project pp").
+		Where(squirrel.Eq{"pp.id": preProjectID}).
+		Where(preProjectActive("pp")).
+		Suffix("FOR UPDATE").
 		ToSql()
 	if err != nil {
 		return fmt.Errorf("failed to build check query: %w", err)
 	}
 
 	err = tx.Get(&existingAcceptedAdvisor, checkQuery, checkArgs...)
-	if err != nil && err != sql.ErrNoRows {
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
 		return fmt.Errorf("failed to check existing accepted advisor: %w", err)
 	}
 
 	if existingAcceptedAdvisor != uuid.Nil {
-		return fmt.Errorf("pre-project has already been accepted by another advisor")
+		return ErrAlreadyAccepted
 	}
 
+	// Remember which version the advisor responded to, so later diffs start there
+	reviewedVersion := squirrel.Expr("(SELECT MAX(version_number) FROM pre_project_versions WHERE pre_project_id = ?)", preProjectID)
 	responseQuery, responseArgs, err := QB.Insert("advisor_responses").
-		Columns("pre_project_id", "advisor_id", "status").
-		Values(preProjectID, advisorID, status).
+		Columns("pre_project_id", "advisor_id", "status", "reviewed_version").
+		Values(preProjectID, advisorID, status, reviewedVersion).
 		Suffix(`
             ON CONFLICT (pre_project_id, advisor_id) 
             DO UPDATE SET 
                 status = EXCLUDED.status, 
+                reviewed_version = EXCLUDED.reviewed_version,
                 updated_at = CURRENT_TIMESTAMP
         `).
 		ToSql()
@@ -638,7 +913,7 @@ func (p *PreProjectDB) InsertAdvisorResponse(preProjectID, advisorID uuid.UUID,
 		}
 
 		if rowsAffected == 0 {
-			return fmt.Errorf("pre-project has already been accepted by another advisor")
+			return ErrAlreadyAccepted
 		}
 
 		updateOtherResponsesQuery, updateOtherResponsesArgs, err := QB.Update("advisor_responses").
@@ -658,6 +933,9 @@ func (p *PreProjectDB) InsertAdvisorResponse(preProjectID, advisorID uuid.UUID,
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 func (p *PreProjectDB) CheckExistingPreProject(studentID uuid.UUID) (*PreProject, error) {
@@ -665,6 +943,7 @@ func (p *PreProjectDB) CheckExistingPreProject(studentID uuid.UUID) (*PreProject
 		From("pre_project_students ps").
 		Join("pre_project pp ON ps.pre_project_id = pp.id").
 		Where(squirrel.Eq{"ps.student_id": studentID}).
+		Where(preProjectActive("pp")).
 		ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("failed to build query: %w", err)
@@ -729,30 +1008,15 @@ func (p *PreProjectDB) RemoveAllDiscussants(preProjectID uuid.UUID) error {
 	return nil
 }
 
-func (p *PreProjectDB) UpdateCanUpdate(canUpdate bool, id uuid.UUID) error {
+// UpdateCanUpdate locks or unlocks the pre-project and, in the same
+// transaction, grants override or revokes the override for override.Window.
+func (p *PreProjectDB) UpdateCanUpdate(canUpdate bool, id uuid.UUID, override *WindowOverride) error {
 	tx, err := p.db.Beginx()
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-		err = tx.Commit()
-	}()
-	lockQuery, lockArgs, err := QB.Select("*").
-		From("pre_project").
-		Where(squirrel.Eq{"id": id}).
-		ToSql()
-	if err != nil {
-		return fmt.Errorf("failed to build lock query: %w", err)
-	}
-	// to prevent datarrace
-	_, err = tx.Exec(lockQuery+" FOR UPDATE", lockArgs...)
-	if err != nil {
-		return fmt.Errorf("failed to lock pre-project: %w", err)
-	}
+	defer tx.Rollback()
+
 	updateQuery, updateArgs, err := QB.Update("pre_project").
 		Set("can_update", canUpdate).
 		Set("updated_at", time.Now()).
@@ -773,7 +1037,81 @@ func (p *PreProjectDB) UpdateCanUpdate(canUpdate bool, id uuid.UUID) error {
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("no pre-project found to update")
+		return ErrRecordNotFound
+	}
+
+	if canUpdate {
+		err = grantOverride(tx, override)
+	} else {
+		err = revokeOverride(tx, id, override.Window)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 	return nil
 }
+
+// AdvisorRequest is one advisor_responses row seen from the advisor's side:
+// their answer and the project that asked for it.
+type AdvisorRequest struct {
+	ResponseID      uuid.UUID `db:"response_id" json:"response_id"`
+	ResponseStatus  string    `db:"response_status" json:"response_status"`
+	ReviewedVersion *int      `db:"reviewed_version" json:"reviewed_version,omitempty"`
+	RequestedAt     time.Time `db:"requested_at" json:"requested_at"`
+	RespondedAt     time.Time `db:"responded_at" json:"responded_at"`
+	PreProjectListItem
+}
+
+// ListAdvisorRequests returns every request addressed to the advisor, newest
+// first, with each project's members and the other advisors' responses.
+func (p *PreProjectDB) ListAdvisorRequests(advisorID uuid.UUID) ([]AdvisorRequest, error) {
+	query, args, err := QB.Select(
+		"ar.id AS response_id",
+		"ar.status AS response_status",
+		"ar.reviewed_version",
+		"ar.created_at AS requested_at",
+		"ar.updated_at AS responded_at",
+		"b.id",
+		"b.name",
+		"b.description",
+		"b.project_owner",
+		"b.accepted_advisor",
+		"b.year",
+		"b.season",
+		"b.can_update",
+		"b.degree",
+		"b.created_at",
+		"b.updated_at",
+		preProjectStatusExpr+" AS status",
+	).
+		From("advisor_responses ar").
+		Join("pre_project b ON b.id = ar.pre_project_id").
+		Where(squirrel.Eq{"ar.advisor_id": advisorID}).
+		Where(preProjectActive("b")).
+		OrderBy("ar.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	requests := []AdvisorRequest{}
+	if err := p.db.Select(&requests, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list advisor requests: %w", err)
+	}
+
+	items := make([]PreProjectListItem, len(requests))
+	for i := range requests {
+		items[i] = requests[i].PreProjectListItem
+	}
+	if err := p.loadMembers(items); err != nil {
+		return nil, err
+	}
+	for i := range requests {
+		requests[i].PreProjectListItem = items[i]
+	}
+	return requests, nil
+}
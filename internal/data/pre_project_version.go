@@ -0,0 +1,179 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// PreProjectVersion is an immutable snapshot of a submitted pre-project.
+type PreProjectVersion struct {
+	ID              uuid.UUID  `db:"id" json:"id"`
+	PreProjectID    uuid.UUID  `db:"pre_project_id" json:"pre_project_id"`
+	Version         int        `db:"version_number" json:"version"`
+	Name            string     `db:"name" json:"name"`
+	Description     string     `db:"description" json:"description"`
+	FileDescription *string    `db:"file_description" json:"file_description,omitempty"`
+	File            *string    `db:"file" json:"file,omitempty"`
+	SubmittedBy     *uuid.UUID `db:"submitted_by" json:"submitted_by,omitempty"`
+	SubmittedByName string     `db:"submitted_by_name" json:"submitted_by_name"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+}
+
+// PreProjectVersionDiff describes what changed between two versions.
+type PreProjectVersionDiff struct {
+	From            int              `json:"from"`
+	To              int              `json:"to"`
+	NameChanged     bool             `json:"name_changed"`
+	FileChanged     bool             `json:"file_changed"`
+	Name            []utils.DiffPart `json:"name"`
+	Description     []utils.DiffPart `json:"description"`
+	FileDescription []utils.DiffPart `json:"file_description"`
+}
+
+var preProjectVersionColumns = []string{
+	"v.id",
+	"v.pre_project_id",
+	"v.version_number",
+	"v.name",
+	"v.description",
+	"v.file_description",
+	fmt.Sprintf("CASE WHEN NULLIF(v.file, '') IS NOT NULL THEN FORMAT('%s/%%s', v.file) ELSE NULL END AS file", Domain),
+	"v.submitted_by",
+	"COALESCE(u.name, '') AS submitted_by_name",
+	"v.created_at",
+}
+
+// insertPreProjectVersion snapshots the submitted content of a pre-project.
+// It must run inside the transaction that wrote the pre-project row.
+func insertPreProjectVersion(tx *sqlx.Tx, preProject *PreProject, submittedBy uuid.UUID) error {
+	var next int
+	err := tx.Get(&next, "SELECT COALESCE(MAX(version_number), 0) + 1 FROM pre_project_versions WHERE pre_project_id = $1", preProject.ID)
+	if err != nil {
+		return fmt.Errorf("failed to compute next version: %w", err)
+	}
+
+	var submitter interface{}
+	if submittedBy != uuid.Nil {
+		submitter = submittedBy
+	}
+
+	query, args, err := QB.Insert("pre_project_versions").
+		Columns("pre_project_id", "version_number", "name", "description", "file_description", "file", "submitted_by").
+		Values(preProject.ID, next, preProject.Name, preProject.Description, preProject.FileDescription, preProject.File, submitter).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build version query: %w", err)
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to insert pre-project version: %w", err)
+	}
+	return nil
+}
+
+// versionContentChanged reports whether the submitted content differs from
+// the latest stored version. Admin-only fields such as degree or can_update
+// do not create a new version.
+func versionContentChanged(tx *sqlx.Tx, preProject *PreProject) (bool, error) {
+	var latest PreProjectVersion
+	err := tx.Get(&latest, `SELECT name, description, file_description, file
+		FROM pre_project_versions WHERE pre_project_id = $1
+		ORDER BY version_number DESC LIMIT 1`, preProject.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed to load latest version: %w", err)
+	}
+
+	return latest.Name != preProject.Name ||
+		latest.Description != stringValue(preProject.Description) ||
+		stringValue(latest.FileDescription) != stringValue(preProject.FileDescription) ||
+		stringValue(latest.File) != stringValue(preProject.File), nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (p *PreProjectDB) ListPreProjectVersions(preProjectID uuid.UUID) ([]PreProjectVersion, error) {
+	query, args, err := QB.Select(preProjectVersionColumns...).
+		From("pre_project_versions v").
+		LeftJoin("users u ON u.id = v.submitted_by").
+		Where(squirrel.Eq{"v.pre_project_id": preProjectID}).
+		OrderBy("v.version_number DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	versions := []PreProjectVersion{}
+	if err := p.db.Select(&versions, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list pre-project versions: %w", err)
+	}
+	return versions, nil
+}
+
+func (p *PreProjectDB) GetPreProjectVersion(preProjectID uuid.UUID, version int) (*PreProjectVersion, error) {
+	query, args, err := QB.Select(preProjectVersionColumns...).
+		From("pre_project_versions v").
+		LeftJoin("users u ON u.id = v.submitted_by").
+		Where(squirrel.Eq{"v.pre_project_id": preProjectID, "v.version_number": version}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var v PreProjectVersion
+	if err := p.db.Get(&v, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get pre-project version: %w", err)
+	}
+	return &v, nil
+}
+
+// LatestPreProjectVersion returns the newest version number, or 0 if the
+// pre-project has no history yet.
+func (p *PreProjectDB) LatestPreProjectVersion(preProjectID uuid.UUID) (int, error) {
+	var latest int
+	err := p.db.Get(&latest, "SELECT COALESCE(MAX(version_number), 0) FROM pre_project_versions WHERE pre_project_id = $1", preProjectID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get latest version: %w", err)
+	}
+	return latest, nil
+}
+
+// preProjectVersionFiles returns every distinct file referenced by the
+// pre-project's history, so they can be removed with the pre-project.
+func preProjectVersionFiles(q sqlx.Queryer, preProjectID uuid.UUID) ([]string, error) {
+	var files []string
+	err := sqlx.Select(q, &files, "SELECT DISTINCT file FROM pre_project_versions WHERE pre_project_id = $1 AND NULLIF(file, '') IS NOT NULL", preProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list version files: %w", err)
+	}
+	return files, nil
+}
+
+func DiffPreProjectVersions(from, to *PreProjectVersion) *PreProjectVersionDiff {
+	return &PreProjectVersionDiff{
+		From:            from.Version,
+		To:              to.Version,
+		NameChanged:     from.Name != to.Name,
+		FileChanged:     stringValue(from.File) != stringValue(to.File),
+		Name:            utils.DiffText(from.Name, to.Name),
+		Description:     utils.DiffText(from.Description, to.Description),
+		FileDescription: utils.DiffText(stringValue(from.FileDescription), stringValue(to.FileDescription)),
+	}
+}
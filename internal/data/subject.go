@@ -0,0 +1,363 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils"
+	"project/utils/validator"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const maxSubjects = 3
+
+var (
+	ErrSubjectNotFound  = errors.New("أحد التخصصات المختارة غير موجود")
+	ErrSubjectMergeSelf = errors.New("لا يمكن دمج التخصص مع نفسه")
+)
+
+type SubjectDB struct {
+	db *sqlx.DB
+}
+
+// Subject is an entry of the controlled vocabulary of subject areas.
+type Subject struct {
+	ID              uuid.UUID `db:"id" json:"id"`
+	Name            string    `db:"name" json:"name"`
+	Description     *string   `db:"description" json:"description,omitempty"`
+	BookCount       int       `db:"book_count" json:"book_count"`
+	PreProjectCount int       `db:"pre_project_count" json:"pre_project_count"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updated_at"`
+}
+
+func ValidateSubject(v *validator.Validator, subject *Subject) {
+	v.Check(subject.Name != "", "name", "اسم التخصص مطلوب")
+	v.Check(len(subject.Name) <= 150, "name", "يجب أن يكون اسم التخصص أقل من 150 حرف")
+	if subject.Description != nil {
+		v.Check(len(*subject.Description) <= 1000, "description", "لا يمكن لوصف التخصص أن يكون أكثر من 1000 حرف")
+	}
+}
+
+func ValidateSubjects(v *validator.Validator, subjectIDs []uuid.UUID) {
+	v.Check(len(subjectIDs) <= maxSubjects, "subjects", "لا يمكن اختيار أكثر من 3 تخصصات")
+}
+
+var subjectColumns = []string{
+	"s.id",
+	"s.name",
+	"s.description",
+	"(SELECT COUNT(*) FROM book_subjects bs WHERE bs.subject_id = s.id) AS book_count",
+	"(SELECT COUNT(*) FROM pre_project_subjects ps JOIN pre_project pp ON pp.id = ps.pre_project_id WHERE ps.subject_id = s.id AND " + preProjectActive("pp") + ") AS pre_project_count",
+	"s.created_at",
+	"s.updated_at",
+}
+
+// ListSubjects returns the vocabulary by name. A search matches names
+// containing it, ignoring case, diacritics and letter variants.
+func (s *SubjectDB) ListSubjects(search string) ([]Subject, error) {
+	builder := QB.Select(subjectColumns...).From("subjects s").OrderBy("s.name")
+	if search = strings.TrimSpace(search); search != "" {
+		builder = builder.Where("normalize_arabic(s.name) LIKE '%' || normalize_arabic(?) || '%' ESCAPE '\\'", escapeLike(search))
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	subjects := []Subject{}
+	if err := s.db.Select(&subjects, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list subjects: %w", err)
+	}
+	return subjects, nil
+}
+
+func (s *SubjectDB) GetSubject(id uuid.UUID) (*Subject, error) {
+	query, args, err := QB.Select(subjectColumns...).From("subjects s").Where(squirrel.Eq{"s.id": id}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var subject Subject
+	if err := s.db.Get(&subject, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get subject: %w", err)
+	}
+	return &subject, nil
+}
+
+func (s *SubjectDB) InsertSubject(subject *Subject) error {
+	query, args, err := QB.Insert("subjects").
+		Columns("name", "description").
+		Values(subject.Name, subject.Description).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := s.db.QueryRowx(query, args...).StructScan(subject); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to insert subject: %w", err)
+	}
+	return nil
+}
+
+// UpdateSubject renames a subject or changes its description. Projects and
+// books refer to it by id, so they follow the new name.
+func (s *SubjectDB) UpdateSubject(subject *Subject) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query, args, err := QB.Update("subjects").
+		Set("name", subject.Name).
+		Set("description", subject.Description).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": subject.ID}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to update subject: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	} else if rows == 0 {
+		return ErrRecordNotFound
+	}
+	if err := touchSubjectBooks(tx, subject.ID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *SubjectDB) DeleteSubject(id uuid.UUID) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := touchSubjectBooks(tx, id); err != nil {
+		return err
+	}
+	result, err := tx.Exec("DELETE FROM subjects WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete subject: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to check rows affected: %w", err)
+	} else if rows == 0 {
+		return ErrRecordNotFound
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// touchSubjectBooks marks the books of a subject as changed, so harvesters
+// pick up their new subject list.
+func touchSubjectBooks(tx *sqlx.Tx, subjectID uuid.UUID) error {
+	_, err := tx.Exec(`
+        UPDATE book SET updated_at = $1
+        WHERE id IN (SELECT book_id FROM book_subjects WHERE subject_id = $2)`, time.Now(), subjectID)
+	if err != nil {
+		return fmt.Errorf("failed to touch subject books: %w", err)
+	}
+	return nil
+}
+
+// MergeSubjects moves every project and book of source to target and
+// removes source, for duplicates such as "شبكات" and "شبكات الحاسوب".
+func (s *SubjectDB) MergeSubjects(sourceID, targetID uuid.UUID) error {
+	if sourceID == targetID {
+		return ErrSubjectMergeSelf
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.Get(&found, "SELECT COUNT(*) FROM (SELECT id FROM subjects WHERE id IN ($1, $2) FOR UPDATE) s", sourceID, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to lock subjects: %w", err)
+	}
+	if found != 2 {
+		return ErrRecordNotFound
+	}
+
+	if err := touchSubjectBooks(tx, sourceID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO pre_project_subjects (pre_project_id, subject_id)
+        SELECT pre_project_id, $2 FROM pre_project_subjects WHERE subject_id = $1
+        ON CONFLICT DO NOTHING`, sourceID, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to move pre-project subjects: %w", err)
+	}
+	_, err = tx.Exec(`
+        INSERT INTO book_subjects (book_id, subject_id)
+        SELECT book_id, $2 FROM book_subjects WHERE subject_id = $1
+        ON CONFLICT DO NOTHING`, sourceID, targetID)
+	if err != nil {
+		return fmt.Errorf("failed to move book subjects: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM subjects WHERE id = $1", sourceID); err != nil {
+		return fmt.Errorf("failed to delete merged subject: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE subjects SET updated_at = $1 WHERE id = $2", time.Now(), targetID); err != nil {
+		return fmt.Errorf("failed to update subject: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// CheckSubjects returns ErrSubjectNotFound unless every id is a subject.
+func (s *SubjectDB) CheckSubjects(subjectIDs []uuid.UUID) error {
+	if len(subjectIDs) == 0 {
+		return nil
+	}
+	var found int
+	err := s.db.Get(&found, "SELECT COUNT(*) FROM subjects WHERE id = ANY($1)", pq.Array(subjectIDs))
+	if err != nil {
+		return fmt.Errorf("failed to check subjects: %w", err)
+	}
+	if found != len(subjectIDs) {
+		return ErrSubjectNotFound
+	}
+	return nil
+}
+
+// setSubjects replaces the subjects of a pre-project or book.
+func setSubjects(tx *sqlx.Tx, table, column string, id uuid.UUID, subjectIDs []uuid.UUID) error {
+	_, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = $1", table, column), id)
+	if err != nil {
+		return fmt.Errorf("failed to remove existing subjects: %w", err)
+	}
+	for _, subjectID := range subjectIDs {
+		_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s, subject_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", table, column), id, subjectID)
+		if err != nil {
+			return fmt.Errorf("failed to insert subject %s: %w", subjectID, err)
+		}
+	}
+	return nil
+}
+
+// subjectsOf loads the subjects of a pre-project or book.
+func subjectsOf(q sqlx.Queryer, table, column string, id uuid.UUID) ([]Subject, error) {
+	subjects := []Subject{}
+	err := sqlx.Select(q, &subjects, fmt.Sprintf(`
+        SELECT s.id, s.name, s.description, s.created_at, s.updated_at
+        FROM %s t
+        JOIN subjects s ON s.id = t.subject_id
+        WHERE t.%s = $1
+        ORDER BY s.name`, table, column), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load subjects: %w", err)
+	}
+	return subjects, nil
+}
+
+// ListSubjectBooks returns a page of the books filed under a subject, newest
+// first.
+func (s *SubjectDB) ListSubjectBooks(subjectID uuid.UUID, page, perPage int) ([]Book, *utils.Meta, error) {
+	where := squirrel.Expr("EXISTS (SELECT 1 FROM book_subjects bs WHERE bs.book_id = b.id AND bs.subject_id = ?)", subjectID)
+
+	countQuery, countArgs, err := QB.Select("COUNT(*)").From("book b").Where(where).ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build count query: %w", err)
+	}
+	var total int
+	if err := s.db.Get(&total, countQuery, countArgs...); err != nil {
+		return nil, nil, fmt.Errorf("failed to count books: %w", err)
+	}
+
+	builder := QB.Select(
+		"b.id",
+		"b.name",
+		"b.description",
+		bookFileColumn,
+		thumbnailColumn(bookFile),
+		"b.year",
+		"b.season",
+		"b.degree",
+		"b.keywords",
+		"b.source_pre_project_id",
+		"b.created_at",
+		"b.updated_at",
+	).From("book b").Where(where).OrderBy("b.year DESC", "b.name")
+	if page > 0 && perPage > 0 {
+		builder = builder.Limit(uint64(perPage)).Offset(uint64((page - 1) * perPage))
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	books := []Book{}
+	if err := s.db.Select(&books, query, args...); err != nil {
+		return nil, nil, fmt.Errorf("failed to list subject books: %w", err)
+	}
+	return books, utils.NewMeta(total, page, perPage), nil
+}
+
+type KeywordSuggestion struct {
+	Keyword string `db:"keyword" json:"keyword"`
+	Count   int    `db:"count" json:"count"`
+}
+
+// SuggestKeywords autocompletes a keyword from those already used on books
+// and active pre-projects, most used first.
+func (s *SubjectDB) SuggestKeywords(prefix string, limit int) ([]KeywordSuggestion, error) {
+	suggestions := []KeywordSuggestion{}
+	err := s.db.Select(&suggestions, `
+        SELECT keyword, COUNT(*) AS count
+        FROM (
+            SELECT unnest(keywords) AS keyword FROM book
+            UNION ALL
+            SELECT unnest(pp.keywords) FROM pre_project pp WHERE `+preProjectActive("pp")+`
+        ) k
+        WHERE normalize_arabic(keyword) LIKE normalize_arabic($1) || '%' ESCAPE '\'
+        GROUP BY keyword
+        ORDER BY count DESC, keyword
+        LIMIT $2`, escapeLike(strings.TrimSpace(prefix)), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest keywords: %w", err)
+	}
+	return suggestions, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
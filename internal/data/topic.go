@@ -0,0 +1,458 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"project/utils/validator"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	TopicOpen     = "open"
+	TopicClosed   = "closed"
+	TopicAssigned = "assigned"
+
+	ApplicationPending   = "pending"
+	ApplicationAccepted  = "accepted"
+	ApplicationRejected  = "rejected"
+	ApplicationWithdrawn = "withdrawn"
+)
+
+var (
+	ErrTopicUnavailable   = errors.New("هذا الموضوع لم يعد متاحاً للتقديم")
+	ErrApplicationClosed  = errors.New("تمت مراجعة هذا الطلب بالفعل")
+	ErrTeamTooLarge       = errors.New("عدد أعضاء الفريق أكبر من المسموح لهذا الموضوع")
+	ErrTopicAlreadyTaken  = errors.New("تم إسناد هذا الموضوع لفريق آخر")
+	ErrTopicHasApplicants = errors.New("لا يمكن تعديل موضوع تم إسناده")
+)
+
+type TopicDB struct {
+	db *sqlx.DB
+}
+
+type ProjectTopic struct {
+	ID            uuid.UUID  `db:"id" json:"id"`
+	TeacherID     uuid.UUID  `db:"teacher_id" json:"teacher_id"`
+	TeacherName   string     `db:"teacher_name" json:"teacher_name"`
+	Title         string     `db:"title" json:"title"`
+	Description   string     `db:"description" json:"description"`
+	Prerequisites *string    `db:"prerequisites" json:"prerequisites,omitempty"`
+	MaxTeamSize   int        `db:"max_team_size" json:"max_team_size"`
+	Year          int        `db:"year" json:"year"`
+	Season        string     `db:"season" json:"season"`
+	Status        string     `db:"status" json:"status"`
+	PreProjectID  *uuid.UUID `db:"pre_project_id" json:"pre_project_id,omitempty"`
+	Applications  int        `db:"applications" json:"applications"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+type TopicApplication struct {
+	ID           uuid.UUID     `db:"id" json:"id"`
+	TopicID      uuid.UUID     `db:"topic_id" json:"topic_id"`
+	TopicTitle   string        `db:"topic_title" json:"topic_title"`
+	ApplicantID  uuid.UUID     `db:"applicant_id" json:"applicant_id"`
+	Message      *string       `db:"message" json:"message,omitempty"`
+	Status       string        `db:"status" json:"status"`
+	PreProjectID *uuid.UUID    `db:"pre_project_id" json:"pre_project_id,omitempty"`
+	ReviewedAt   *time.Time    `db:"reviewed_at" json:"reviewed_at,omitempty"`
+	CreatedAt    time.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time     `db:"updated_at" json:"updated_at"`
+	Students     []UserDetails `db:"-" json:"students"`
+}
+
+// TopicFilter narrows ListTopics; zero values do not filter.
+type TopicFilter struct {
+	Year      int
+	Season    string
+	Status    string
+	TeacherID *uuid.UUID
+}
+
+func ValidateTopic(v *validator.Validator, topic *ProjectTopic) {
+	v.Check(len(topic.Title) >= 3, "title", "يجب أن يكون عنوان الموضوع على الأقل 3 أحرف")
+	v.Check(len(topic.Title) <= 255, "title", "يجب أن يكون عنوان الموضوع أقل من 255 حرف")
+	v.Check(len(topic.Description) >= 10, "description", "يجب أن يكون وصف الموضوع على الأقل 10 أحرف")
+	v.Check(len(topic.Description) <= 1500, "description", "لا يمكن لوصف الموضوع أن يكون أكثر من 1500 حرف")
+	if topic.Prerequisites != nil {
+		v.Check(len(*topic.Prerequisites) <= 1500, "prerequisites", "المتطلبات طويلة جداً")
+	}
+	v.Check(topic.MaxTeamSize >= 1 && topic.MaxTeamSize <= 3, "max_team_size", "يجب أن يكون حجم الفريق بين 1 و 3")
+	v.Check(topic.Year > 0, "year", "السنة مطلوبة")
+	v.Check(validator.In(topic.Season, "spring", "fall"), "season", "يجب اختيار موسم ربيع أو خريف")
+	v.Check(validator.In(topic.Status, TopicOpen, TopicClosed), "status", "حالة الموضوع غير صالحة")
+}
+
+var topicColumns = []string{
+	"t.*",
+	"u.name AS teacher_name",
+	"(SELECT COUNT(*) FROM topic_applications a WHERE a.topic_id = t.id AND a.status = 'pending') AS applications",
+}
+
+func (d *TopicDB) InsertTopic(topic *ProjectTopic) error {
+	query, args, err := QB.Insert("project_topics").
+		Columns("teacher_id", "title", "description", "prerequisites", "max_team_size", "year", "season", "status").
+		Values(topic.TeacherID, topic.Title, topic.Description, topic.Prerequisites, topic.MaxTeamSize,
+			topic.Year, topic.Season, topic.Status).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := d.db.QueryRowx(query, args...).StructScan(topic); err != nil {
+		return fmt.Errorf("failed to insert topic: %w", err)
+	}
+	return nil
+}
+
+// UpdateTopic edits a topic that has not been assigned to a team yet.
+func (d *TopicDB) UpdateTopic(topic *ProjectTopic) error {
+	query, args, err := QB.Update("project_topics").
+		Set("title", topic.Title).
+		Set("description", topic.Description).
+		Set("prerequisites", topic.Prerequisites).
+		Set("max_team_size", topic.MaxTeamSize).
+		Set("year", topic.Year).
+		Set("season", topic.Season).
+		Set("status", topic.Status).
+		Set("updated_at", time.Now()).
+		Where(squirrel.Eq{"id": topic.ID}).
+		Where(squirrel.NotEq{"status": TopicAssigned}).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	result, err := d.db.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update topic: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrTopicHasApplicants
+	}
+	return nil
+}
+
+func (d *TopicDB) DeleteTopic(topicID uuid.UUID) error {
+	result, err := d.db.Exec("DELETE FROM project_topics WHERE id = $1", topicID)
+	if err != nil {
+		return fmt.Errorf("failed to delete topic: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+func (d *TopicDB) GetTopic(topicID uuid.UUID) (*ProjectTopic, error) {
+	query, args, err := QB.Select(topicColumns...).
+		From("project_topics t").
+		Join("users u ON u.id = t.teacher_id").
+		Where(squirrel.Eq{"t.id": topicID}).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	var topic ProjectTopic
+	if err := d.db.Get(&topic, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get topic: %w", err)
+	}
+	return &topic, nil
+}
+
+func (d *TopicDB) ListTopics(filter TopicFilter) ([]ProjectTopic, error) {
+	where := squirrel.Eq{}
+	if filter.Year > 0 {
+		where["t.year"] = filter.Year
+	}
+	if filter.Season != "" {
+		where["t.season"] = filter.Season
+	}
+	if filter.Status != "" {
+		where["t.status"] = filter.Status
+	}
+	if filter.TeacherID != nil {
+		where["t.teacher_id"] = *filter.TeacherID
+	}
+
+	query, args, err := QB.Select(topicColumns...).
+		From("project_topics t").
+		Join("users u ON u.id = t.teacher_id").
+		Where(where).
+		OrderBy("t.created_at DESC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	topics := []ProjectTopic{}
+	if err := d.db.Select(&topics, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list topics: %w", err)
+	}
+	return topics, nil
+}
+
+// InsertApplication files a team's application. studentIDs is the whole
+// team, the applicant included.
+func (d *TopicDB) InsertApplication(application *TopicApplication, studentIDs []uuid.UUID) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var topic struct {
+		Status      string `db:"status"`
+		MaxTeamSize int    `db:"max_team_size"`
+	}
+	err = tx.Get(&topic, "SELECT status, max_team_size FROM project_topics WHERE id = $1 FOR SHARE", application.TopicID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return fmt.Errorf("failed to lock topic: %w", err)
+	}
+	if topic.Status != TopicOpen {
+		return ErrTopicUnavailable
+	}
+	if len(studentIDs) > topic.MaxTeamSize {
+		return ErrTeamTooLarge
+	}
+
+	query, args, err := QB.Insert("topic_applications").
+		Columns("topic_id", "applicant_id", "message").
+		Values(application.TopicID, application.ApplicantID, application.Message).
+		Suffix("RETURNING id, status, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(application); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrDuplicatedKey
+		}
+		return fmt.Errorf("failed to insert application: %w", err)
+	}
+
+	for _, studentID := range studentIDs {
+		_, err := tx.Exec("INSERT INTO topic_application_students (application_id, student_id) VALUES ($1, $2)",
+			application.ID, studentID)
+		if err != nil {
+			return fmt.Errorf("failed to insert application student: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (d *TopicDB) loadApplicationStudents(applications []TopicApplication) error {
+	for i := range applications {
+		applications[i].Students = []UserDetails{}
+		err := d.db.Select(&applications[i].Students, `
+            SELECT u.id, u.name, u.email
+            FROM topic_application_students s
+            JOIN users u ON u.id = s.student_id
+            WHERE s.application_id = $1
+            ORDER BY u.name`, applications[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to load application students: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *TopicDB) listApplications(where squirrel.Sqlizer) ([]TopicApplication, error) {
+	query, args, err := QB.Select("a.*", "t.title AS topic_title").
+		From("topic_applications a").
+		Join("project_topics t ON t.id = a.topic_id").
+		Where(where).
+		OrderBy("a.created_at ASC").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+
+	applications := []TopicApplication{}
+	if err := d.db.Select(&applications, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list applications: %w", err)
+	}
+	if err := d.loadApplicationStudents(applications); err != nil {
+		return nil, err
+	}
+	return applications, nil
+}
+
+func (d *TopicDB) GetApplication(applicationID uuid.UUID) (*TopicApplication, error) {
+	applications, err := d.listApplications(squirrel.Eq{"a.id": applicationID})
+	if err != nil {
+		return nil, err
+	}
+	if len(applications) == 0 {
+		return nil, ErrRecordNotFound
+	}
+	return &applications[0], nil
+}
+
+func (d *TopicDB) ListApplications(topicID uuid.UUID) ([]TopicApplication, error) {
+	return d.listApplications(squirrel.Eq{"a.topic_id": topicID})
+}
+
+// ListStudentApplications returns the applications the student is part of,
+// whether they filed them or were named on the team.
+func (d *TopicDB) ListStudentApplications(studentID uuid.UUID) ([]TopicApplication, error) {
+	return d.listApplications(squirrel.Expr(
+		"a.id IN (SELECT application_id FROM topic_application_students WHERE student_id = ?)", studentID))
+}
+
+// setApplicationStatus moves a pending application to a final status.
+func setApplicationStatus(q sqlx.Execer, applicationID uuid.UUID, status string, preProjectID *uuid.UUID) error {
+	now := time.Now()
+	result, err := q.Exec(`
+        UPDATE topic_applications
+        SET status = $1, pre_project_id = $2, reviewed_at = $3, updated_at = $3
+        WHERE id = $4 AND status = 'pending'`, status, preProjectID, now, applicationID)
+	if err != nil {
+		return fmt.Errorf("failed to update application: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return ErrApplicationClosed
+	}
+	return nil
+}
+
+func (d *TopicDB) RejectApplication(applicationID uuid.UUID) error {
+	return setApplicationStatus(d.db, applicationID, ApplicationRejected, nil)
+}
+
+func (d *TopicDB) WithdrawApplication(applicationID uuid.UUID) error {
+	return setApplicationStatus(d.db, applicationID, ApplicationWithdrawn, nil)
+}
+
+// AcceptApplication assigns the topic to the application's team in one
+// transaction: it creates the pre-project with the topic's teacher as the
+// accepted advisor, closes the topic and declines the other applications.
+func (d *TopicDB) AcceptApplication(applicationID uuid.UUID) (*PreProject, error) {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var application TopicApplication
+	err = tx.Get(&application, "SELECT *, '' AS topic_title FROM topic_applications WHERE id = $1", applicationID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRecordNotFound
+		}
+		return nil, fmt.Errorf("failed to get application: %w", err)
+	}
+	if application.Status != ApplicationPending {
+		return nil, ErrApplicationClosed
+	}
+
+	var topic ProjectTopic
+	err = tx.Get(&topic, "SELECT t.*, '' AS teacher_name, 0 AS applications FROM project_topics t WHERE t.id = $1 FOR UPDATE", application.TopicID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock topic: %w", err)
+	}
+	if topic.Status == TopicAssigned {
+		return nil, ErrTopicAlreadyTaken
+	}
+
+	var studentIDs []uuid.UUID
+	err = tx.Select(&studentIDs, "SELECT student_id FROM topic_application_students WHERE application_id = $1", applicationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get application students: %w", err)
+	}
+	if len(studentIDs) > topic.MaxTeamSize {
+		return nil, ErrTeamTooLarge
+	}
+
+	// A student may have joined another project since applying
+	var busy bool
+	err = tx.Get(&busy, `
+        SELECT EXISTS (
+            SELECT 1
+            FROM pre_project_students ps
+            JOIN pre_project pp ON pp.id = ps.pre_project_id
+            WHERE `+preProjectActive("pp")+` AND ps.student_id = ANY($1)
+        )`, pq.Array(studentIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to check students: %w", err)
+	}
+	if busy {
+		return nil, ErrStudentHasProject
+	}
+
+	description := topic.Description
+	preProject := &PreProject{
+		Name:            topic.Title,
+		Description:     &description,
+		ProjectOwner:    application.ApplicantID,
+		AcceptedAdvisor: &topic.TeacherID,
+		Year:            topic.Year,
+		Season:          topic.Season,
+		CanUpdate:       true,
+	}
+	query, args, err := QB.Insert("pre_project").
+		Columns("name", "description", "project_owner", "accepted_advisor", "year", "season", "can_update").
+		Values(preProject.Name, preProject.Description, preProject.ProjectOwner, preProject.AcceptedAdvisor,
+			preProject.Year, preProject.Season, preProject.CanUpdate).
+		Suffix("RETURNING id, created_at, updated_at").
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build query: %w", err)
+	}
+	if err := tx.QueryRowx(query, args...).StructScan(preProject); err != nil {
+		return nil, fmt.Errorf("failed to insert pre-project: %w", err)
+	}
+
+	for _, studentID := range studentIDs {
+		_, err := tx.Exec("INSERT INTO pre_project_students (pre_project_id, student_id) VALUES ($1, $2)", preProject.ID, studentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to insert student association: %w", err)
+		}
+	}
+	_, err = tx.Exec(`
+        INSERT INTO advisor_responses (pre_project_id, advisor_id, status, reviewed_version)
+        VALUES ($1, $2, 'accepted', 1)`, preProject.ID, topic.TeacherID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert advisor response: %w", err)
+	}
+	if err := insertPreProjectVersion(tx, preProject, application.ApplicantID); err != nil {
+		return nil, err
+	}
+
+	if err := setApplicationStatus(tx, applicationID, ApplicationAccepted, &preProject.ID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+        UPDATE topic_applications
+        SET status = 'rejected', reviewed_at = $1, updated_at = $1
+        WHERE topic_id = $2 AND status = 'pending'`, time.Now(), topic.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decline other applications: %w", err)
+	}
+	_, err = tx.Exec("UPDATE project_topics SET status = $1, pre_project_id = $2, updated_at = $3 WHERE id = $4",
+		TopicAssigned, preProject.ID, time.Now(), topic.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign topic: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return preProject, nil
+}
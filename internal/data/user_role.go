@@ -92,8 +92,8 @@ func (u *UserRoleDB) GetTeachers(queryParams url.Values) ([]User, *utils.Meta, e
 		"users.updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
-	searchCols := []string{"users.name", "users.email"}     // Fields for search functionality
-	additionalFilters := []string{"user_roles.role_id = 2"} // Ensure only teachers are retrieved
+	searchCols := []string{"users.name", "users.email"}                           // Fields for search functionality
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"user_roles.role_id": 2}} // Ensure only teachers are retrieved
 
 	// Prepare destination for query results
 	var users []User
@@ -119,8 +119,8 @@ func (u *UserRoleDB) GetStudents(queryParams url.Values) ([]User, *utils.Meta, e
 		"users.updated_at",
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
-	searchCols := []string{"users.name", "users.email"}     // Fields for search functionality
-	additionalFilters := []string{"user_roles.role_id = 3"} // Ensure only teachers are retrieved
+	searchCols := []string{"users.name", "users.email"}                           // Fields for search functionality
+	additionalFilters := []squirrel.Sqlizer{squirrel.Eq{"user_roles.role_id": 3}} // Ensure only teachers are retrieved
 
 	// Prepare destination for query results
 	var users []User
@@ -147,8 +147,8 @@ func (u *UserRoleDB) GetGraduationStudents(queryParams url.Values) ([]User, *uti
 		fmt.Sprintf("CASE WHEN NULLIF(users.image, '') IS NOT NULL THEN FORMAT('%s/%%s', users.image) ELSE NULL END AS image", Domain),
 	}
 	searchCols := []string{"users.name", "users.email"}
-	additionalFilters := []string{
-		"user_roles.role_id = 4",
+	additionalFilters := []squirrel.Sqlizer{
+		squirrel.Eq{"user_roles.role_id": 4},
 	}
 	var users []User
 
@@ -0,0 +1,2 @@
+ALTER TABLE advisor_responses DROP COLUMN IF EXISTS reviewed_version;
+DROP TABLE IF EXISTS pre_project_versions;
@@ -0,0 +1,24 @@
+-- Immutable snapshots of every submitted change to a pre-project
+CREATE TABLE pre_project_versions (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    version_number INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    file_description TEXT,
+    file VARCHAR(255),
+    submitted_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pre_project_id, version_number)
+);
+
+CREATE INDEX idx_pre_project_versions_pre_project_id ON pre_project_versions(pre_project_id);
+
+-- The version an advisor last reviewed, so they can diff against the latest one
+ALTER TABLE advisor_responses
+ADD COLUMN reviewed_version INTEGER;
+
+-- Existing pre-projects start their history at version 1
+INSERT INTO pre_project_versions (pre_project_id, version_number, name, description, file_description, file, submitted_by, created_at)
+SELECT id, 1, name, description, file_description, file, project_owner, updated_at
+FROM pre_project;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS pre_project_window_overrides;
+DROP TABLE IF EXISTS academic_terms;
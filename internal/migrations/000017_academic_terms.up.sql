@@ -0,0 +1,33 @@
+-- Academic calendar: one row per season with its submission windows
+CREATE TABLE academic_terms (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    year INTEGER NOT NULL,
+    season VARCHAR(10) CHECK (season IN ('spring', 'fall')) NOT NULL,
+    proposal_open TIMESTAMP NOT NULL,
+    proposal_close TIMESTAMP NOT NULL,
+    advisor_response_deadline TIMESTAMP NOT NULL,
+    final_submission_deadline TIMESTAMP NOT NULL,
+    defense_start TIMESTAMP NOT NULL,
+    defense_end TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (year, season),
+    CHECK (proposal_open < proposal_close),
+    CHECK (proposal_close <= advisor_response_deadline),
+    CHECK (advisor_response_deadline <= final_submission_deadline),
+    CHECK (defense_start <= defense_end)
+);
+
+-- Per-project exceptions to the calendar granted by an admin
+CREATE TABLE pre_project_window_overrides (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    window_name VARCHAR(30) CHECK (window_name IN ('proposal', 'advisor_response', 'final_submission')) NOT NULL,
+    expires_at TIMESTAMP,
+    reason TEXT,
+    granted_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pre_project_id, window_name)
+);
+
+CREATE INDEX idx_pre_project_window_overrides_pre_project_id ON pre_project_window_overrides(pre_project_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS committee_availability;
+DROP TABLE IF EXISTS defense_committee;
+DROP TABLE IF EXISTS defenses;
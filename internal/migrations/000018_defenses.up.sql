@@ -0,0 +1,37 @@
+-- Scheduled defense (viva) of a pre-project
+CREATE TABLE defenses (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL UNIQUE REFERENCES pre_project(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP NOT NULL,
+    duration_minutes INTEGER NOT NULL CHECK (duration_minutes > 0),
+    room VARCHAR(100) NOT NULL,
+    advisor_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_defenses_starts_at ON defenses(starts_at);
+CREATE INDEX idx_defenses_room ON defenses(room);
+
+-- Committee members sitting on a defense (the advisor and the discussants)
+CREATE TABLE defense_committee (
+    defense_id uuid NOT NULL REFERENCES defenses(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) CHECK (role IN ('advisor', 'discussant')) NOT NULL,
+    PRIMARY KEY (defense_id, user_id)
+);
+
+CREATE INDEX idx_defense_committee_user_id ON defense_committee(user_id);
+
+-- Time slots in which a teacher declared they can sit on a committee
+CREATE TABLE committee_availability (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (starts_at < ends_at)
+);
+
+CREATE INDEX idx_committee_availability_user_id ON committee_availability(user_id);
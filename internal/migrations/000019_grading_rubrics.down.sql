@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS score_sheet_items;
+DROP TABLE IF EXISTS score_sheets;
+DROP TABLE IF EXISTS rubric_criteria;
+DROP TABLE IF EXISTS rubrics;
@@ -0,0 +1,48 @@
+-- Grading rubric of a term: weighted criteria and the rule that turns
+-- examiner score sheets into the final degree
+CREATE TABLE rubrics (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    year INTEGER NOT NULL,
+    season VARCHAR(10) CHECK (season IN ('spring', 'fall')) NOT NULL,
+    name VARCHAR(200) NOT NULL,
+    aggregation_rule VARCHAR(20) CHECK (aggregation_rule IN ('weighted_average', 'drop_outlier', 'advisor_share')) NOT NULL DEFAULT 'weighted_average',
+    advisor_share NUMERIC(4, 3) NOT NULL DEFAULT 0.5 CHECK (advisor_share >= 0 AND advisor_share <= 1),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (year, season)
+);
+
+CREATE TABLE rubric_criteria (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    rubric_id uuid NOT NULL REFERENCES rubrics(id) ON DELETE CASCADE,
+    name VARCHAR(200) NOT NULL,
+    description TEXT,
+    weight NUMERIC(6, 2) NOT NULL CHECK (weight > 0),
+    max_points INTEGER NOT NULL CHECK (max_points > 0),
+    position INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_rubric_criteria_rubric_id ON rubric_criteria(rubric_id);
+
+-- One score sheet per examiner (accepted advisor or discussant) per project
+CREATE TABLE score_sheets (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    examiner_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) CHECK (role IN ('advisor', 'discussant')) NOT NULL,
+    rubric_id uuid NOT NULL REFERENCES rubrics(id) ON DELETE RESTRICT,
+    comment TEXT,
+    submitted_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pre_project_id, examiner_id)
+);
+
+CREATE INDEX idx_score_sheets_pre_project_id ON score_sheets(pre_project_id);
+
+CREATE TABLE score_sheet_items (
+    sheet_id uuid NOT NULL REFERENCES score_sheets(id) ON DELETE CASCADE,
+    criterion_id uuid NOT NULL REFERENCES rubric_criteria(id) ON DELETE CASCADE,
+    points NUMERIC(6, 2) NOT NULL CHECK (points >= 0),
+    PRIMARY KEY (sheet_id, criterion_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS progress_reports;
+DROP TABLE IF EXISTS project_milestones;
+DROP TABLE IF EXISTS milestone_templates;
@@ -0,0 +1,47 @@
+-- Milestones every accepted project of a term goes through
+CREATE TABLE milestone_templates (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    year INTEGER NOT NULL,
+    season VARCHAR(10) CHECK (season IN ('spring', 'fall')) NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    description TEXT,
+    due_date TIMESTAMP NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_milestone_templates_term ON milestone_templates(year, season);
+
+-- A template instantiated for one pre-project
+CREATE TABLE project_milestones (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    template_id uuid REFERENCES milestone_templates(id) ON DELETE SET NULL,
+    title VARCHAR(200) NOT NULL,
+    description TEXT,
+    due_date TIMESTAMP NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) CHECK (status IN ('pending', 'submitted', 'approved', 'changes_requested')) NOT NULL DEFAULT 'pending',
+    completed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pre_project_id, template_id)
+);
+
+CREATE INDEX idx_project_milestones_due_date ON project_milestones(due_date) WHERE status <> 'approved';
+
+CREATE TABLE progress_reports (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    milestone_id uuid NOT NULL REFERENCES project_milestones(id) ON DELETE CASCADE,
+    submitted_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    content TEXT NOT NULL,
+    file VARCHAR(255),
+    status VARCHAR(20) CHECK (status IN ('submitted', 'approved', 'changes_requested')) NOT NULL DEFAULT 'submitted',
+    advisor_comment TEXT,
+    reviewed_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_progress_reports_milestone_id ON progress_reports(milestone_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS meeting_action_items;
+DROP TABLE IF EXISTS meeting_participants;
+DROP TABLE IF EXISTS supervision_meetings;
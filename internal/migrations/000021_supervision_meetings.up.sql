@@ -0,0 +1,36 @@
+-- Advisor-student supervision meetings of a pre-project
+CREATE TABLE supervision_meetings (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    held_at TIMESTAMP NOT NULL,
+    agenda TEXT NOT NULL,
+    minutes TEXT,
+    created_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_supervision_meetings_pre_project_id ON supervision_meetings(pre_project_id, held_at);
+
+-- Team members and advisor invited to a meeting, whether they attended and,
+-- for students, when they acknowledged the minutes
+CREATE TABLE meeting_participants (
+    meeting_id uuid NOT NULL REFERENCES supervision_meetings(id) ON DELETE CASCADE,
+    user_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) CHECK (role IN ('advisor', 'student')) NOT NULL,
+    attended BOOLEAN NOT NULL DEFAULT FALSE,
+    acknowledged_at TIMESTAMP,
+    PRIMARY KEY (meeting_id, user_id)
+);
+
+CREATE TABLE meeting_action_items (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    meeting_id uuid NOT NULL REFERENCES supervision_meetings(id) ON DELETE CASCADE,
+    description TEXT NOT NULL,
+    owner_id uuid REFERENCES users(id) ON DELETE SET NULL,
+    due_date TIMESTAMP,
+    done_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_meeting_action_items_meeting_id ON meeting_action_items(meeting_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_pre_project_active;
+ALTER TABLE book DROP COLUMN IF EXISTS source_pre_project_id;
+ALTER TABLE pre_project DROP COLUMN IF EXISTS archived_at;
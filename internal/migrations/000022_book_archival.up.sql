@@ -0,0 +1,11 @@
+-- Archiving a pre-project keeps its row (versions, grades, reports, meetings)
+-- and hides it behind archived_at; the book links back to it so the transfer
+-- can be undone
+ALTER TABLE pre_project
+ADD COLUMN archived_at TIMESTAMP;
+
+ALTER TABLE book
+ADD COLUMN source_pre_project_id uuid UNIQUE REFERENCES pre_project(id) ON DELETE SET NULL;
+
+-- The per-term listings only look at the pre-projects still under way
+CREATE INDEX idx_pre_project_active ON pre_project(year, season) WHERE archived_at IS NULL;
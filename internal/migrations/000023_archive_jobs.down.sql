@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS archive_job_items;
+DROP TABLE IF EXISTS archive_jobs;
@@ -0,0 +1,31 @@
+-- End-of-season bulk archival runs and their per-project outcome
+CREATE TABLE archive_jobs (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    year INTEGER NOT NULL,
+    season VARCHAR(10) CHECK (season IN ('spring', 'fall')) NOT NULL,
+    status VARCHAR(20) CHECK (status IN ('running', 'completed', 'failed')) NOT NULL DEFAULT 'running',
+    total INTEGER NOT NULL DEFAULT 0,
+    processed INTEGER NOT NULL DEFAULT 0,
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    started_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP
+);
+
+-- Only one run per term at a time
+CREATE UNIQUE INDEX idx_archive_jobs_running ON archive_jobs(year, season) WHERE status = 'running';
+
+CREATE TABLE archive_job_items (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    job_id uuid NOT NULL REFERENCES archive_jobs(id) ON DELETE CASCADE,
+    pre_project_id uuid NOT NULL,
+    project_name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) CHECK (status IN ('archived', 'failed')) NOT NULL,
+    book_id uuid REFERENCES book(id) ON DELETE SET NULL,
+    message TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_archive_job_items_job_id ON archive_job_items(job_id);
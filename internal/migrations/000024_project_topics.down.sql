@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS topic_application_students;
+DROP TABLE IF EXISTS topic_applications;
+DROP TABLE IF EXISTS project_topics;
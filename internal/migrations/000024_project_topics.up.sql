@@ -0,0 +1,42 @@
+-- Project ideas published by teachers for student teams to apply to
+CREATE TABLE project_topics (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    teacher_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    prerequisites TEXT,
+    max_team_size INTEGER NOT NULL CHECK (max_team_size BETWEEN 1 AND 3),
+    year INTEGER NOT NULL,
+    season VARCHAR(10) CHECK (season IN ('spring', 'fall')) NOT NULL,
+    status VARCHAR(20) CHECK (status IN ('open', 'closed', 'assigned')) NOT NULL DEFAULT 'open',
+    pre_project_id uuid REFERENCES pre_project(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_project_topics_teacher_id ON project_topics(teacher_id);
+CREATE INDEX idx_project_topics_term ON project_topics(year, season);
+
+CREATE TABLE topic_applications (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    topic_id uuid NOT NULL REFERENCES project_topics(id) ON DELETE CASCADE,
+    applicant_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message TEXT,
+    status VARCHAR(20) CHECK (status IN ('pending', 'accepted', 'rejected', 'withdrawn')) NOT NULL DEFAULT 'pending',
+    pre_project_id uuid REFERENCES pre_project(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (topic_id, applicant_id)
+);
+
+CREATE INDEX idx_topic_applications_applicant_id ON topic_applications(applicant_id);
+
+-- The team applying, the applicant included
+CREATE TABLE topic_application_students (
+    application_id uuid NOT NULL REFERENCES topic_applications(id) ON DELETE CASCADE,
+    student_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (application_id, student_id)
+);
+
+CREATE INDEX idx_topic_application_students_student_id ON topic_application_students(student_id);
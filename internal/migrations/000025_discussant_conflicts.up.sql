@@ -0,0 +1,17 @@
+-- Declared conflicts of interest: the discussant must not examine projects of
+-- the given user (a student or a teacher) or the given project
+CREATE TABLE discussant_conflicts (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    discussant_id uuid NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+    pre_project_id uuid REFERENCES pre_project(id) ON DELETE CASCADE,
+    reason TEXT,
+    declared_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (user_id IS NOT NULL OR pre_project_id IS NOT NULL),
+    CHECK (user_id IS NULL OR user_id <> discussant_id)
+);
+
+CREATE INDEX idx_discussant_conflicts_discussant_id ON discussant_conflicts(discussant_id);
+CREATE UNIQUE INDEX idx_discussant_conflicts_unique
+    ON discussant_conflicts(discussant_id, COALESCE(user_id, '00000000-0000-0000-0000-000000000000'), COALESCE(pre_project_id, '00000000-0000-0000-0000-000000000000'));
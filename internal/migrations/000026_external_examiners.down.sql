@@ -0,0 +1,11 @@
+DELETE FROM score_sheets WHERE external_examiner_id IS NOT NULL;
+ALTER TABLE score_sheets
+    DROP CONSTRAINT IF EXISTS score_sheets_external_examiner_unique,
+    DROP CONSTRAINT IF EXISTS score_sheets_one_examiner,
+    DROP COLUMN IF EXISTS external_examiner_id,
+    ALTER COLUMN examiner_id SET NOT NULL;
+
+DROP TABLE IF EXISTS external_examiner_links;
+DROP TABLE IF EXISTS book_external_discussants;
+DROP TABLE IF EXISTS pre_project_external_discussants;
+DROP TABLE IF EXISTS external_examiners;
@@ -0,0 +1,49 @@
+-- Examiners from other universities; they have no user account and reach
+-- their assigned projects through time-limited links
+CREATE TABLE external_examiners (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL UNIQUE,
+    affiliation VARCHAR(255),
+    created_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE pre_project_external_discussants (
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    examiner_id uuid NOT NULL REFERENCES external_examiners(id) ON DELETE CASCADE,
+    PRIMARY KEY (pre_project_id, examiner_id)
+);
+
+CREATE INDEX idx_pre_project_external_discussants_examiner_id ON pre_project_external_discussants(examiner_id);
+
+CREATE TABLE book_external_discussants (
+    book_id uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    examiner_id uuid NOT NULL REFERENCES external_examiners(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, examiner_id)
+);
+
+CREATE INDEX idx_book_external_discussants_examiner_id ON book_external_discussants(examiner_id);
+
+-- Only the SHA-256 of a link's token is stored
+CREATE TABLE external_examiner_links (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    examiner_id uuid NOT NULL REFERENCES external_examiners(id) ON DELETE CASCADE,
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_external_examiner_links_assignment ON external_examiner_links(pre_project_id, examiner_id);
+
+-- A score sheet belongs either to a user or to an external examiner
+ALTER TABLE score_sheets
+    ALTER COLUMN examiner_id DROP NOT NULL,
+    ADD COLUMN external_examiner_id uuid REFERENCES external_examiners(id) ON DELETE CASCADE,
+    ADD CONSTRAINT score_sheets_one_examiner CHECK (num_nonnulls(examiner_id, external_examiner_id) = 1),
+    ADD CONSTRAINT score_sheets_external_examiner_unique UNIQUE (pre_project_id, external_examiner_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS project_closures;
+ALTER TABLE pre_project DROP COLUMN IF EXISTS closure;
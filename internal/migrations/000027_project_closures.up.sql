@@ -0,0 +1,23 @@
+-- How a pre-project ended when it did not become a book. The row is kept,
+-- hidden through archived_at, so its history stays available
+ALTER TABLE pre_project
+ADD COLUMN closure VARCHAR(20) CHECK (closure IN ('withdrawn', 'cancelled'));
+
+-- Student withdrawal requests and admin cancellations
+CREATE TABLE project_closures (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    kind VARCHAR(20) CHECK (kind IN ('withdrawal', 'cancellation')) NOT NULL,
+    status VARCHAR(20) CHECK (status IN ('pending', 'approved', 'rejected', 'retracted', 'superseded')) NOT NULL DEFAULT 'pending',
+    reason TEXT NOT NULL,
+    requested_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    review_note TEXT,
+    reviewed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_project_closures_pre_project_id ON project_closures(pre_project_id);
+
+-- At most one open withdrawal request per project
+CREATE UNIQUE INDEX idx_project_closures_one_pending ON project_closures(pre_project_id) WHERE status = 'pending';
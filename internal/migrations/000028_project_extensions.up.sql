@@ -0,0 +1,16 @@
+-- Carry-overs of unfinished pre-projects from one term to the next
+CREATE TABLE project_extensions (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    from_year INTEGER NOT NULL,
+    from_season VARCHAR(10) CHECK (from_season IN ('spring', 'fall')) NOT NULL,
+    to_year INTEGER NOT NULL,
+    to_season VARCHAR(10) CHECK (to_season IN ('spring', 'fall')) NOT NULL,
+    reason TEXT,
+    extended_by uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (pre_project_id, from_year, from_season)
+);
+
+CREATE INDEX idx_project_extensions_pre_project_id ON project_extensions(pre_project_id);
+CREATE INDEX idx_project_extensions_from_term ON project_extensions(from_year, from_season);
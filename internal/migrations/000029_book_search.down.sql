@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS users_book_authors ON users;
+DROP TRIGGER IF EXISTS book_students_authors ON book_students;
+DROP FUNCTION IF EXISTS users_book_authors_trigger();
+DROP FUNCTION IF EXISTS book_students_authors_trigger();
+DROP FUNCTION IF EXISTS refresh_book_authors(uuid);
+
+DROP INDEX IF EXISTS idx_book_search_vector;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS book_search_vector(TEXT, TEXT, TEXT);
+DROP FUNCTION IF EXISTS normalize_arabic(TEXT);
+
+ALTER TABLE book DROP COLUMN IF EXISTS authors;
+
+CREATE INDEX idx_book_name ON book USING gin (to_tsvector('english', name));
+CREATE INDEX idx_book_description ON book USING gin (to_tsvector('english', description));
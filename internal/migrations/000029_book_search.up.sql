@@ -0,0 +1,72 @@
+-- Student names, kept in sync by triggers so the search vector can be a
+-- generated column of the book row
+ALTER TABLE book
+ADD COLUMN authors TEXT NOT NULL DEFAULT '';
+
+-- Mirrors utils.NormalizeArabicText: strips diacritics and tatweel and folds
+-- alef, alef maqsura and ta marbuta variants
+CREATE OR REPLACE FUNCTION normalize_arabic(input TEXT) RETURNS TEXT AS $$
+    SELECT translate(
+        regexp_replace(lower(COALESCE(input, '')), '[\u0610-\u061A\u064B-\u065F\u0670\u06D6-\u06ED\u0640]', '', 'g'),
+        'أإآٱىة',
+        'اااايه'
+    )
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+-- Weighted document of a book under the Arabic and English configurations:
+-- name (A), authors (B), description (C)
+CREATE OR REPLACE FUNCTION book_search_vector(name TEXT, description TEXT, authors TEXT) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('arabic', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('english', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('simple', normalize_arabic(authors)), 'B') ||
+        setweight(to_tsvector('arabic', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('english', normalize_arabic(description)), 'C')
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE book
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (book_search_vector(name, description, authors)) STORED;
+
+CREATE INDEX idx_book_search_vector ON book USING gin (search_vector);
+
+DROP INDEX IF EXISTS idx_book_name;
+DROP INDEX IF EXISTS idx_book_description;
+
+CREATE OR REPLACE FUNCTION refresh_book_authors(target uuid) RETURNS void AS $$
+    UPDATE book SET authors = COALESCE((
+        SELECT string_agg(u.name, ' ' ORDER BY u.name)
+        FROM book_students bs
+        JOIN users u ON u.id = bs.student_id
+        WHERE bs.book_id = target
+    ), '')
+    WHERE id = target;
+$$ LANGUAGE sql;
+
+CREATE OR REPLACE FUNCTION book_students_authors_trigger() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM refresh_book_authors(OLD.book_id);
+    ELSE
+        PERFORM refresh_book_authors(NEW.book_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_students_authors
+AFTER INSERT OR DELETE ON book_students
+FOR EACH ROW EXECUTE FUNCTION book_students_authors_trigger();
+
+CREATE OR REPLACE FUNCTION users_book_authors_trigger() RETURNS trigger AS $$
+BEGIN
+    PERFORM refresh_book_authors(bs.book_id) FROM book_students bs WHERE bs.student_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_book_authors
+AFTER UPDATE OF name ON users
+FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+EXECUTE FUNCTION users_book_authors_trigger();
+
+SELECT refresh_book_authors(id) FROM book;
@@ -0,0 +1,24 @@
+DROP INDEX IF EXISTS idx_book_search_vector;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS book_search_vector(TEXT, TEXT, TEXT[], TEXT);
+
+CREATE OR REPLACE FUNCTION book_search_vector(name TEXT, description TEXT, authors TEXT) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('arabic', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('english', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('simple', normalize_arabic(authors)), 'B') ||
+        setweight(to_tsvector('arabic', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('english', normalize_arabic(description)), 'C')
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE book
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (book_search_vector(name, description, authors)) STORED;
+
+CREATE INDEX idx_book_search_vector ON book USING gin (search_vector);
+
+DROP INDEX IF EXISTS idx_book_keywords;
+ALTER TABLE book DROP COLUMN IF EXISTS keywords;
+ALTER TABLE pre_project DROP COLUMN IF EXISTS keywords;
+DROP TABLE IF EXISTS book_subjects;
+DROP TABLE IF EXISTS pre_project_subjects;
+DROP TABLE IF EXISTS subjects;
@@ -0,0 +1,55 @@
+-- Controlled vocabulary of subject areas, managed by admins
+CREATE TABLE subjects (
+    id uuid NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(150) NOT NULL,
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Names differing only in case, diacritics or letter variants are the same subject
+CREATE UNIQUE INDEX idx_subjects_name ON subjects (normalize_arabic(name));
+
+CREATE TABLE pre_project_subjects (
+    pre_project_id uuid NOT NULL REFERENCES pre_project(id) ON DELETE CASCADE,
+    subject_id uuid NOT NULL REFERENCES subjects(id) ON DELETE CASCADE,
+    PRIMARY KEY (pre_project_id, subject_id)
+);
+
+CREATE TABLE book_subjects (
+    book_id uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    subject_id uuid NOT NULL REFERENCES subjects(id) ON DELETE CASCADE,
+    PRIMARY KEY (book_id, subject_id)
+);
+
+CREATE INDEX idx_pre_project_subjects_subject_id ON pre_project_subjects(subject_id);
+CREATE INDEX idx_book_subjects_subject_id ON book_subjects(subject_id);
+
+-- Free-form keywords, carried into the book on archival
+ALTER TABLE pre_project
+ADD COLUMN keywords TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE book
+ADD COLUMN keywords TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_book_keywords ON book USING gin (keywords);
+
+-- The search vector gains the keywords, weighted like the authors (B)
+DROP INDEX IF EXISTS idx_book_search_vector;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS book_search_vector(TEXT, TEXT, TEXT);
+
+CREATE OR REPLACE FUNCTION book_search_vector(name TEXT, description TEXT, keywords TEXT[], authors TEXT) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('arabic', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('english', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('simple', normalize_arabic(array_to_string(keywords, ' '))), 'B') ||
+        setweight(to_tsvector('simple', normalize_arabic(authors)), 'B') ||
+        setweight(to_tsvector('arabic', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('english', normalize_arabic(description)), 'C')
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE book
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (book_search_vector(name, description, keywords, authors)) STORED;
+
+CREATE INDEX idx_book_search_vector ON book USING gin (search_vector);
@@ -0,0 +1,24 @@
+DROP INDEX IF EXISTS idx_book_search_vector;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS book_search_vector(TEXT, TEXT, TEXT[], TEXT, TEXT);
+
+CREATE OR REPLACE FUNCTION book_search_vector(name TEXT, description TEXT, keywords TEXT[], authors TEXT) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('arabic', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('english', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('simple', normalize_arabic(array_to_string(keywords, ' '))), 'B') ||
+        setweight(to_tsvector('simple', normalize_arabic(authors)), 'B') ||
+        setweight(to_tsvector('arabic', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('english', normalize_arabic(description)), 'C')
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE book
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (book_search_vector(name, description, keywords, authors)) STORED;
+
+CREATE INDEX idx_book_search_vector ON book USING gin (search_vector);
+
+ALTER TABLE book
+DROP COLUMN IF EXISTS full_text,
+DROP COLUMN IF EXISTS file_author,
+DROP COLUMN IF EXISTS file_title,
+DROP COLUMN IF EXISTS page_count;
@@ -0,0 +1,29 @@
+-- What ingestion read out of the uploaded PDF
+ALTER TABLE book
+ADD COLUMN page_count INT,
+ADD COLUMN file_title TEXT,
+ADD COLUMN file_author TEXT,
+ADD COLUMN full_text TEXT NOT NULL DEFAULT '';
+
+-- The search vector gains the full text at the lowest weight (D). Only the
+-- start of it is indexed so a long thesis stays under the tsvector size limit
+DROP INDEX IF EXISTS idx_book_search_vector;
+ALTER TABLE book DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS book_search_vector(TEXT, TEXT, TEXT[], TEXT);
+
+CREATE OR REPLACE FUNCTION book_search_vector(name TEXT, description TEXT, keywords TEXT[], authors TEXT, full_text TEXT) RETURNS tsvector AS $$
+    SELECT
+        setweight(to_tsvector('arabic', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('english', normalize_arabic(name)), 'A') ||
+        setweight(to_tsvector('simple', normalize_arabic(array_to_string(keywords, ' '))), 'B') ||
+        setweight(to_tsvector('simple', normalize_arabic(authors)), 'B') ||
+        setweight(to_tsvector('arabic', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('english', normalize_arabic(description)), 'C') ||
+        setweight(to_tsvector('arabic', normalize_arabic(left(full_text, 100000))), 'D') ||
+        setweight(to_tsvector('english', normalize_arabic(left(full_text, 100000))), 'D')
+$$ LANGUAGE sql IMMUTABLE PARALLEL SAFE;
+
+ALTER TABLE book
+ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (book_search_vector(name, description, keywords, authors, full_text)) STORED;
+
+CREATE INDEX idx_book_search_vector ON book USING gin (search_vector);
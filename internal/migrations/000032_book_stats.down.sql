@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_book_file;
+DROP TABLE IF EXISTS book_daily_stats;
+DROP TABLE IF EXISTS book_events;
@@ -0,0 +1,25 @@
+-- One row per visitor, book and day: the primary key deduplicates repeated
+-- views and downloads. Rows of past days are pruned; only the daily counts
+-- below are kept.
+CREATE TABLE book_events (
+    day DATE NOT NULL DEFAULT CURRENT_DATE,
+    book_id uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('view', 'download')),
+    visitor TEXT NOT NULL,
+    PRIMARY KEY (day, book_id, kind, visitor)
+);
+
+-- Unique views and downloads of a book per day, what the statistics and
+-- popularity listings aggregate
+CREATE TABLE book_daily_stats (
+    book_id uuid NOT NULL REFERENCES book(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    views INT NOT NULL DEFAULT 0,
+    downloads INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, day)
+);
+
+CREATE INDEX idx_book_daily_stats_day ON book_daily_stats (day);
+
+-- Downloads are matched to their book by file path
+CREATE INDEX idx_book_file ON book (file);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_chats_file;
+DROP INDEX IF EXISTS idx_progress_reports_file;
+DROP INDEX IF EXISTS idx_pre_project_versions_file;
+DROP INDEX IF EXISTS idx_pre_project_file;
+
+ALTER TABLE book
+    DROP COLUMN IF EXISTS embargo_until,
+    DROP COLUMN IF EXISTS file_access;
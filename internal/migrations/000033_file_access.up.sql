@@ -0,0 +1,13 @@
+-- Who may download the file of a book: anyone, signed-in users, or only
+-- its students, advisors and discussants. Until embargo_until the file is
+-- hidden from everyone but them and administrators; the metadata stays
+-- public.
+ALTER TABLE book
+    ADD COLUMN file_access TEXT NOT NULL DEFAULT 'public' CHECK (file_access IN ('public', 'authenticated', 'members')),
+    ADD COLUMN embargo_until TIMESTAMP;
+
+-- Uploads are matched to the resource that owns them to decide access
+CREATE INDEX idx_pre_project_file ON pre_project (file);
+CREATE INDEX idx_pre_project_versions_file ON pre_project_versions (file);
+CREATE INDEX idx_progress_reports_file ON progress_reports (file);
+CREATE INDEX idx_chats_file ON chats (file);
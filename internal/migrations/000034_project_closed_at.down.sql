@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_pre_project_active;
+
+UPDATE pre_project SET archived_at = closed_at WHERE closed_at IS NOT NULL;
+ALTER TABLE pre_project DROP COLUMN IF EXISTS closed_at;
+
+CREATE INDEX idx_pre_project_active ON pre_project(year, season) WHERE archived_at IS NULL;
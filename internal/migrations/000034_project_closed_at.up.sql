@@ -0,0 +1,9 @@
+-- A withdrawn or cancelled pre-project is hidden through closed_at rather
+-- than archived_at, which only marks a pre-project that became a book
+ALTER TABLE pre_project ADD COLUMN closed_at TIMESTAMP;
+
+UPDATE pre_project SET closed_at = archived_at, archived_at = NULL
+WHERE closure IS NOT NULL AND archived_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_pre_project_active;
+CREATE INDEX idx_pre_project_active ON pre_project(year, season) WHERE archived_at IS NULL AND closed_at IS NULL;
@@ -17,7 +17,11 @@ type SimilarityResponse struct {
 	TotalProjects   int                      `json:"total_similar_projects"`
 }
 
-func CheckProjectSimilarity(name, description string, similarityThreshold float64) (*SimilarityResponse, error) {
+// CheckProjectSimilarity asks the similarity service for projects close to
+// this one. text is the full text of the project's document when one was
+// extracted; the service then compares it with the stored books' full_text
+// rather than the description alone.
+func CheckProjectSimilarity(name, description, text string, similarityThreshold float64) (*SimilarityResponse, error) {
 	// Option 1: Use environment variable (recommended)
 	pythonServiceURL := os.Getenv("PYTHON_SERVICE_URL")
 	if pythonServiceURL == "" {
@@ -26,16 +30,20 @@ func CheckProjectSimilarity(name, description string, similarityThreshold float6
 	}
 	fmt.Print(pythonServiceURL)
 
-	requestBody, err := json.Marshal(map[string]interface{}{
+	body := map[string]interface{}{
 		"project_name":         name,
 		"project_description":  description,
 		"similarity_threshold": similarityThreshold,
-	})
+	}
+	if text != "" {
+		body["project_text"] = text
+	}
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Post(
 		pythonServiceURL,
 		"application/json",
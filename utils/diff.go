@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// DiffPart is one run of a word-level text diff. Op is "equal", "insert" or "delete".
+type DiffPart struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffText computes a word-level diff between two texts using the longest
+// common subsequence of their tokens. Whitespace is kept as separate tokens
+// so that joining every non-deleted part reproduces the new text exactly.
+func DiffText(oldText, newText string) []DiffPart {
+	a := tokenize(oldText)
+	b := tokenize(newText)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	parts := []DiffPart{}
+	add := func(op, text string) {
+		if n := len(parts); n > 0 && parts[n-1].Op == op {
+			parts[n-1].Text += text
+			return
+		}
+		parts = append(parts, DiffPart{Op: op, Text: text})
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			add("equal", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("delete", a[i])
+			i++
+		default:
+			add("insert", b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		add("delete", a[i])
+	}
+	for ; j < len(b); j++ {
+		add("insert", b[j])
+	}
+
+	return parts
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var current strings.Builder
+	inSpace := false
+	for _, r := range s {
+		space := unicode.IsSpace(r)
+		if current.Len() > 0 && space != inSpace {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+		inSpace = space
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
@@ -0,0 +1,204 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// trueTypeFont holds the parts of a TrueType font needed to embed it and lay
+// text out with it: glyph lookup, advance widths and the descriptor metrics.
+type trueTypeFont struct {
+	name       string
+	data       []byte
+	unitsPerEm int
+	ascent     int
+	descent    int
+	capHeight  int
+	bbox       [4]int
+	advances   []int
+	glyphs     map[rune]uint16
+}
+
+var errUnsupportedFont = errors.New("unsupported font")
+
+func loadTrueType(path string) (*trueTypeFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTrueType(data)
+}
+
+func parseTrueType(data []byte) (*trueTypeFont, error) {
+	if len(data) < 12 {
+		return nil, errUnsupportedFont
+	}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	tables := map[string][]byte{}
+	for i := 0; i < numTables; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(data) {
+			return nil, errUnsupportedFont
+		}
+		tag := string(data[rec : rec+4])
+		offset := int(binary.BigEndian.Uint32(data[rec+8:]))
+		length := int(binary.BigEndian.Uint32(data[rec+12:]))
+		if offset+length > len(data) {
+			return nil, errUnsupportedFont
+		}
+		tables[tag] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "maxp", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("%w: missing %s table", errUnsupportedFont, tag)
+		}
+	}
+
+	f := &trueTypeFont{name: "EmbeddedFont", data: data, glyphs: map[rune]uint16{}}
+
+	head := tables["head"]
+	if len(head) < 54 {
+		return nil, errUnsupportedFont
+	}
+	f.unitsPerEm = int(binary.BigEndian.Uint16(head[18:]))
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(binary.BigEndian.Uint16(head[36+2*i:])))
+	}
+
+	hhea := tables["hhea"]
+	if len(hhea) < 36 {
+		return nil, errUnsupportedFont
+	}
+	f.ascent = int(int16(binary.BigEndian.Uint16(hhea[4:])))
+	f.descent = int(int16(binary.BigEndian.Uint16(hhea[6:])))
+	f.capHeight = f.ascent
+	numberOfHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+
+	numGlyphs := int(binary.BigEndian.Uint16(tables["maxp"][4:]))
+	hmtx := tables["hmtx"]
+	f.advances = make([]int, numGlyphs)
+	last := 0
+	for i := 0; i < numGlyphs; i++ {
+		if i < numberOfHMetrics && 4*i+2 <= len(hmtx) {
+			last = int(binary.BigEndian.Uint16(hmtx[4*i:]))
+		}
+		f.advances[i] = last
+	}
+
+	if err := f.parseCmap(tables["cmap"]); err != nil {
+		return nil, err
+	}
+	if name := postScriptName(tables["name"]); name != "" {
+		f.name = name
+	}
+	return f, nil
+}
+
+// parseCmap reads the Windows Unicode BMP (format 4) subtable.
+func (f *trueTypeFont) parseCmap(cmap []byte) error {
+	if len(cmap) < 4 {
+		return errUnsupportedFont
+	}
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n; i++ {
+		rec := 4 + 8*i
+		if rec+8 > len(cmap) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(cmap[rec:])
+		encoding := binary.BigEndian.Uint16(cmap[rec+2:])
+		offset := int(binary.BigEndian.Uint32(cmap[rec+4:]))
+		if platform != 3 || encoding != 1 || offset+14 > len(cmap) {
+			continue
+		}
+		sub := cmap[offset:]
+		if binary.BigEndian.Uint16(sub) != 4 {
+			continue
+		}
+		segCount := int(binary.BigEndian.Uint16(sub[6:])) / 2
+		ends := 14
+		starts := ends + 2*segCount + 2
+		deltas := starts + 2*segCount
+		rangeOffsets := deltas + 2*segCount
+		if rangeOffsets+2*segCount > len(sub) {
+			return errUnsupportedFont
+		}
+		for s := 0; s < segCount; s++ {
+			end := int(binary.BigEndian.Uint16(sub[ends+2*s:]))
+			start := int(binary.BigEndian.Uint16(sub[starts+2*s:]))
+			delta := int(binary.BigEndian.Uint16(sub[deltas+2*s:]))
+			rangeOffset := int(binary.BigEndian.Uint16(sub[rangeOffsets+2*s:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				var glyph int
+				if rangeOffset == 0 {
+					glyph = (c + delta) & 0xFFFF
+				} else {
+					at := rangeOffsets + 2*s + rangeOffset + 2*(c-start)
+					if at+2 > len(sub) {
+						continue
+					}
+					glyph = int(binary.BigEndian.Uint16(sub[at:]))
+					if glyph != 0 {
+						glyph = (glyph + delta) & 0xFFFF
+					}
+				}
+				if glyph != 0 {
+					f.glyphs[rune(c)] = uint16(glyph)
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: no unicode cmap", errUnsupportedFont)
+}
+
+// postScriptName returns name ID 6 from the name table, if it is stored as
+// Windows UTF-16 or Mac Roman.
+func postScriptName(name []byte) string {
+	if len(name) < 6 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint16(name[2:]))
+	storage := int(binary.BigEndian.Uint16(name[4:]))
+	for i := 0; i < count; i++ {
+		rec := 6 + 12*i
+		if rec+12 > len(name) {
+			break
+		}
+		platform := binary.BigEndian.Uint16(name[rec:])
+		nameID := binary.BigEndian.Uint16(name[rec+6:])
+		length := int(binary.BigEndian.Uint16(name[rec+8:]))
+		offset := storage + int(binary.BigEndian.Uint16(name[rec+10:]))
+		if nameID != 6 || offset+length > len(name) {
+			continue
+		}
+		raw := name[offset : offset+length]
+		if platform == 3 {
+			out := make([]rune, 0, length/2)
+			for j := 0; j+1 < len(raw); j += 2 {
+				out = append(out, rune(binary.BigEndian.Uint16(raw[j:])))
+			}
+			return string(out)
+		}
+		return string(raw)
+	}
+	return ""
+}
+
+// width returns the advance of a glyph in thousandths of an em, the unit PDF
+// uses for glyph widths.
+func (f *trueTypeFont) width(glyph uint16) int {
+	if int(glyph) >= len(f.advances) || f.unitsPerEm == 0 {
+		return 0
+	}
+	return f.advances[glyph] * 1000 / f.unitsPerEm
+}
+
+func (f *trueTypeFont) scale(v int) int {
+	if f.unitsPerEm == 0 {
+		return v
+	}
+	return v * 1000 / f.unitsPerEm
+}
@@ -0,0 +1,335 @@
+package pdf
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// The objects of a parsed PDF: nil, bool, int, float64, name, pdfString,
+// array, dict, ref, *stream, and keyword for content stream operators.
+type (
+	object    interface{}
+	name      string
+	keyword   string
+	pdfString string
+	array     []object
+	dict      map[name]object
+	ref       struct{ num, gen int }
+)
+
+type stream struct {
+	hdr dict
+	raw []byte
+}
+
+// lexer reads PDF objects out of a byte slice, as found in the file body,
+// object streams, content streams and CMaps.
+type lexer struct {
+	buf []byte
+	pos int
+}
+
+func isSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelim(c byte) bool {
+	return bytes.IndexByte([]byte("()<>[]{}/%"), c) >= 0
+}
+
+func (l *lexer) eof() bool { return l.pos >= len(l.buf) }
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		if isSpace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.buf) && l.buf[l.pos] != '\n' && l.buf[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+// regular reads a run of regular characters: a number or a keyword.
+func (l *lexer) regular() string {
+	start := l.pos
+	for l.pos < len(l.buf) && !isSpace(l.buf[l.pos]) && !isDelim(l.buf[l.pos]) {
+		l.pos++
+	}
+	return string(l.buf[start:l.pos])
+}
+
+func (l *lexer) readName() name {
+	l.pos++ // '/'
+	var out []byte
+	for l.pos < len(l.buf) && !isSpace(l.buf[l.pos]) && !isDelim(l.buf[l.pos]) {
+		c := l.buf[l.pos]
+		if c == '#' && l.pos+2 < len(l.buf) {
+			if v, err := strconv.ParseUint(string(l.buf[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				out = append(out, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		out = append(out, c)
+		l.pos++
+	}
+	return name(out)
+}
+
+func (l *lexer) readLiteralString() pdfString {
+	l.pos++ // '('
+	var out []byte
+	depth := 1
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(out)
+			}
+		case '\\':
+			if l.pos >= len(l.buf) {
+				return pdfString(out)
+			}
+			c = l.buf[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.buf) && l.buf[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.buf) && l.buf[l.pos] >= '0' && l.buf[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.buf[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return pdfString(out)
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (l *lexer) readHexString() pdfString {
+	l.pos++ // '<'
+	var out []byte
+	var hi byte
+	half := false
+	for l.pos < len(l.buf) {
+		c := l.buf[l.pos]
+		l.pos++
+		if c == '>' {
+			break
+		}
+		v, ok := unhex(c)
+		if !ok {
+			continue
+		}
+		if half {
+			out = append(out, hi<<4|v)
+		} else {
+			hi = v
+		}
+		half = !half
+	}
+	if half {
+		out = append(out, hi<<4)
+	}
+	return pdfString(out)
+}
+
+// token reads the next token. Delimiters come back as keywords ("[", "<<").
+func (l *lexer) token() (object, bool) {
+	l.skipSpace()
+	if l.eof() {
+		return nil, false
+	}
+	c := l.buf[l.pos]
+	switch c {
+	case '/':
+		return l.readName(), true
+	case '(':
+		return l.readLiteralString(), true
+	case '<':
+		if l.pos+1 < len(l.buf) && l.buf[l.pos+1] == '<' {
+			l.pos += 2
+			return keyword("<<"), true
+		}
+		return l.readHexString(), true
+	case '>':
+		if l.pos+1 < len(l.buf) && l.buf[l.pos+1] == '>' {
+			l.pos += 2
+			return keyword(">>"), true
+		}
+		l.pos++
+		return keyword(">"), true
+	case '[', ']', '{', '}', ')':
+		l.pos++
+		return keyword(string(c)), true
+	}
+
+	word := l.regular()
+	if word == "" {
+		l.pos++
+		return keyword(string(c)), true
+	}
+	if n, err := strconv.Atoi(word); err == nil {
+		return n, true
+	}
+	if f, err := strconv.ParseFloat(word, 64); err == nil {
+		return f, true
+	}
+	switch word {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	case "null":
+		return nil, true
+	}
+	return keyword(word), true
+}
+
+// object reads one complete object, resolving "n g R" into a ref. Inside
+// content streams operators come back as keywords.
+func (l *lexer) object() (object, bool) {
+	return l.objectDepth(0)
+}
+
+const maxNesting = 64
+
+func (l *lexer) objectDepth(depth int) (object, bool) {
+	tok, ok := l.token()
+	if !ok {
+		return nil, false
+	}
+	switch t := tok.(type) {
+	case keyword:
+		if depth > maxNesting {
+			return nil, false
+		}
+		switch t {
+		case "[":
+			var arr array
+			for {
+				l.skipSpace()
+				if l.eof() {
+					return arr, true
+				}
+				if l.buf[l.pos] == ']' {
+					l.pos++
+					return arr, true
+				}
+				obj, ok := l.objectDepth(depth + 1)
+				if !ok {
+					return arr, true
+				}
+				if k, isKeyword := obj.(keyword); isKeyword && (k == "endobj" || k == ">>") {
+					return arr, true
+				}
+				arr = append(arr, obj)
+			}
+		case "<<":
+			d := dict{}
+			for {
+				l.skipSpace()
+				if l.eof() {
+					return d, true
+				}
+				if l.pos+1 < len(l.buf) && l.buf[l.pos] == '>' && l.buf[l.pos+1] == '>' {
+					l.pos += 2
+					return d, true
+				}
+				key, ok := l.token()
+				if !ok {
+					return d, true
+				}
+				k, isName := key.(name)
+				if !isName {
+					// Skip junk up to the next key
+					if kw, isKeyword := key.(keyword); isKeyword && kw == "endobj" {
+						return d, true
+					}
+					continue
+				}
+				value, ok := l.objectDepth(depth + 1)
+				if !ok {
+					return d, true
+				}
+				d[k] = value
+			}
+		}
+		return t, true
+	case int:
+		// "n g R" is a reference
+		save := l.pos
+		if gen, ok := l.token(); ok {
+			if g, isInt := gen.(int); isInt {
+				if r, ok := l.token(); ok && r == keyword("R") {
+					return ref{t, g}, true
+				}
+			}
+		}
+		l.pos = save
+		return t, true
+	}
+	return tok, true
+}
+
+// skipInlineImage moves past the data of an inline image, just read "ID",
+// which runs to the next whitespace-delimited EI.
+func (l *lexer) skipInlineImage() bool {
+	end := l.pos + 1
+	for end < len(l.buf) {
+		i := bytes.Index(l.buf[end:], []byte("EI"))
+		if i < 0 {
+			return false
+		}
+		end += i
+		after := end + 2
+		if isSpace(l.buf[end-1]) && (after >= len(l.buf) || isSpace(l.buf[after])) {
+			l.pos = after
+			return true
+		}
+		end += 2
+	}
+	return false
+}
@@ -0,0 +1,510 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"unicode/utf16"
+)
+
+var (
+	ErrNotPDF  = errors.New("pdf: not a PDF file")
+	ErrDamaged = errors.New("pdf: damaged or empty document")
+)
+
+const (
+	maxStreamSize = 64 << 20 // decoded bytes of one stream
+	maxTextSize   = 2 << 20  // extracted text of a document
+	maxPages      = 20000
+)
+
+// Info is what ingestion keeps of an uploaded PDF. Encrypted documents
+// report their page count only.
+type Info struct {
+	Pages     int
+	Title     string
+	Author    string
+	Text      string
+	Encrypted bool
+}
+
+// document is a PDF read by scanning its body for "n g obj" headers rather
+// than trusting the cross-reference table, which is often stale in files
+// saved by office suites.
+type document struct {
+	data    []byte
+	objects map[int]object
+	trailer dict
+}
+
+var objectHeader = regexp.MustCompile(`(\d+)[\x00\t\n\f\r ]+\d+[\x00\t\n\f\r ]+obj\b`)
+
+// Read parses a PDF and extracts its page count, the title and author of
+// its Info dictionary and the text of its pages.
+func Read(data []byte) (info *Info, err error) {
+	// Malformed input must not take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			info, err = nil, ErrDamaged
+		}
+	}()
+
+	d, pages, err := open(data)
+	if err != nil {
+		return nil, err
+	}
+
+	info = &Info{Pages: len(pages)}
+	if _, ok := d.trailer["Encrypt"]; ok {
+		info.Encrypted = true
+		return info, nil
+	}
+	if meta, ok := d.resolve(d.trailer["Info"]).(dict); ok {
+		info.Title = decodeTextString(d.resolve(meta["Title"]))
+		info.Author = decodeTextString(d.resolve(meta["Author"]))
+	}
+	info.Text = d.text(pages)
+	return info, nil
+}
+
+// open parses a PDF down to its page tree.
+func open(data []byte) (*document, []page, error) {
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if !bytes.Contains(head, []byte("%PDF-")) {
+		return nil, nil, ErrNotPDF
+	}
+
+	d := &document{data: data, objects: map[int]object{}, trailer: dict{}}
+	d.scan()
+	d.loadObjectStreams()
+
+	root := d.catalog()
+	if root == nil {
+		return nil, nil, ErrDamaged
+	}
+	pages := d.pages(root)
+	if len(pages) == 0 {
+		return nil, nil, ErrDamaged
+	}
+	return d, pages, nil
+}
+
+// scan parses every object of the body in file order, so objects redefined
+// by incremental updates end up with their last definition.
+func (d *document) scan() {
+	pos := 0
+	for pos < len(d.data) {
+		loc := objectHeader.FindSubmatchIndex(d.data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(d.data[pos+loc[2] : pos+loc[3]]))
+		l := &lexer{buf: d.data, pos: pos + loc[1]}
+		next := pos + loc[1]
+		if obj, ok := l.object(); ok {
+			next = l.pos
+			if hdr, isDict := obj.(dict); isDict {
+				if s, end := d.readStream(hdr, l.pos); s != nil {
+					obj, next = s, end
+				}
+				d.mergeTrailer(hdr)
+			}
+			d.objects[num] = obj
+		}
+		pos = next
+	}
+
+	// Classic trailers follow the xref tables
+	for pos := 0; ; {
+		i := bytes.Index(d.data[pos:], []byte("trailer"))
+		if i < 0 {
+			break
+		}
+		l := &lexer{buf: d.data, pos: pos + i + len("trailer")}
+		if hdr, ok := l.object(); ok {
+			if t, isDict := hdr.(dict); isDict {
+				d.mergeTrailer(t)
+			}
+		}
+		pos += i + len("trailer")
+	}
+}
+
+// mergeTrailer takes Root, Info and Encrypt from trailer dictionaries and
+// cross-reference streams; later ones win.
+func (d *document) mergeTrailer(hdr dict) {
+	if _, isTrailer := hdr["Root"]; !isTrailer {
+		return
+	}
+	if t, ok := hdr["Type"].(name); ok && t != "XRef" {
+		return
+	}
+	for _, key := range []name{"Root", "Info", "Encrypt"} {
+		if value, ok := hdr[key]; ok {
+			d.trailer[key] = value
+		}
+	}
+}
+
+// readStream returns the stream whose dictionary ends at pos, if any, and
+// the position after "endstream". A direct /Length is used when it points at
+// endstream; otherwise the data runs up to the next endstream.
+func (d *document) readStream(hdr dict, pos int) (*stream, int) {
+	l := &lexer{buf: d.data, pos: pos}
+	for l.pos < len(d.data) && isSpace(d.data[l.pos]) {
+		l.pos++
+	}
+	if !bytes.HasPrefix(d.data[l.pos:], []byte("stream")) {
+		return nil, pos
+	}
+	start := l.pos + len("stream")
+	if start < len(d.data) && d.data[start] == '\r' {
+		start++
+	}
+	if start < len(d.data) && d.data[start] == '\n' {
+		start++
+	}
+
+	if length, ok := hdr["Length"].(int); ok && length >= 0 && start+length <= len(d.data) {
+		after := start + length
+		for after < len(d.data) && isSpace(d.data[after]) {
+			after++
+		}
+		if bytes.HasPrefix(d.data[after:], []byte("endstream")) {
+			return &stream{hdr, d.data[start : start+length]}, after + len("endstream")
+		}
+	}
+
+	i := bytes.Index(d.data[start:], []byte("endstream"))
+	if i < 0 {
+		return &stream{hdr, d.data[start:]}, len(d.data)
+	}
+	end := start + i
+	if end > start && d.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && d.data[end-1] == '\r' {
+		end--
+	}
+	return &stream{hdr, d.data[start:end]}, start + i + len("endstream")
+}
+
+// loadObjectStreams adds the objects compressed into object streams (PDF
+// 1.5+) that were not found in the body.
+func (d *document) loadObjectStreams() {
+	var streams []*stream
+	for _, obj := range d.objects {
+		if s, ok := obj.(*stream); ok && s.hdr["Type"] == name("ObjStm") {
+			streams = append(streams, s)
+		}
+	}
+	for _, s := range streams {
+		data, err := d.decode(s)
+		if err != nil {
+			continue
+		}
+		n, _ := d.resolve(s.hdr["N"]).(int)
+		first, _ := d.resolve(s.hdr["First"]).(int)
+		if first < 0 || first > len(data) {
+			continue
+		}
+		l := &lexer{buf: data[:first]}
+		for i := 0; i < n; i++ {
+			num, ok1 := l.token()
+			offset, ok2 := l.token()
+			objNum, isInt1 := num.(int)
+			objOffset, isInt2 := offset.(int)
+			if !ok1 || !ok2 || !isInt1 || !isInt2 {
+				break
+			}
+			if _, exists := d.objects[objNum]; exists || first+objOffset >= len(data) {
+				continue
+			}
+			body := &lexer{buf: data, pos: first + objOffset}
+			if obj, ok := body.object(); ok {
+				d.objects[objNum] = obj
+			}
+		}
+	}
+}
+
+func (d *document) resolve(obj object) object {
+	for i := 0; i < 32; i++ {
+		r, ok := obj.(ref)
+		if !ok {
+			return obj
+		}
+		obj = d.objects[r.num]
+	}
+	return nil
+}
+
+func (d *document) dictOf(obj object) dict {
+	switch v := d.resolve(obj).(type) {
+	case dict:
+		return v
+	case *stream:
+		return v.hdr
+	}
+	return nil
+}
+
+func (d *document) catalog() dict {
+	if root := d.dictOf(d.trailer["Root"]); root != nil {
+		return root
+	}
+	for _, obj := range d.objects {
+		if hdr, ok := obj.(dict); ok && hdr["Type"] == name("Catalog") {
+			return hdr
+		}
+	}
+	return nil
+}
+
+// page is a leaf of the page tree with the attributes it inherits: its
+// resources, media and crop boxes and rotation.
+type page struct {
+	hdr       dict
+	resources dict
+	mediaBox  array
+	cropBox   array
+	rotate    int
+}
+
+func (d *document) pages(root dict) []page {
+	var pages []page
+	seen := map[int]bool{}
+	var walk func(node object, inherited page, depth int)
+	walk = func(node object, inherited page, depth int) {
+		if r, ok := node.(ref); ok {
+			if seen[r.num] {
+				return
+			}
+			seen[r.num] = true
+		}
+		hdr := d.dictOf(node)
+		if hdr == nil || depth > maxNesting || len(pages) >= maxPages {
+			return
+		}
+		if own := d.dictOf(hdr["Resources"]); own != nil {
+			inherited.resources = own
+		}
+		if box, ok := d.resolve(hdr["MediaBox"]).(array); ok {
+			inherited.mediaBox = box
+		}
+		if box, ok := d.resolve(hdr["CropBox"]).(array); ok {
+			inherited.cropBox = box
+		}
+		if rotate, ok := d.resolve(hdr["Rotate"]).(int); ok {
+			inherited.rotate = rotate
+		}
+		if kids, ok := d.resolve(hdr["Kids"]).(array); ok {
+			for _, kid := range kids {
+				walk(kid, inherited, depth+1)
+			}
+			return
+		}
+		if hdr["Type"] == name("Pages") {
+			return
+		}
+		inherited.hdr = hdr
+		pages = append(pages, inherited)
+	}
+	walk(root["Pages"], page{}, 0)
+	return pages
+}
+
+// content returns the page's content streams decoded and joined.
+func (d *document) content(p page) []byte {
+	var content []byte
+	switch c := d.resolve(p.hdr["Contents"]).(type) {
+	case *stream:
+		content, _ = d.decode(c)
+	case array:
+		for _, part := range c {
+			if s, ok := d.resolve(part).(*stream); ok {
+				if data, err := d.decode(s); err == nil {
+					content = append(append(content, data...), '\n')
+				}
+			}
+		}
+	}
+	return content
+}
+
+// decode applies the stream's filters. Image filters are not supported;
+// text lives in Flate-compressed streams.
+func (d *document) decode(s *stream) ([]byte, error) {
+	var filters array
+	switch f := d.resolve(s.hdr["Filter"]).(type) {
+	case name:
+		filters = array{f}
+	case array:
+		filters = f
+	}
+	var params array
+	switch p := d.resolve(s.hdr["DecodeParms"]).(type) {
+	case dict:
+		params = array{p}
+	case array:
+		params = p
+	}
+
+	data := s.raw
+	for i, filter := range filters {
+		var err error
+		switch d.resolve(filter) {
+		case name("FlateDecode"), name("Fl"):
+			data, err = inflate(data)
+			if err == nil && i < len(params) {
+				data, err = d.unpredict(data, d.dictOf(params[i]))
+			}
+		case name("ASCIIHexDecode"), name("AHx"):
+			data = []byte((&lexer{buf: append([]byte("<"), data...)}).readHexString())
+		case name("ASCII85Decode"), name("A85"):
+			data, err = decodeASCII85(data)
+		default:
+			err = fmt.Errorf("pdf: unsupported filter %v", filter)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses zlib data, keeping what could be read from truncated
+// or checksum-damaged streams.
+func inflate(data []byte) ([]byte, error) {
+	var r io.Reader
+	if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+		r = zr
+	} else {
+		r = flate.NewReader(bytes.NewReader(data))
+	}
+	out, err := io.ReadAll(io.LimitReader(r, maxStreamSize))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// unpredict reverses the PNG predictors of FlateDecode parameters.
+func (d *document) unpredict(data []byte, params dict) ([]byte, error) {
+	predictor, _ := d.resolve(params["Predictor"]).(int)
+	if predictor < 10 {
+		return data, nil
+	}
+	columns, ok := d.resolve(params["Columns"]).(int)
+	if !ok || columns < 1 {
+		columns = 1
+	}
+	colors, ok := d.resolve(params["Colors"]).(int)
+	if !ok || colors < 1 {
+		colors = 1
+	}
+	bpc, ok := d.resolve(params["BitsPerComponent"]).(int)
+	if !ok || bpc < 1 {
+		bpc = 8
+	}
+	bpp := (colors*bpc + 7) / 8
+	rowLen := (columns*colors*bpc + 7) / 8
+
+	var out []byte
+	prev := make([]byte, rowLen)
+	for len(data) >= rowLen+1 {
+		kind, row := data[0], append([]byte(nil), data[1:rowLen+1]...)
+		data = data[rowLen+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			up := prev[i]
+			switch kind {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	var clean []byte
+	for _, c := range data {
+		if !isSpace(c) {
+			clean = append(clean, c)
+		}
+	}
+	clean = bytes.TrimPrefix(clean, []byte("<~"))
+	if i := bytes.Index(clean, []byte("~>")); i >= 0 {
+		clean = clean[:i]
+	}
+	return io.ReadAll(io.LimitReader(ascii85.NewDecoder(bytes.NewReader(clean)), maxStreamSize))
+}
+
+// decodeTextString decodes a PDF text string: UTF-16BE with a byte order mark,
+// UTF-8 with one, or PDFDocEncoding, read here as Latin-1.
+func decodeTextString(obj object) string {
+	s, ok := obj.(pdfString)
+	if !ok {
+		return ""
+	}
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		return decodeUTF16(b[2:])
+	case len(b) >= 3 && b[0] == 0xEF && b[1] == 0xBB && b[2] == 0xBF:
+		return string(b[3:])
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,669 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrTooSlow is returned for a page that takes longer than maxRenderTime to
+// draw.
+var ErrTooSlow = errors.New("pdf: page too slow to preview")
+
+const (
+	// maxPreviewPixels bounds the canvas of a preview, whatever the page shape.
+	maxPreviewPixels = 4 << 20
+	// maxRenderTime bounds the drawing of a preview, which a crafted page
+	// could otherwise keep busy
+	maxRenderTime = 5 * time.Second
+)
+
+// matrix is a PDF transformation [a b c d e f]: a point (x, y) maps to
+// (a*x + c*y + e, b*x + d*y + f).
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+// mul returns m then n, which is m × n in PDF's row-vector convention.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+func (m matrix) invert() (matrix, bool) {
+	det := m[0]*m[3] - m[1]*m[2]
+	if math.Abs(det) < 1e-12 {
+		return matrix{}, false
+	}
+	return matrix{
+		m[3] / det,
+		-m[1] / det,
+		-m[2] / det,
+		m[0] / det,
+		(m[2]*m[5] - m[3]*m[4]) / det,
+		(m[1]*m[4] - m[0]*m[5]) / det,
+	}, true
+}
+
+func (d *document) matrixOf(obj object) (matrix, bool) {
+	arr, ok := d.resolve(obj).(array)
+	if !ok || len(arr) != 6 {
+		return matrix{}, false
+	}
+	var m matrix
+	for i, item := range arr {
+		v, ok := number(d.resolve(item))
+		if !ok {
+			return matrix{}, false
+		}
+		m[i] = v
+	}
+	return m, true
+}
+
+type graphicsState struct {
+	ctm  matrix
+	fill color.RGBA
+}
+
+// painter draws a page onto an image. It is a preview, not a renderer:
+// images and filled rectangles are drawn, and text shows as bars where its
+// words are, since fonts are not rasterised.
+type painter struct {
+	d        *document
+	canvas   *image.RGBA
+	gs       graphicsState
+	stack    []graphicsState
+	fonts    map[int]*font
+	deadline time.Time
+	ops      int
+	tooSlow  bool
+}
+
+// expired reports whether the painter ran out of time, checking the clock
+// every few hundred operators.
+func (p *painter) expired() bool {
+	p.ops++
+	if !p.tooSlow && p.ops%256 == 0 && time.Now().After(p.deadline) {
+		p.tooSlow = true
+	}
+	return p.tooSlow
+}
+
+// Render draws the first page of a PDF as a preview the given number of
+// pixels wide. Encrypted documents come out as a blank page, and a page
+// that takes longer than maxRenderTime fails with ErrTooSlow.
+func Render(data []byte, width int) (img *image.RGBA, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, ErrDamaged
+		}
+	}()
+
+	d, pages, err := open(data)
+	if err != nil {
+		return nil, err
+	}
+	first := pages[0]
+
+	box := first.cropBox
+	if len(box) != 4 {
+		box = first.mediaBox
+	}
+	x0, y0, x1, y1 := 0.0, 0.0, 595.0, 842.0
+	if len(box) == 4 {
+		var v [4]float64
+		valid := true
+		for i := range v {
+			v[i], valid = number(d.resolve(box[i]))
+			if !valid {
+				break
+			}
+		}
+		if valid && v[0] != v[2] && v[1] != v[3] {
+			x0, y0 = math.Min(v[0], v[2]), math.Min(v[1], v[3])
+			x1, y1 = math.Max(v[0], v[2]), math.Max(v[1], v[3])
+		}
+	}
+
+	// Map the page box to pixels, turned by the page's rotation
+	rotate := ((first.rotate % 360) + 360) % 360
+	w, h := x1-x0, y1-y0
+	if rotate == 90 || rotate == 270 {
+		w, h = h, w
+	}
+	scale := float64(width) / w
+	height := int(math.Round(h * scale))
+	if width < 1 || height < 1 || width*height > maxPreviewPixels {
+		return nil, ErrDamaged
+	}
+	var device matrix
+	switch rotate {
+	case 90:
+		device = matrix{0, scale, scale, 0, -y0 * scale, -x0 * scale}
+	case 180:
+		device = matrix{-scale, 0, 0, scale, x1 * scale, -y0 * scale}
+	case 270:
+		device = matrix{0, -scale, -scale, 0, y1 * scale, x1 * scale}
+	default:
+		device = matrix{scale, 0, 0, -scale, -x0 * scale, y1 * scale}
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	if _, encrypted := d.trailer["Encrypt"]; encrypted {
+		return canvas, nil
+	}
+
+	p := &painter{
+		d:        d,
+		canvas:   canvas,
+		gs:       graphicsState{ctm: device, fill: color.RGBA{0, 0, 0, 255}},
+		fonts:    map[int]*font{},
+		deadline: time.Now().Add(maxRenderTime),
+	}
+	p.run(d.content(first), first.resources, 0)
+	if p.tooSlow {
+		return nil, ErrTooSlow
+	}
+	return canvas, nil
+}
+
+// textState is the part of the graphics state text operators use.
+type textState struct {
+	tm, tlm     matrix
+	font        *font
+	size        float64
+	leading     float64
+	charSpacing float64
+	wordSpacing float64
+	scaling     float64
+	invisible   bool
+}
+
+func (p *painter) run(content []byte, resources dict, depth int) {
+	l := &lexer{buf: content}
+	var operands []object
+	var path []image.Rectangle
+	ts := textState{tm: identity, tlm: identity, scaling: 1}
+
+	for {
+		obj, ok := l.object()
+		if !ok {
+			return
+		}
+		op, isKeyword := obj.(keyword)
+		if !isKeyword {
+			operands = append(operands, obj)
+			continue
+		}
+		if p.expired() {
+			return
+		}
+		nums := make([]float64, 0, len(operands))
+		for _, operand := range operands {
+			if v, ok := number(operand); ok {
+				nums = append(nums, v)
+			}
+		}
+
+		switch op {
+		case "q":
+			if len(p.stack) < 64 {
+				p.stack = append(p.stack, p.gs)
+			}
+		case "Q":
+			if n := len(p.stack); n > 0 {
+				p.gs = p.stack[n-1]
+				p.stack = p.stack[:n-1]
+			}
+		case "cm":
+			if len(nums) == 6 {
+				p.gs.ctm = matrix{nums[0], nums[1], nums[2], nums[3], nums[4], nums[5]}.mul(p.gs.ctm)
+			}
+
+		case "g", "rg", "k", "sc", "scn":
+			if c, ok := deviceColor(nums); ok && len(nums) == len(operands) {
+				p.gs.fill = c
+			}
+
+		case "re":
+			if len(nums) == 4 {
+				path = append(path, p.bounds(p.gs.ctm, nums[0], nums[1], nums[0]+nums[2], nums[1]+nums[3]))
+			}
+		case "f", "F", "f*", "B", "B*", "b", "b*":
+			for _, rect := range path {
+				draw.Draw(p.canvas, rect, image.NewUniform(p.gs.fill), image.Point{}, draw.Src)
+			}
+			path = path[:0]
+		case "n", "S", "s":
+			path = path[:0]
+
+		case "BT":
+			ts.tm, ts.tlm = identity, identity
+		case "Tf":
+			if len(operands) >= 2 {
+				ts.font = p.font(resources, operands[len(operands)-2])
+				ts.size, _ = number(operands[len(operands)-1])
+			}
+		case "TL":
+			if len(nums) == 1 {
+				ts.leading = nums[0]
+			}
+		case "Tc":
+			if len(nums) == 1 {
+				ts.charSpacing = nums[0]
+			}
+		case "Tw":
+			if len(nums) == 1 {
+				ts.wordSpacing = nums[0]
+			}
+		case "Tz":
+			if len(nums) == 1 {
+				ts.scaling = nums[0] / 100
+			}
+		case "Tr":
+			ts.invisible = len(nums) == 1 && nums[0] == 3
+		case "Td", "TD":
+			if len(nums) == 2 {
+				if op == "TD" {
+					ts.leading = -nums[1]
+				}
+				ts.tlm = matrix{1, 0, 0, 1, nums[0], nums[1]}.mul(ts.tlm)
+				ts.tm = ts.tlm
+			}
+		case "Tm":
+			if len(nums) == 6 {
+				ts.tlm = matrix{nums[0], nums[1], nums[2], nums[3], nums[4], nums[5]}
+				ts.tm = ts.tlm
+			}
+		case "T*":
+			ts.nextLine()
+		case "Tj", "'", "\"":
+			if op != "Tj" {
+				ts.nextLine()
+			}
+			if op == "\"" && len(nums) >= 2 {
+				ts.wordSpacing, ts.charSpacing = nums[0], nums[1]
+			}
+			if len(operands) > 0 {
+				if s, ok := operands[len(operands)-1].(pdfString); ok {
+					p.show(&ts, s)
+				}
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				items, _ := operands[len(operands)-1].(array)
+				for _, item := range items {
+					if s, ok := item.(pdfString); ok {
+						p.show(&ts, s)
+					} else if v, ok := number(item); ok {
+						ts.tm = matrix{1, 0, 0, 1, -v / 1000 * ts.size * ts.scaling, 0}.mul(ts.tm)
+					}
+				}
+			}
+
+		case "Do":
+			if len(operands) > 0 && depth < maxFormDepth {
+				p.xobject(resources, operands[len(operands)-1], depth)
+			}
+		case "ID":
+			if !l.skipInlineImage() {
+				return
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func (ts *textState) nextLine() {
+	ts.tlm = matrix{1, 0, 0, 1, 0, -ts.leading}.mul(ts.tlm)
+	ts.tm = ts.tlm
+}
+
+// deviceColor reads gray, RGB or CMYK components.
+func deviceColor(c []float64) (color.RGBA, bool) {
+	clamp := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+	switch len(c) {
+	case 1:
+		return color.RGBA{clamp(c[0]), clamp(c[0]), clamp(c[0]), 255}, true
+	case 3:
+		return color.RGBA{clamp(c[0]), clamp(c[1]), clamp(c[2]), 255}, true
+	case 4:
+		return color.RGBA{
+			clamp((1 - c[0]) * (1 - c[3])),
+			clamp((1 - c[1]) * (1 - c[3])),
+			clamp((1 - c[2]) * (1 - c[3])),
+			255,
+		}, true
+	}
+	return color.RGBA{}, false
+}
+
+// bounds is the pixel rectangle covering a user-space rectangle.
+func (p *painter) bounds(m matrix, x0, y0, x1, y1 float64) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{x0, y0}, {x1, y0}, {x0, y1}, {x1, y1}} {
+		x, y := m.apply(corner[0], corner[1])
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	rect := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+	return rect.Intersect(p.canvas.Bounds())
+}
+
+func (p *painter) font(resources dict, key object) *font {
+	fonts := p.d.dictOf(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	n, _ := key.(name)
+	entry := fonts[n]
+	r, isRef := entry.(ref)
+	if isRef {
+		if f, ok := p.fonts[r.num]; ok {
+			return f
+		}
+	}
+	hdr := p.d.dictOf(entry)
+	if hdr == nil {
+		return nil
+	}
+	f := p.d.loadFont(hdr)
+	if isRef {
+		p.fonts[r.num] = f
+	}
+	return f
+}
+
+// show advances over a shown string, drawing a bar for each word, a light
+// tint of the fill colour so the page reads as text at a glance.
+func (p *painter) show(ts *textState, s pdfString) {
+	if ts.font == nil {
+		return
+	}
+	tint := p.gs.fill
+	tint.R = uint8((int(tint.R) + 2*255) / 3)
+	tint.G = uint8((int(tint.G) + 2*255) / 3)
+	tint.B = uint8((int(tint.B) + 2*255) / 3)
+
+	x, wordStart := 0.0, -1.0
+	flush := func() {
+		if wordStart >= 0 && !ts.invisible {
+			trm := ts.tm.mul(p.gs.ctm)
+			rect := p.bounds(trm, wordStart, 0, x, 0.55*ts.size)
+			draw.Draw(p.canvas, rect, image.NewUniform(tint), image.Point{}, draw.Src)
+		}
+		wordStart = -1
+	}
+	for b := []byte(s); len(b) > 0; {
+		var code []byte
+		code, b = ts.font.next(b)
+		text := ts.font.text(code)
+		space := text != "" && strings.TrimSpace(text) == ""
+		if text == "" {
+			space = !ts.font.multiByte && len(code) == 1 && code[0] == ' '
+		}
+		if space {
+			flush()
+		} else if wordStart < 0 {
+			wordStart = x
+		}
+		advance := ts.font.width(code)/1000*ts.size + ts.charSpacing
+		if len(code) == 1 && code[0] == ' ' {
+			advance += ts.wordSpacing
+		}
+		x += advance * ts.scaling
+	}
+	flush()
+	ts.tm = matrix{1, 0, 0, 1, x, 0}.mul(ts.tm)
+}
+
+func (p *painter) xobject(resources dict, key object, depth int) {
+	n, _ := key.(name)
+	xobjects := p.d.dictOf(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	s, ok := p.d.resolve(xobjects[n]).(*stream)
+	if !ok {
+		return
+	}
+
+	switch s.hdr["Subtype"] {
+	case name("Image"):
+		if img := p.d.image(s, p.gs.fill); img != nil {
+			p.drawImage(img)
+		}
+	case name("Form"):
+		data, err := p.d.decode(s)
+		if err != nil {
+			return
+		}
+		saved, stackDepth := p.gs, len(p.stack)
+		if m, ok := p.d.matrixOf(s.hdr["Matrix"]); ok {
+			p.gs.ctm = m.mul(p.gs.ctm)
+		}
+		formResources := resources
+		if own := p.d.dictOf(s.hdr["Resources"]); own != nil {
+			formResources = own
+		}
+		p.run(data, formResources, depth+1)
+		p.gs, p.stack = saved, p.stack[:stackDepth]
+	}
+}
+
+// drawImage maps the image onto the unit square of the current matrix,
+// sampling the nearest source pixel for every covered canvas pixel.
+func (p *painter) drawImage(img image.Image) {
+	rect := p.bounds(p.gs.ctm, 0, 0, 1, 1)
+	inverse, ok := p.gs.ctm.invert()
+	if !ok || rect.Empty() {
+		return
+	}
+	src := img.Bounds()
+	for py := rect.Min.Y; py < rect.Max.Y; py++ {
+		for px := rect.Min.X; px < rect.Max.X; px++ {
+			u, v := inverse.apply(float64(px)+0.5, float64(py)+0.5)
+			if u < 0 || u >= 1 || v < 0 || v >= 1 {
+				continue
+			}
+			sx := src.Min.X + int(u*float64(src.Dx()))
+			sy := src.Min.Y + int((1-v)*float64(src.Dy()))
+			c := img.At(sx, sy)
+			if _, _, _, a := c.RGBA(); a == 0 {
+				continue
+			}
+			p.canvas.Set(px, py, c)
+		}
+	}
+}
+
+// image decodes an image XObject: JPEG data, or raw samples in a gray, RGB,
+// CMYK or indexed colour space. Stencil masks paint with the fill colour.
+func (d *document) image(s *stream, fill color.RGBA) image.Image {
+	var filters array
+	switch f := d.resolve(s.hdr["Filter"]).(type) {
+	case name:
+		filters = array{f}
+	case array:
+		filters = f
+	}
+	if len(filters) > 0 {
+		last := d.resolve(filters[len(filters)-1])
+		if last == name("DCTDecode") || last == name("DCT") {
+			raw := s.raw
+			if len(filters) > 1 {
+				partial := &stream{hdr: dict{"Filter": filters[:len(filters)-1], "DecodeParms": s.hdr["DecodeParms"]}, raw: s.raw}
+				var err error
+				if raw, err = d.decode(partial); err != nil {
+					return nil
+				}
+			}
+			img, err := jpeg.Decode(bytes.NewReader(raw))
+			if err != nil {
+				return nil
+			}
+			return img
+		}
+	}
+
+	width, _ := d.resolve(s.hdr["Width"]).(int)
+	height, _ := d.resolve(s.hdr["Height"]).(int)
+	if width < 1 || height < 1 || width*height > maxStreamSize {
+		return nil
+	}
+	data, err := d.decode(s)
+	if err != nil {
+		return nil
+	}
+
+	if mask, _ := d.resolve(s.hdr["ImageMask"]).(bool); mask {
+		out := image.NewRGBA(image.Rect(0, 0, width, height))
+		r := bitReader{data: data, bits: 1}
+		for y := 0; y < height; y++ {
+			r.startRow()
+			for x := 0; x < width; x++ {
+				if r.read() == 0 {
+					out.SetRGBA(x, y, fill)
+				}
+			}
+		}
+		return out
+	}
+
+	bpc, ok := d.resolve(s.hdr["BitsPerComponent"]).(int)
+	if !ok || (bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8) {
+		return nil
+	}
+	components, palette := d.colorSpace(s.hdr["ColorSpace"])
+	if components == 0 {
+		return nil
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	r := bitReader{data: data, bits: bpc}
+	maxValue := float64(int(1)<<bpc - 1)
+	values := make([]float64, components)
+	for y := 0; y < height; y++ {
+		r.startRow()
+		for x := 0; x < width; x++ {
+			if palette != nil {
+				index := r.read()
+				if index*3+2 < len(palette) {
+					out.SetRGBA(x, y, color.RGBA{palette[index*3], palette[index*3+1], palette[index*3+2], 255})
+				}
+				continue
+			}
+			for i := range values {
+				values[i] = float64(r.read()) / maxValue
+			}
+			c, _ := deviceColor(values)
+			out.SetRGBA(x, y, c)
+		}
+	}
+	return out
+}
+
+// colorSpace returns the number of components of an image colour space, or
+// for an indexed one a single component and its RGB palette.
+func (d *document) colorSpace(obj object) (int, []byte) {
+	switch cs := d.resolve(obj).(type) {
+	case name:
+		switch cs {
+		case "DeviceGray", "G", "CalGray":
+			return 1, nil
+		case "DeviceRGB", "RGB", "CalRGB":
+			return 3, nil
+		case "DeviceCMYK", "CMYK":
+			return 4, nil
+		}
+	case array:
+		if len(cs) == 0 {
+			return 0, nil
+		}
+		switch d.resolve(cs[0]) {
+		case name("ICCBased"):
+			if len(cs) > 1 {
+				if s, ok := d.resolve(cs[1]).(*stream); ok {
+					if n, ok := d.resolve(s.hdr["N"]).(int); ok && (n == 1 || n == 3 || n == 4) {
+						return n, nil
+					}
+				}
+			}
+		case name("CalGray"):
+			return 1, nil
+		case name("CalRGB"), name("Lab"):
+			return 3, nil
+		case name("Indexed"), name("I"):
+			if len(cs) < 4 {
+				return 0, nil
+			}
+			base, _ := d.colorSpace(cs[1])
+			var lookup []byte
+			switch v := d.resolve(cs[3]).(type) {
+			case pdfString:
+				lookup = []byte(v)
+			case *stream:
+				lookup, _ = d.decode(v)
+			}
+			if base == 0 || len(lookup) == 0 {
+				return 0, nil
+			}
+			palette := make([]byte, 0, len(lookup)/base*3)
+			values := make([]float64, base)
+			for i := 0; i+base <= len(lookup); i += base {
+				for j := range values {
+					values[j] = float64(lookup[i+j]) / 255
+				}
+				c, _ := deviceColor(values)
+				palette = append(palette, c.R, c.G, c.B)
+			}
+			return 1, palette
+		}
+	}
+	return 0, nil
+}
+
+// bitReader reads samples of 1 to 8 bits; rows start on a byte boundary.
+type bitReader struct {
+	data []byte
+	bits int
+	pos  int // in bits
+}
+
+func (r *bitReader) startRow() {
+	if r.pos%8 != 0 {
+		r.pos += 8 - r.pos%8
+	}
+}
+
+func (r *bitReader) read() int {
+	byteIndex := r.pos / 8
+	if byteIndex >= len(r.data) {
+		r.pos += r.bits
+		return 0
+	}
+	shift := 8 - r.bits - r.pos%8
+	r.pos += r.bits
+	return int(r.data[byteIndex]>>shift) & (1<<r.bits - 1)
+}
@@ -0,0 +1,173 @@
+package pdf
+
+import "strings"
+
+// arabicForms maps an Arabic letter to its presentation forms:
+// isolated, final, initial, medial. Letters that only join to the preceding
+// letter (alef, dal, reh, waw...) have no initial or medial form.
+var arabicForms = map[rune][4]rune{
+	0x0621: {0xFE80, 0, 0, 0},
+	0x0622: {0xFE81, 0xFE82, 0, 0},
+	0x0623: {0xFE83, 0xFE84, 0, 0},
+	0x0624: {0xFE85, 0xFE86, 0, 0},
+	0x0625: {0xFE87, 0xFE88, 0, 0},
+	0x0626: {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	0x0627: {0xFE8D, 0xFE8E, 0, 0},
+	0x0628: {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	0x0629: {0xFE93, 0xFE94, 0, 0},
+	0x062A: {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	0x062B: {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	0x062C: {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	0x062D: {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	0x062E: {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	0x062F: {0xFEA9, 0xFEAA, 0, 0},
+	0x0630: {0xFEAB, 0xFEAC, 0, 0},
+	0x0631: {0xFEAD, 0xFEAE, 0, 0},
+	0x0632: {0xFEAF, 0xFEB0, 0, 0},
+	0x0633: {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	0x0634: {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	0x0635: {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	0x0636: {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	0x0637: {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	0x0638: {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	0x0639: {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	0x063A: {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	0x0640: {0x0640, 0x0640, 0x0640, 0x0640},
+	0x0641: {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	0x0642: {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	0x0643: {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	0x0644: {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	0x0645: {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	0x0646: {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	0x0647: {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	0x0648: {0xFEED, 0xFEEE, 0, 0},
+	0x0649: {0xFEEF, 0xFEF0, 0, 0},
+	0x064A: {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+}
+
+// lamAlef maps the alef following a lam to the isolated and final ligature.
+var lamAlef = map[rune][2]rune{
+	0x0622: {0xFEF5, 0xFEF6},
+	0x0623: {0xFEF7, 0xFEF8},
+	0x0625: {0xFEF9, 0xFEFA},
+	0x0627: {0xFEFB, 0xFEFC},
+}
+
+func isArabic(r rune) bool {
+	return (r >= 0x0600 && r <= 0x06FF) || (r >= 0xFB50 && r <= 0xFDFF) || (r >= 0xFE70 && r <= 0xFEFF)
+}
+
+// isHaraka reports whether r is a diacritic. They are dropped because plain
+// text drawing cannot position them over their letter.
+func isHaraka(r rune) bool {
+	return (r >= 0x064B && r <= 0x065F) || r == 0x0670
+}
+
+func joinsNext(r rune) bool {
+	forms, ok := arabicForms[r]
+	return ok && forms[2] != 0
+}
+
+func joinsPrevious(r rune) bool {
+	forms, ok := arabicForms[r]
+	return ok && forms[1] != 0
+}
+
+// shapeArabic replaces the letters of a logical-order string with their
+// contextual presentation forms.
+func shapeArabic(s string) []rune {
+	var in []rune
+	for _, r := range s {
+		if !isHaraka(r) {
+			in = append(in, r)
+		}
+	}
+
+	out := make([]rune, 0, len(in))
+	for i := 0; i < len(in); i++ {
+		r := in[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		prevJoins := i > 0 && joinsNext(in[i-1]) && joinsPrevious(r)
+
+		if r == 0x0644 && i+1 < len(in) {
+			if lig, ok := lamAlef[in[i+1]]; ok {
+				if prevJoins {
+					out = append(out, lig[1])
+				} else {
+					out = append(out, lig[0])
+				}
+				i++
+				continue
+			}
+		}
+
+		nextJoins := i+1 < len(in) && joinsNext(r) && joinsPrevious(in[i+1])
+		switch {
+		case prevJoins && nextJoins:
+			out = append(out, forms[3])
+		case prevJoins:
+			out = append(out, forms[1])
+		case nextJoins:
+			out = append(out, forms[2])
+		default:
+			out = append(out, forms[0])
+		}
+	}
+	return out
+}
+
+// isRTL reports whether a paragraph should be laid out right to left, which
+// is the case as soon as it contains Arabic.
+func isRTL(s string) bool {
+	for _, r := range s {
+		if isArabic(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// visualLine turns one line of words, in logical order, into the runes to
+// draw from left to right. Right-to-left lines reverse the word order and
+// the letters of Arabic words, leaving Latin words and numbers readable.
+func visualLine(words []string, rtl bool) []rune {
+	if !rtl {
+		return []rune(strings.Join(words, " "))
+	}
+
+	var out []rune
+	for i := len(words) - 1; i >= 0; i-- {
+		word := shapeArabic(words[i])
+		if isRTL(words[i]) {
+			for l, r := 0, len(word)-1; l < r; l, r = l+1, r-1 {
+				word[l], word[r] = word[r], word[l]
+			}
+			word = mirrorBrackets(word)
+		}
+		out = append(out, word...)
+		if i > 0 {
+			out = append(out, ' ')
+		}
+	}
+	return out
+}
+
+func mirrorBrackets(word []rune) []rune {
+	for i, r := range word {
+		switch r {
+		case '(':
+			word[i] = ')'
+		case ')':
+			word[i] = '('
+		case '[':
+			word[i] = ']'
+		case ']':
+			word[i] = '['
+		}
+	}
+	return word
+}
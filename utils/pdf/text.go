@@ -0,0 +1,537 @@
+package pdf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	maxFormDepth  = 5
+	maxCMapRanges = 1 << 16
+	// TJ adjustments below this (thousandths of an em) are word gaps
+	wordGap = -250
+)
+
+// font maps the codes of shown strings to text and widths. Type0 fonts use
+// multi-byte codes and need a ToUnicode CMap; simple fonts fall back on
+// their encoding.
+type font struct {
+	toUnicode *cmap
+	multiByte bool
+	encoding  *[256]rune
+	// Glyph widths in thousandths of an em by code, for previews
+	widths       map[int]float64
+	defaultWidth float64
+}
+
+type codespace struct{ lo, hi []byte }
+
+type cmap struct {
+	spaces []codespace
+	chars  map[string]string
+}
+
+// next splits the first code off s using the codespace ranges.
+func (c *cmap) next(s []byte, multiByte bool) ([]byte, []byte) {
+	for _, space := range c.spaces {
+		n := len(space.lo)
+		if n == 0 || len(s) < n || len(space.hi) != n {
+			continue
+		}
+		inRange := true
+		for i := 0; i < n; i++ {
+			if s[i] < space.lo[i] || s[i] > space.hi[i] {
+				inRange = false
+				break
+			}
+		}
+		if inRange {
+			return s[:n], s[n:]
+		}
+	}
+	if multiByte && len(s) >= 2 {
+		return s[:2], s[2:]
+	}
+	return s[:1], s[1:]
+}
+
+// parseCMap reads the codespaces and bfchar/bfrange mappings of a
+// ToUnicode CMap.
+func parseCMap(data []byte) *cmap {
+	c := &cmap{chars: map[string]string{}}
+	l := &lexer{buf: data}
+	var operands []object
+	entries := 0
+	for {
+		obj, ok := l.object()
+		if !ok {
+			break
+		}
+		op, isKeyword := obj.(keyword)
+		if !isKeyword {
+			operands = append(operands, obj)
+			continue
+		}
+		switch op {
+		case "endcodespacerange":
+			for i := 0; i+1 < len(operands); i += 2 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					c.spaces = append(c.spaces, codespace{[]byte(lo), []byte(hi)})
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok := operands[i].(pdfString)
+				if !ok {
+					continue
+				}
+				switch dst := operands[i+1].(type) {
+				case pdfString:
+					c.chars[string(src)] = decodeUTF16([]byte(dst))
+				case name:
+					if r, ok := glyphRune(dst); ok {
+						c.chars[string(src)] = string(r)
+					}
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands) && entries < maxCMapRanges; i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 {
+					continue
+				}
+				code := []byte(lo)
+				for j := 0; bytes.Compare(code, []byte(hi)) <= 0 && entries < maxCMapRanges; j++ {
+					switch dst := operands[i+2].(type) {
+					case pdfString:
+						c.chars[string(code)] = decodeUTF16(offsetLast([]byte(dst), j))
+					case array:
+						if j < len(dst) {
+							if s, ok := dst[j].(pdfString); ok {
+								c.chars[string(code)] = decodeUTF16([]byte(s))
+							}
+						}
+					}
+					entries++
+					if !increment(code) {
+						break
+					}
+				}
+			}
+		}
+		if strings.HasPrefix(string(op), "end") || strings.HasPrefix(string(op), "begin") {
+			operands = operands[:0]
+		}
+	}
+	return c
+}
+
+// offsetLast adds n to the last UTF-16 unit of a bfrange destination.
+func offsetLast(b []byte, n int) []byte {
+	out := append([]byte(nil), b...)
+	if len(out) < 2 {
+		return out
+	}
+	v := int(out[len(out)-2])<<8 | int(out[len(out)-1]) + n
+	out[len(out)-2], out[len(out)-1] = byte(v>>8), byte(v)
+	return out
+}
+
+// increment adds one to a big-endian code, reporting false on overflow.
+func increment(code []byte) bool {
+	for i := len(code) - 1; i >= 0; i-- {
+		code[i]++
+		if code[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// winAnsi is the WinAnsiEncoding of simple fonts: Latin-1 with the
+// Windows-1252 characters in 0x80-0x9F.
+var winAnsi = func() *[256]rune {
+	var enc [256]rune
+	for i := range enc {
+		enc[i] = rune(i)
+	}
+	high := []rune("€\x81‚ƒ„…†‡ˆ‰Š‹Œ\x8dŽ\x8f\x90‘’“”•–—˜™š›œ\x9džŸ")
+	copy(enc[0x80:], high)
+	return &enc
+}()
+
+// glyphNames covers the glyph names found in Differences arrays beyond the
+// uniXXXX convention and single letters.
+var glyphNames = map[name]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#', "dollar": '$',
+	"percent": '%', "ampersand": '&', "quotesingle": '\'', "parenleft": '(',
+	"parenright": ')', "asterisk": '*', "plus": '+', "comma": ',', "hyphen": '-',
+	"period": '.', "slash": '/', "zero": '0', "one": '1', "two": '2', "three": '3',
+	"four": '4', "five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less": '<', "equal": '=', "greater": '>',
+	"question": '?', "at": '@', "bracketleft": '[', "backslash": '\\',
+	"bracketright": ']', "underscore": '_', "braceleft": '{', "bar": '|',
+	"braceright": '}', "quoteleft": '‘', "quoteright": '’', "quotedblleft": '“',
+	"quotedblright": '”', "endash": '–', "emdash": '—', "bullet": '•',
+	"ellipsis": '…', "fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ',
+}
+
+func glyphRune(n name) (rune, bool) {
+	if r, ok := glyphNames[n]; ok {
+		return r, true
+	}
+	s := string(n)
+	if i := strings.IndexByte(s, '.'); i > 0 {
+		s = s[:i]
+	}
+	if len(s) == 1 {
+		return rune(s[0]), true
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if strings.HasPrefix(s, prefix) && len(s) >= len(prefix)+4 {
+			if v, err := strconv.ParseUint(s[len(prefix):len(prefix)+4], 16, 32); err == nil {
+				return rune(v), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func (d *document) loadFont(hdr dict) *font {
+	f := &font{widths: map[int]float64{}, defaultWidth: 500}
+	if s, ok := d.resolve(hdr["ToUnicode"]).(*stream); ok {
+		if data, err := d.decode(s); err == nil {
+			f.toUnicode = parseCMap(data)
+		}
+	}
+	if hdr["Subtype"] == name("Type0") {
+		f.multiByte = true
+		if descendants, ok := d.resolve(hdr["DescendantFonts"]).(array); ok && len(descendants) > 0 {
+			d.loadCIDWidths(f, d.dictOf(descendants[0]))
+		}
+		return f
+	}
+
+	if first, ok := d.resolve(hdr["FirstChar"]).(int); ok {
+		widths, _ := d.resolve(hdr["Widths"]).(array)
+		for i, w := range widths {
+			if v, ok := number(d.resolve(w)); ok {
+				f.widths[first+i] = v
+			}
+		}
+	}
+
+	enc := *winAnsi
+	if encoding := d.dictOf(hdr["Encoding"]); encoding != nil {
+		if diffs, ok := d.resolve(encoding["Differences"]).(array); ok {
+			code := 0
+			for _, item := range diffs {
+				switch v := d.resolve(item).(type) {
+				case int:
+					code = v
+				case name:
+					if r, ok := glyphRune(v); ok && code >= 0 && code < 256 {
+						enc[code] = r
+					}
+					code++
+				}
+			}
+		}
+	}
+	f.encoding = &enc
+	return f
+}
+
+// loadCIDWidths reads the DW and W entries of a CID font. W lists either
+// "c [w1 w2 ...]" or "cFirst cLast w".
+func (d *document) loadCIDWidths(f *font, cidFont dict) {
+	if cidFont == nil {
+		return
+	}
+	f.defaultWidth = 1000
+	if dw, ok := number(d.resolve(cidFont["DW"])); ok {
+		f.defaultWidth = dw
+	}
+	w, _ := d.resolve(cidFont["W"]).(array)
+	for i := 0; i+1 < len(w) && len(f.widths) < maxCMapRanges; {
+		first, ok := d.resolve(w[i]).(int)
+		if !ok {
+			return
+		}
+		if list, ok := d.resolve(w[i+1]).(array); ok {
+			for j, item := range list {
+				if v, ok := number(d.resolve(item)); ok {
+					f.widths[first+j] = v
+				}
+			}
+			i += 2
+			continue
+		}
+		if i+2 >= len(w) {
+			return
+		}
+		last, ok1 := d.resolve(w[i+1]).(int)
+		v, ok2 := number(d.resolve(w[i+2]))
+		if !ok1 || !ok2 {
+			return
+		}
+		for c := first; c <= last && len(f.widths) < maxCMapRanges; c++ {
+			f.widths[c] = v
+		}
+		i += 3
+	}
+}
+
+func number(obj object) (float64, bool) {
+	switch v := obj.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// next splits the first code off a shown string.
+func (f *font) next(b []byte) (code, rest []byte) {
+	if f.toUnicode != nil {
+		return f.toUnicode.next(b, f.multiByte)
+	}
+	if f.multiByte && len(b) >= 2 {
+		return b[:2], b[2:]
+	}
+	return b[:1], b[1:]
+}
+
+// text is what one code shows; CIDs without a ToUnicode map carry no text.
+func (f *font) text(code []byte) string {
+	if f.toUnicode != nil {
+		if text, ok := f.toUnicode.chars[string(code)]; ok {
+			return text
+		}
+	}
+	if f.encoding != nil && len(code) == 1 {
+		if r := f.encoding[code[0]]; r >= ' ' {
+			return string(r)
+		}
+	}
+	return ""
+}
+
+func (f *font) width(code []byte) float64 {
+	value := 0
+	for _, c := range code {
+		value = value<<8 | int(c)
+	}
+	if w, ok := f.widths[value]; ok {
+		return w
+	}
+	return f.defaultWidth
+}
+
+func (f *font) decode(s pdfString, out *strings.Builder) {
+	for b := []byte(s); len(b) > 0; {
+		var code []byte
+		code, b = f.next(b)
+		out.WriteString(f.text(code))
+	}
+}
+
+// extractor runs content streams, keeping only what is shown as text.
+type extractor struct {
+	d     *document
+	out   strings.Builder
+	fonts map[int]*font
+}
+
+// text extracts the text of the pages, NFKC-normalised so Arabic
+// presentation forms come out as the letters they show. Lines drawn with
+// presentation forms were shaped by the producer and are in visual order.
+func (d *document) text(pages []page) string {
+	e := &extractor{d: d, fonts: map[int]*font{}}
+	for _, p := range pages {
+		if e.out.Len() > maxTextSize {
+			break
+		}
+		e.run(d.content(p), p.resources, 0)
+		e.out.WriteByte('\n')
+	}
+
+	var lines []string
+	for _, line := range strings.Split(e.out.String(), "\n") {
+		fields := strings.Fields(strings.Map(dropControl, line))
+		if len(fields) == 0 {
+			continue
+		}
+		if isVisual(line) {
+			fields = logicalLine(fields)
+		}
+		lines = append(lines, norm.NFKC.String(strings.Join(fields, " ")))
+	}
+	text := strings.Join(lines, "\n")
+	if len(text) > maxTextSize {
+		text = strings.ToValidUTF8(text[:maxTextSize], "")
+	}
+	return text
+}
+
+// dropControl removes control characters, NUL among them, which Postgres
+// does not accept in text.
+func dropControl(r rune) rune {
+	if unicode.IsControl(r) && r != '\t' {
+		return -1
+	}
+	return r
+}
+
+func isVisual(line string) bool {
+	for _, r := range line {
+		if (r >= 0xFB50 && r <= 0xFDFF) || (r >= 0xFE70 && r <= 0xFEFF) {
+			return true
+		}
+	}
+	return false
+}
+
+// logicalLine undoes visualLine: the word order is reversed and so are the
+// letters of Arabic words.
+func logicalLine(words []string) []string {
+	out := make([]string, 0, len(words))
+	for i := len(words) - 1; i >= 0; i-- {
+		word := []rune(words[i])
+		if isRTL(words[i]) {
+			for l, r := 0, len(word)-1; l < r; l, r = l+1, r-1 {
+				word[l], word[r] = word[r], word[l]
+			}
+			word = mirrorBrackets(word)
+		}
+		out = append(out, string(word))
+	}
+	return out
+}
+
+func (e *extractor) font(resources dict, key object) *font {
+	fonts := e.d.dictOf(resources["Font"])
+	if fonts == nil {
+		return nil
+	}
+	n, _ := key.(name)
+	entry := fonts[n]
+	r, isRef := entry.(ref)
+	if isRef {
+		if f, ok := e.fonts[r.num]; ok {
+			return f
+		}
+	}
+	hdr := e.d.dictOf(entry)
+	if hdr == nil {
+		return nil
+	}
+	f := e.d.loadFont(hdr)
+	if isRef {
+		e.fonts[r.num] = f
+	}
+	return f
+}
+
+func (e *extractor) run(content []byte, resources dict, depth int) {
+	l := &lexer{buf: content}
+	var operands []object
+	var current *font
+	for e.out.Len() <= maxTextSize {
+		obj, ok := l.object()
+		if !ok {
+			return
+		}
+		op, isKeyword := obj.(keyword)
+		if !isKeyword {
+			operands = append(operands, obj)
+			continue
+		}
+		last := func() object {
+			if len(operands) == 0 {
+				return nil
+			}
+			return operands[len(operands)-1]
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				current = e.font(resources, operands[len(operands)-2])
+			}
+		case "Tj", "'", "\"":
+			if op != "Tj" {
+				e.out.WriteByte('\n')
+			}
+			if s, ok := last().(pdfString); ok && current != nil {
+				current.decode(s, &e.out)
+			}
+		case "TJ":
+			items, _ := last().(array)
+			for _, item := range items {
+				switch v := item.(type) {
+				case pdfString:
+					if current != nil {
+						current.decode(v, &e.out)
+					}
+				case int:
+					if v < wordGap {
+						e.out.WriteByte(' ')
+					}
+				case float64:
+					if v < wordGap {
+						e.out.WriteByte(' ')
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[len(operands)-1] != 0 && operands[len(operands)-1] != 0.0 {
+				e.out.WriteByte('\n')
+			} else {
+				e.out.WriteByte(' ')
+			}
+		case "T*", "Tm":
+			e.out.WriteByte('\n')
+		case "ET":
+			e.out.WriteByte(' ')
+		case "Do":
+			e.form(resources, last(), depth)
+		case "ID":
+			if !l.skipInlineImage() {
+				return
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func (e *extractor) form(resources dict, key object, depth int) {
+	if depth >= maxFormDepth {
+		return
+	}
+	n, _ := key.(name)
+	xobjects := e.d.dictOf(resources["XObject"])
+	if xobjects == nil {
+		return
+	}
+	s, ok := e.d.resolve(xobjects[n]).(*stream)
+	if !ok || s.hdr["Subtype"] != name("Form") {
+		return
+	}
+	data, err := e.d.decode(s)
+	if err != nil {
+		return
+	}
+	if own := e.d.dictOf(s.hdr["Resources"]); own != nil {
+		resources = own
+	}
+	e.run(data, resources, depth+1)
+}
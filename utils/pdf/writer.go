@@ -0,0 +1,347 @@
+// Package pdf writes simple text documents (reports, logs, appendices) as PDF
+// and reads back the page count, metadata and text of uploaded ones, without
+// any dependency outside the standard library.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	pageWidth  = 595.28 // A4 in points
+	pageHeight = 841.89
+	margin     = 50.0
+)
+
+// DefaultFontPath is the TrueType font embedded when PDF_FONT_PATH is not
+// set. Any font with Arabic presentation forms works; without one the
+// document falls back to Helvetica and can only show Latin text.
+const DefaultFontPath = "/usr/share/fonts/truetype/dejavu/DejaVuSans.ttf"
+
+type Document struct {
+	title string
+	font  *trueTypeFont
+	used  map[uint16]rune
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+// New starts an empty A4 document.
+func New(title string) *Document {
+	path := os.Getenv("PDF_FONT_PATH")
+	if path == "" {
+		path = DefaultFontPath
+	}
+	font, err := loadTrueType(path)
+	if err != nil {
+		font = nil
+	}
+	d := &Document{title: title, font: font, used: map[uint16]rune{}}
+	d.newPage()
+	return d
+}
+
+func (d *Document) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pageHeight - margin
+}
+
+// Title writes a large centred line.
+func (d *Document) Title(text string) {
+	d.write(text, 16, true)
+	d.Space(8)
+}
+
+// Heading writes a bold-sized line that starts a section.
+func (d *Document) Heading(text string) {
+	d.Space(6)
+	d.write(text, 13, false)
+	d.Space(2)
+}
+
+// Paragraph writes wrapped text; embedded newlines start new lines.
+func (d *Document) Paragraph(text string) {
+	for _, line := range strings.Split(text, "\n") {
+		d.write(line, 10.5, false)
+	}
+}
+
+// Field writes a "label: value" line.
+func (d *Document) Field(label, value string) {
+	d.Paragraph(label + ": " + value)
+}
+
+// Space moves the cursor down.
+func (d *Document) Space(points float64) {
+	d.y -= points
+}
+
+// Rule draws a thin horizontal line across the text area.
+func (d *Document) Rule() {
+	d.ensure(8)
+	d.y -= 4
+	fmt.Fprintf(d.page, "0.6 G 0.5 w %.2f %.2f m %.2f %.2f l S\n", margin, d.y, pageWidth-margin, d.y)
+	d.y -= 4
+}
+
+func (d *Document) ensure(height float64) {
+	if d.y-height < margin {
+		d.newPage()
+	}
+}
+
+func (d *Document) write(text string, size float64, centred bool) {
+	lineHeight := size * 1.45
+	rtl := isRTL(text)
+	maxWidth := pageWidth - 2*margin
+
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		d.ensure(lineHeight)
+		d.y -= lineHeight
+		return
+	}
+
+	var lines [][]string
+	var current []string
+	for _, word := range words {
+		for _, part := range d.splitLongWord(word, size, maxWidth, rtl) {
+			candidate := append(append([]string(nil), current...), part)
+			if len(current) > 0 && d.measure(visualLine(candidate, rtl), size) > maxWidth {
+				lines = append(lines, current)
+				current = []string{part}
+				continue
+			}
+			current = candidate
+		}
+	}
+	lines = append(lines, current)
+
+	for _, line := range lines {
+		d.ensure(lineHeight)
+		d.y -= lineHeight
+		runes := visualLine(line, rtl)
+		width := d.measure(runes, size)
+		x := margin
+		switch {
+		case centred:
+			x = (pageWidth - width) / 2
+		case rtl:
+			x = pageWidth - margin - width
+		}
+		fmt.Fprintf(d.page, "0 g BT /F1 %.1f Tf %.2f %.2f Td %s Tj ET\n", size, x, d.y, d.encode(runes))
+	}
+}
+
+// splitLongWord breaks a word wider than the line into chunks.
+func (d *Document) splitLongWord(word string, size, maxWidth float64, rtl bool) []string {
+	if d.measure(visualLine([]string{word}, rtl), size) <= maxWidth {
+		return []string{word}
+	}
+	var parts []string
+	var current []rune
+	for _, r := range word {
+		if len(current) > 0 && d.measure(append(current, r), size) > maxWidth {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, r)
+	}
+	return append(parts, string(current))
+}
+
+func (d *Document) measure(runes []rune, size float64) float64 {
+	total := 0
+	for _, r := range runes {
+		if d.font == nil {
+			total += 556 // Helvetica's most common advance
+			continue
+		}
+		total += d.font.width(d.font.glyphs[r])
+	}
+	return float64(total) * size / 1000
+}
+
+// encode renders runes as a PDF string operand for the current font.
+func (d *Document) encode(runes []rune) string {
+	var b strings.Builder
+	if d.font == nil {
+		b.WriteByte('(')
+		for _, r := range runes {
+			switch {
+			case r == '(' || r == ')' || r == '\\':
+				b.WriteByte('\\')
+				b.WriteRune(r)
+			case r >= 32 && r < 127:
+				b.WriteRune(r)
+			default:
+				b.WriteByte('?')
+			}
+		}
+		b.WriteByte(')')
+		return b.String()
+	}
+
+	b.WriteByte('<')
+	for _, r := range runes {
+		glyph := d.font.glyphs[r]
+		if glyph != 0 {
+			d.used[glyph] = r
+		}
+		fmt.Fprintf(&b, "%04X", glyph)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// Bytes serialises the document.
+func (d *Document) Bytes() []byte {
+	w := &objectWriter{}
+	w.buf.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+
+	catalog := w.reserve()
+	pagesObj := w.reserve()
+	fontObj := w.reserve()
+	info := w.reserve()
+
+	if d.font == nil {
+		w.object(fontObj, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	} else {
+		d.writeFont(w, fontObj)
+	}
+
+	var kids []string
+	for _, page := range d.pages {
+		content := w.reserve()
+		w.stream(content, "", page.Bytes())
+		pageObj := w.reserve()
+		w.object(pageObj, fmt.Sprintf(
+			"<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesObj, pageWidth, pageHeight, fontObj, content))
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageObj))
+	}
+
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+	w.object(info, fmt.Sprintf("<< /Title %s /Producer (project) >>", textString(d.title)))
+
+	return w.finish(catalog, info)
+}
+
+func (d *Document) writeFont(w *objectWriter, fontObj int) {
+	f := d.font
+	cidFont := w.reserve()
+	descriptor := w.reserve()
+	fontFile := w.reserve()
+	toUnicode := w.reserve()
+
+	glyphs := make([]int, 0, len(d.used))
+	for glyph := range d.used {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+
+	var widths strings.Builder
+	for _, glyph := range glyphs {
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, f.width(uint16(glyph)))
+	}
+
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n")
+	cmap.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	cmap.WriteString("/CMapName /Adobe-Identity-UCS def /CMapType 2 def\n")
+	cmap.WriteString("1 begincodespacerange <0000> <FFFF> endcodespacerange\n")
+	for start := 0; start < len(glyphs); start += 100 {
+		end := start + 100
+		if end > len(glyphs) {
+			end = len(glyphs)
+		}
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", end-start)
+		for _, glyph := range glyphs[start:end] {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", glyph, utf16Hex(d.used[uint16(glyph)]))
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap CMapName currentdict /CMap defineresource pop end end\n")
+
+	name := strings.ReplaceAll(f.name, " ", "")
+	w.object(fontObj, fmt.Sprintf(
+		"<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		name, cidFont, toUnicode))
+	w.object(cidFont, fmt.Sprintf(
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW %d /W [%s] >>",
+		name, descriptor, f.width(0), widths.String()))
+	w.object(descriptor, fmt.Sprintf(
+		"<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		name, f.scale(f.bbox[0]), f.scale(f.bbox[1]), f.scale(f.bbox[2]), f.scale(f.bbox[3]),
+		f.scale(f.ascent), f.scale(f.descent), f.scale(f.capHeight), fontFile))
+	w.stream(fontFile, fmt.Sprintf("/Length1 %d", len(f.data)), f.data)
+	w.stream(toUnicode, "", []byte(cmap.String()))
+}
+
+func utf16Hex(r rune) string {
+	var b strings.Builder
+	for _, unit := range utf16.Encode([]rune{r}) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	return b.String()
+}
+
+// textString encodes a document string (title, author) as UTF-16BE hex.
+func textString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, unit := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", unit)
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+// objectWriter numbers indirect objects and records their offsets for the
+// cross-reference table.
+type objectWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (w *objectWriter) reserve() int {
+	w.offsets = append(w.offsets, 0)
+	return len(w.offsets)
+}
+
+func (w *objectWriter) object(id int, body string) {
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", id, body)
+}
+
+func (w *objectWriter) stream(id int, extra string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	w.offsets[id-1] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode %s >>\nstream\n", id, compressed.Len(), extra)
+	w.buf.Write(compressed.Bytes())
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+func (w *objectWriter) finish(root, info int) []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(w.offsets)+1, root, info, xref)
+	return w.buf.Bytes()
+}
@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"project/utils/pdf"
+	"strings"
+)
+
+const (
+	// ThumbnailSuffix names the preview of an upload, stored next to it:
+	// uploads/books/books_1700000000_42.pdf.thumb.jpg
+	ThumbnailSuffix = ".thumb.jpg"
+	ThumbnailWidth  = 320
+
+	maxPreviewSource = 64 << 20 // bytes
+	maxPreviewPixels = 40 << 20 // decoded image pixels
+)
+
+var ErrNoPreview = errors.New("file has no preview")
+
+// previewTypes are the uploads that get a thumbnail: the first page of
+// PDFs, and a resized rendition of images.
+var previewTypes = map[string]bool{".pdf": true, ".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// HasPreview reports whether a thumbnail can be made of the file.
+func HasPreview(file string) bool {
+	return previewTypes[strings.ToLower(filepath.Ext(file))]
+}
+
+func ThumbnailPath(file string) string {
+	return file + ThumbnailSuffix
+}
+
+// GenerateThumbnail renders the preview of an upload and stores it next to
+// it, replacing any previous one, and returns its path.
+func GenerateThumbnail(file string) (string, error) {
+	if !HasPreview(file) {
+		return "", ErrNoPreview
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() > maxPreviewSource {
+		return "", errors.New("file too large to preview")
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	var img image.Image
+	if strings.ToLower(filepath.Ext(file)) == ".pdf" {
+		// Rendered at twice the size so downscaling smooths it
+		img, err = pdf.Render(content, 2*ThumbnailWidth)
+	} else {
+		var config image.Config
+		config, _, err = image.DecodeConfig(bytes.NewReader(content))
+		if err == nil && config.Width*config.Height > maxPreviewPixels {
+			err = errors.New("image too large to preview")
+		}
+		if err == nil {
+			img, _, err = image.Decode(bytes.NewReader(content))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Written under a temporary name so a half-written thumbnail is never
+	// served
+	thumbnail := ThumbnailPath(file)
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".thumb-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, resize(img, ThumbnailWidth), &jpeg.Options{Quality: 80}); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), thumbnail); err != nil {
+		return "", err
+	}
+	return thumbnail, nil
+}
+
+// resize scales an image down to width by averaging the source pixels under
+// each target pixel, over a white background for transparent images.
+// Narrower images keep their size.
+func resize(img image.Image, width int) *image.RGBA {
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Over)
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= width {
+		return src
+	}
+	height := h * width / w
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*h/height, (y+1)*h/height
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := x*w/width, (x+1)*w/width
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = uint8(r/n), uint8(g/n), uint8(b/n), 255
+		}
+	}
+	return dst
+}
@@ -152,6 +152,8 @@ func DeleteFile(filePath string) error {
 	if err := os.Remove(filePath); err != nil {
 		return fmt.Errorf("could not delete file: %v", err)
 	}
+	// The preview goes with its file, if one was made
+	os.Remove(ThumbnailPath(filePath))
 	return nil
 }
 func HashPassword(password string) (string, error) {
@@ -232,7 +234,7 @@ func ParseBoolOrDefault(value string, defaultValue bool) (bool, error) {
 func BuildQuery(dest interface{}, table string,
 	joins []string, columns []string,
 	searchCols []string, queryParams url.Values,
-	additionalFilters []string) (*Meta, error) {
+	additionalFilters []squirrel.Sqlizer) (*Meta, error) {
 
 	q := queryParams.Get("q")
 	filters := queryParams.Get("filters")
@@ -337,17 +339,33 @@ func BuildQuery(dest interface{}, table string,
 
 	return &meta, nil
 }
-func normalizeArabicText(input string) string {
+
+// arabicFolding maps letter variants that are written interchangeably onto
+// one form: hamza-carrying alefs and alef wasla to bare alef, alef maqsura
+// to ya and ta marbuta to ha.
+var arabicFolding = strings.NewReplacer(
+	"أ", "ا",
+	"إ", "ا",
+	"آ", "ا",
+	"ٱ", "ا",
+	"ى", "ي",
+	"ة", "ه",
+)
+
+// NormalizeArabicText strips diacritics and tatweel and folds letter
+// variants so differently spelled forms of a word compare equal. The
+// normalize_arabic SQL function applies the same rules to the search index.
+func NormalizeArabicText(input string) string {
 	// Normalize the text
 	input = norm.NFC.String(input)
-	// Remove diacritics
+	// Remove diacritics and tatweel
 	var normalized strings.Builder
 	for _, r := range input {
-		if !unicode.Is(unicode.Mn, r) {
+		if !unicode.Is(unicode.Mn, r) && r != 'ـ' {
 			normalized.WriteRune(r)
 		}
 	}
-	return normalized.String()
+	return arabicFolding.Replace(normalized.String())
 }
 
 // ComputeTFIDF computes the term frequency-inverse document frequency.
@@ -357,7 +375,7 @@ func ComputeTFIDF(doc string, corpus []string) map[string]float64 {
 	tfIDF := make(map[string]float64)
 
 	// Normalize and tokenize the document
-	words := strings.Fields(normalizeArabicText(strings.ToLower(doc)))
+	words := strings.Fields(NormalizeArabicText(strings.ToLower(doc)))
 
 	// Compute term frequency
 	for _, word := range words {
@@ -369,7 +387,7 @@ func ComputeTFIDF(doc string, corpus []string) map[string]float64 {
 
 	// Compute inverse document frequency
 	for _, document := range corpus {
-		docWords := strings.Fields(normalizeArabicText(strings.ToLower(document)))
+		docWords := strings.Fields(NormalizeArabicText(strings.ToLower(document)))
 		uniqueWords := make(map[string]struct{})
 		for _, word := range docWords {
 			uniqueWords[word] = struct{}{}
@@ -438,3 +456,41 @@ func GenerateRandomCode() string {
 	// Return the generated code as a string
 	return string(code)
 }
+
+// ParseKeywords splits a comma separated keyword list, trimming each entry
+// and dropping blanks and case-insensitive duplicates.
+func ParseKeywords(input string) []string {
+	keywords := []string{}
+	seen := map[string]bool{}
+	for _, keyword := range strings.Split(input, ",") {
+		keyword = strings.Join(strings.Fields(keyword), " ")
+		key := strings.ToLower(NormalizeArabicText(keyword))
+		if keyword == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keywords = append(keywords, keyword)
+	}
+	return keywords
+}
+
+// NewMeta computes pagination metadata the way BuildQuery does. A page or
+// perPage of zero means everything on one page.
+func NewMeta(total, page, perPage int) *Meta {
+	if page <= 0 || perPage <= 0 {
+		return &Meta{Total: total, PerPage: total, CurrentPage: 1, FirstPage: 1, LastPage: 1, From: 1, To: total}
+	}
+	to := page * perPage
+	if to > total {
+		to = total
+	}
+	return &Meta{
+		Total:       total,
+		PerPage:     perPage,
+		CurrentPage: page,
+		FirstPage:   1,
+		LastPage:    (total + perPage - 1) / perPage,
+		From:        (page-1)*perPage + 1,
+		To:          to,
+	}
+}